@@ -0,0 +1,446 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"code.google.com/p/goprotobuf/proto"
+	"github.com/influxdb/influxdb/compression"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+// maxBlockValues is the maximum number of values stored in a single block.
+const maxBlockValues = 1000
+
+// Block value types.
+const (
+	blockInt64  = byte(1)
+	blockDouble = byte(2)
+	blockString = byte(3)
+	blockBool   = byte(4)
+)
+
+// errUnsupportedBlockValue is returned when writing a value without a type.
+var errUnsupportedBlockValue = errors.New("unsupported block value")
+
+// blockValue represents the value of a field at a given timestamp and sequence.
+type blockValue struct {
+	timestamp int64
+	seq       uint64
+	value     *protocol.FieldValue
+}
+
+// before returns true if the value is before another value.
+func (v *blockValue) before(other *blockValue) bool {
+	return (v.timestamp < other.timestamp) || (v.timestamp == other.timestamp && v.seq < other.seq)
+}
+
+// after returns true if the value is after another value.
+func (v *blockValue) after(other *blockValue) bool {
+	return (v.timestamp > other.timestamp) || (v.timestamp == other.timestamp && v.seq > other.seq)
+}
+
+func (v *blockValue) String() string {
+	return fmt.Sprintf("[time: %d, sequence: %d, value: %v]", v.timestamp, v.seq, v.value)
+}
+
+// blockValues represents a list of block values, sortable by timestamp and sequence.
+type blockValues []*blockValue
+
+func (p blockValues) Len() int           { return len(p) }
+func (p blockValues) Less(i, j int) bool { return p[i].before(p[j]) }
+func (p blockValues) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// blockValueType returns the block type of a field value.
+func blockValueType(v *protocol.FieldValue) byte {
+	switch {
+	case v.Int64Value != nil:
+		return blockInt64
+	case v.DoubleValue != nil:
+		return blockDouble
+	case v.StringValue != nil:
+		return blockString
+	case v.BoolValue != nil:
+		return blockBool
+	default:
+		return 0
+	}
+}
+
+// splitBlocks splits a sorted list of values into runs which can each be
+// stored in a single block. A new block is started when the value type changes.
+func splitBlocks(a []*blockValue) [][]*blockValue {
+	var blocks [][]*blockValue
+	for len(a) > 0 {
+		typ := blockValueType(a[0].value)
+		n := 1
+		for n < len(a) && n < maxBlockValues && blockValueType(a[n].value) == typ {
+			n++
+		}
+		blocks, a = append(blocks, a[:n]), a[n:]
+	}
+	return blocks
+}
+
+// marshalBlock encodes a list of values of the same type into a block.
+//
+// A block begins with the value type followed by the timestamp, sequence and
+// value columns. Each column is prefixed by its length as a uvarint.
+func marshalBlock(a []*blockValue) ([]byte, error) {
+	assert(len(a) > 0, "block values required")
+	typ := blockValueType(a[0].value)
+	if typ == 0 {
+		return nil, errUnsupportedBlockValue
+	}
+
+	// Encode timestamps and sequence numbers.
+	timestamps, seqs := make([]int64, len(a)), make([]int64, len(a))
+	for i, v := range a {
+		timestamps[i], seqs[i] = v.timestamp, int64(v.seq)
+	}
+
+	// Encode values.
+	var values []byte
+	switch typ {
+	case blockInt64:
+		ints := make([]int64, len(a))
+		for i, v := range a {
+			ints[i] = v.value.GetInt64Value()
+		}
+		values = compression.EncodeIntegers(ints)
+	case blockDouble:
+		floats := make([]float64, len(a))
+		for i, v := range a {
+			floats[i] = v.value.GetDoubleValue()
+		}
+		values = compression.EncodeFloats(floats)
+	case blockString:
+		for _, v := range a {
+			values = appendBlockBytes(values, []byte(v.value.GetStringValue()))
+		}
+	case blockBool:
+		values = make([]byte, (len(a)+7)/8)
+		for i, v := range a {
+			if v.value.GetBoolValue() {
+				values[i/8] |= 1 << uint(i%8)
+			}
+		}
+	}
+
+	b := []byte{typ}
+	b = appendBlockBytes(b, compression.EncodeTimestamps(timestamps))
+	b = appendBlockBytes(b, compression.EncodeIntegers(seqs))
+	b = appendBlockBytes(b, values)
+	return b, nil
+}
+
+// unmarshalBlock decodes a block into a list of values.
+// Values do not reference the underlying byte slice.
+func unmarshalBlock(b []byte) ([]*blockValue, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty block")
+	}
+	typ, b := b[0], b[1:]
+
+	// Read the columns.
+	var columns [3][]byte
+	for i := range columns {
+		n, sz := binary.Uvarint(b)
+		if sz <= 0 || uint64(len(b)-sz) < n {
+			return nil, compression.ErrShortBuffer
+		}
+		columns[i], b = b[sz:sz+int(n)], b[sz+int(n):]
+	}
+
+	// Decode timestamps and sequence numbers.
+	timestamps, err := compression.DecodeTimestamps(columns[0])
+	if err != nil {
+		return nil, fmt.Errorf("timestamps: %s", err)
+	}
+	seqs, err := compression.DecodeIntegers(columns[1])
+	if err != nil {
+		return nil, fmt.Errorf("sequences: %s", err)
+	} else if len(seqs) != len(timestamps) {
+		return nil, fmt.Errorf("sequence count mismatch: %d != %d", len(seqs), len(timestamps))
+	}
+
+	// Decode values.
+	values := make([]*protocol.FieldValue, len(timestamps))
+	switch typ {
+	case blockInt64:
+		ints, err := compression.DecodeIntegers(columns[2])
+		if err != nil {
+			return nil, fmt.Errorf("values: %s", err)
+		} else if len(ints) != len(values) {
+			return nil, fmt.Errorf("value count mismatch: %d != %d", len(ints), len(values))
+		}
+		for i, v := range ints {
+			values[i] = &protocol.FieldValue{Int64Value: proto.Int64(v)}
+		}
+	case blockDouble:
+		floats, err := compression.DecodeFloats(columns[2])
+		if err != nil {
+			return nil, fmt.Errorf("values: %s", err)
+		} else if len(floats) != len(values) {
+			return nil, fmt.Errorf("value count mismatch: %d != %d", len(floats), len(values))
+		}
+		for i, v := range floats {
+			values[i] = &protocol.FieldValue{DoubleValue: proto.Float64(v)}
+		}
+	case blockString:
+		buf := columns[2]
+		for i := range values {
+			n, sz := binary.Uvarint(buf)
+			if sz <= 0 || uint64(len(buf)-sz) < n {
+				return nil, fmt.Errorf("values: %s", compression.ErrShortBuffer)
+			}
+			values[i] = &protocol.FieldValue{StringValue: proto.String(string(buf[sz : sz+int(n)]))}
+			buf = buf[sz+int(n):]
+		}
+	case blockBool:
+		if len(columns[2]) < (len(values)+7)/8 {
+			return nil, fmt.Errorf("values: %s", compression.ErrShortBuffer)
+		}
+		for i := range values {
+			values[i] = &protocol.FieldValue{BoolValue: proto.Bool(columns[2][i/8]&(1<<uint(i%8)) != 0)}
+		}
+	default:
+		return nil, fmt.Errorf("invalid block type: %d", typ)
+	}
+
+	a := make([]*blockValue, len(values))
+	for i := range values {
+		a[i] = &blockValue{timestamp: timestamps[i], seq: uint64(seqs[i]), value: values[i]}
+	}
+	return a, nil
+}
+
+// appendBlockBytes appends a byte slice prefixed by its length.
+func appendBlockBytes(b, v []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	b = append(b, buf[:binary.PutUvarint(buf[:], uint64(len(v)))]...)
+	return append(b, v...)
+}
+
+// blockFieldID returns the field id from a block key.
+func blockFieldID(k []byte) uint64 { return binary.BigEndian.Uint64(k[0:8]) }
+
+// seekBlock moves a cursor to the block for a field which may contain key.
+// This is the last block starting at or before key or, if no such block
+// exists, the first block after key. The returned block may belong to a
+// different field so callers must check the field id.
+func seekBlock(c ShardStoreCursor, fieldID uint64, key []byte) (k, v []byte) {
+	k, v = c.Seek(key)
+	if k != nil && bytes.Equal(k, key) {
+		return k, v
+	}
+
+	// Check the previous block.
+	var pk, pv []byte
+	if k == nil {
+		pk, pv = c.Last()
+	} else {
+		pk, pv = c.Prev()
+	}
+	if pk != nil && blockFieldID(pk) == fieldID {
+		return pk, pv
+	}
+
+	return c.Seek(key)
+}
+
+// rewriteBlocks returns the writes which replace the blocks of a field that
+// may contain values between min and max, inclusive. The decoded values are
+// passed to fn and the returned values are written back as new blocks.
+func rewriteBlocks(c ShardStoreCursor, fieldID uint64, min, max storageKey, fn func([]*blockValue) []*blockValue) ([]ShardStoreWrite, error) {
+	maxKey := marshalStorageKey(max)
+
+	// Read all blocks which may overlap the range and remove them.
+	var batch []ShardStoreWrite
+	var values []*blockValue
+	for k, v := seekBlock(c, fieldID, marshalStorageKey(min)); k != nil; k, v = c.Next() {
+		if blockFieldID(k) != fieldID || bytes.Compare(k, maxKey) > 0 {
+			break
+		}
+
+		a, err := unmarshalBlock(v)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal block: %s", err)
+		}
+		batch = append(batch, ShardStoreWrite{Key: append([]byte(nil), k...)})
+		values = append(values, a...)
+	}
+
+	// Write the new values as blocks.
+	for _, a := range splitBlocks(fn(values)) {
+		buf, err := marshalBlock(a)
+		if err != nil {
+			return nil, err
+		}
+		k := marshalStorageKey(newStorageKey(fieldID, a[0].timestamp, a[0].seq))
+		batch = append(batch, ShardStoreWrite{Key: k, Value: buf})
+	}
+
+	return batch, nil
+}
+
+// sortBlockValues sorts values by timestamp and sequence number. When values
+// share a timestamp and sequence number then only the last one is kept.
+func sortBlockValues(a []*blockValue) []*blockValue {
+	sort.Stable(blockValues(a))
+	for i := len(a) - 1; i > 0; i-- {
+		if !a[i-1].before(a[i]) {
+			a = append(a[:i-1], a[i:]...)
+		}
+	}
+	return a
+}
+
+// mergeBlockValues merges sorted values into a sorted list of existing
+// values. New values replace existing values with the same timestamp and
+// sequence number.
+func mergeBlockValues(a, other []*blockValue) []*blockValue {
+	merged := make([]*blockValue, 0, len(a)+len(other))
+	for len(a) > 0 || len(other) > 0 {
+		if len(other) == 0 || (len(a) > 0 && a[0].before(other[0])) {
+			merged, a = append(merged, a[0]), a[1:]
+			continue
+		}
+
+		// Skip an existing value if it is being replaced.
+		if len(a) > 0 && !other[0].before(a[0]) {
+			a = a[1:]
+		}
+		merged, other = append(merged, other[0]), other[1:]
+	}
+	return merged
+}
+
+// removeNullValues returns the values which are not null.
+func removeNullValues(a []*blockValue) []*blockValue {
+	other := make([]*blockValue, 0, len(a))
+	for _, v := range a {
+		if !v.value.GetIsNull() {
+			other = append(other, v)
+		}
+	}
+	return other
+}
+
+// blockCursor iterates over the values of a single field within a time range.
+// Blocks are only decoded when the cursor reaches them.
+type blockCursor struct {
+	cursor  ShardStoreCursor
+	fieldID uint64
+
+	tmin, tmax int64
+	ascending  bool
+
+	values []*blockValue // decoded values of the current block
+	index  int
+	eof    bool
+	err    error
+}
+
+// first moves the cursor to the first value in the time range.
+func (c *blockCursor) first() *blockValue {
+	c.eof = false
+
+	// Find the block which may contain the start of the range.
+	var key []byte
+	if c.ascending {
+		key = marshalStorageKey(newStorageKey(c.fieldID, c.tmin, 0))
+	} else {
+		key = marshalStorageKey(newStorageKey(c.fieldID, c.tmax, math.MaxUint64))
+	}
+	if k, v := seekBlock(c.cursor, c.fieldID, key); !c.load(k, v) {
+		return nil
+	}
+
+	return c.next()
+}
+
+// next moves the cursor to the next value in the time range.
+func (c *blockCursor) next() *blockValue {
+	for !c.eof {
+		if c.ascending {
+			c.index++
+		} else {
+			c.index--
+		}
+
+		// Read the next block once the current block is exhausted.
+		if c.index < 0 || c.index >= len(c.values) {
+			var k, v []byte
+			if c.ascending {
+				k, v = c.cursor.Next()
+			} else {
+				k, v = c.cursor.Prev()
+			}
+			c.load(k, v)
+			continue
+		}
+
+		// Skip values before the range and stop at the end of the range.
+		v := c.values[c.index]
+		if (c.ascending && v.timestamp < c.tmin) || (!c.ascending && v.timestamp > c.tmax) {
+			continue
+		} else if v.timestamp < c.tmin || v.timestamp > c.tmax {
+			c.eof, c.values = true, nil
+			continue
+		}
+		return v
+	}
+	return nil
+}
+
+// seek moves the cursor to the next value in the time range which doesn't
+// come before key in the cursor's direction. Blocks between the current
+// block and the key are skipped without being decoded.
+func (c *blockCursor) seek(key *blockValue) *blockValue {
+	if c.eof {
+		return nil
+	}
+
+	// Move to the block which may contain the key if it's outside the current block.
+	if n := len(c.values); n == 0 || (c.ascending && c.values[n-1].before(key)) || (!c.ascending && c.values[0].after(key)) {
+		k, v := seekBlock(c.cursor, c.fieldID, marshalStorageKey(newStorageKey(c.fieldID, key.timestamp, key.seq)))
+		if !c.load(k, v) {
+			return nil
+		}
+	}
+
+	for v := c.next(); v != nil; v = c.next() {
+		if (c.ascending && !v.before(key)) || (!c.ascending && !v.after(key)) {
+			return v
+		}
+	}
+	return nil
+}
+
+// load decodes a block and positions the cursor before its first value.
+// Returns false if the block belongs to a different field or cannot be decoded.
+func (c *blockCursor) load(k, v []byte) bool {
+	if k == nil || blockFieldID(k) != c.fieldID {
+		c.eof, c.values = true, nil
+		return false
+	}
+
+	a, err := unmarshalBlock(v)
+	if err != nil {
+		c.eof, c.values, c.err = true, nil, fmt.Errorf("unmarshal block: %s", err)
+		return false
+	}
+	c.values = a
+
+	if c.ascending {
+		c.index = -1
+	} else {
+		c.index = len(a)
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package influxdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltShardStore is a shard store backed by a Bolt database.
+// All keys are stored in a single bucket.
+type boltShardStore struct {
+	db *bolt.DB
+}
+
+// Open opens the Bolt database at path and creates the bucket.
+func (s *boltShardStore) Open(path string) error {
+	if s.db != nil {
+		return errors.New("bolt store already open")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+
+	// Initialize bucket.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("blocks"))
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return fmt.Errorf("init: %s", err)
+	}
+
+	s.db = db
+	return nil
+}
+
+// Close closes the Bolt database.
+func (s *boltShardStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// WriteBatch applies a batch of writes in a single transaction.
+func (s *boltShardStore) WriteBatch(batch []ShardStoreWrite) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("blocks"))
+		for _, w := range batch {
+			if w.Value == nil {
+				if err := b.Delete(w.Key); err != nil {
+					return fmt.Errorf("del: %s", err)
+				}
+				continue
+			}
+			if err := b.Put(w.Key, w.Value); err != nil {
+				return fmt.Errorf("put: %s", err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteRange removes all keys between min and max, inclusive.
+func (s *boltShardStore) DeleteRange(min, max []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("blocks"))
+
+		// Find keys within the range. Keys are copied since the bucket
+		// cannot be changed while iterating.
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.Seek(min); k != nil && bytes.Compare(k, max) <= 0; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		// Remove the keys.
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return fmt.Errorf("del: %s", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Cursor returns a cursor within a read-only transaction.
+func (s *boltShardStore) Cursor() (ShardStoreCursor, error) {
+	tx, err := s.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &boltShardStoreCursor{tx: tx, cursor: tx.Bucket([]byte("blocks")).Cursor()}, nil
+}
+
+// boltShardStoreCursor wraps a Bolt cursor and its transaction.
+type boltShardStoreCursor struct {
+	tx     *bolt.Tx
+	cursor *bolt.Cursor
+}
+
+func (c *boltShardStoreCursor) First() (key, value []byte)           { return c.cursor.First() }
+func (c *boltShardStoreCursor) Last() (key, value []byte)            { return c.cursor.Last() }
+func (c *boltShardStoreCursor) Seek(seek []byte) (key, value []byte) { return c.cursor.Seek(seek) }
+func (c *boltShardStoreCursor) Next() (key, value []byte)            { return c.cursor.Next() }
+func (c *boltShardStoreCursor) Prev() (key, value []byte)            { return c.cursor.Prev() }
+
+// Close rolls back the cursor's read-only transaction.
+func (c *boltShardStoreCursor) Close() error { return c.tx.Rollback() }
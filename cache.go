@@ -0,0 +1,142 @@
+package influxdb
+
+import (
+	"sort"
+
+	"github.com/influxdb/influxdb/protocol"
+)
+
+// shardCache holds values written to a shard which have not been flushed to
+// the shard's store. Null values are kept so they remove stored values when
+// the cache is flushed.
+type shardCache struct {
+	values map[uint64][]*blockValue // sorted values by field id
+	n      int                      // total number of values
+}
+
+// newShardCache returns a new, empty cache.
+func newShardCache() *shardCache {
+	return &shardCache{values: make(map[uint64][]*blockValue)}
+}
+
+// add merges the values of a series into the cache.
+//
+// Existing elements of a field's slice are never modified so readers can
+// hold on to a slice while writes continue.
+func (c *shardCache) add(s *protocol.Series) {
+	if len(s.Points) == 0 {
+		return
+	}
+
+	for i, fieldID := range s.FieldIds {
+		values := make([]*blockValue, 0, len(s.Points))
+		for _, p := range s.Points {
+			values = append(values, &blockValue{timestamp: p.GetTimestamp(), seq: p.GetSequenceNumber(), value: p.Values[i]})
+		}
+		values = sortBlockValues(values)
+
+		// Append values written in order. Otherwise merge them.
+		prev := c.values[fieldID]
+		if len(prev) == 0 || prev[len(prev)-1].before(values[0]) {
+			values = append(prev, values...)
+		} else {
+			values = mergeBlockValues(prev, values)
+		}
+		c.values[fieldID] = values
+		c.n += len(values) - len(prev)
+	}
+}
+
+// reset removes all values from the cache.
+func (c *shardCache) reset() {
+	c.values = make(map[uint64][]*blockValue)
+	c.n = 0
+}
+
+// fieldCursor iterates over the values of a field by merging the cached values
+// with the values stored in blocks. Cached values replace stored values with
+// the same timestamp and sequence number and cached null values hide them.
+type fieldCursor struct {
+	blocks *blockCursor
+	cache  []*blockValue
+	index  int
+
+	bv *blockValue // current stored value
+	cv *blockValue // current cached value
+}
+
+// first moves the cursor to the first value in the time range.
+func (c *fieldCursor) first() *blockValue {
+	c.bv = c.blocks.first()
+
+	// Position the cache index before the start of the range.
+	tmin, tmax := c.blocks.tmin, c.blocks.tmax
+	if c.blocks.ascending {
+		c.index = sort.Search(len(c.cache), func(i int) bool { return c.cache[i].timestamp >= tmin }) - 1
+	} else {
+		c.index = sort.Search(len(c.cache), func(i int) bool { return c.cache[i].timestamp > tmax })
+	}
+	c.cv = c.nextCached()
+
+	return c.next()
+}
+
+// next moves the cursor to the next value in the time range.
+func (c *fieldCursor) next() *blockValue {
+	for c.bv != nil || c.cv != nil {
+		bv, cv := c.bv, c.cv
+
+		// Read from the store if its value comes first.
+		if cv == nil || (c.blocks.ascending && bv != nil && bv.before(cv)) || (!c.blocks.ascending && bv != nil && bv.after(cv)) {
+			c.bv = c.blocks.next()
+			return bv
+		}
+
+		// Otherwise read from the cache and skip the stored value it replaces.
+		if bv != nil && bv.timestamp == cv.timestamp && bv.seq == cv.seq {
+			c.bv = c.blocks.next()
+		}
+		c.cv = c.nextCached()
+		if cv.value.GetIsNull() {
+			continue
+		}
+		return cv
+	}
+	return nil
+}
+
+// seek moves the cursor to the next value which doesn't come before key in
+// the cursor's direction.
+func (c *fieldCursor) seek(key *blockValue) *blockValue {
+	ascending := c.blocks.ascending
+	if c.bv != nil && ((ascending && c.bv.before(key)) || (!ascending && c.bv.after(key))) {
+		c.bv = c.blocks.seek(key)
+	}
+	if c.cv != nil && ((ascending && c.cv.before(key)) || (!ascending && c.cv.after(key))) {
+		if ascending {
+			c.index = sort.Search(len(c.cache), func(i int) bool { return !c.cache[i].before(key) }) - 1
+		} else {
+			c.index = sort.Search(len(c.cache), func(i int) bool { return c.cache[i].after(key) })
+		}
+		c.cv = c.nextCached()
+	}
+	return c.next()
+}
+
+// nextCached moves to the next cached value in the time range.
+func (c *fieldCursor) nextCached() *blockValue {
+	if c.blocks.ascending {
+		c.index++
+	} else {
+		c.index--
+	}
+	if c.index < 0 || c.index >= len(c.cache) {
+		return nil
+	}
+
+	v := c.cache[c.index]
+	if v.timestamp < c.blocks.tmin || v.timestamp > c.blocks.tmax {
+		return nil
+	}
+	return v
+}
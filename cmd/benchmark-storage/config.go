@@ -7,7 +7,7 @@ import (
 	"time"
 
 	"code.google.com/p/goprotobuf/proto"
-	"github.com/influxdb/influxdb/datastore/storage"
+	"github.com/influxdb/influxdb"
 	"github.com/influxdb/influxdb/protocol"
 )
 
@@ -22,8 +22,8 @@ type Config struct {
 	threads      int
 }
 
-func (c *Config) MakeBatch() []storage.Write {
-	ws := make([]storage.Write, 0, c.batch)
+func (c *Config) MakeBatch() []influxdb.ShardStoreWrite {
+	ws := make([]influxdb.ShardStoreWrite, 0, c.batch)
 	for b := c.batch; b > 0; b-- {
 		key := bytes.NewBuffer(nil)
 		binary.Write(key, binary.BigEndian, int64(c.nextSeriesId))
@@ -39,7 +39,7 @@ func (c *Config) MakeBatch() []storage.Write {
 			panic(err)
 		}
 
-		ws = append(ws, storage.Write{
+		ws = append(ws, influxdb.ShardStoreWrite{
 			Key:   key.Bytes(),
 			Value: b,
 		})
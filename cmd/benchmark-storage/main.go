@@ -12,7 +12,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/influxdb/influxdb/datastore/storage"
+	"github.com/influxdb/influxdb"
 )
 
 func main() {
@@ -24,6 +24,7 @@ func main() {
 	path := flag.String("path", "/tmp", "Path to DB files")
 	threads := flag.Int("threads", 1, "Number of threads that write data")
 	rmdir := flag.Bool("remove-dir", false, "Remove directory before running the benchmark")
+	engines := flag.String("engines", strings.Join(influxdb.ShardStores(), ","), "Comma-separated list of shard stores to benchmark")
 	flag.Parse()
 
 	if *threads < 1 {
@@ -31,31 +32,27 @@ func main() {
 		os.Exit(2)
 	}
 
-	if *rmdir {
-		os.RemoveAll("/tmp/test-leveldb")
-		os.RemoveAll("/tmp/test-lmdb")
-		os.RemoveAll("/tmp/test-rocksdb")
-		os.RemoveAll("/tmp/test-hyperleveldb")
+	for _, name := range strings.Split(*engines, ",") {
+		if *rmdir {
+			os.RemoveAll(fmt.Sprintf("%s/test-%s", *path, name))
+		}
+		benchmark(name, Config{*points, *batchSize, *series, 0, 0, time.Now(), *path, *threads})
 	}
-
-	benchmark("lmdb", Config{*points, *batchSize, *series, 0, 0, time.Now(), *path, *threads})
-	benchmark("leveldb", Config{*points, *batchSize, *series, 0, 0, time.Now(), *path, *threads})
-	benchmark("rocksdb", Config{*points, *batchSize, *series, 0, 0, time.Now(), *path, *threads})
-	benchmark("hyperleveldb", Config{*points, *batchSize, *series, 0, 0, time.Now(), *path, *threads})
 }
 
 func benchmark(name string, c Config) {
-	init, err := storage.GetInitializer(name)
+	db, err := influxdb.NewShardStore(name)
 	if err != nil {
-		panic(err)
+		panic(fmt.Sprintf("%s: %s", name, err))
 	}
 
-	conf := init.NewConfig()
-	db, err := init.Initialize(fmt.Sprintf("%s/test-%s", c.path, name), conf)
-
+	path := fmt.Sprintf("%s/test-%s", c.path, name)
+	if err := db.Open(path); err != nil {
+		panic(err)
+	}
 	defer db.Close()
 
-	benchmarkDbCommon(db, c)
+	benchmarkDbCommon(db, name, path, c)
 }
 
 func getSize(path string) string {
@@ -67,8 +64,8 @@ func getSize(path string) string {
 	return strings.Fields(string(out))[0]
 }
 
-func benchmarkDbCommon(db storage.Engine, c Config) {
-	fmt.Printf("################ Benchmarking: %s\n", db.Name())
+func benchmarkDbCommon(db influxdb.ShardStore, name, path string, c Config) {
+	fmt.Printf("################ Benchmarking: %s\n", name)
 	start := time.Now()
 
 	count := benchmarkWrites(db, &c, c.points)
@@ -83,10 +80,10 @@ func benchmarkDbCommon(db storage.Engine, c Config) {
 	)
 
 	timeQuerying(db, c.series)
-	fmt.Printf("Size: %s\n", getSize(db.Path()))
+	fmt.Printf("Size: %s\n", getSize(path))
 	queryAndDelete(db, c.points, c.series)
 	timeQuerying(db, c.series)
-	fmt.Printf("Size: %s\n", getSize(db.Path()))
+	fmt.Printf("Size: %s\n", getSize(path))
 
 	start = time.Now()
 	count = benchmarkWrites(db, &c, c.points/2)
@@ -97,14 +94,14 @@ func benchmarkDbCommon(db storage.Engine, c Config) {
 		d,
 		float64(d.Nanoseconds())/1000.0/float64(count),
 	)
-	fmt.Printf("Size: %s\n", getSize(db.Path()))
+	fmt.Printf("Size: %s\n", getSize(path))
 }
 
-func timeQuerying(db storage.Engine, series int) {
+func timeQuerying(db influxdb.ShardStore, series int) {
 	s := time.Now()
 	count := 0
 	for series -= 1; series >= 0; series-- {
-		query(db, int64(series), func(itr storage.Iterator) {
+		query(db, int64(series), func(key []byte) {
 			count++
 		})
 	}
@@ -114,7 +111,7 @@ func timeQuerying(db storage.Engine, series int) {
 
 }
 
-func queryAndDelete(db storage.Engine, points, series int) {
+func queryAndDelete(db influxdb.ShardStore, points, series int) {
 	// query the database
 	startCount := points / series / 4
 	endCount := points * 3 / series / 4
@@ -126,31 +123,28 @@ func queryAndDelete(db storage.Engine, points, series int) {
 		count := 0
 		var delStart []byte
 		var delEnd []byte
-		query(db, int64(series), func(itr storage.Iterator) {
+		query(db, int64(series), func(key []byte) {
 			count++
 			if count == startCount {
-				delStart = itr.Key()
+				delStart = append([]byte(nil), key...)
 			}
 			if count == endCount-1 {
-				delEnd = itr.Key()
+				delEnd = append([]byte(nil), key...)
 				total += endCount - startCount
 			}
 		})
 
 		start := time.Now()
-		err := db.Del(delStart, delEnd)
+		err := db.DeleteRange(delStart, delEnd)
 		if err != nil {
 			panic(err)
 		}
 		d += time.Now().Sub(start)
 	}
 	fmt.Printf("Took %s to delete %d points\n", d, total)
-	start := time.Now()
-	db.Compact()
-	fmt.Printf("Took %s to compact\n", time.Now().Sub(start))
 }
 
-func query(db storage.Engine, s int64, yield func(storage.Iterator)) {
+func query(db influxdb.ShardStore, s int64, yield func(key []byte)) {
 	sb := bytes.NewBuffer(nil)
 	binary.Write(sb, binary.BigEndian, s)
 	binary.Write(sb, binary.BigEndian, int64(0))
@@ -160,24 +154,24 @@ func query(db storage.Engine, s int64, yield func(storage.Iterator)) {
 	binary.Write(eb, binary.BigEndian, int64(-1))
 	binary.Write(eb, binary.BigEndian, int64(-1))
 
-	itr := db.Iterator()
-	defer itr.Close()
-	count := 0
-	for itr.Seek(sb.Bytes()); itr.Valid(); itr.Next() {
-		key := itr.Key()
+	c, err := db.Cursor()
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+	for key, _ := c.Seek(sb.Bytes()); key != nil; key, _ = c.Next() {
 		if bytes.Compare(key, eb.Bytes()) > 0 {
 			break
 		}
 
-		count++
-		yield(itr)
+		yield(key)
 	}
 }
 
-func benchmarkWrites(db storage.Engine, c *Config, points int) int {
-	writesChan := make([]chan []storage.Write, c.threads)
+func benchmarkWrites(db influxdb.ShardStore, c *Config, points int) int {
+	writesChan := make([]chan []influxdb.ShardStoreWrite, c.threads)
 	for i := range writesChan {
-		writesChan[i] = make(chan []storage.Write, 1)
+		writesChan[i] = make(chan []influxdb.ShardStoreWrite, 1)
 	}
 
 	count := 0
@@ -210,7 +204,7 @@ func benchmarkWrites(db storage.Engine, c *Config, points int) int {
 		go func(idx int) {
 			defer wg.Done()
 			for w := range writesChan[idx] {
-				if err := db.BatchPut(w); err != nil {
+				if err := db.WriteBatch(w); err != nil {
 					panic(err)
 				}
 				fmt.Print(".")
@@ -7,11 +7,12 @@ import (
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/influxdb/influxdb"
 )
 
 const (
 	// DefaultPointBatchSize represents the number of points to batch together.
-	DefaultPointBatchSize = 100
+	DefaultPointBatchSize = influxdb.DefaultPointBatchSize
 
 	// DefaultPointBatchSize represents the number of writes to batch together.
 	DefaultWriteBatchSize = 10 * 1024 * 1024 // 10MB
@@ -43,6 +44,9 @@ type Config struct {
 		SSLPort     int      `toml:"ssl-port"`
 		SSLCertPath string   `toml:"ssl-cert"`
 		ReadTimeout Duration `toml:"read-timeout"`
+
+		// Parse queries with InfluxQL instead of the cgo query parser.
+		InfluxQL bool `toml:"influxql"`
 	} `toml:"api"`
 
 	InputPlugins struct {
@@ -112,7 +116,7 @@ type Config struct {
 // NewConfig returns an instance of Config with reasonable defaults.
 func NewConfig() *Config {
 	c := &Config{}
-	c.Storage.DefaultEngine = "leveldb"
+	c.Storage.DefaultEngine = influxdb.DefaultShardStore
 	c.Storage.RetentionSweepPeriod = Duration(10 * time.Minute)
 	c.Cluster.ConcurrentShardQueryLimit = DefaultConcurrentShardQueryLimit
 	c.Raft.Timeout = Duration(1 * time.Second)
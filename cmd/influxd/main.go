@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"time"
 
 	"code.google.com/p/log4go"
 	"github.com/influxdb/influxdb"
@@ -114,14 +115,34 @@ func start() error {
 		panic(err)
 	}
 
-	// Start server.
+	// Start server. Engines that aren't available, such as the leveldb and
+	// rocksdb engines of older configs, fall back to the default store.
 	s := influxdb.NewServer(client)
+	if err := s.SetDefaultShardStore(config.Storage.DefaultEngine); err != nil {
+		log4go.Warn("default engine %q isn't available, using %q", config.Storage.DefaultEngine, influxdb.DefaultShardStore)
+	}
+	s.SetPointBatchSize(config.PointBatchSize())
+	if err := s.Open(config.Storage.Dir); err != nil {
+		panic(err)
+	}
+
+	// Create the root cluster admin on a new cluster so that administrative
+	// statements can be authorized.
+	if err := s.CreateRootIfNotExists(); err != nil {
+		panic(err)
+	}
+
+	// Drop shards that have passed their retention period.
+	if err := s.StartRetentionService(time.Duration(config.Storage.RetentionSweepPeriod)); err != nil {
+		panic(err)
+	}
 
 	// TODO: startProfiler()
 	// TODO: -reset-root
 
 	// Initialize HTTP handler.
 	h := influxdb.NewHandler(s)
+	h.InfluxQL = config.HTTPAPI.InfluxQL
 
 	// Start HTTP server.
 	func() { log.Fatal(http.ListenAndServe(":8086", h)) }() // TODO: Change HTTP port.
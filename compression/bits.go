@@ -0,0 +1,70 @@
+package compression
+
+import (
+	"errors"
+)
+
+// ErrShortBuffer is returned when decoding a buffer that ends before all
+// values have been read.
+var ErrShortBuffer = errors.New("short buffer")
+
+// bitWriter appends individual bits to a byte slice.
+type bitWriter struct {
+	buf []byte
+	n   uint // number of bits used in the last byte
+}
+
+// writeBit appends a single bit.
+func (w *bitWriter) writeBit(bit bool) {
+	if w.n == 0 || w.n == 8 {
+		w.buf = append(w.buf, 0)
+		w.n = 0
+	}
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << (7 - w.n)
+	}
+	w.n++
+}
+
+// writeBits appends the lowest nbits bits of v, most significant bit first.
+func (w *bitWriter) writeBits(v uint64, nbits uint) {
+	for i := nbits; i > 0; i-- {
+		w.writeBit(v&(1<<(i-1)) != 0)
+	}
+}
+
+// bitReader reads individual bits from a byte slice.
+type bitReader struct {
+	buf []byte
+	i   int  // current byte
+	n   uint // number of bits read from the current byte
+}
+
+// readBit reads a single bit.
+func (r *bitReader) readBit() (bool, error) {
+	if r.n == 8 {
+		r.i, r.n = r.i+1, 0
+	}
+	if r.i >= len(r.buf) {
+		return false, ErrShortBuffer
+	}
+	bit := r.buf[r.i]&(1<<(7-r.n)) != 0
+	r.n++
+	return bit, nil
+}
+
+// readBits reads nbits bits into the lowest bits of the returned value.
+func (r *bitReader) readBits(nbits uint) (uint64, error) {
+	var v uint64
+	for i := uint(0); i < nbits; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
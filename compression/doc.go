@@ -0,0 +1,25 @@
+/*
+Package compression implements the encodings used to store columns of shard
+data in compressed blocks.
+
+Timestamps
+
+Timestamps are stored as the difference between consecutive deltas. Points
+written at a regular interval have a delta-of-delta of zero which is stored in
+a single bit. Other values are stored in the smallest of several fixed-size
+buckets.
+
+Floats
+
+Floating-point values are XOR'd with the previous value. Values that change
+slowly share their sign, exponent and high bits of the mantissa so only the
+meaningful bits between the leading and trailing zeros are stored.
+
+Integers
+
+Integers are stored as the zigzag-encoded difference from the previous value,
+written as a varint. Counters and small gauges typically need one or two bytes
+per value.
+
+*/
+package compression
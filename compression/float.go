@@ -0,0 +1,126 @@
+package compression
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// EncodeFloats returns the XOR encoding of a list of floating-point values.
+func EncodeFloats(a []float64) []byte {
+	w := &bitWriter{buf: appendUvarint(nil, uint64(len(a))), n: 8}
+	if len(a) == 0 {
+		return w.buf
+	}
+
+	// Write the first value in full.
+	prev := math.Float64bits(a[0])
+	w.writeBits(prev, 64)
+
+	// Write the XOR of each remaining value with its previous value. If the
+	// meaningful bits fit in the previous window of leading and trailing zeros
+	// then the window is reused.
+	var window bool
+	var leading, trailing uint
+	for _, f := range a[1:] {
+		v := math.Float64bits(f)
+		x := v ^ prev
+		prev = v
+
+		if x == 0 {
+			w.writeBit(false)
+			continue
+		}
+		w.writeBit(true)
+
+		l, t := leadingZeros(x), trailingZeros(x)
+		if window && l >= leading && t >= trailing {
+			w.writeBit(false)
+			w.writeBits(x>>trailing, 64-leading-trailing)
+			continue
+		}
+
+		window, leading, trailing = true, l, t
+		w.writeBit(true)
+		w.writeBits(uint64(leading), 6)
+		w.writeBits(uint64(64-leading-trailing-1), 6)
+		w.writeBits(x>>trailing, 64-leading-trailing)
+	}
+
+	return w.buf
+}
+
+// DecodeFloats decodes a list of values encoded by EncodeFloats.
+func DecodeFloats(b []byte) ([]float64, error) {
+	n, sz := binary.Uvarint(b)
+	if sz <= 0 {
+		return nil, ErrShortBuffer
+	} else if n == 0 {
+		return []float64{}, nil
+	} else if n > uint64(len(b)*8) {
+		return nil, ErrShortBuffer
+	}
+	r := &bitReader{buf: b[sz:]}
+
+	// Read the first value.
+	prev, err := r.readBits(64)
+	if err != nil {
+		return nil, err
+	}
+	a := make([]float64, 1, n)
+	a[0] = math.Float64frombits(prev)
+
+	// Read the remaining values.
+	var leading, trailing uint
+	for uint64(len(a)) < n {
+		// A zero bit means the value is unchanged.
+		if changed, err := r.readBit(); err != nil {
+			return nil, err
+		} else if !changed {
+			a = append(a, math.Float64frombits(prev))
+			continue
+		}
+
+		// Read a new window if the previous one isn't reused.
+		if newWindow, err := r.readBit(); err != nil {
+			return nil, err
+		} else if newWindow {
+			l, err := r.readBits(6)
+			if err != nil {
+				return nil, err
+			}
+			sig, err := r.readBits(6)
+			if err != nil {
+				return nil, err
+			}
+			leading, trailing = uint(l), 64-uint(l)-uint(sig+1)
+		}
+
+		// Read the meaningful bits and XOR with the previous value.
+		x, err := r.readBits(64 - leading - trailing)
+		if err != nil {
+			return nil, err
+		}
+		prev ^= x << trailing
+		a = append(a, math.Float64frombits(prev))
+	}
+
+	return a, nil
+}
+
+// leadingZeros returns the number of leading zero bits in x.
+func leadingZeros(x uint64) uint {
+	var n uint
+	for i := uint(63); i < 64 && x&(1<<i) == 0; i-- {
+		n++
+	}
+	return n
+}
+
+// trailingZeros returns the number of trailing zero bits in x.
+func trailingZeros(x uint64) uint {
+	var n uint
+	for i := uint(0); i < 64 && x&(1<<i) == 0; i++ {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,67 @@
+package compression_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/influxdb/influxdb/compression"
+)
+
+// Ensure floats can be encoded and decoded.
+func TestFloats(t *testing.T) {
+	var tests = []struct {
+		a   []float64
+		max int // maximum encoded size, in bytes
+	}{
+		{a: []float64{}, max: 1},
+		{a: []float64{1.5}, max: 9},
+		{a: []float64{12, 12, 12, 12, 12, 12, 12, 12}, max: 10},
+		{a: []float64{12, 12, 24, 13, 24, 24, 25, 24}, max: 18},
+		{a: []float64{-1, 0, math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(1), math.Inf(-1)}, max: 60},
+	}
+
+	for i, tt := range tests {
+		b := compression.EncodeFloats(tt.a)
+		if len(b) > tt.max {
+			t.Errorf("%d. encoded size: %d > %d", i, len(b), tt.max)
+		}
+		if a, err := compression.DecodeFloats(b); err != nil {
+			t.Errorf("%d. decode: %s", i, err)
+		} else if !reflect.DeepEqual(tt.a, a) {
+			t.Errorf("%d. mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.a, a)
+		}
+	}
+}
+
+// Ensure NaN values keep their bit pattern.
+func TestFloats_NaN(t *testing.T) {
+	a, err := compression.DecodeFloats(compression.EncodeFloats([]float64{1, math.NaN(), 2}))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a) != 3 || a[0] != 1 || !math.IsNaN(a[1]) || a[2] != 2 {
+		t.Fatalf("unexpected values: %#v", a)
+	}
+}
+
+// Ensure arbitrary floats round trip through the encoding.
+func TestFloats_Quick(t *testing.T) {
+	if err := quick.Check(func(a []float64) bool {
+		if a == nil {
+			a = []float64{}
+		}
+		other, err := compression.DecodeFloats(compression.EncodeFloats(a))
+		return err == nil && reflect.DeepEqual(a, other)
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure decoding a truncated buffer returns an error.
+func TestDecodeFloats_ErrShortBuffer(t *testing.T) {
+	b := compression.EncodeFloats([]float64{1, 2, 3, 4})
+	if _, err := compression.DecodeFloats(b[:len(b)-2]); err != compression.ErrShortBuffer {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
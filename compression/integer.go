@@ -0,0 +1,42 @@
+package compression
+
+import (
+	"encoding/binary"
+)
+
+// EncodeIntegers returns the encoding of a list of integers. Each value is
+// stored as the zigzag varint of its difference from the previous value.
+func EncodeIntegers(a []int64) []byte {
+	b := appendUvarint(make([]byte, 0, len(a)+binary.MaxVarintLen64), uint64(len(a)))
+	var prev int64
+	for _, v := range a {
+		b = appendVarint(b, v-prev)
+		prev = v
+	}
+	return b
+}
+
+// DecodeIntegers decodes a list of integers encoded by EncodeIntegers.
+func DecodeIntegers(b []byte) ([]int64, error) {
+	n, sz := binary.Uvarint(b)
+	if sz <= 0 {
+		return nil, ErrShortBuffer
+	} else if n > uint64(len(b)) {
+		return nil, ErrShortBuffer
+	}
+	b = b[sz:]
+
+	a := make([]int64, 0, n)
+	var prev int64
+	for uint64(len(a)) < n {
+		d, sz := binary.Varint(b)
+		if sz <= 0 {
+			return nil, ErrShortBuffer
+		}
+		b = b[sz:]
+
+		prev += d
+		a = append(a, prev)
+	}
+	return a, nil
+}
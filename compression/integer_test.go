@@ -0,0 +1,56 @@
+package compression_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/influxdb/influxdb/compression"
+)
+
+// Ensure integers can be encoded and decoded.
+func TestIntegers(t *testing.T) {
+	var tests = []struct {
+		a   []int64
+		max int // maximum encoded size, in bytes
+	}{
+		{a: []int64{}, max: 1},
+		{a: []int64{100, 101, 102, 103, 105, 108}, max: 8},
+		{a: []int64{-3, 3, -3, 3}, max: 5},
+		{a: []int64{math.MinInt64, math.MaxInt64, 0}, max: 31},
+	}
+
+	for i, tt := range tests {
+		b := compression.EncodeIntegers(tt.a)
+		if len(b) > tt.max {
+			t.Errorf("%d. encoded size: %d > %d", i, len(b), tt.max)
+		}
+		if a, err := compression.DecodeIntegers(b); err != nil {
+			t.Errorf("%d. decode: %s", i, err)
+		} else if !reflect.DeepEqual(tt.a, a) {
+			t.Errorf("%d. mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.a, a)
+		}
+	}
+}
+
+// Ensure arbitrary integers round trip through the encoding.
+func TestIntegers_Quick(t *testing.T) {
+	if err := quick.Check(func(a []int64) bool {
+		if a == nil {
+			a = []int64{}
+		}
+		other, err := compression.DecodeIntegers(compression.EncodeIntegers(a))
+		return err == nil && reflect.DeepEqual(a, other)
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure decoding a truncated buffer returns an error.
+func TestDecodeIntegers_ErrShortBuffer(t *testing.T) {
+	b := compression.EncodeIntegers([]int64{1, 2, 3, 100000})
+	if _, err := compression.DecodeIntegers(b[:len(b)-2]); err != compression.ErrShortBuffer {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
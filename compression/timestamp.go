@@ -0,0 +1,118 @@
+package compression
+
+import (
+	"encoding/binary"
+)
+
+// EncodeTimestamps returns the delta-of-delta encoding of a list of timestamps.
+// Timestamps do not need to be sorted but sorted timestamps compress best.
+func EncodeTimestamps(a []int64) []byte {
+	w := &bitWriter{buf: appendUvarint(nil, uint64(len(a))), n: 8}
+	if len(a) == 0 {
+		return w.buf
+	}
+
+	// Write the first timestamp in full.
+	w.writeBits(uint64(a[0]), 64)
+
+	// Write the change in delta for each remaining timestamp.
+	var delta int64
+	for i := 1; i < len(a); i++ {
+		d := a[i] - a[i-1]
+		dod := d - delta
+		delta = d
+
+		switch {
+		case dod == 0:
+			w.writeBit(false)
+		case fitsBits(dod, 7):
+			w.writeBits(0x2, 2)
+			w.writeBits(uint64(dod), 7)
+		case fitsBits(dod, 9):
+			w.writeBits(0x6, 3)
+			w.writeBits(uint64(dod), 9)
+		case fitsBits(dod, 12):
+			w.writeBits(0xe, 4)
+			w.writeBits(uint64(dod), 12)
+		default:
+			w.writeBits(0xf, 4)
+			w.writeBits(uint64(dod), 64)
+		}
+	}
+
+	return w.buf
+}
+
+// DecodeTimestamps decodes a list of timestamps encoded by EncodeTimestamps.
+func DecodeTimestamps(b []byte) ([]int64, error) {
+	n, sz := binary.Uvarint(b)
+	if sz <= 0 {
+		return nil, ErrShortBuffer
+	} else if n == 0 {
+		return []int64{}, nil
+	} else if n > uint64(len(b)*8) {
+		return nil, ErrShortBuffer
+	}
+	r := &bitReader{buf: b[sz:]}
+
+	// Read the first timestamp.
+	v, err := r.readBits(64)
+	if err != nil {
+		return nil, err
+	}
+	a := make([]int64, 1, n)
+	a[0] = int64(v)
+
+	// Read the remaining timestamps.
+	var delta int64
+	for uint64(len(a)) < n {
+		// Count the leading one bits to determine the size of the value.
+		var ones int
+		for ones < 4 {
+			bit, err := r.readBit()
+			if err != nil {
+				return nil, err
+			} else if !bit {
+				break
+			}
+			ones++
+		}
+
+		var dod int64
+		if ones > 0 {
+			nbits := []uint{0, 7, 9, 12, 64}[ones]
+			v, err := r.readBits(nbits)
+			if err != nil {
+				return nil, err
+			}
+			dod = signExtend(v, nbits)
+		}
+
+		delta += dod
+		a = append(a, a[len(a)-1]+delta)
+	}
+
+	return a, nil
+}
+
+// fitsBits returns true if v can be stored as a signed integer in nbits bits.
+func fitsBits(v int64, nbits uint) bool {
+	return v >= -(1<<(nbits-1)) && v < 1<<(nbits-1)
+}
+
+// signExtend converts the lowest nbits bits of v to a signed integer.
+func signExtend(v uint64, nbits uint) int64 {
+	return int64(v<<(64-nbits)) >> (64 - nbits)
+}
+
+// appendUvarint appends the varint encoding of v to b.
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendVarint appends the zigzag varint encoding of v to b.
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
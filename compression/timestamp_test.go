@@ -0,0 +1,58 @@
+package compression_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/influxdb/influxdb/compression"
+)
+
+// Ensure timestamps can be encoded and decoded.
+func TestTimestamps(t *testing.T) {
+	var tests = []struct {
+		a   []int64
+		max int // maximum encoded size, in bytes
+	}{
+		{a: []int64{}, max: 1},
+		{a: []int64{0}, max: 9},
+		{a: []int64{1000, 2000, 3000, 4000, 5000, 6000, 7000, 8000}, max: 12},
+		{a: []int64{1000, 2010, 2990, 4000, 5100, 5900, 7000}, max: 20},
+		{a: []int64{-5, 5, -5, 5}, max: 13},
+		{a: []int64{math.MinInt64, math.MaxInt64, 0, math.MinInt64}, max: 36},
+	}
+
+	for i, tt := range tests {
+		b := compression.EncodeTimestamps(tt.a)
+		if len(b) > tt.max {
+			t.Errorf("%d. encoded size: %d > %d", i, len(b), tt.max)
+		}
+		if a, err := compression.DecodeTimestamps(b); err != nil {
+			t.Errorf("%d. decode: %s", i, err)
+		} else if !reflect.DeepEqual(tt.a, a) {
+			t.Errorf("%d. mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.a, a)
+		}
+	}
+}
+
+// Ensure arbitrary timestamps round trip through the encoding.
+func TestTimestamps_Quick(t *testing.T) {
+	if err := quick.Check(func(a []int64) bool {
+		if a == nil {
+			a = []int64{}
+		}
+		other, err := compression.DecodeTimestamps(compression.EncodeTimestamps(a))
+		return err == nil && reflect.DeepEqual(a, other)
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure decoding a truncated buffer returns an error.
+func TestDecodeTimestamps_ErrShortBuffer(t *testing.T) {
+	b := compression.EncodeTimestamps([]int64{1, 2, 3, 100000})
+	if _, err := compression.DecodeTimestamps(b[:len(b)-2]); err != compression.ErrShortBuffer {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package influxdb
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"regexp"
 	"sort"
 	"sync"
@@ -11,6 +12,7 @@ import (
 	"code.google.com/p/goprotobuf/proto"
 	"code.google.com/p/log4go"
 	"github.com/influxdb/influxdb/engine"
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
 	"github.com/influxdb/influxdb/parser"
 	"github.com/influxdb/influxdb/protocol"
@@ -27,6 +29,8 @@ type Database struct {
 	shards map[uint64]*Shard      // shards by id
 	series map[string]*Series     // series by name
 
+	continuousQueries map[uint64]*ContinuousQuery // continuous queries by id
+
 	maxFieldID uint64 // largest field id in use
 }
 
@@ -38,6 +42,8 @@ func newDatabase(s *Server) *Database {
 		spaces: make(map[string]*ShardSpace),
 		shards: make(map[uint64]*Shard),
 		series: make(map[string]*Series),
+
+		continuousQueries: make(map[uint64]*ContinuousQuery),
 	}
 }
 
@@ -111,8 +117,9 @@ func (db *Database) applyCreateUser(username, password string, permissions []str
 	// Create the user.
 	db.users[username] = &DBUser{
 		CommonUser: CommonUser{
-			Name: username,
-			Hash: string(hash),
+			Name:     username,
+			Hash:     string(hash),
+			CacheKey: db.name + "%" + username,
 		},
 		DB:       db.name,
 		ReadFrom: rmatcher,
@@ -178,8 +185,47 @@ func (db *Database) applyChangePassword(username, newPassword string) error {
 		return err
 	}
 
-	// Update user password hash.
-	u.Hash = string(hash)
+	// Update user password hash and drop the cached password.
+	u.ChangePassword(string(hash))
+
+	return nil
+}
+
+// ChangePermissions sets the read and write permissions for a user in the
+// database. Permissions are regular expressions matched against series names.
+// A blank permission denies all access.
+func (db *Database) ChangePermissions(username, readFrom, writeTo string) error {
+	c := &dbUserSetPermissionsCommand{
+		Database: db.Name(),
+		Username: username,
+		ReadFrom: readFrom,
+		WriteTo:  writeTo,
+	}
+	_, err := db.server.broadcast(dbUserSetPermissionsMessageType, c)
+	return err
+}
+
+func (db *Database) applyChangePermissions(username, readFrom, writeTo string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Validate user.
+	u := db.users[username]
+	if username == "" {
+		return ErrUsernameRequired
+	} else if u == nil {
+		return ErrUserNotFound
+	}
+
+	// Validate permissions.
+	if _, err := regexp.Compile(readFrom); err != nil {
+		return err
+	} else if _, err := regexp.Compile(writeTo); err != nil {
+		return err
+	}
+
+	// Update user permissions.
+	u.ChangePermissions(readFrom, writeTo)
 
 	return nil
 }
@@ -191,6 +237,18 @@ func (db *Database) ShardSpace(name string) *ShardSpace {
 	return db.spaces[name]
 }
 
+// ShardSpaces returns a list of all shard spaces, sorted by name.
+func (db *Database) ShardSpaces() []*ShardSpace {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var a ShardSpaces
+	for _, ss := range db.spaces {
+		a = append(a, ss)
+	}
+	sort.Sort(a)
+	return a
+}
+
 // shardSpaceBySeries returns a shard space that matches a series name.
 func (db *Database) shardSpaceBySeries(name string) *ShardSpace {
 	for _, ss := range db.spaces {
@@ -210,6 +268,7 @@ func (db *Database) CreateShardSpace(ss *ShardSpace) error {
 		Duration:  ss.Duration,
 		ReplicaN:  ss.ReplicaN,
 		SplitN:    ss.SplitN,
+		Engine:    ss.Engine,
 	}
 	if ss.Regex != nil {
 		c.Regex = ss.Regex.String()
@@ -218,7 +277,7 @@ func (db *Database) CreateShardSpace(ss *ShardSpace) error {
 	return err
 }
 
-func (db *Database) applyCreateShardSpace(name, regex string, retention, duration time.Duration, replicaN, splitN uint32) error {
+func (db *Database) applyCreateShardSpace(name, regex string, retention, duration time.Duration, replicaN, splitN uint32, engine string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -227,6 +286,8 @@ func (db *Database) applyCreateShardSpace(name, regex string, retention, duratio
 		return ErrShardSpaceNameRequired
 	} else if db.spaces[name] != nil {
 		return ErrShardSpaceExists
+	} else if engine != "" && shardStores[engine] == nil {
+		return ErrShardStoreNotFound
 	}
 
 	// Compile regex.
@@ -240,11 +301,59 @@ func (db *Database) applyCreateShardSpace(name, regex string, retention, duratio
 		Duration:  duration,
 		ReplicaN:  replicaN,
 		SplitN:    splitN,
+		Engine:    engine,
 	}
 
 	return nil
 }
 
+// UpdateShardSpace changes the settings of an existing shard space.
+// Existing shards are kept and new settings apply to shards created afterward.
+func (db *Database) UpdateShardSpace(ss *ShardSpace) error {
+	c := &updateShardSpaceCommand{
+		Database:  db.Name(),
+		Name:      ss.Name,
+		Retention: ss.Retention,
+		Duration:  ss.Duration,
+		ReplicaN:  ss.ReplicaN,
+		SplitN:    ss.SplitN,
+		Engine:    ss.Engine,
+	}
+	if ss.Regex != nil {
+		c.Regex = ss.Regex.String()
+	}
+	_, err := db.server.broadcast(updateShardSpaceMessageType, c)
+	return err
+}
+
+func (db *Database) applyUpdateShardSpace(name, regex string, retention, duration time.Duration, replicaN, splitN uint32, engine string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Validate shard space.
+	ss := db.spaces[name]
+	if name == "" {
+		return ErrShardSpaceNameRequired
+	} else if ss == nil {
+		return ErrShardSpaceNotFound
+	} else if engine != "" && shardStores[engine] == nil {
+		return ErrShardStoreNotFound
+	}
+
+	// Compile regex.
+	re := regexp.MustCompile(regex)
+
+	// Update the space settings.
+	ss.Regex = re
+	ss.Retention = retention
+	ss.Duration = duration
+	ss.ReplicaN = replicaN
+	ss.SplitN = splitN
+	ss.Engine = engine
+
+	return nil
+}
+
 // DeleteShardSpace removes a shard space from the database.
 func (db *Database) DeleteShardSpace(name string) error {
 	c := &deleteShardSpaceCommand{Database: db.Name(), Name: name}
@@ -281,13 +390,15 @@ func (db *Database) shard(id uint64) *Shard {
 }
 
 // CreateShardIfNotExists creates a shard for a shard space for a given timestamp.
+// The server's default store is sent with the request so that every server
+// creates the shard with the same store.
 func (db *Database) CreateShardIfNotExists(space string, timestamp time.Time) error {
-	c := &createShardIfNotExistsSpaceCommand{Database: db.name, Space: space, Timestamp: timestamp}
+	c := &createShardIfNotExistsSpaceCommand{Database: db.name, Space: space, Timestamp: timestamp, Engine: db.server.defaultShardStore()}
 	_, err := db.server.broadcast(createShardIfNotExistsMessageType, c)
 	return err
 }
 
-func (db *Database) applyCreateShardIfNotExists(id uint64, space string, timestamp time.Time) (error, bool) {
+func (db *Database) applyCreateShardIfNotExists(id uint64, space string, timestamp time.Time, engine string) (error, bool) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -310,6 +421,11 @@ func (db *Database) applyCreateShardIfNotExists(id uint64, space string, timesta
 	s := newShard()
 	s.ID, s.StartTime, s.EndTime = id, startTime, endTime
 
+	// Use the shard space's store or the default of the requesting server.
+	if s.Engine = ss.Engine; s.Engine == "" {
+		s.Engine = engine
+	}
+
 	// Open shard.
 	if err := s.open(db.server.shardPath(s.ID)); err != nil {
 		panic("unable to open shard: " + err.Error())
@@ -321,6 +437,66 @@ func (db *Database) applyCreateShardIfNotExists(id uint64, space string, timesta
 	return nil, true
 }
 
+// expiredShardIDs returns the ids of shards that ended before the retention
+// period of their shard space.
+func (db *Database) expiredShardIDs(now time.Time) (a []uint64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, ss := range db.spaces {
+		if ss.Retention == 0 {
+			continue
+		}
+		for _, s := range ss.Shards {
+			if s.EndTime.Add(ss.Retention).Before(now) {
+				a = append(a, s.ID)
+			}
+		}
+	}
+	return
+}
+
+// DeleteShard removes a shard and its data from every server.
+func (db *Database) DeleteShard(id uint64) error {
+	c := &deleteShardCommand{Database: db.Name(), ID: id}
+	_, err := db.server.broadcast(deleteShardMessageType, c)
+	return err
+}
+
+func (db *Database) applyDeleteShard(id uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Find the shard and remove it from its shard space.
+	var s *Shard
+	for _, ss := range db.spaces {
+		for i, sh := range ss.Shards {
+			if sh.ID == id {
+				s = sh
+				ss.Shards = append(ss.Shards[:i], ss.Shards[i+1:]...)
+				break
+			}
+		}
+	}
+	if s == nil {
+		return ErrShardNotFound
+	}
+	delete(db.shards, id)
+
+	// Close the store and remove the data. The shard is already removed so
+	// failures are only logged.
+	if err := s.close(); err != nil {
+		warnf("close shard(%d): %s", id, err)
+	}
+	if err := os.RemoveAll(db.server.shardPath(id)); err != nil {
+		warnf("remove shard(%d): %s", id, err)
+	}
+	if err := os.RemoveAll(db.server.shardPath(id) + ".wal"); err != nil {
+		warnf("remove shard log(%d): %s", id, err)
+	}
+
+	return nil
+}
+
 // WriteSeries writes series data to the database.
 func (db *Database) WriteSeries(series *protocol.Series) error {
 	// Find shard space matching the series and split points by shard.
@@ -339,7 +515,7 @@ func (db *Database) WriteSeries(series *protocol.Series) error {
 
 	// Request shard creation for timestamps for missing shards.
 	for _, p := range unassigned {
-		timestamp := time.Unix(0, p.GetTimestamp())
+		timestamp := time.Unix(0, p.GetTimestamp()*int64(time.Microsecond))
 		if err := db.CreateShardIfNotExists(space.Name, timestamp); err != nil {
 			return fmt.Errorf("create shard(%s/%d): %s", space.Name, timestamp.Format(time.RFC3339Nano), err)
 		}
@@ -449,13 +625,19 @@ func (db *Database) ExecuteQuery(u parser.User, q *parser.Query, p engine.Proces
 	//	return err
 	//}
 
-	// TODO: ListSeries
-	// TODO: DropSeries
-	// TODO: DeleteQuery
-
 	switch q.Type() {
 	case parser.Select:
 		return db.executeSelectQuery(u, spec, p)
+	case parser.Delete:
+		return db.executeDeleteQuery(spec)
+	case parser.DropSeries:
+		return db.DropSeries(q.DropSeriesQuery.GetTableName())
+	case parser.ListSeries:
+		var re *regexp.Regexp
+		if lq := q.GetListSeriesQuery(); lq.HasRegex() {
+			re = lq.GetRegex()
+		}
+		return db.executeListSeriesQuery(re, p)
 	default:
 		return ErrInvalidQuery
 	}
@@ -465,6 +647,18 @@ func (db *Database) ExecuteQuery(u parser.User, q *parser.Query, p engine.Proces
 func (db *Database) executeSelectQuery(u parser.User, spec *parser.QuerySpec, p engine.Processor) error {
 	q := spec.SelectQuery()
 
+	// Window functions, forecasts and having conditions are computed by
+	// the coordinator over the results of the query without them, which is
+	// the query sent to the shards.
+	if engine.HasPostAggregation(q) {
+		pe, inner, err := engine.NewPostAggregationEngine(q, engine.NewPassthroughEngineWithLimit(p, 100, q.Limit))
+		if err != nil {
+			return fmt.Errorf("new post aggregation engine: %s", err)
+		}
+		p, q = pe, inner
+		spec = parser.NewQuerySpec(u, spec.Database(), &parser.Query{SelectQuery: inner})
+	}
+
 	// Find series matching query.
 	series := db.seriesByValues(parser.TableNames(q.FromClause.Names).Names())
 
@@ -488,25 +682,41 @@ func (db *Database) executeSelectQuery(u parser.User, spec *parser.QuerySpec, p
 
 	// If "group by" interval lines up with shard duration and from clause
 	// is not "inner join" or "merge" then we can aggregate locally.
-	local := true
+	// Otherwise shards compute partial aggregates if every aggregate can
+	// be merged. The mode is decided once so every shard agrees on it.
+	mode := aggregateLocal
 	for _, s := range shards {
 		if !spec.CanAggregateLocally(s.Duration()) {
-			local = false
+			mode = aggregateNone
 			break
 		}
 	}
+	if mode == aggregateNone && q.FromClause.Type == parser.FromClauseArray && engine.CanAggregatePartially(q) {
+		mode = aggregatePartial
+	}
 
 	// If aggregating locally then use PassthroughEngineWithLimit processor.
+	// If aggregating partially then merge the states from each shard.
 	// Otherwise create a new query engine with a list of shard ids.
-	if local {
+	var err error
+	switch mode {
+	case aggregateLocal:
 		p = engine.NewPassthroughEngineWithLimit(p, 100, q.Limit)
-	} else {
-		var err error
+	case aggregatePartial:
+		if p, err = engine.NewPartialMergeEngine(p, q); err != nil {
+			return fmt.Errorf("new partial merge engine: %s", err)
+		}
+	default:
 		if p, err = engine.NewQueryEngine(p, q, Shards(shards).IDs()); err != nil {
 			return fmt.Errorf("new query engine: %s", err)
 		}
 	}
 
+	// Read the number of points to yield at a time from the shards.
+	db.server.mu.RLock()
+	batchSize := db.server.pointBatchSize
+	db.server.mu.RUnlock()
+
 	// Create MergeChannelProcessor.
 	mcp := NewMergeChannelProcessor(p, 1)
 	go mcp.ProcessChannels()
@@ -522,15 +732,24 @@ func (db *Database) executeSelectQuery(u parser.User, spec *parser.QuerySpec, p
 				}
 
 				// If we have a set of matching fields then create a channel.
-				c, err := mcp.NextChannel(1000)
+				aliases := q.GetTableAliases(series.Name)
+				c, done, err := mcp.NextChannel(1000, aliases...)
 				if err != nil {
 					mcp.Close()
 					return fmt.Errorf("next channel: %s", err)
 				}
 
+				// Don't query the shard if the series already has all the
+				// data it needs from previous shards (e.g. a LIMIT was hit).
+				if mcp.Stopped(aliases...) {
+					log4go.Debug("SKIPPING: shard: %d, series: %s", i, series.Name)
+					c <- &protocol.Response{Type: protocol.Response_END_STREAM.Enum()}
+					continue
+				}
+
 				// We query shards for data and stream them to query processor
 				log4go.Debug("QUERYING: shard: %d", i)
-				go s.query(spec, series.Name, fields, c)
+				go s.query(spec, series.Name, fields, mode, batchSize, c, done)
 			}
 		}
 	}
@@ -544,6 +763,370 @@ func (db *Database) executeSelectQuery(u parser.User, spec *parser.QuerySpec, p
 	return p.Close()
 }
 
+// executeDeleteQuery removes the values of each matching series within the
+// query's time range. Deletes are published to each shard's topic so every
+// replica applies them in the same order as writes.
+func (db *Database) executeDeleteQuery(spec *parser.QuerySpec) error {
+	q := spec.DeleteQuery()
+	tmin, tmax := spec.GetStartTime(), spec.GetEndTime()
+
+	// Group the field ids of matching series by shard.
+	db.mu.Lock()
+	fieldIDs := make(map[uint64][]uint64)
+	for _, series := range db.seriesByValues(parser.TableNames(q.FromClause.Names).Names()) {
+		for _, ss := range db.spacesBySeries([]*Series{series}) {
+			for _, s := range shardsInRange(ss.Shards, tmin, tmax) {
+				for _, f := range series.Fields {
+					fieldIDs[s.ID] = append(fieldIDs[s.ID], f.ID)
+				}
+			}
+		}
+	}
+	name := db.name
+	db.mu.Unlock()
+
+	// Publish a delete to each shard.
+	for shardID, ids := range fieldIDs {
+		c := &deleteSeriesCommand{Database: name, ShardID: shardID, FieldIDs: ids, StartTime: tmin, EndTime: tmax}
+		if err := db.publishDeleteSeries(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publishDeleteSeries publishes a delete to the topic of a shard and waits
+// until it is applied locally. Deletes are published to the shard's topic so
+// they are ordered with the writes to the shard.
+func (db *Database) publishDeleteSeries(c *deleteSeriesCommand) error {
+	m := &messaging.Message{
+		Type:    deleteSeriesMessageType,
+		TopicID: c.ShardID,
+		Data:    mustMarshalJSON(c),
+	}
+	index, err := db.server.client.Publish(m)
+	if err != nil {
+		return err
+	}
+	return db.server.sync(index)
+}
+
+func (db *Database) applyDeleteSeries(shardID uint64, fieldIDs []uint64, tmin, tmax time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Find shard.
+	s := db.shard(shardID)
+	if s == nil {
+		return ErrShardNotFound
+	}
+
+	return s.deleteSeries(fieldIDs, tmin, tmax)
+}
+
+// DropSeries removes a series, its fields and all of its data. The data is
+// deleted from each shard first and the series is removed once every shard
+// has applied the delete.
+func (db *Database) DropSeries(name string) error {
+	// Find the shards the series could be stored in.
+	db.mu.Lock()
+	series := db.series[name]
+	if series == nil {
+		db.mu.Unlock()
+		return ErrSeriesNotFound
+	}
+	var fieldIDs []uint64
+	for _, f := range series.Fields {
+		fieldIDs = append(fieldIDs, f.ID)
+	}
+	var commands []*deleteSeriesCommand
+	for _, ss := range db.spacesBySeries([]*Series{series}) {
+		for _, s := range ss.Shards {
+			commands = append(commands, &deleteSeriesCommand{Database: db.name, ShardID: s.ID, FieldIDs: fieldIDs, StartTime: s.StartTime, EndTime: s.EndTime})
+		}
+	}
+	db.mu.Unlock()
+
+	// Delete the series data from every shard. Shards dropped in the meantime
+	// don't hold any data of the series anymore.
+	for _, c := range commands {
+		if err := db.publishDeleteSeries(c); err != nil && err != ErrShardNotFound {
+			return fmt.Errorf("delete series(%d): %s", c.ShardID, err)
+		}
+	}
+
+	// Remove the series and its fields.
+	c := &dropSeriesCommand{Database: db.Name(), Name: name}
+	_, err := db.server.broadcast(dropSeriesMessageType, c)
+	return err
+}
+
+func (db *Database) applyDropSeries(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Validate series.
+	if db.series[name] == nil {
+		return ErrSeriesNotFound
+	}
+
+	// Remove series and its fields.
+	delete(db.series, name)
+	return nil
+}
+
+// Series returns a series by name.
+// Returns nil if the series does not exist.
+func (db *Database) Series(name string) *Series {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.series[name]
+}
+
+// executeListSeriesQuery writes the names of all series matching a regex to
+// the processor. All series are listed if the regex is nil.
+func (db *Database) executeListSeriesQuery(re *regexp.Regexp, p engine.Processor) error {
+	db.mu.Lock()
+	var names []string
+	for name := range db.series {
+		if re == nil || re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	db.mu.Unlock()
+	sort.Strings(names)
+
+	s := newListSeries("series", "name")
+	for _, name := range names {
+		s.Points = append(s.Points, newListPoint(name))
+	}
+	_, err := p.Yield(s)
+	return err
+}
+
+// executeListFieldsQuery writes the fields of a series to the processor.
+func (db *Database) executeListFieldsQuery(name string, p engine.Processor) error {
+	series := db.Series(name)
+	if series == nil {
+		return ErrSeriesNotFound
+	}
+
+	s := newListSeries("fields", "id", "name")
+	for _, f := range series.Fields {
+		s.Points = append(s.Points, newListPoint(f.ID, f.Name))
+	}
+	_, err := p.Yield(s)
+	return err
+}
+
+// executeListShardSpacesQuery writes the settings of each shard space to the processor.
+func (db *Database) executeListShardSpacesQuery(p engine.Processor) error {
+	s := newListSeries("shard_spaces", "name", "regex", "duration", "retention", "replication", "split")
+	for _, ss := range db.ShardSpaces() {
+		s.Points = append(s.Points, newListPoint(ss.Name, ss.Regex.String(), influxql.FormatDuration(ss.Duration), influxql.FormatDuration(ss.Retention), ss.ReplicaN, ss.SplitN))
+	}
+	_, err := p.Yield(s)
+	return err
+}
+
+// executeListShardsQuery writes the time range of each shard to the processor.
+// Times are in microseconds since the epoch.
+func (db *Database) executeListShardsQuery(p engine.Processor) error {
+	s := newListSeries("shards", "id", "space", "start_time", "end_time")
+	for _, ss := range db.ShardSpaces() {
+		for _, sh := range ss.Shards {
+			s.Points = append(s.Points, newListPoint(sh.ID, ss.Name, sh.StartTime.UnixNano()/int64(time.Microsecond), sh.EndTime.UnixNano()/int64(time.Microsecond)))
+		}
+	}
+	_, err := p.Yield(s)
+	return err
+}
+
+// executeListUsersQuery writes the users and their permissions to the processor.
+func (db *Database) executeListUsersQuery(p engine.Processor) error {
+	s := newListSeries("users", "name", "read_from", "write_to")
+	for _, u := range db.Users() {
+		s.Points = append(s.Points, newListPoint(u.Name, u.GetReadPermission(), u.GetWritePermission()))
+	}
+	_, err := p.Yield(s)
+	return err
+}
+
+// ContinuousQueries returns a list of all continuous queries, sorted by id.
+func (db *Database) ContinuousQueries() []*ContinuousQuery {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var a continuousQueries
+	for _, cq := range db.continuousQueries {
+		a = append(a, cq)
+	}
+	sort.Sort(a)
+	return a
+}
+
+// CreateContinuousQuery registers a continuous query with the database.
+// The query must be a selection with a target series and a GROUP BY time
+// interval. Unless NO BACKFILL is set, the query is also run over the data
+// that exists before its creation. Returns the id of the new continuous query.
+func (db *Database) CreateContinuousQuery(query string) (uint64, error) {
+	c := &createContinuousQueryCommand{Database: db.Name(), Query: query, Timestamp: time.Now().UTC(), Backfill: db.minShardStartTime()}
+	return db.server.broadcast(createContinuousQueryMessageType, c)
+}
+
+func (db *Database) applyCreateContinuousQuery(id uint64, query string, timestamp, backfill time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Parse and validate query.
+	cq, err := newContinuousQuery(id, query)
+	if err != nil {
+		return err
+	}
+
+	// Intervals before the creation time are only processed by a backfill,
+	// which starts at the earliest shard when the query was created.
+	cq.db = db
+	cq.lastRun = timestamp.Truncate(cq.interval())
+	if !cq.query.NoBackfill && !backfill.IsZero() && backfill.Before(cq.lastRun) {
+		cq.lastRun = backfill.Truncate(cq.interval())
+	}
+	db.continuousQueries[id] = cq
+
+	return nil
+}
+
+// minShardStartTime returns the start time of the earliest shard.
+// Returns the zero time if the database has no shards.
+func (db *Database) minShardStartTime() (t time.Time) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, s := range db.shards {
+		if t.IsZero() || s.StartTime.Before(t) {
+			t = s.StartTime
+		}
+	}
+	return
+}
+
+// DeleteContinuousQuery removes a continuous query from the database.
+func (db *Database) DeleteContinuousQuery(id uint64) error {
+	c := &deleteContinuousQueryCommand{Database: db.Name(), ID: id}
+	_, err := db.server.broadcast(deleteContinuousQueryMessageType, c)
+	return err
+}
+
+func (db *Database) applyDeleteContinuousQuery(id uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Validate continuous query.
+	if db.continuousQueries[id] == nil {
+		return ErrContinuousQueryNotFound
+	}
+
+	// Remove continuous query.
+	delete(db.continuousQueries, id)
+	return nil
+}
+
+func (db *Database) applyContinuousQueryRun(id uint64, lastRun time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Validate continuous query.
+	cq := db.continuousQueries[id]
+	if cq == nil {
+		return ErrContinuousQueryNotFound
+	}
+
+	// Intervals are never processed twice.
+	if lastRun.After(cq.lastRun) {
+		cq.lastRun = lastRun
+	}
+	return nil
+}
+
+// executeCreateContinuousQueryQuery registers a selection as a continuous
+// query and writes its id to the processor. Existing data is backfilled in
+// the background when the query is run.
+func (db *Database) executeCreateContinuousQueryQuery(q *influxql.SelectQuery, p engine.Processor) error {
+	id, err := db.CreateContinuousQuery(q.String())
+	if err != nil {
+		return err
+	}
+
+	s := newListSeries("continuous_query", "id")
+	s.Points = append(s.Points, newListPoint(id))
+	_, err = p.Yield(s)
+	return err
+}
+
+// runContinuousQuery runs a continuous query over the intervals that have
+// completed since it was last run, up to DefaultContinuousQueryBatchSize
+// intervals. The end of the processed intervals is broadcast so that every
+// server resumes from it, including after a restart.
+func (db *Database) runContinuousQuery(cq *ContinuousQuery, now time.Time) error {
+	db.mu.Lock()
+	start, end := cq.lastRun, now.Truncate(cq.interval())
+	db.mu.Unlock()
+
+	if limit := start.Add(DefaultContinuousQueryBatchSize * cq.interval()); end.After(limit) {
+		end = limit
+	}
+	if !end.After(start) {
+		return nil
+	}
+	if err := db.writeContinuousQuery(cq, start, end); err != nil {
+		return err
+	}
+
+	c := &continuousQueryRunCommand{Database: db.Name(), ID: cq.ID, LastRun: end}
+	_, err := db.server.broadcast(continuousQueryRunMessageType, c)
+	return err
+}
+
+// writeContinuousQuery executes a continuous query for the time range
+// [start, end) and writes the results into the query's target series.
+func (db *Database) writeContinuousQuery(cq *ContinuousQuery, start, end time.Time) error {
+	q, err := engine.NewQueryFromInfluxQL(cq.selectQuery(start, end))
+	if err != nil {
+		return err
+	}
+
+	// Collect the results.
+	var buf seriesBuffer
+	if err := db.ExecuteQuery(nil, q, &buf); err != nil {
+		return err
+	}
+
+	// Write each non-empty result to the target series.
+	for _, s := range buf.series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		if err := db.WriteSeries(&protocol.Series{
+			Name:   proto.String(cq.query.Target),
+			Fields: s.Fields,
+			Points: s.Points,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// executeListContinuousQueriesQuery writes the continuous queries to the processor.
+func (db *Database) executeListContinuousQueriesQuery(p engine.Processor) error {
+	s := newListSeries("continuous_queries", "id", "query")
+	for _, cq := range db.ContinuousQueries() {
+		s.Points = append(s.Points, newListPoint(cq.ID, cq.Query))
+	}
+	_, err := p.Yield(s)
+	return err
+}
+
 // seriesByValues returns a list of series that match a set of parser values.
 func (db *Database) seriesByValues(values []*parser.Value) (a []*Series) {
 	for _, value := range values {
@@ -586,10 +1169,36 @@ func (db *Database) spacesBySeries(series []*Series) (a []*ShardSpace) {
 	return
 }
 
-// shardsInRange returns a subset of shards that are in the range of tmin and tmax.
+// newListSeries returns a series used to return the results of a list query.
+func newListSeries(name string, fields ...string) *protocol.Series {
+	return &protocol.Series{Name: proto.String(name), Fields: fields}
+}
+
+// newListPoint returns a point for the results of a list query.
+// Values must be strings or integers.
+func newListPoint(values ...interface{}) *protocol.Point {
+	p := &protocol.Point{Timestamp: proto.Int64(0)}
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			p.Values = append(p.Values, &protocol.FieldValue{StringValue: proto.String(v)})
+		case int64:
+			p.Values = append(p.Values, &protocol.FieldValue{Int64Value: proto.Int64(v)})
+		case uint64:
+			p.Values = append(p.Values, &protocol.FieldValue{Int64Value: proto.Int64(int64(v))})
+		case uint32:
+			p.Values = append(p.Values, &protocol.FieldValue{Int64Value: proto.Int64(int64(v))})
+		default:
+			panic(fmt.Sprintf("invalid list value: %T", v))
+		}
+	}
+	return p
+}
+
+// shardsInRange returns a subset of shards that overlap the range of tmin and tmax.
 func shardsInRange(shards []*Shard, tmin, tmax time.Time) (a []*Shard) {
 	for _, s := range shards {
-		if timeBetween(s.StartTime, tmin, tmax) && timeBetween(s.EndTime, tmin, tmax) {
+		if !s.StartTime.After(tmax) && !s.EndTime.Before(tmin) {
 			a = append(a, s)
 		}
 	}
@@ -619,6 +1228,9 @@ func (db *Database) MarshalJSON() ([]byte, error) {
 	for _, s := range db.series {
 		o.Series = append(o.Series, s)
 	}
+	for _, cq := range db.continuousQueries {
+		o.ContinuousQueries = append(o.ContinuousQueries, &continuousQueryJSON{ID: cq.ID, Query: cq.Query, LastRun: cq.lastRun})
+	}
 	return json.Marshal(&o)
 }
 
@@ -658,6 +1270,23 @@ func (db *Database) UnmarshalJSON(data []byte) error {
 		db.series[s.Name] = s
 	}
 
+	// Parse continuous queries. They resume from the last processed interval
+	// so intervals that completed while the cluster was down are processed.
+	// Queries stored without it start at the current interval.
+	db.continuousQueries = make(map[uint64]*ContinuousQuery)
+	for _, o := range o.ContinuousQueries {
+		cq, err := newContinuousQuery(o.ID, o.Query)
+		if err != nil {
+			return fmt.Errorf("continuous query %d: %s", o.ID, err)
+		}
+		cq.db = db
+		cq.lastRun = o.LastRun
+		if cq.lastRun.IsZero() {
+			cq.lastRun = time.Now().UTC().Truncate(cq.interval())
+		}
+		db.continuousQueries[cq.ID] = cq
+	}
+
 	return nil
 }
 
@@ -669,8 +1298,31 @@ type databaseJSON struct {
 	Spaces     []*ShardSpace `json:"spaces,omitempty"`
 	Shards     []*Shard      `json:"shards,omitempty"`
 	Series     []*Series     `json:"series,omitempty"`
+
+	ContinuousQueries []*continuousQueryJSON `json:"continuousQueries,omitempty"`
+}
+
+// continuousQueryJSON represents the JSON-serialization format for a
+// continuous query.
+type continuousQueryJSON struct {
+	ID      uint64    `json:"id"`
+	Query   string    `json:"query"`
+	LastRun time.Time `json:"lastRun,omitempty"`
 }
 
+// seriesBuffer is a processor that holds every series yielded to it.
+type seriesBuffer struct {
+	series []*protocol.Series
+}
+
+func (p *seriesBuffer) Yield(s *protocol.Series) (bool, error) {
+	p.series = append(p.series, s)
+	return true, nil
+}
+func (p *seriesBuffer) Name() string           { return "seriesBuffer" }
+func (p *seriesBuffer) Next() engine.Processor { return nil }
+func (p *seriesBuffer) Close() error           { return nil }
+
 // databases represents a list of databases, sortable by name.
 type databases []*Database
 
@@ -692,6 +1344,10 @@ type ShardSpace struct {
 	ReplicaN uint32
 	SplitN   uint32
 
+	// Name of the store used by new shards. Optional.
+	// Defaults to the server's default shard store.
+	Engine string
+
 	Shards []*Shard
 }
 
@@ -711,7 +1367,7 @@ func NewShardSpace() *ShardSpace {
 func (ss *ShardSpace) Split(a []*protocol.Point) (points map[uint64][]*protocol.Point, unassigned []*protocol.Point) {
 	points = make(map[uint64][]*protocol.Point)
 	for _, p := range a {
-		if s := ss.ShardByTimestamp(time.Unix(0, p.GetTimestamp())); s != nil {
+		if s := ss.ShardByTimestamp(time.Unix(0, p.GetTimestamp()*int64(time.Microsecond))); s != nil {
 			points[s.ID] = append(points[s.ID], p)
 		} else {
 			unassigned = append(unassigned, p)
@@ -740,6 +1396,8 @@ func (s *ShardSpace) MarshalJSON() ([]byte, error) {
 		Duration:  s.Duration,
 		ReplicaN:  s.ReplicaN,
 		SplitN:    s.SplitN,
+		Engine:    s.Engine,
+		Shards:    s.Shards,
 	})
 }
 
@@ -757,6 +1415,7 @@ func (s *ShardSpace) UnmarshalJSON(data []byte) error {
 	s.SplitN = o.SplitN
 	s.Retention = o.Retention
 	s.Duration = o.Duration
+	s.Engine = o.Engine
 	s.Shards = o.Shards
 
 	s.Regex, _ = regexp.Compile(o.Regex)
@@ -775,12 +1434,17 @@ type shardSpaceJSON struct {
 	SplitN    uint32        `json:"splitN,omitempty"`
 	Retention time.Duration `json:"retention,omitempty"`
 	Duration  time.Duration `json:"duration,omitempty"`
+	Engine    string        `json:"engine,omitempty"`
 	Shards    []*Shard      `json:"shards,omitempty"`
 }
 
-// ShardSpaces represents a list of shard spaces.
+// ShardSpaces represents a list of shard spaces, sortable by name.
 type ShardSpaces []*ShardSpace
 
+func (a ShardSpaces) Len() int           { return len(a) }
+func (a ShardSpaces) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a ShardSpaces) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 // Shards returns a list of all shards for all spaces.
 func (a ShardSpaces) Shards() []*Shard {
 	var shards []*Shard
@@ -796,7 +1460,15 @@ type Series struct {
 	Fields []*Field `json:"fields,omitempty"`
 }
 
+// FieldsByNames returns the fields matching a list of names in field order.
+// A wildcard name matches all fields.
 func (s *Series) FieldsByNames(names []string) (a []*Field) {
+	for _, name := range names {
+		if name == "*" {
+			return append(a, s.Fields...)
+		}
+	}
+
 	for _, f := range s.Fields {
 		for _, name := range names {
 			if f.Name == name {
@@ -1,8 +1,11 @@
 package influxdb_test
 
 import (
+	"fmt"
+	"math"
 	"reflect"
 	"regexp"
+	"strconv"
 	"testing"
 	"time"
 
@@ -10,6 +13,7 @@ import (
 	"code.google.com/p/goprotobuf/proto"
 	"github.com/influxdb/influxdb"
 	"github.com/influxdb/influxdb/engine"
+	"github.com/influxdb/influxdb/messaging"
 	"github.com/influxdb/influxdb/parser"
 	"github.com/influxdb/influxdb/protocol"
 )
@@ -196,6 +200,43 @@ func TestDatabase_ChangePassword(t *testing.T) {
 	}
 }
 
+// Ensure the server can change the permissions of a user.
+func TestDatabase_ChangePermissions(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create a database and user.
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	if err := db.CreateUser("susy", "pass", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restrict reads to "cpu" series and remove write access.
+	if err := db.ChangePermissions("susy", "^cpu", ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	if u := s.Database("foo").User("susy"); !u.HasReadAccess("cpu.load") {
+		t.Fatal("expected read access")
+	} else if u.HasReadAccess("mem") {
+		t.Fatal("unexpected read access")
+	} else if u.HasWriteAccess("cpu.load") {
+		t.Fatal("unexpected write access")
+	}
+}
+
+// Ensure the server returns an error when changing permissions of a non-existent user.
+func TestDatabase_ChangePermissions_ErrUserNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.Database("foo").ChangePermissions("no_such_user", ".*", ".*"); err != influxdb.ErrUserNotFound {
+		t.Fatal(err)
+	}
+}
+
 // Ensure the database can return a list of all users.
 func TestDatabase_Users(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -237,6 +278,7 @@ func TestDatabase_CreateShardSpace(t *testing.T) {
 		Retention: time.Minute,
 		ReplicaN:  2,
 		SplitN:    3,
+		Engine:    "bolt",
 	}
 	if err := s.Database("foo").CreateShardSpace(ss); err != nil {
 		t.Fatal(err)
@@ -288,6 +330,59 @@ func TestDatabase_CreateShardSpace_ErrShardSpaceExists(t *testing.T) {
 	}
 }
 
+// Ensure the server returns an error when creating a shard space with an unknown store.
+func TestDatabase_CreateShardSpace_ErrShardStoreNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.Database("foo").CreateShardSpace(&influxdb.ShardSpace{Name: "bar", Engine: "no_such_store"}); err != influxdb.ErrShardStoreNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the database can update an existing shard space.
+func TestDatabase_UpdateShardSpace(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create a database and shard space.
+	s.CreateDatabase("foo")
+	if err := s.Database("foo").CreateShardSpace(&influxdb.ShardSpace{Name: "bar", Duration: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Update the shard space settings.
+	ss := &influxdb.ShardSpace{
+		Name:      "bar",
+		Regex:     regexp.MustCompile(`cpu`),
+		Duration:  2 * time.Hour,
+		Retention: 24 * time.Hour,
+		ReplicaN:  3,
+		SplitN:    2,
+	}
+	if err := s.Database("foo").UpdateShardSpace(ss); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Verify that the settings changed.
+	if o := s.Database("foo").ShardSpace("bar"); o == nil {
+		t.Fatalf("shard space not found")
+	} else if !reflect.DeepEqual(ss, o) {
+		t.Fatalf("shard space mismatch: %#v", o)
+	}
+}
+
+// Ensure the server returns an error when updating a non-existent shard space.
+func TestDatabase_UpdateShardSpace_ErrShardSpaceNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.Database("foo").UpdateShardSpace(&influxdb.ShardSpace{Name: "no_such_space"}); err != influxdb.ErrShardSpaceNotFound {
+		t.Fatal(err)
+	}
+}
+
 // Ensure the server can delete an existing shard space.
 func TestDatabase_DeleteShardSpace(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -351,6 +446,73 @@ func TestDatabase_DeleteShardSpace_ErrShardSpaceNotFound(t *testing.T) {
 	}
 }
 
+// Ensure the database can create a continuous query.
+func TestDatabase_CreateContinuousQuery(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	// Create the continuous query.
+	q := `SELECT count(value) FROM cpu GROUP BY time(1h) INTO cpu.1h`
+	id, err := s.Database("foo").CreateContinuousQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	} else if id == 0 {
+		t.Fatal("expected continuous query id")
+	}
+	s.Restart()
+
+	// Verify that the query exists after restart.
+	if a := s.Database("foo").ContinuousQueries(); len(a) != 1 {
+		t.Fatalf("unexpected continuous query count: %d", len(a))
+	} else if a[0].ID != id {
+		t.Fatalf("unexpected id: %d", a[0].ID)
+	} else if a[0].Query != q {
+		t.Fatalf("unexpected query: %s", a[0].Query)
+	}
+}
+
+// Ensure the database returns an error when creating a continuous query without a time interval.
+func TestDatabase_CreateContinuousQuery_ErrInvalidContinuousQuery(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if _, err := s.Database("foo").CreateContinuousQuery(`SELECT value FROM cpu INTO cpu.copy`); err != influxdb.ErrInvalidContinuousQuery {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the database can delete a continuous query.
+func TestDatabase_DeleteContinuousQuery(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+
+	id, err := db.CreateContinuousQuery(`SELECT count(value) FROM cpu GROUP BY time(1h) INTO cpu.1h`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteContinuousQuery(id); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	if a := s.Database("foo").ContinuousQueries(); len(a) != 0 {
+		t.Fatalf("unexpected continuous query count: %d", len(a))
+	}
+}
+
+// Ensure the database returns an error when deleting a non-existent continuous query.
+func TestDatabase_DeleteContinuousQuery_ErrContinuousQueryNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.Database("foo").DeleteContinuousQuery(100); err != influxdb.ErrContinuousQueryNotFound {
+		t.Fatal(err)
+	}
+}
+
 // Ensure the database can write data to the database.
 func TestDatabase_WriteSeries(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -399,6 +561,837 @@ func TestDatabase_WriteSeries(t *testing.T) {
 	// &protocol.Series{Points:[]*protocol.Point{(*protocol.Point)(0xc20804b940)}, Name:(*string)(0xc2080b6760), Fields:[]string{"myval"}, FieldIds:[]uint64(nil), ShardId:(*uint64)(0xc20807c340), XXX_unrecognized:[]uint8(nil)}
 }
 
+// Ensure the database can write and read back values spanning multiple blocks.
+func TestDatabase_WriteSeries_Blocks(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+
+	// Write points of several types in reverse order.
+	timestamp := mustParseMicroTime("2000-01-01T00:00:00Z")
+	series := &protocol.Series{Name: proto.String("cpu"), Fields: []string{"fval", "sval", "bval"}}
+	for i := 2499; i >= 0; i-- {
+		series.Points = append(series.Points, &protocol.Point{
+			Values: []*protocol.FieldValue{
+				{DoubleValue: proto.Float64(float64(i) / 10)},
+				{StringValue: proto.String(strconv.Itoa(i))},
+				{BoolValue: proto.Bool(i%3 == 0)},
+			},
+			Timestamp: proto.Int64(timestamp + int64(i)*int64(time.Second/time.Microsecond)),
+		})
+	}
+	if err := db.WriteSeries(series); err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwrite a point and remove one of its values.
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu"),
+		Fields: []string{"fval", "bval"},
+		Points: []*protocol.Point{
+			{
+				Values:    []*protocol.FieldValue{{DoubleValue: proto.Float64(-1)}, {IsNull: proto.Bool(true)}},
+				Timestamp: proto.Int64(timestamp + 1500*int64(time.Second/time.Microsecond)),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read all points back in ascending order.
+	var rec ProcessorRecorder
+	if err := db.ExecuteQuery(nil, mustParseQuery(`select fval, sval, bval from cpu order asc`)[0], &rec); err != nil {
+		t.Fatal(err)
+	}
+	var points []*protocol.Point
+	for _, series := range rec.Series {
+		points = append(points, series.Points...)
+	}
+	if len(points) != 2500 {
+		t.Fatalf("unexpected point count: %d", len(points))
+	}
+	for i, p := range points {
+		fval, bval := float64(i)/10, i%3 == 0
+		if i == 1500 {
+			fval = -1
+		}
+		if v := p.GetTimestamp(); v != timestamp+int64(i)*int64(time.Second/time.Microsecond) {
+			t.Fatalf("%d. unexpected timestamp: %d", i, v)
+		} else if v := p.GetValues()[0].GetDoubleValue(); v != fval {
+			t.Fatalf("%d. unexpected float: %v", i, v)
+		} else if v := p.GetValues()[1].GetStringValue(); v != strconv.Itoa(i) {
+			t.Fatalf("%d. unexpected string: %s", i, v)
+		} else if i == 1500 && !p.GetValues()[2].GetIsNull() {
+			t.Fatalf("%d. expected null bool", i)
+		} else if v := p.GetValues()[2].GetBoolValue(); i != 1500 && v != bval {
+			t.Fatalf("%d. unexpected bool: %v", i, v)
+		}
+	}
+}
+
+// Ensure cached writes are available after the server restarts.
+func TestDatabase_WriteSeries_Restart(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+
+	// Write two points and then remove the first one.
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z"))},
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(200)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:30:00Z"))},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{IsNull: proto.Bool(true)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z"))},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Verify only the second value remains.
+	var rec ProcessorRecorder
+	if err := s.Database("foo").ExecuteQuery(nil, mustParseQuery(`select myval from cpu_load`)[0], &rec); err != nil {
+		t.Fatal(err)
+	}
+	var values []int64
+	for _, series := range rec.Series {
+		for _, p := range series.Points {
+			values = append(values, p.GetValues()[0].GetInt64Value())
+		}
+	}
+	if !reflect.DeepEqual(values, []int64{200}) {
+		t.Fatalf("unexpected values: %v", values)
+	}
+}
+
+// Ensure writes that weren't flushed to the shard store are replayed from
+// the write-ahead log when the files of a server that wasn't closed are
+// reopened. This doesn't cover the durability of the log on disk.
+func TestDatabase_WriteSeries_Unclosed(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z"))},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Open a copy of the data files as they are while the server is running.
+	other := NewServer(NewMessagingClient())
+	defer other.Close()
+	path := tempfile()
+	mustCopyDir(s.Path(), path)
+	if err := other.Open(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the write was replayed.
+	var rec ProcessorRecorder
+	if err := other.Database("foo").ExecuteQuery(nil, mustParseQuery(`select myval from cpu_load`)[0], &rec); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Series) != 1 || len(rec.Series[0].Points) != 1 || rec.Series[0].Points[0].GetValues()[0].GetInt64Value() != 100 {
+		t.Fatalf("unexpected series: %v", rec.Series)
+	}
+}
+
+// Ensure the database can drop a series and its data.
+func TestDatabase_DropSeries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+
+	// Write a point and drop the series.
+	series := &protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z"))},
+		},
+	}
+	if err := db.WriteSeries(series); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DropSeries("cpu_load"); err != nil {
+		t.Fatal(err)
+	} else if db.Series("cpu_load") != nil {
+		t.Fatal("series not dropped")
+	}
+	s.Restart()
+
+	if s.Database("foo").Series("cpu_load") != nil {
+		t.Fatal("series not dropped after restart")
+	}
+}
+
+// Ensure dropping a series deletes its data through the topics of its shards
+// before the series is removed.
+func TestDatabase_DropSeries_ShardTopics(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+	db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z"))},
+		},
+	})
+
+	// Record the topics of the messages published by the drop.
+	var topicIDs []uint64
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		topicIDs = append(topicIDs, m.TopicID)
+		return c.send(m)
+	}
+	if err := db.DropSeries("cpu_load"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The delete is sent to the shard's topic and the drop is broadcast last.
+	shardID := db.ShardSpace("myspace").Shards[0].ID
+	if !reflect.DeepEqual(topicIDs, []uint64{shardID, messaging.BroadcastTopicID}) {
+		t.Fatalf("unexpected topics: %v", topicIDs)
+	}
+}
+
+// Ensure the database returns an error when dropping a non-existent series.
+func TestDatabase_DropSeries_ErrSeriesNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.Database("foo").DropSeries("no_such_series"); err != influxdb.ErrSeriesNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the database can delete a time range of series data.
+func TestDatabase_ExecuteQuery_Delete(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+
+	// Write points to two different shards.
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z"))},
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(200)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:30:00Z"))},
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(300)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T01:30:00Z"))},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete the first hour of data.
+	if err := db.ExecuteQuery(nil, mustParseQuery(`delete from cpu_load where time < '2000-01-01 00:45:00'`)[0], nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify only the last value remains.
+	var rec ProcessorRecorder
+	if err := db.ExecuteQuery(nil, mustParseQuery(`select myval from cpu_load`)[0], &rec); err != nil {
+		t.Fatal(err)
+	}
+	var values []int64
+	for _, series := range rec.Series {
+		for _, p := range series.Points {
+			values = append(values, p.GetValues()[0].GetInt64Value())
+		}
+	}
+	if !reflect.DeepEqual(values, []int64{300}) {
+		t.Fatalf("unexpected values: %v", values)
+	}
+}
+
+// Ensure a query stops reading each series once its limit is reached.
+func TestDatabase_ExecuteQuery_Limit(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+
+	// Write points for two series to two different shards.
+	for _, name := range []string{"cpu", "mem"} {
+		if err := db.WriteSeries(&protocol.Series{
+			Name:   proto.String(name),
+			Fields: []string{"myval"},
+			Points: []*protocol.Point{
+				{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z"))},
+				{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(200)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:30:00Z"))},
+				{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(300)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T01:30:00Z"))},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		query  string
+		values map[string][]int64
+	}{
+		{`select myval from cpu limit 1`, map[string][]int64{"cpu": {300}}},
+		{`select myval from cpu limit 2`, map[string][]int64{"cpu": {300, 200}}},
+		{`select myval from cpu limit 3 order asc`, map[string][]int64{"cpu": {100, 200, 300}}},
+		{`select myval from /.*/ limit 1`, map[string][]int64{"cpu": {300}, "mem": {300}}},
+	} {
+		var rec ProcessorRecorder
+		if err := db.ExecuteQuery(nil, mustParseQuery(tt.query)[0], &rec); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		values := make(map[string][]int64)
+		for _, series := range rec.Series {
+			for _, p := range series.Points {
+				values[series.GetName()] = append(values[series.GetName()], p.GetValues()[0].GetInt64Value())
+			}
+		}
+		if !reflect.DeepEqual(values, tt.values) {
+			t.Fatalf("%s: unexpected values: %v", tt.query, values)
+		}
+	}
+}
+
+// Ensure a query only returns points matching its where condition when
+// stored and cached values are read in either direction.
+func TestDatabase_ExecuteQuery_Where(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.Database("foo").CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 24 * time.Hour})
+
+	// Write points to blocks and the cache and remove some "c" values.
+	timestamp := mustParseMicroTime("2000-01-01T00:00:00Z")
+	write := func(min, max int) {
+		series := &protocol.Series{Name: proto.String("cpu"), Fields: []string{"a", "b", "c"}}
+		for i := min; i < max; i++ {
+			b := "y"
+			if i%100 == 0 {
+				b = "x"
+			}
+			series.Points = append(series.Points, &protocol.Point{
+				Values:    []*protocol.FieldValue{{Int64Value: proto.Int64(int64(i))}, {StringValue: proto.String(b)}, {DoubleValue: proto.Float64(float64(i) / 2)}},
+				Timestamp: proto.Int64(timestamp + int64(i)*int64(time.Second/time.Microsecond)),
+			})
+		}
+		if err := s.Database("foo").WriteSeries(series); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(0, 2000)
+	s.Restart()
+	write(2000, 3000)
+
+	nulls := &protocol.Series{Name: proto.String("cpu"), Fields: []string{"c"}}
+	for i := 0; i < 3000; i += 7 {
+		nulls.Points = append(nulls.Points, &protocol.Point{
+			Values:    []*protocol.FieldValue{{IsNull: proto.Bool(true)}},
+			Timestamp: proto.Int64(timestamp + int64(i)*int64(time.Second/time.Microsecond)),
+		})
+	}
+	if err := s.Database("foo").WriteSeries(nulls); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		query  string
+		values []int64
+	}{
+		{`select a from cpu where b = 'x' and a > 1000 order asc`, []int64{1100, 1200, 1300, 1400, 1500, 1600, 1700, 1800, 1900, 2000, 2100, 2200, 2300, 2400, 2500, 2600, 2700, 2800, 2900}},
+		{`select a from cpu where b = 'x' and a < 1000`, []int64{900, 800, 700, 600, 500, 400, 300, 200, 100, 0}},
+		{`select a from cpu where c > 1399 and c < 1402 order asc`, []int64{2799, 2801, 2802, 2803}},
+		{`select a from cpu where c > 1399 and c < 1402`, []int64{2803, 2802, 2801, 2799}},
+		{`select a from cpu where a = 5 or a = 2995`, []int64{2995, 5}},
+		{`select a from cpu where a = 3000`, nil},
+		{`select * from cpu where a = 42`, []int64{42}},
+	} {
+		var rec ProcessorRecorder
+		if err := s.Database("foo").ExecuteQuery(nil, mustParseQuery(tt.query)[0], &rec); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		var values []int64
+		for _, series := range rec.Series {
+			for _, p := range series.Points {
+				values = append(values, p.GetValues()[0].GetInt64Value())
+			}
+		}
+		if !reflect.DeepEqual(values, tt.values) {
+			t.Fatalf("%s: unexpected values: %v", tt.query, values)
+		}
+	}
+}
+
+// Ensure aggregates are merged from partial results when the group by
+// interval doesn't line up with the shard duration.
+func TestDatabase_ExecuteQuery_PartialAggregates(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+
+	// Write a point every 10 minutes across three shards.
+	timestamp := mustParseMicroTime("2000-01-01T00:00:00Z")
+	series := &protocol.Series{Name: proto.String("cpu"), Fields: []string{"myval"}}
+	for i := 0; i < 18; i++ {
+		series.Points = append(series.Points, &protocol.Point{
+			Values:    []*protocol.FieldValue{{Int64Value: proto.Int64(int64(i))}},
+			Timestamp: proto.Int64(timestamp + int64(i)*int64(10*time.Minute/time.Microsecond)),
+		})
+	}
+	if err := db.WriteSeries(series); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := func(d time.Duration) int64 { return timestamp + int64(d/time.Microsecond) }
+	for _, tt := range []struct {
+		query  string
+		points []string
+	}{
+		{`select count(myval), mean(myval), first(myval), last(myval) from cpu`, []string{"0 [18 8.5 17 0]"}},
+		{`select count(myval), sum(myval), min(myval), max(myval), mean(myval), first(myval), last(myval) from cpu group by time(2h) order asc`, []string{
+			fmt.Sprintf("%d [12 66 0 11 5.5 0 11]", bucket(0)),
+			fmt.Sprintf("%d [6 87 12 17 14.5 12 17]", bucket(2*time.Hour)),
+		}},
+		{`select count_distinct_approx(myval) from cpu group by time(2h) order asc`, []string{
+			fmt.Sprintf("%d [12]", bucket(0)),
+			fmt.Sprintf("%d [6]", bucket(2*time.Hour)),
+		}},
+		{`select count(myval), max(myval) from cpu group by time(90m)`, []string{
+			fmt.Sprintf("%d [9 17]", bucket(90*time.Minute)),
+			fmt.Sprintf("%d [9 8]", bucket(0)),
+		}},
+
+		// Percentiles can't be merged so raw points are sent instead.
+		{`select count(myval), percentile(myval, 50) from cpu group by time(2h) order asc`, []string{
+			fmt.Sprintf("%d [12 5]", bucket(0)),
+			fmt.Sprintf("%d [6 14]", bucket(2*time.Hour)),
+		}},
+		{`select median_approx(myval), percentile_approx(myval, 99, 50) from cpu group by time(2h) order asc`, []string{
+			fmt.Sprintf("%d [5.5 11]", bucket(0)),
+			fmt.Sprintf("%d [14.5 17]", bucket(2*time.Hour)),
+		}},
+	} {
+		var rec ProcessorRecorder
+		if err := db.ExecuteQuery(nil, mustParseQuery(tt.query)[0], &rec); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		var points []string
+		for _, series := range rec.Series {
+			for _, p := range series.Points {
+				var values []interface{}
+				for _, v := range p.GetValues() {
+					value, _ := v.GetValue()
+					values = append(values, value)
+				}
+				points = append(points, fmt.Sprintf("%d %v", p.GetTimestamp(), values))
+			}
+		}
+		if !reflect.DeepEqual(points, tt.points) {
+			t.Fatalf("%s: unexpected points: %v", tt.query, points)
+		}
+	}
+}
+
+// Ensure counter rates ignore resets and wraparounds within time buckets
+// that span several shards.
+func TestDatabase_ExecuteQuery_CounterRate(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 30 * time.Minute})
+
+	// Write a counter every 10 minutes which increases by 600 and goes
+	// back to 600 twice.
+	timestamp := mustParseMicroTime("2000-01-01T00:00:00Z")
+	series := &protocol.Series{Name: proto.String("requests"), Fields: []string{"count"}}
+	for i, v := range []int64{0, 600, 1200, 1800, 600, 1200, 1800, 2400, 3000, 3600, 600, 1200} {
+		series.Points = append(series.Points, &protocol.Point{
+			Values:    []*protocol.FieldValue{{Int64Value: proto.Int64(v)}},
+			Timestamp: proto.Int64(timestamp + int64(i)*int64(10*time.Minute/time.Microsecond)),
+		})
+	}
+	if err := db.WriteSeries(series); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		query  string
+		values [][]float64
+	}{
+		{`select rate(count, 1m), non_negative_derivative(count, 1m) from requests group by time(1h) order asc`, [][]float64{{60, 60}, {60, 60}}},
+		{`select rate(count, 1s) from requests`, [][]float64{{1}}},
+
+		// The counter wraps around after 3999.
+		{`select rate(count, 1m, 3999) from requests group by time(1h) order asc`, [][]float64{{5200.0 / 50}, {3400.0 / 50}}},
+	} {
+		var rec ProcessorRecorder
+		if err := db.ExecuteQuery(nil, mustParseQuery(tt.query)[0], &rec); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		var points []*protocol.Point
+		for _, series := range rec.Series {
+			points = append(points, series.Points...)
+		}
+		if len(points) != len(tt.values) {
+			t.Fatalf("%s: unexpected points: %v", tt.query, points)
+		}
+		for i, p := range points {
+			for j, v := range p.GetValues() {
+				if math.Abs(v.GetDoubleValue()-tt.values[i][j]) > 1e-9 {
+					t.Errorf("%s: point %d: expected %v, got %v", tt.query, i, tt.values[i][j], v.GetDoubleValue())
+				}
+			}
+		}
+	}
+}
+
+// Ensure every bucket in the time range is returned once when buckets are
+// filled, even if the shards could aggregate locally.
+func TestDatabase_ExecuteQuery_Fill(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+
+	// Write points in the first and last shard of the time range.
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(10)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:10:00Z"))},
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(40)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T02:40:00Z"))},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	const where = `where time > '2000-01-01 00:00:00' and time < '2000-01-01 02:59:00'`
+	for _, tt := range []struct {
+		query  string
+		values string
+	}{
+		{`select count(myval) from cpu ` + where + ` group by time(30m) fill(0) order asc`, `[[1] [0] [0] [0] [0] [1]]`},
+		{`select count(myval) from cpu ` + where + ` group by time(30m) fill(null) order asc`, `[[1] [<nil>] [<nil>] [<nil>] [<nil>] [1]]`},
+		{`select count(myval) from cpu ` + where + ` group by time(30m) fill(none) order asc`, `[[1] [1]]`},
+		{`select mean(myval) from cpu ` + where + ` group by time(30m) fill(previous) order asc`, `[[10] [10] [10] [10] [10] [40]]`},
+		{`select mean(myval) from cpu ` + where + ` group by time(30m) fill(linear)`, `[[40] [34] [28] [22] [16] [10]]`},
+	} {
+		var rec ProcessorRecorder
+		if err := db.ExecuteQuery(nil, mustParseQuery(tt.query)[0], &rec); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		var values [][]interface{}
+		for _, series := range rec.Series {
+			for _, p := range series.Points {
+				var row []interface{}
+				for _, v := range p.GetValues() {
+					var value interface{}
+					if v != nil {
+						value, _ = v.GetValue()
+					}
+					row = append(row, value)
+				}
+				values = append(values, row)
+			}
+		}
+		if fmt.Sprint(values) != tt.values {
+			t.Errorf("%s: unexpected values: %v", tt.query, values)
+		}
+	}
+}
+
+// Ensure queries grouped by time in a time zone return buckets of local days.
+func TestDatabase_ExecuteQuery_TimeZone(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 24 * time.Hour})
+
+	// Write a point every hour from Nov 1 to Nov 3, 2014 in New York. The
+	// clocks are set back an hour on Nov 2.
+	series := &protocol.Series{Name: proto.String("cpu"), Fields: []string{"myval"}}
+	for ts := mustParseTime("2014-11-01T04:00:00Z"); ts.Before(mustParseTime("2014-11-04T05:00:00Z")); ts = ts.Add(time.Hour) {
+		series.Points = append(series.Points, &protocol.Point{
+			Values:    []*protocol.FieldValue{{Int64Value: proto.Int64(1)}},
+			Timestamp: proto.Int64(ts.UnixNano() / int64(time.Microsecond)),
+		})
+	}
+	if err := db.WriteSeries(series); err != nil {
+		t.Fatal(err)
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		query  string
+		loc    *time.Location
+		values string
+	}{
+		{`select count(myval) from cpu group by time(1d) order asc`, time.UTC, `[2014-11-01T00:00:00Z=20 2014-11-02T00:00:00Z=24 2014-11-03T00:00:00Z=24 2014-11-04T00:00:00Z=5]`},
+		{`select count(myval) from cpu group by time(1d) tz('America/New_York') order asc`, loc, `[2014-11-01T00:00:00-04:00=24 2014-11-02T00:00:00-04:00=25 2014-11-03T00:00:00-05:00=24]`},
+		{`select count(myval) from cpu group by time(1d, 6h) tz('America/New_York') order asc`, loc, `[2014-10-31T06:00:00-04:00=6 2014-11-01T06:00:00-04:00=25 2014-11-02T06:00:00-05:00=24 2014-11-03T06:00:00-05:00=18]`},
+		{`select count(myval) from cpu group by time(1d, 6h) order asc`, time.UTC, `[2014-10-31T06:00:00Z=2 2014-11-01T06:00:00Z=24 2014-11-02T06:00:00Z=24 2014-11-03T06:00:00Z=23]`},
+	} {
+		var rec ProcessorRecorder
+		if err := db.ExecuteQuery(nil, mustParseQuery(tt.query)[0], &rec); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		var values []string
+		for _, series := range rec.Series {
+			for _, p := range series.Points {
+				ts := time.Unix(0, p.GetTimestamp()*int64(time.Microsecond)).In(tt.loc)
+				values = append(values, fmt.Sprintf("%s=%d", ts.Format(time.RFC3339), p.GetValues()[0].GetInt64Value()))
+			}
+		}
+		if fmt.Sprint(values) != tt.values {
+			t.Errorf("%s: unexpected values: %v", tt.query, values)
+		}
+	}
+}
+
+// Ensure window functions are computed over the buckets or points of every
+// shard whether the shards aggregate locally or not.
+func TestDatabase_ExecuteQuery_WindowFunctions(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 24 * time.Hour})
+
+	// Write a point every hour from Nov 1 to Nov 3, 2014.
+	series := &protocol.Series{Name: proto.String("cpu"), Fields: []string{"myval"}}
+	for ts := mustParseTime("2014-11-01T00:00:00Z"); ts.Before(mustParseTime("2014-11-04T00:00:00Z")); ts = ts.Add(time.Hour) {
+		series.Points = append(series.Points, &protocol.Point{
+			Values:    []*protocol.FieldValue{{Int64Value: proto.Int64(1)}},
+			Timestamp: proto.Int64(ts.UnixNano() / int64(time.Microsecond)),
+		})
+	}
+	if err := db.WriteSeries(series); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		query  string
+		values string
+	}{
+		{`select cumulative_sum(count(myval)) from cpu group by time(1d) order asc`, `[2014-11-01T00:00:00Z=24 2014-11-02T00:00:00Z=48 2014-11-03T00:00:00Z=72]`},
+		{`select moving_average(count(myval), 2) from cpu group by time(1d)`, `[2014-11-03T00:00:00Z=24 2014-11-02T00:00:00Z=24 2014-11-01T00:00:00Z=<nil>]`},
+		{`select cumulative_sum(count(myval)) from cpu group by time(36h) order asc`, `[2014-10-31T00:00:00Z=12 2014-11-01T12:00:00Z=48 2014-11-03T00:00:00Z=72]`},
+		{`select cumulative_sum(myval) from cpu limit 3`, `[2014-11-03T23:00:00Z=72 2014-11-03T22:00:00Z=71 2014-11-03T21:00:00Z=70]`},
+	} {
+		var rec ProcessorRecorder
+		if err := db.ExecuteQuery(nil, mustParseQuery(tt.query)[0], &rec); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		var values []string
+		for _, series := range rec.Series {
+			for _, p := range series.Points {
+				ts := time.Unix(0, p.GetTimestamp()*int64(time.Microsecond)).UTC()
+				var value interface{}
+				if v := p.GetValues()[len(p.GetValues())-1]; v != nil {
+					value, _ = v.GetValue()
+				}
+				values = append(values, fmt.Sprintf("%s=%v", ts.Format(time.RFC3339), value))
+			}
+		}
+		if fmt.Sprint(values) != tt.values {
+			t.Errorf("%s: unexpected values: %v", tt.query, values)
+		}
+	}
+}
+
+// Ensure forecasts are appended after the buckets of every shard.
+func TestDatabase_ExecuteQuery_HoltWinters(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 24 * time.Hour})
+
+	// Write a point every hour from Nov 1 to Nov 3, 2014 with the number
+	// of hours since the first point.
+	series := &protocol.Series{Name: proto.String("cpu"), Fields: []string{"myval"}}
+	for i := 0; i < 72; i++ {
+		ts := mustParseTime("2014-11-01T00:00:00Z").Add(time.Duration(i) * time.Hour)
+		series.Points = append(series.Points, &protocol.Point{
+			Values:    []*protocol.FieldValue{{Int64Value: proto.Int64(int64(i))}},
+			Timestamp: proto.Int64(ts.UnixNano() / int64(time.Microsecond)),
+		})
+	}
+	if err := db.WriteSeries(series); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		query  string
+		values string
+	}{
+		{`select holt_winters(max(myval), 2, 0) from cpu group by time(1d) order asc`, `[2014-11-01T00:00:00Z=23 2014-11-02T00:00:00Z=47 2014-11-03T00:00:00Z=71 2014-11-04T00:00:00Z=95(forecast) 2014-11-05T00:00:00Z=119(forecast)]`},
+		{`select holt_winters(count(myval), 1, 0) from cpu group by time(48h) limit 2`, `[2014-11-04T00:00:00Z=72(forecast) 2014-11-02T00:00:00Z=48]`},
+	} {
+		var rec ProcessorRecorder
+		if err := db.ExecuteQuery(nil, mustParseQuery(tt.query)[0], &rec); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		var values []string
+		for _, series := range rec.Series {
+			for _, p := range series.Points {
+				ts := time.Unix(0, p.GetTimestamp()*int64(time.Microsecond)).UTC()
+				value, _ := p.GetValues()[0].GetValue()
+				v := fmt.Sprintf("%s=%v", ts.Format(time.RFC3339), value)
+				if p.GetValues()[1].GetBoolValue() {
+					v += "(forecast)"
+				}
+				values = append(values, v)
+			}
+		}
+		if fmt.Sprint(values) != tt.values {
+			t.Errorf("%s: unexpected values: %v", tt.query, values)
+		}
+	}
+}
+
+func TestDatabase_ExecuteQuery_Having(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 24 * time.Hour})
+
+	// Write a point every hour from Nov 1 to Nov 3, 2014 with the number
+	// of hours since the first point.
+	series := &protocol.Series{Name: proto.String("cpu"), Fields: []string{"myval"}}
+	for i := 0; i < 72; i++ {
+		ts := mustParseTime("2014-11-01T00:00:00Z").Add(time.Duration(i) * time.Hour)
+		series.Points = append(series.Points, &protocol.Point{
+			Values:    []*protocol.FieldValue{{Int64Value: proto.Int64(int64(i))}},
+			Timestamp: proto.Int64(ts.UnixNano() / int64(time.Microsecond)),
+		})
+	}
+	if err := db.WriteSeries(series); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		query  string
+		values string
+	}{
+		{`select max(myval) from cpu group by time(1d) having max(myval) > 30 and min(myval) < 30`, `[2014-11-02T00:00:00Z=47]`},
+		{`select max(myval) as m from cpu group by time(1d) having m > 30 limit 1`, `[2014-11-03T00:00:00Z=71]`},
+	} {
+		var rec ProcessorRecorder
+		if err := db.ExecuteQuery(nil, mustParseQuery(tt.query)[0], &rec); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		var values []string
+		for _, series := range rec.Series {
+			if len(series.Fields) != 1 {
+				t.Errorf("%s: unexpected fields: %v", tt.query, series.Fields)
+			}
+			for _, p := range series.Points {
+				ts := time.Unix(0, p.GetTimestamp()*int64(time.Microsecond)).UTC()
+				value, _ := p.GetValues()[0].GetValue()
+				values = append(values, fmt.Sprintf("%s=%v", ts.Format(time.RFC3339), value))
+			}
+		}
+		if fmt.Sprint(values) != tt.values {
+			t.Errorf("%s: unexpected values: %v", tt.query, values)
+		}
+	}
+}
+
+func BenchmarkDatabase_ExecuteQuery_Raw_1(b *testing.B) {
+	benchmarkDatabaseExecuteQuery(b, `select value from cpu`, 1)
+}
+func BenchmarkDatabase_ExecuteQuery_Raw_100(b *testing.B) {
+	benchmarkDatabaseExecuteQuery(b, `select value from cpu`, 100)
+}
+func BenchmarkDatabase_ExecuteQuery_Mean_1(b *testing.B) {
+	benchmarkDatabaseExecuteQuery(b, `select mean(value) from cpu group by time(1h)`, 1)
+}
+func BenchmarkDatabase_ExecuteQuery_Mean_100(b *testing.B) {
+	benchmarkDatabaseExecuteQuery(b, `select mean(value) from cpu group by time(1h)`, 100)
+}
+func BenchmarkDatabase_ExecuteQuery_Where_1(b *testing.B) {
+	benchmarkDatabaseExecuteQuery(b, `select value from cpu where value > 50`, 1)
+}
+func BenchmarkDatabase_ExecuteQuery_Where_100(b *testing.B) {
+	benchmarkDatabaseExecuteQuery(b, `select value from cpu where value > 50`, 100)
+}
+
+// benchmarkDatabaseExecuteQuery executes a query against a week of 10s data
+// while reading batchSize points from the shard at a time.
+func benchmarkDatabaseExecuteQuery(b *testing.B, query string, batchSize int) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.SetPointBatchSize(batchSize)
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 7 * 24 * time.Hour})
+
+	// Write a week of points in chunks.
+	timestamp := mustParseMicroTime("2000-01-01T00:00:00Z")
+	const n, interval = 7 * 24 * 360, 10 * int64(time.Second/time.Microsecond)
+	for i := 0; i < n; {
+		series := &protocol.Series{Name: proto.String("cpu"), Fields: []string{"value"}}
+		for ; i < n && len(series.Points) < 10000; i++ {
+			series.Points = append(series.Points, &protocol.Point{
+				Values:    []*protocol.FieldValue{{DoubleValue: proto.Float64(float64(i % 100))}},
+				Timestamp: proto.Int64(timestamp + int64(i)*interval),
+			})
+		}
+		if err := db.WriteSeries(series); err != nil {
+			b.Fatal(err)
+		}
+	}
+	q := mustParseQuery(query)[0]
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var rec ProcessorRecorder
+		if err := db.ExecuteQuery(nil, q, &rec); err != nil {
+			b.Fatal(err)
+		} else if len(rec.Series) == 0 {
+			b.Fatal("no series returned")
+		}
+	}
+}
+
 // ProcessorRecorder records all yields to the processor.
 type ProcessorRecorder struct {
 	Series []*protocol.Series
@@ -16,6 +16,16 @@ type SeriesState struct {
 	trie          *Trie
 	pointsRange   *PointRange
 	lastTimestamp int64
+
+	// The time bucket of the last point and its start as a group value.
+	// Consecutive points are usually in the same bucket, so the bucket is
+	// only looked up again once a point falls outside of it.
+	bucket      *timeBucket
+	bucketValue *protocol.FieldValue
+
+	// The last flushed bucket with points of each group, which fills the
+	// buckets at the start of the next flush.
+	filled map[string]*filledBucket
 }
 
 type AggregatorEngine struct {
@@ -23,6 +33,7 @@ type AggregatorEngine struct {
 	ascending   bool
 	fields      []string
 	isFillQuery bool
+	fillType    parser.FillType
 
 	// was start time set in the query, e.g. time > now() - 1d
 	startTimeSpecified bool
@@ -37,7 +48,14 @@ type AggregatorEngine struct {
 	elems             []*parser.Value // group by columns other than time()
 	duration          *time.Duration  // the time by duration if any
 	irregularInterval bool            // group by time is week, month, or year
+	offset            time.Duration   // the offset of the time buckets
+	location          *time.Location  // the time zone of the time buckets
 	seriesStates      map[string]*SeriesState
+
+	// partial yields the serialized state of each aggregator instead of
+	// its values and merge aggregates such states into the final values
+	partial bool
+	merge   bool
 }
 
 func (self *AggregatorEngine) Name() string {
@@ -53,36 +71,94 @@ func (self *AggregatorEngine) Close() error {
 	return self.next.Close()
 }
 
-func (self *AggregatorEngine) getTimestampFromPoint(point *protocol.Point) int64 {
-	return self.getTimestampBucket(*point.GetTimestampInMicroseconds())
-}
-
+// getTimestampBucket returns the start of the time bucket of the given
+// timestamp. Buckets start at multiples of the duration since the epoch
+// shifted by the offset. If the query has a time zone, buckets are aligned
+// to its local time, e.g. daily buckets start at local midnight and are 23
+// or 25 hours long on days with a daylight saving time transition.
 func (self *AggregatorEngine) getTimestampBucket(timestampMicroseconds int64) int64 {
-	timestamp := time.Unix(0, timestampMicroseconds*1000)
+	location := self.location
+	if location == nil {
+		location = time.UTC
+	}
+	timestamp := time.Unix(0, timestampMicroseconds*1000).In(location)
 
 	if self.irregularInterval {
+		// find the boundary of the local date shifted by the offset
+		year, month, day := timestamp.Date()
+		hour, min, sec := timestamp.Clock()
+		local := time.Date(year, month, day, hour, min, sec, timestamp.Nanosecond()-int(self.offset), time.UTC)
+		year, month, day = local.Date()
+		offset := int(self.offset)
+
 		switch d := *self.duration; d {
 		case 7 * 24 * time.Hour:
-			year, month, day := timestamp.Date()
-			weekday := timestamp.Weekday()
-			offset := day - int(weekday)
-			boundaryTime := time.Date(year, month, offset, 0, 0, 0, 0, time.UTC)
-			return boundaryTime.Unix() * 1000000
+			weekday := local.Weekday()
+			boundaryTime := time.Date(year, month, day-int(weekday), 0, 0, 0, offset, location)
+			return boundaryTime.UnixNano() / 1000
 		case 30 * 24 * time.Hour:
-			year, month, _ := timestamp.Date()
-			boundaryTime := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
-			return boundaryTime.Unix() * 1000000
+			boundaryTime := time.Date(year, month, 1, 0, 0, 0, offset, location)
+			return boundaryTime.UnixNano() / 1000
 		case 365 * 24 * time.Hour:
-			year := timestamp.Year()
-			boundaryTime := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
-			return boundaryTime.Unix() * 1000000
+			boundaryTime := time.Date(year, time.January, 1, 0, 0, 0, offset, location)
+			return boundaryTime.UnixNano() / 1000
 		default:
 			log4go.Debug("Logical intervals are supported for 1w, 1m/1M and 1Y only")
 		}
 	}
 
-	// the duration is a non-special interval
-	return timestamp.Truncate(*self.duration).UnixNano() / 1000
+	// the duration is a non-special interval, truncate the local time
+	_, zone := timestamp.Zone()
+	t := timestamp.UnixNano()
+	start := t - floorMod(t+int64(zone)*int64(time.Second)-int64(self.offset), int64(*self.duration))
+
+	// if the zone offset changed since the start of the bucket, e.g. on a
+	// daylight saving time transition, move the start to the local time
+	// boundary unless the bucket is shorter than the change
+	_, startZone := time.Unix(0, start).In(location).Zone()
+	if diff := int64(zone-startZone) * int64(time.Second); diff != 0 && diff < int64(*self.duration) && -diff < int64(*self.duration) {
+		start += diff
+	}
+	return start / 1000
+}
+
+// timeBucket is the range of timestamps, in microseconds, of a time bucket.
+type timeBucket struct {
+	start, end int64
+}
+
+// contains returns true if the timestamp is in the bucket.
+func (b *timeBucket) contains(timestamp int64) bool {
+	return timestamp >= b.start && timestamp < b.end
+}
+
+// getTimeBucket returns the time bucket of the given timestamp.
+func (self *AggregatorEngine) getTimeBucket(timestampMicroseconds int64) *timeBucket {
+	start := self.getTimestampBucket(timestampMicroseconds)
+	return &timeBucket{start: start, end: self.getNextBucket(start)}
+}
+
+// getNextBucket returns the start of the bucket after the given bucket.
+// Buckets with a time zone or a calendar interval don't have the same
+// length, half an interval after the end of a bucket is always in the next
+// one.
+func (self *AggregatorEngine) getNextBucket(bucket int64) int64 {
+	durationMicro := self.duration.Nanoseconds() / 1000
+	return self.getTimestampBucket(bucket + durationMicro + durationMicro/2)
+}
+
+// getPreviousBucket returns the start of the bucket before the given bucket.
+func (self *AggregatorEngine) getPreviousBucket(bucket int64) int64 {
+	return self.getTimestampBucket(bucket - 1)
+}
+
+// floorMod returns x modulo y with the sign of y.
+func floorMod(x, y int64) int64 {
+	m := x % y
+	if m < 0 {
+		m += y
+	}
+	return m
 }
 
 func (self *AggregatorEngine) Yield(s *protocol.Series) (bool, error) {
@@ -95,7 +171,11 @@ func (self *AggregatorEngine) Yield(s *protocol.Series) (bool, error) {
 }
 
 func (self *AggregatorEngine) initializeFields() {
-	for _, aggregator := range self.aggregators {
+	for idx, aggregator := range self.aggregators {
+		if self.partial {
+			self.fields = append(self.fields, partialColumnName(idx))
+			continue
+		}
 		columnNames := aggregator.ColumnNames()
 		self.fields = append(self.fields, columnNames...)
 	}
@@ -125,6 +205,7 @@ func (self *AggregatorEngine) getSeriesState(name string) *SeriesState {
 			trie:          NewTrie(levels, len(self.aggregators)),
 			lastTimestamp: 0,
 			pointsRange:   &PointRange{math.MaxInt64, math.MinInt64},
+			filled:        make(map[string]*filledBucket),
 		}
 		self.seriesStates[name] = state
 	}
@@ -141,16 +222,26 @@ func (self *AggregatorEngine) getSeriesState(name string) *SeriesState {
 // bucket. We reset the trie once the series is yielded. For (2), we
 // keep track of all group by columns with time being the last level
 // in the prefix tree. At the end of the query we step through [start
-// time, end time] in self.duration steps and get the state from the
+// time, end time] bucket by bucket and get the state from the
 // prefix tree, using default values for groups without state in the
 // prefix tree. For the last case we keep the groups in the prefix
 // tree and on close() we loop through the groups and flush their
 // values with a timestamp equal to now()
 func (self *AggregatorEngine) aggregateValuesForSeries(series *protocol.Series) (bool, error) {
-	for _, aggregator := range self.aggregators {
-		if err := aggregator.InitializeFieldsMetadata(series); err != nil {
+	// partial states are stored in their own columns, the aggregated
+	// columns aren't part of the series
+	var partialColumns []int
+	if self.merge && len(series.Points) > 0 {
+		var err error
+		if partialColumns, err = self.getPartialColumns(series); err != nil {
 			return false, err
 		}
+	} else {
+		for _, aggregator := range self.aggregators {
+			if err := aggregator.InitializeFieldsMetadata(series); err != nil {
+				return false, err
+			}
+		}
 	}
 
 	seriesState := self.getSeriesState(series.GetName())
@@ -164,13 +255,29 @@ func (self *AggregatorEngine) aggregateValuesForSeries(series *protocol.Series)
 		group = make([]*protocol.FieldValue, len(self.elems)+1)
 	}
 
+	// Group by columns are looked up once for all points of the series.
+	elemColumns := make([]int, len(self.elems))
+	for idx, elem := range self.elems {
+		elemColumns[idx] = -1
+		if elem.Type == parser.ValueSimpleName || elem.Type == parser.ValueTableName {
+			elemColumns[idx] = fieldIndex(series.Fields, elem.Name)
+		}
+	}
+
 	for _, point := range series.Points {
 		currentRange.UpdateRange(point)
 
+		if self.duration != nil {
+			if t := *point.GetTimestampInMicroseconds(); seriesState.bucket == nil || !seriesState.bucket.contains(t) {
+				seriesState.bucket = self.getTimeBucket(t)
+				seriesState.bucketValue = &protocol.FieldValue{Int64Value: protocol.Int64(seriesState.bucket.start)}
+			}
+		}
+
 		// this is a groupby with time() and no fill, flush as soon as we
 		// start a new bucket
 		if self.duration != nil && !self.isFillQuery {
-			timestamp := self.getTimestampFromPoint(point)
+			timestamp := seriesState.bucket.start
 			// this is the timestamp aggregator
 			if seriesState.started && seriesState.lastTimestamp != timestamp {
 				self.runAggregatesForTable(series.GetName())
@@ -181,7 +288,10 @@ func (self *AggregatorEngine) aggregateValuesForSeries(series *protocol.Series)
 
 		// get the group this point belongs to
 		for idx, elem := range self.elems {
-			// TODO: create an index from fieldname to index
+			if i := elemColumns[idx]; i >= 0 {
+				group[idx] = point.Values[i]
+				continue
+			}
 
 			// TODO: We shouldn't rely on GetValue() to do arithmetic
 			// operations. Instead we should cascade the arithmetic engine
@@ -197,8 +307,7 @@ func (self *AggregatorEngine) aggregateValuesForSeries(series *protocol.Series)
 
 		// if this is a fill() query, add the timestamp at the end
 		if includeTimestampInGroup {
-			timestamp := self.getTimestampFromPoint(point)
-			group[len(self.elems)] = &protocol.FieldValue{Int64Value: protocol.Int64(timestamp)}
+			group[len(self.elems)] = seriesState.bucketValue
 		}
 
 		// update the state of the given group
@@ -207,7 +316,11 @@ func (self *AggregatorEngine) aggregateValuesForSeries(series *protocol.Series)
 		log4go.Debug("Aggregating for group %v", group)
 		for idx, aggregator := range self.aggregators {
 			log4go.Debug("Aggregating value for %T for group %v and state %v", aggregator, group, node.states[idx])
-			node.states[idx], err = aggregator.AggregatePoint(node.states[idx], point)
+			if self.merge {
+				node.states[idx], err = mergePartialState(aggregator.(PartialAggregator), node.states[idx], point.Values[partialColumns[idx]])
+			} else {
+				node.states[idx], err = aggregator.AggregatePoint(node.states[idx], point)
+			}
 			if err != nil {
 				return false, err
 			}
@@ -236,7 +349,25 @@ func (self *AggregatorEngine) runAggregatesForTable(table string) (bool, error)
 	state := self.getSeriesState(table)
 	trie := state.trie
 	points := make([]*protocol.Point, 0, trie.CountLeafNodes())
+	fillFromNeighbours := self.duration != nil && self.isFillQuery &&
+		(self.fillType == parser.FillPrevious || self.fillType == parser.FillLinear)
+	var buckets []*filledBucket
 	f := func(group []*protocol.FieldValue, node *Node) error {
+		if self.partial {
+			point, err := self.getPartialValuesForGroup(table, group, node)
+			if err != nil {
+				return err
+			}
+			points = append(points, point)
+			return nil
+		}
+		if fillFromNeighbours {
+			// check whether the bucket is empty before its states are reset
+			bucket := newFilledBucket(group, node)
+			bucket.points = self.getValuesForGroup(table, group, node)
+			buckets = append(buckets, bucket)
+			return nil
+		}
 		points = append(points, self.getValuesForGroup(table, group, node)...)
 		return nil
 	}
@@ -250,8 +381,7 @@ func (self *AggregatorEngine) runAggregatesForTable(table string) (bool, error)
 
 		startBucket := self.getTimestampBucket(timestampRange.startTime)
 		endBucket := self.getTimestampBucket(timestampRange.endTime)
-		durationMicro := self.duration.Nanoseconds() / 1000
-		traverser := newBucketTraverser(trie, len(self.elems), len(self.aggregators), startBucket, endBucket, durationMicro, self.ascending)
+		traverser := newBucketTraverser(trie, len(self.elems), len(self.aggregators), startBucket, endBucket, self.getNextBucket, self.getPreviousBucket, self.ascending)
 		// apply the function f to the nodes of the trie, such that n1 is
 		// applied before n2 iff n1's timestamp is lower (or higher in
 		// case of descending queries) than the timestamp of n2
@@ -262,6 +392,9 @@ func (self *AggregatorEngine) runAggregatesForTable(table string) (bool, error)
 	if err != nil {
 		panic(err)
 	}
+	if buckets != nil {
+		points = fillBuckets(buckets, self.fillType, len(self.fields)-len(self.elems), self.ascending, state.filled)
+	}
 	trie.Clear()
 	return self.next.Yield(&protocol.Series{
 		Name:   &table,
@@ -325,6 +458,49 @@ func (self *AggregatorEngine) getValuesForGroup(table string, group []*protocol.
 	return points
 }
 
+// getPartialValuesForGroup returns a point with the serialized state of
+// each aggregator followed by the group by values.
+func (self *AggregatorEngine) getPartialValuesForGroup(table string, group []*protocol.FieldValue, node *Node) (*protocol.Point, error) {
+	point := &protocol.Point{}
+	if self.duration != nil {
+		point.SetTimestampInMicroseconds(self.getSeriesState(table).lastTimestamp)
+	} else {
+		point.SetTimestampInMicroseconds(0)
+	}
+
+	for idx, aggregator := range self.aggregators {
+		value, err := marshalPartialState(aggregator.(PartialAggregator), node.states[idx])
+		if err != nil {
+			return nil, err
+		}
+		point.Values = append(point.Values, value)
+		node.states[idx] = nil
+	}
+
+	for idx := range self.elems {
+		point.Values = append(point.Values, group[idx])
+	}
+	return point, nil
+}
+
+// getPartialColumns returns the index of each aggregator's partial state
+// column in the series.
+func (self *AggregatorEngine) getPartialColumns(series *protocol.Series) ([]int, error) {
+	columns := make([]int, len(self.aggregators))
+outer:
+	for idx := range self.aggregators {
+		name := partialColumnName(idx)
+		for i, field := range series.Fields {
+			if field == name {
+				columns[idx] = i
+				continue outer
+			}
+		}
+		return nil, fmt.Errorf("missing partial state column %s in series %s", name, series.GetName())
+	}
+	return columns, nil
+}
+
 func (self *AggregatorEngine) init(query *parser.SelectQuery) error {
 	return nil
 }
@@ -345,9 +521,22 @@ func NewAggregatorEngine(query *parser.SelectQuery, next Processor) (*Aggregator
 	if err != nil {
 		return nil, err
 	}
+	ae.offset, err = query.GetGroupByClause().GetGroupByTimeOffset()
+	if err != nil {
+		return nil, err
+	}
+	ae.location = query.GetGroupByClause().Location
 
 	ae.aggregators = []Aggregator{}
 
+	// Aggregators return the fill value for buckets without points. Other
+	// fills return null values which are replaced when filling from the
+	// neighbouring buckets.
+	var defaultValue *parser.Value
+	if query.GetGroupByClause().FillType == parser.FillNumber {
+		defaultValue = query.GetGroupByClause().FillValue
+	}
+
 	for _, value := range query.GetColumnNames() {
 		if !value.IsFunctionCall() {
 			continue
@@ -357,7 +546,7 @@ func NewAggregatorEngine(query *parser.SelectQuery, next Processor) (*Aggregator
 		if initializer == nil {
 			return nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("Unknown function %s", value.Name))
 		}
-		aggregator, err := initializer(query, value, query.GetGroupByClause().FillValue)
+		aggregator, err := initializer(query, value, defaultValue)
 		if err != nil {
 			return nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("%s", err))
 		}
@@ -372,14 +561,15 @@ func NewAggregatorEngine(query *parser.SelectQuery, next Processor) (*Aggregator
 	}
 
 	ae.isFillQuery = query.GetGroupByClause().FillWithZero
+	ae.fillType = query.GetGroupByClause().FillType
 
 	// This is a special case for issue #426. If the start time is
 	// specified and there's a group by clause and fill with zero, then
 	// we need to fill the entire range from start time to end time
 	if query.IsStartTimeSpecified() && ae.duration != nil && ae.isFillQuery {
 		ae.startTimeSpecified = true
-		ae.startTime = query.GetStartTime().Truncate(*ae.duration).UnixNano() / 1000
-		ae.endTime = query.GetEndTime().Truncate(*ae.duration).UnixNano() / 1000
+		ae.startTime = ae.getTimestampBucket(query.GetStartTime().UnixNano() / 1000)
+		ae.endTime = ae.getTimestampBucket(query.GetEndTime().UnixNano() / 1000)
 	}
 
 	ae.initializeFields()
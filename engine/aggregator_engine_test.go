@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+	. "launchpad.net/gocheck"
+)
+
+type TimeBucketSuite struct{}
+
+var _ = Suite(&TimeBucketSuite{})
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+func newTimeBucketEngine(c *C, query string) *AggregatorEngine {
+	q, err := parser.ParseSelectQuery(query)
+	c.Assert(err, IsNil)
+	e, err := NewAggregatorEngine(q, &seriesRecorder{})
+	c.Assert(err, IsNil)
+	return e
+}
+
+func micro(t time.Time) int64 {
+	return t.UnixNano() / 1000
+}
+
+// Ensure an offset shifts the start of the buckets.
+func (self *TimeBucketSuite) TestOffset(c *C) {
+	e := newTimeBucketEngine(c, "select count(value) from cpu group by time(1d, 6h)")
+	c.Assert(e.getTimestampBucket(micro(time.Date(2014, 3, 9, 5, 59, 0, 0, time.UTC))), Equals, micro(time.Date(2014, 3, 8, 6, 0, 0, 0, time.UTC)))
+	c.Assert(e.getTimestampBucket(micro(time.Date(2014, 3, 9, 6, 0, 0, 0, time.UTC))), Equals, micro(time.Date(2014, 3, 9, 6, 0, 0, 0, time.UTC)))
+
+	// Offsets of a whole interval don't change the buckets.
+	e = newTimeBucketEngine(c, "select count(value) from cpu group by time(1h, 2h)")
+	c.Assert(e.getTimestampBucket(micro(time.Date(2014, 3, 9, 5, 59, 0, 0, time.UTC))), Equals, micro(time.Date(2014, 3, 9, 5, 0, 0, 0, time.UTC)))
+}
+
+// Ensure daily buckets start at local midnight, or at the offset after
+// local midnight, on days with a daylight saving time transition.
+func (self *TimeBucketSuite) TestTimeZone(c *C) {
+	location := mustLoadLocation("America/New_York")
+	for _, offset := range []int{0, 6} {
+		query := "select count(value) from cpu group by time(1d) tz('America/New_York')"
+		if offset != 0 {
+			query = "select count(value) from cpu group by time(1d, 6h) tz('America/New_York')"
+		}
+		e := newTimeBucketEngine(c, query)
+
+		for _, start := range []time.Time{
+			time.Date(2014, 3, 8, 0, 0, 0, 0, time.UTC),
+			time.Date(2014, 11, 1, 0, 0, 0, 0, time.UTC),
+		} {
+			for t := start; t.Before(start.Add(72 * time.Hour)); t = t.Add(15 * time.Minute) {
+				local := t.In(location)
+				if local.Hour() < offset {
+					local = local.AddDate(0, 0, -1)
+				}
+				expected := time.Date(local.Year(), local.Month(), local.Day(), offset, 0, 0, 0, location)
+				c.Assert(e.getTimestampBucket(micro(t)), Equals, micro(expected), Commentf("%s %s", query, t))
+			}
+		}
+	}
+
+	// Hourly buckets are the same as in UTC.
+	e := newTimeBucketEngine(c, "select count(value) from cpu group by time(1h) tz('America/New_York')")
+	for t := time.Date(2014, 11, 2, 0, 0, 0, 0, time.UTC); t.Before(time.Date(2014, 11, 3, 0, 0, 0, 0, time.UTC)); t = t.Add(10 * time.Minute) {
+		c.Assert(e.getTimestampBucket(micro(t)), Equals, micro(t.Truncate(time.Hour)), Commentf("%s", t))
+	}
+
+	// Weekly buckets start on the local Sunday midnight.
+	e = newTimeBucketEngine(c, "select count(value) from cpu group by time(1w) tz('America/New_York')")
+	c.Assert(e.getTimestampBucket(micro(time.Date(2014, 3, 9, 4, 30, 0, 0, time.UTC))), Equals, micro(time.Date(2014, 3, 2, 0, 0, 0, 0, location)))
+	c.Assert(e.getTimestampBucket(micro(time.Date(2014, 3, 9, 5, 30, 0, 0, time.UTC))), Equals, micro(time.Date(2014, 3, 9, 0, 0, 0, 0, location)))
+}
+
+// Ensure stepping through buckets visits each local day or week once.
+func (self *TimeBucketSuite) TestNextBucket(c *C) {
+	location := mustLoadLocation("America/New_York")
+	for query, count := range map[string]int{
+		"select count(value) from cpu group by time(1d) tz('America/New_York')": 364,
+		"select count(value) from cpu group by time(1w) tz('America/New_York')": 52,
+	} {
+		e := newTimeBucketEngine(c, query)
+		var buckets []int64
+		for bucket := micro(time.Date(2014, 1, 5, 0, 0, 0, 0, location)); bucket < micro(time.Date(2015, 1, 4, 0, 0, 0, 0, location)); bucket = e.getNextBucket(bucket) {
+			buckets = append(buckets, bucket)
+			if len(buckets) > 1 {
+				c.Assert(e.getPreviousBucket(bucket), Equals, buckets[len(buckets)-2])
+			}
+		}
+		c.Assert(buckets, HasLen, count, Commentf(query))
+	}
+}
+
+// Ensure filled buckets of a time zone follow local midnights.
+func (self *TimeBucketSuite) TestFill(c *C) {
+	location := mustLoadLocation("America/New_York")
+	q, err := parser.ParseSelectQuery("select count(value) from cpu group by time(1d) fill(0) tz('America/New_York')")
+	c.Assert(err, IsNil)
+	r := &seriesRecorder{}
+	e, err := NewAggregatorEngine(q, r)
+	c.Assert(err, IsNil)
+
+	series := &protocol.Series{Name: protocol.String("cpu"), Fields: []string{"value"}}
+	for _, t := range []time.Time{
+		time.Date(2014, 3, 10, 23, 0, 0, 0, location),
+		time.Date(2014, 3, 8, 0, 30, 0, 0, location),
+		time.Date(2014, 3, 7, 23, 30, 0, 0, location),
+	} {
+		p := &protocol.Point{Values: []*protocol.FieldValue{{DoubleValue: protocol.Float64(1)}}}
+		p.SetTimestampInMicroseconds(micro(t))
+		series.Points = append(series.Points, p)
+	}
+	_, err = e.Yield(series)
+	c.Assert(err, IsNil)
+	c.Assert(e.Close(), IsNil)
+
+	var timestamps []int64
+	for _, p := range r.series[0].Points {
+		timestamps = append(timestamps, *p.GetTimestampInMicroseconds())
+	}
+	c.Assert(timestamps, DeepEquals, []int64{
+		micro(time.Date(2014, 3, 10, 0, 0, 0, 0, location)),
+		micro(time.Date(2014, 3, 9, 0, 0, 0, 0, location)),
+		micro(time.Date(2014, 3, 8, 0, 0, 0, 0, location)),
+		micro(time.Date(2014, 3, 7, 0, 0, 0, 0, location)),
+	})
+	c.Assert(r.values(), DeepEquals, [][]interface{}{{int64(1)}, {int64(0)}, {int64(1)}, {int64(1)}})
+}
@@ -10,6 +10,7 @@ import (
 
 	"code.google.com/p/goprotobuf/proto"
 
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/parser"
 	"github.com/influxdb/influxdb/protocol"
 )
@@ -37,14 +38,21 @@ func init() {
 	registeredAggregators["histogram"] = NewHistogramAggregator
 	registeredAggregators["derivative"] = NewDerivativeAggregator
 	registeredAggregators["difference"] = NewDifferenceAggregator
+	registeredAggregators["rate"] = NewRateAggregator
+	registeredAggregators["non_negative_derivative"] = NewNonNegativeDerivativeAggregator
 	registeredAggregators["stddev"] = NewStandardDeviationAggregator
 	registeredAggregators["min"] = NewMinAggregator
 	registeredAggregators["sum"] = NewSumAggregator
 	registeredAggregators["percentile"] = NewPercentileAggregator
 	registeredAggregators["median"] = NewMedianAggregator
+	registeredAggregators["percentile_approx"] = NewApproxPercentileAggregator
+	registeredAggregators["median_approx"] = NewApproxMedianAggregator
 	registeredAggregators["mean"] = NewMeanAggregator
 	registeredAggregators["mode"] = NewModeAggregator
 	registeredAggregators["distinct"] = NewDistinctAggregator
+	registeredAggregators["count_distinct_approx"] = NewApproxCountDistinctAggregator
+	registeredAggregators["count_distinct_sketch"] = NewCountDistinctSketchAggregator
+	registeredAggregators["count_distinct_merge"] = NewCountDistinctMergeAggregator
 	registeredAggregators["first"] = NewFirstAggregator
 	registeredAggregators["last"] = NewLastAggregator
 	registeredAggregators["top"] = NewTopAggregator
@@ -59,6 +67,41 @@ func GetRegisteredAggregators() (names []string) {
 	return
 }
 
+// The minimum and maximum number of arguments for aggregators that
+// don't take exactly one argument.
+var aggregatorArgs = map[string][2]int{
+	"histogram":               {1, 4},
+	"percentile":              {2, 2},
+	"percentile_approx":       {2, 3},
+	"median_approx":           {1, 2},
+	"count_distinct_approx":   {1, 2},
+	"count_distinct_sketch":   {1, 2},
+	"rate":                    {1, 3},
+	"non_negative_derivative": {1, 3},
+	"top":                     {2, 2},
+	"bottom":                  {2, 2},
+}
+
+// Functions returns the registered aggregators, window functions and
+// holt_winters() as query functions so that queries can be validated before
+// they are executed.
+func Functions() []*influxql.Function {
+	var a []*influxql.Function
+	for name := range registeredAggregators {
+		fn := &influxql.Function{Name: name, MinArgs: 1, MaxArgs: 1, Aggregate: true}
+		if args, ok := aggregatorArgs[name]; ok {
+			fn.MinArgs, fn.MaxArgs = args[0], args[1]
+		}
+		a = append(a, fn)
+	}
+	for name := range registeredWindowFunctions {
+		args := windowFunctionArgs[name]
+		a = append(a, &influxql.Function{Name: name, MinArgs: args[0], MaxArgs: args[1]})
+	}
+	a = append(a, &influxql.Function{Name: "holt_winters", MinArgs: 3, MaxArgs: 3})
+	return a
+}
+
 type AbstractAggregator struct {
 	Aggregator
 	value   *parser.Value
@@ -83,6 +126,15 @@ func wrapDefaultValue(defaultValue *parser.Value) (*protocol.FieldValue, error)
 		v, _ := strconv.Atoi(defaultValue.Name)
 		value := int64(v)
 		return &protocol.FieldValue{Int64Value: &value}, nil
+	case parser.ValueFloat:
+		value, err := strconv.ParseFloat(defaultValue.Name, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &protocol.FieldValue{DoubleValue: &value}, nil
+	case parser.ValueString:
+		value := defaultValue.Name
+		return &protocol.FieldValue{StringValue: &value}, nil
 	case parser.ValueSimpleName:
 		if defaultValue.Name != "null" {
 			return nil, fmt.Errorf("Unsupported fill value %s", defaultValue.Name)
@@ -570,6 +622,175 @@ func NewDifferenceAggregator(q *parser.SelectQuery, v *parser.Value, defaultValu
 	}, nil
 }
 
+//
+// Counter Rate Aggregator
+//
+
+type CounterRateAggregatorState struct {
+	firstTimestamp int64
+	firstValue     float64
+	lastTimestamp  int64
+	lastValue      float64
+	increase       float64 // the sum of the increases between points
+	elapsed        int64   // the microseconds over which increase was measured
+}
+
+// CounterRateAggregator computes the rate of a counter per unit of time.
+// Unlike derivative(), a counter that goes down doesn't produce a negative
+// rate. If the counter wraps around at a maximum, the increase is counted up
+// to the maximum and from zero. Otherwise the counter was reset: rate()
+// counts the increase from zero and non_negative_derivative() ignores the
+// interval.
+type CounterRateAggregator struct {
+	AbstractAggregator
+	name         string
+	unit         time.Duration
+	max          *float64
+	countResets  bool
+	defaultValue *protocol.FieldValue
+	alias        string
+}
+
+// increase returns how much the counter increased from one value to the
+// next and whether the interval is counted.
+func (self *CounterRateAggregator) increase(from, to float64) (float64, bool) {
+	if to >= from {
+		return to - from, true
+	}
+	if self.max != nil && from <= *self.max {
+		return *self.max - from + to + 1, true
+	}
+	return to, self.countResets
+}
+
+// addInterval adds the increase between two consecutive values.
+func (self *CounterRateAggregator) addInterval(s *CounterRateAggregatorState, fromTimestamp int64, from float64, toTimestamp int64, to float64) {
+	if delta, ok := self.increase(from, to); ok {
+		s.increase += delta
+		s.elapsed += toTimestamp - fromTimestamp
+	}
+}
+
+func (self *CounterRateAggregator) AggregatePoint(state interface{}, p *protocol.Point) (interface{}, error) {
+	fieldValue, err := GetValue(self.value, self.columns, p)
+	if err != nil {
+		return nil, err
+	}
+
+	var value float64
+	if ptr := fieldValue.Int64Value; ptr != nil {
+		value = float64(*ptr)
+	} else if ptr := fieldValue.DoubleValue; ptr != nil {
+		value = *ptr
+	} else {
+		// else ignore this point
+		return state, nil
+	}
+	timestamp := p.GetTimestamp()
+
+	s, ok := state.(*CounterRateAggregatorState)
+	if !ok {
+		return &CounterRateAggregatorState{
+			firstTimestamp: timestamp,
+			firstValue:     value,
+			lastTimestamp:  timestamp,
+			lastValue:      value,
+		}, nil
+	}
+
+	// Points are read in ascending or descending order, so the new point
+	// follows the last point or precedes the first one.
+	if timestamp >= s.lastTimestamp {
+		self.addInterval(s, s.lastTimestamp, s.lastValue, timestamp, value)
+		s.lastTimestamp, s.lastValue = timestamp, value
+	} else if timestamp <= s.firstTimestamp {
+		self.addInterval(s, timestamp, value, s.firstTimestamp, s.firstValue)
+		s.firstTimestamp, s.firstValue = timestamp, value
+	}
+	return s, nil
+}
+
+func (self *CounterRateAggregator) ColumnNames() []string {
+	if self.alias != "" {
+		return []string{self.alias}
+	}
+	return []string{self.name}
+}
+
+func (self *CounterRateAggregator) GetValues(state interface{}) [][]*protocol.FieldValue {
+	s, ok := state.(*CounterRateAggregatorState)
+	if !ok || s.elapsed == 0 {
+		return [][]*protocol.FieldValue{{self.defaultValue}}
+	}
+
+	rate := s.increase / float64(s.elapsed) * float64(self.unit/time.Microsecond)
+	return [][]*protocol.FieldValue{
+		{
+			{DoubleValue: &rate},
+		},
+	}
+}
+
+func newCounterRateAggregator(name string, countResets bool, value *parser.Value, defaultValue *parser.Value) (Aggregator, error) {
+	if len(value.Elems) < 1 || len(value.Elems) > 3 {
+		return nil, parser.NewQueryError(parser.WrongNumberOfArguments, fmt.Sprintf("function %s() requires one to three arguments", name))
+	}
+
+	if value.Elems[0].Type == parser.ValueWildcard {
+		return nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("function %s() doesn't work with wildcards", name))
+	}
+
+	unit := time.Second
+	if len(value.Elems) > 1 {
+		var err error
+		if value.Elems[1].Type != parser.ValueDuration {
+			err = fmt.Errorf("not a duration")
+		} else {
+			unit, err = parser.ParseTimeDuration(value.Elems[1].Name)
+		}
+		if err != nil || unit < time.Microsecond {
+			return nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("function %s() requires a duration such as 1s as the second argument", name))
+		}
+	}
+
+	var max *float64
+	if len(value.Elems) > 2 {
+		m, err := strconv.ParseFloat(value.Elems[2].Name, 64)
+		if err != nil || m <= 0 {
+			return nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("function %s() requires a positive numeric counter maximum as the third argument", name))
+		}
+		max = &m
+	}
+
+	wrappedDefaultValue, err := wrapDefaultValue(defaultValue)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CounterRateAggregator{
+		AbstractAggregator: AbstractAggregator{
+			value: value.Elems[0],
+		},
+		name:         name,
+		unit:         unit,
+		max:          max,
+		countResets:  countResets,
+		defaultValue: wrappedDefaultValue,
+		alias:        value.Alias,
+	}, nil
+}
+
+// NewRateAggregator returns an aggregator for rate(counter[, unit[, max]]).
+func NewRateAggregator(_ *parser.SelectQuery, value *parser.Value, defaultValue *parser.Value) (Aggregator, error) {
+	return newCounterRateAggregator("rate", true, value, defaultValue)
+}
+
+// NewNonNegativeDerivativeAggregator returns an aggregator for
+// non_negative_derivative(counter[, unit[, max]]).
+func NewNonNegativeDerivativeAggregator(_ *parser.SelectQuery, value *parser.Value, defaultValue *parser.Value) (Aggregator, error) {
+	return newCounterRateAggregator("non_negative_derivative", false, value, defaultValue)
+}
+
 //
 // Histogram Aggregator
 //
@@ -1028,6 +1249,122 @@ func NewPercentileAggregator(_ *parser.SelectQuery, value *parser.Value, default
 	}, nil
 }
 
+//
+// Approximate Percentile Aggregator
+//
+
+// The default compression of t-digests. Higher values are more accurate
+// but use more memory.
+const defaultTDigestCompression = 100
+
+type ApproxPercentileAggregator struct {
+	AbstractAggregator
+	functionName string
+	percentile   float64
+	compression  float64
+	defaultValue *protocol.FieldValue
+}
+
+func (self *ApproxPercentileAggregator) AggregatePoint(state interface{}, p *protocol.Point) (interface{}, error) {
+	v, err := GetValue(self.value, self.columns, p)
+	if err != nil {
+		return nil, err
+	}
+
+	var value float64
+	if v.Int64Value != nil {
+		value = float64(*v.Int64Value)
+	} else if v.DoubleValue != nil {
+		value = *v.DoubleValue
+	} else {
+		return state, nil
+	}
+
+	digest, ok := state.(*tdigest)
+	if !ok {
+		digest = newTDigest(self.compression)
+	}
+	digest.add(value, 1)
+	return digest, nil
+}
+
+func (self *ApproxPercentileAggregator) ColumnNames() []string {
+	return []string{self.functionName}
+}
+
+func (self *ApproxPercentileAggregator) GetValues(state interface{}) [][]*protocol.FieldValue {
+	digest, ok := state.(*tdigest)
+	if !ok {
+		return [][]*protocol.FieldValue{{self.defaultValue}}
+	}
+	value := digest.quantile(self.percentile / 100)
+	return [][]*protocol.FieldValue{
+		{{DoubleValue: &value}},
+	}
+}
+
+func newApproxPercentileAggregator(name string, value *parser.Value, percentile float64, compression *parser.Value, defaultValue *parser.Value) (Aggregator, error) {
+	if value.Elems[0].Type == parser.ValueWildcard {
+		return nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("function %s() doesn't work with wildcards", name))
+	}
+
+	c := float64(defaultTDigestCompression)
+	if compression != nil {
+		var err error
+		if c, err = strconv.ParseFloat(compression.Name, 64); err != nil || c < 1 {
+			return nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("function %s() requires a numeric compression of at least 1", name))
+		}
+	}
+
+	wrappedDefaultValue, err := wrapDefaultValue(defaultValue)
+	if err != nil {
+		return nil, err
+	}
+
+	if value.Alias != "" {
+		name = value.Alias
+	}
+
+	return &ApproxPercentileAggregator{
+		AbstractAggregator: AbstractAggregator{
+			value: value.Elems[0],
+		},
+		functionName: name,
+		percentile:   percentile,
+		compression:  c,
+		defaultValue: wrappedDefaultValue,
+	}, nil
+}
+
+func NewApproxPercentileAggregator(_ *parser.SelectQuery, value *parser.Value, defaultValue *parser.Value) (Aggregator, error) {
+	if len(value.Elems) != 2 && len(value.Elems) != 3 {
+		return nil, parser.NewQueryError(parser.WrongNumberOfArguments, "function percentile_approx() requires two or three arguments")
+	}
+
+	percentile, err := strconv.ParseFloat(value.Elems[1].Name, 64)
+	if err != nil || percentile <= 0 || percentile >= 100 {
+		return nil, parser.NewQueryError(parser.InvalidArgument, "function percentile_approx() requires a numeric second argument between 0 and 100")
+	}
+
+	var compression *parser.Value
+	if len(value.Elems) == 3 {
+		compression = value.Elems[2]
+	}
+	return newApproxPercentileAggregator("percentile_approx", value, percentile, compression, defaultValue)
+}
+
+func NewApproxMedianAggregator(_ *parser.SelectQuery, value *parser.Value, defaultValue *parser.Value) (Aggregator, error) {
+	if len(value.Elems) != 1 && len(value.Elems) != 2 {
+		return nil, parser.NewQueryError(parser.WrongNumberOfArguments, "function median_approx() requires one or two arguments")
+	}
+
+	var compression *parser.Value
+	if len(value.Elems) == 2 {
+		compression = value.Elems[1]
+	}
+	return newApproxPercentileAggregator("median_approx", value, 50, compression, defaultValue)
+}
+
 //
 // Mode Aggregator
 //
@@ -1263,6 +1600,130 @@ func NewDistinctAggregator(_ *parser.SelectQuery, value *parser.Value, defaultVa
 	}, nil
 }
 
+//
+// Approximate Count Distinct Aggregator
+//
+
+// HyperLogLogAggregator estimates the number of distinct values with a
+// hyperloglog sketch. It can return the sketch itself so a continuous query
+// can store it, and can merge stored sketches to count distinct values over
+// longer periods, e.g. weekly from daily sketches.
+type HyperLogLogAggregator struct {
+	AbstractAggregator
+	name          string
+	precision     uint8
+	mergeSketches bool // the column holds sketches instead of values
+	returnSketch  bool // return the sketch instead of the estimate
+	defaultValue  *protocol.FieldValue
+}
+
+func (self *HyperLogLogAggregator) AggregatePoint(state interface{}, p *protocol.Point) (interface{}, error) {
+	v, err := GetValue(self.value, self.columns, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !self.mergeSketches {
+		h, ok := state.(*hyperLogLog)
+		if !ok {
+			h = newHyperLogLog(self.precision)
+		}
+		h.add(v)
+		return h, nil
+	}
+
+	if v.StringValue == nil {
+		return state, nil
+	}
+	other, err := parseHyperLogLog(*v.StringValue)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return other, nil
+	}
+	h := state.(*hyperLogLog)
+	if err := h.merge(other); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (self *HyperLogLogAggregator) ColumnNames() []string {
+	return []string{self.name}
+}
+
+func (self *HyperLogLogAggregator) GetValues(state interface{}) [][]*protocol.FieldValue {
+	h, ok := state.(*hyperLogLog)
+	if !ok {
+		return [][]*protocol.FieldValue{{self.defaultValue}}
+	}
+
+	if self.returnSketch {
+		return [][]*protocol.FieldValue{
+			{{StringValue: protocol.String(h.String())}},
+		}
+	}
+	return [][]*protocol.FieldValue{
+		{{Int64Value: protocol.Int64(h.count())}},
+	}
+}
+
+func newHyperLogLogAggregator(name string, value *parser.Value, mergeSketches, returnSketch bool, defaultValue *parser.Value) (Aggregator, error) {
+	if value.Elems[0].Type == parser.ValueWildcard {
+		return nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("function %s() doesn't work with wildcards", name))
+	}
+
+	precision := defaultHyperLogLogPrecision
+	if len(value.Elems) == 2 {
+		var err error
+		if precision, err = strconv.Atoi(value.Elems[1].Name); err != nil || precision < minHyperLogLogPrecision || precision > maxHyperLogLogPrecision {
+			return nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("function %s() requires a precision between %d and %d", name, minHyperLogLogPrecision, maxHyperLogLogPrecision))
+		}
+	}
+
+	wrappedDefaultValue, err := wrapDefaultValue(defaultValue)
+	if err != nil {
+		return nil, err
+	}
+
+	if value.Alias != "" {
+		name = value.Alias
+	}
+
+	return &HyperLogLogAggregator{
+		AbstractAggregator: AbstractAggregator{
+			value: value.Elems[0],
+		},
+		name:          name,
+		precision:     uint8(precision),
+		mergeSketches: mergeSketches,
+		returnSketch:  returnSketch,
+		defaultValue:  wrappedDefaultValue,
+	}, nil
+}
+
+func NewApproxCountDistinctAggregator(_ *parser.SelectQuery, value *parser.Value, defaultValue *parser.Value) (Aggregator, error) {
+	if len(value.Elems) != 1 && len(value.Elems) != 2 {
+		return nil, parser.NewQueryError(parser.WrongNumberOfArguments, "function count_distinct_approx() requires one or two arguments")
+	}
+	return newHyperLogLogAggregator("count_distinct_approx", value, false, false, defaultValue)
+}
+
+func NewCountDistinctSketchAggregator(_ *parser.SelectQuery, value *parser.Value, defaultValue *parser.Value) (Aggregator, error) {
+	if len(value.Elems) != 1 && len(value.Elems) != 2 {
+		return nil, parser.NewQueryError(parser.WrongNumberOfArguments, "function count_distinct_sketch() requires one or two arguments")
+	}
+	return newHyperLogLogAggregator("count_distinct_sketch", value, false, true, defaultValue)
+}
+
+func NewCountDistinctMergeAggregator(_ *parser.SelectQuery, value *parser.Value, defaultValue *parser.Value) (Aggregator, error) {
+	if len(value.Elems) != 1 {
+		return nil, parser.NewQueryError(parser.WrongNumberOfArguments, "function count_distinct_merge() requires exactly one argument")
+	}
+	return newHyperLogLogAggregator("count_distinct_merge", value, true, false, defaultValue)
+}
+
 //
 // Max, Min and Sum Aggregators
 //
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+	. "launchpad.net/gocheck"
+)
+
+type CounterRateSuite struct{}
+
+var _ = Suite(&CounterRateSuite{})
+
+// counterPoints returns a point with each value, one minute apart.
+func counterPoints(values ...int64) []*protocol.Point {
+	var points []*protocol.Point
+	for i, v := range values {
+		points = append(points, &protocol.Point{
+			Values:    []*protocol.FieldValue{{Int64Value: protocol.Int64(v)}},
+			Timestamp: protocol.Int64(int64(i) * int64(time.Minute/time.Microsecond)),
+		})
+	}
+	return points
+}
+
+func mustCounterRateAggregator(c *C, query string) *CounterRateAggregator {
+	q, err := parser.ParseSelectQuery(query)
+	c.Assert(err, IsNil)
+	ae, err := NewAggregatorEngine(q, nil)
+	c.Assert(err, IsNil)
+	aggregator := ae.aggregators[0].(*CounterRateAggregator)
+	c.Assert(aggregator.InitializeFieldsMetadata(&protocol.Series{Fields: []string{"value"}}), IsNil)
+	return aggregator
+}
+
+// Ensure counter resets and wraparounds don't produce negative rates in
+// either order and when states are merged.
+func (self *CounterRateSuite) TestRate(c *C) {
+	points := counterPoints(0, 600, 1200, 100, 700)
+	for query, expected := range map[string]float64{
+		// The counter was reset and counted 100 since.
+		"select rate(value, 1m) from foo": 1900.0 / 4,
+		"select rate(value) from foo":     1900.0 / 4 / 60,
+		// The interval with the reset is ignored.
+		"select non_negative_derivative(value, 1m) from foo": 1800.0 / 3,
+		// The counter wrapped around after 1299.
+		"select rate(value, 1m, 1299) from foo":                    2000.0 / 4,
+		"select non_negative_derivative(value, 1m, 1299) from foo": 2000.0 / 4,
+	} {
+		aggregator := mustCounterRateAggregator(c, query)
+
+		var asc, desc, a, b interface{}
+		var err error
+		for i := range points {
+			asc, err = aggregator.AggregatePoint(asc, points[i])
+			c.Assert(err, IsNil)
+			desc, err = aggregator.AggregatePoint(desc, points[len(points)-1-i])
+			c.Assert(err, IsNil)
+			if i < 3 {
+				a, err = aggregator.AggregatePoint(a, points[i])
+			} else {
+				b, err = aggregator.AggregatePoint(b, points[i])
+			}
+			c.Assert(err, IsNil)
+		}
+		merged, err := aggregator.MergeStates(b, a)
+		c.Assert(err, IsNil)
+
+		for _, state := range []interface{}{asc, desc, merged} {
+			c.Assert(*aggregator.GetValues(state)[0][0].DoubleValue, Equals, expected, Commentf(query))
+		}
+	}
+}
+
+// Ensure a rate isn't returned without an interval.
+func (self *CounterRateSuite) TestSinglePoint(c *C) {
+	aggregator := mustCounterRateAggregator(c, "select rate(value, 1s) from foo")
+	state, err := aggregator.AggregatePoint(nil, counterPoints(10)[0])
+	c.Assert(err, IsNil)
+	c.Assert(aggregator.GetValues(state), DeepEquals, [][]*protocol.FieldValue{{nil}})
+}
+
+// Ensure invalid arguments are rejected.
+func (self *CounterRateSuite) TestInvalidArguments(c *C) {
+	for _, query := range []string{
+		"select rate(*, 1s) from foo",
+		"select rate(value, 10) from foo",
+		"select non_negative_derivative(value, 1s, -1) from foo",
+		"select non_negative_derivative(value, 1s, 10, 20) from foo",
+	} {
+		q, err := parser.ParseSelectQuery(query)
+		if err == nil {
+			_, err = NewAggregatorEngine(q, nil)
+		}
+		c.Assert(err, NotNil, Commentf(query))
+	}
+}
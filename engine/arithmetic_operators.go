@@ -33,6 +33,11 @@ func GetValue(value *parser.Value, fields []string, point *protocol.Point) (*pro
 			}
 		}
 		return nil, fmt.Errorf("Invalid column name %s", value.Name)
+	case parser.ValueFunctionCall:
+		if idx := fieldIndex(fields, value.GetString()); idx >= 0 {
+			return point.Values[idx], nil
+		}
+		return nil, fmt.Errorf("Cannot process function call %s in expression", value.Name)
 	case parser.ValueExpression:
 		operator := registeredArithmeticOperator[value.Name]
 		return operator(value.Elems, fields, point)
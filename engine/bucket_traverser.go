@@ -8,23 +8,23 @@ import "github.com/influxdb/influxdb/protocol"
 // function on all nodes with the timestamp in either ascending or
 // descending order
 type bucketTraverser struct {
-	trie        *Trie // the trie we're going to traverse
-	last        int64 // the last bucket's timestamp that we will visit
-	current     int64 // the current timestamp
-	step        int64 // the step (could be negative if the query is descending)
-	levels      int   // the number of levels in the trie
-	aggregators int   // the number of aggregators that we have to run
-	asc         bool  // ascending or descending query
+	trie        *Trie             // the trie we're going to traverse
+	last        int64             // the last bucket's timestamp that we will visit
+	current     int64             // the current timestamp
+	step        func(int64) int64 // returns the next (or previous if the query is descending) bucket's timestamp
+	levels      int               // the number of levels in the trie
+	aggregators int               // the number of aggregators that we have to run
+	asc         bool              // ascending or descending query
 }
 
-func newBucketTraverser(trie *Trie, levels, aggregators int, startBucket, endBucket, durationMicro int64, asc bool) *bucketTraverser {
+func newBucketTraverser(trie *Trie, levels, aggregators int, startBucket, endBucket int64, next, previous func(int64) int64, asc bool) *bucketTraverser {
 	bt := &bucketTraverser{
 		trie:        trie,
 		levels:      levels,
 		aggregators: aggregators,
 		last:        startBucket,
 		current:     endBucket,
-		step:        -durationMicro,
+		step:        previous,
 		asc:         asc,
 	}
 	if !asc {
@@ -33,7 +33,7 @@ func newBucketTraverser(trie *Trie, levels, aggregators int, startBucket, endBuc
 
 	bt.last = endBucket
 	bt.current = startBucket
-	bt.step = durationMicro
+	bt.step = next
 	return bt
 }
 
@@ -60,7 +60,7 @@ func (bt *bucketTraverser) apply(f func(fv []*protocol.FieldValue, node *Node) e
 			return err
 		}
 
-		bt.current += bt.step
+		bt.current = bt.step(bt.current)
 	}
 	return nil
 }
@@ -7,6 +7,16 @@ func NewQueryEngine(next Processor, query *parser.SelectQuery, shards []uint64)
 
 	var engine Processor = NewPassthroughEngineWithLimit(next, 1, limit)
 
+	// Window functions, forecasts and having conditions are computed over
+	// the output of the query without them.
+	if HasPostAggregation(query) {
+		postAggregationEngine, inner, err := NewPostAggregationEngine(query, engine)
+		if err != nil {
+			return nil, err
+		}
+		engine, query = postAggregationEngine, inner
+	}
+
 	var err error
 	if query.HasAggregates() {
 		engine, err = NewAggregatorEngine(query, engine)
@@ -36,3 +46,41 @@ func NewQueryEngine(next Processor, query *parser.SelectQuery, shards []uint64)
 	}
 	return engine, nil
 }
+
+// HasPostAggregation returns true if the query has window functions,
+// forecasts or a having condition, which are computed over the output of
+// the query without them.
+func HasPostAggregation(query *parser.SelectQuery) bool {
+	return HasWindowFunctions(query) || HasHoltWinters(query) || query.GetHavingCondition() != nil
+}
+
+// NewPostAggregationEngine returns the engines for the window functions,
+// forecasts and having condition of a query and the query that has to be
+// run for them. Forecasts are computed after window functions so they can
+// forecast a window function, and the having condition is evaluated last
+// so it can filter both.
+func NewPostAggregationEngine(query *parser.SelectQuery, next Processor) (Processor, *parser.SelectQuery, error) {
+	engine := next
+	if query.GetHavingCondition() != nil {
+		havingEngine, inner, err := NewHavingEngine(query, engine)
+		if err != nil {
+			return nil, nil, err
+		}
+		engine, query = havingEngine, inner
+	}
+	if HasHoltWinters(query) {
+		holtWintersEngine, inner, err := NewHoltWintersEngine(query, engine)
+		if err != nil {
+			return nil, nil, err
+		}
+		engine, query = holtWintersEngine, inner
+	}
+	if HasWindowFunctions(query) {
+		windowEngine, inner, err := NewWindowEngine(query, engine)
+		if err != nil {
+			return nil, nil, err
+		}
+		engine, query = windowEngine, inner
+	}
+	return engine, query, nil
+}
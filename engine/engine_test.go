@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+func BenchmarkQueryEngine_Raw_1(b *testing.B) {
+	benchmarkQueryEngine(b, `select value from cpu`, 1)
+}
+func BenchmarkQueryEngine_Raw_100(b *testing.B) {
+	benchmarkQueryEngine(b, `select value from cpu`, 100)
+}
+func BenchmarkQueryEngine_Where_1(b *testing.B) {
+	benchmarkQueryEngine(b, `select value from cpu where value > 50`, 1)
+}
+func BenchmarkQueryEngine_Where_100(b *testing.B) {
+	benchmarkQueryEngine(b, `select value from cpu where value > 50`, 100)
+}
+func BenchmarkQueryEngine_Mean_1(b *testing.B) {
+	benchmarkQueryEngine(b, `select mean(value) from cpu group by time(1h)`, 1)
+}
+func BenchmarkQueryEngine_Mean_100(b *testing.B) {
+	benchmarkQueryEngine(b, `select mean(value) from cpu group by time(1h)`, 100)
+}
+func BenchmarkQueryEngine_MeanByHost_1(b *testing.B) {
+	benchmarkQueryEngine(b, `select mean(value) from cpu group by time(1h), host`, 1)
+}
+func BenchmarkQueryEngine_MeanByHost_100(b *testing.B) {
+	benchmarkQueryEngine(b, `select mean(value) from cpu group by time(1h), host`, 100)
+}
+func BenchmarkQueryEngine_MeanFill_1(b *testing.B) {
+	benchmarkQueryEngine(b, `select mean(value) from cpu group by time(1h) fill(0)`, 1)
+}
+func BenchmarkQueryEngine_MeanFill_100(b *testing.B) {
+	benchmarkQueryEngine(b, `select mean(value) from cpu group by time(1h) fill(0)`, 100)
+}
+
+// benchmarkQueryEngine runs a query over a week of 10s data yielded to the
+// query engine in series of batchSize points, the same way shards yield the
+// points they read.
+func benchmarkQueryEngine(b *testing.B, query string, batchSize int) {
+	q, err := parser.ParseSelectQuery(query)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Filters change the points so they are generated for each run.
+		b.StopTimer()
+		batches := benchmarkBatches(batchSize)
+		e, err := NewQueryEngine(&seriesRecorder{}, q, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		e = NewFilteringEngine(q, e)
+		b.StartTimer()
+
+		for _, series := range batches {
+			if _, err := e.Yield(series); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkBatches returns a week of 10s points of four hosts, newest first,
+// in series of up to batchSize points.
+func benchmarkBatches(batchSize int) []*protocol.Series {
+	const n = 7 * 24 * 360
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var batches []*protocol.Series
+	for i := 0; i < n; i++ {
+		if i%batchSize == 0 {
+			batches = append(batches, &protocol.Series{
+				Name:   protocol.String("cpu"),
+				Fields: []string{"value", "host"},
+				Points: make([]*protocol.Point, 0, batchSize),
+			})
+		}
+
+		p := &protocol.Point{Values: []*protocol.FieldValue{
+			{DoubleValue: protocol.Float64(float64(i % 100))},
+			{StringValue: protocol.String(fmt.Sprintf("server%d", i%4))},
+		}}
+		p.SetTimestampInMicroseconds(start.Add(time.Duration(n-i)*10*time.Second).UnixNano() / 1000)
+
+		series := batches[len(batches)-1]
+		series.Points = append(series.Points, p)
+	}
+	return batches
+}
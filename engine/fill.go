@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+// filledBucket holds the points of a group's time bucket until the buckets
+// without points are filled from their neighbours.
+type filledBucket struct {
+	group     string
+	timestamp int64
+	empty     bool
+	points    []*protocol.Point
+}
+
+// newFilledBucket returns a bucket for a node of the trie whose last group
+// value is the bucket's timestamp.
+func newFilledBucket(group []*protocol.FieldValue, node *Node) *filledBucket {
+	b := &filledBucket{
+		group:     fmt.Sprint(group[:len(group)-1]),
+		timestamp: group[len(group)-1].GetInt64Value(),
+		empty:     true,
+	}
+	for _, state := range node.states {
+		if state != nil {
+			b.empty = false
+		}
+	}
+	return b
+}
+
+// fillBuckets fills the first columns values of each empty bucket from the
+// buckets of the same group before and after it and returns the points of
+// all buckets. Buckets are in ascending or descending time order.
+//
+// last holds the bucket with points of each group that was flushed last.
+// It is used as the neighbour of the buckets at the start of the next flush
+// and is updated with the buckets of this flush.
+func fillBuckets(buckets []*filledBucket, fillType parser.FillType, columns int, ascending bool, last map[string]*filledBucket) []*protocol.Point {
+	groups := map[string][]*filledBucket{}
+	var names []string
+	for _, b := range buckets {
+		if _, ok := groups[b.group]; !ok {
+			names = append(names, b.group)
+		}
+		groups[b.group] = append(groups[b.group], b)
+	}
+
+	for _, name := range names {
+		group := groups[name]
+		if !ascending {
+			for i, j := 0, len(group)-1; i < j; i, j = i+1, j-1 {
+				group[i], group[j] = group[j], group[i]
+			}
+		}
+
+		// Groups are in ascending time order, so the last flushed bucket
+		// comes before them or, for descending queries, after them.
+		var before, after *filledBucket
+		if ascending {
+			before = last[name]
+		} else {
+			after = last[name]
+		}
+
+		switch fillType {
+		case parser.FillPrevious:
+			fillPrevious(group, before)
+		case parser.FillLinear:
+			fillLinear(group, columns, before, after)
+		}
+
+		for i := range group {
+			b := group[len(group)-1-i]
+			if !ascending {
+				b = group[i]
+			}
+			if !b.empty {
+				last[name] = b
+				break
+			}
+		}
+	}
+
+	var points []*protocol.Point
+	for _, b := range buckets {
+		points = append(points, b.points...)
+	}
+	return points
+}
+
+// fillPrevious copies the points of the last bucket with points into each
+// empty bucket after it, starting with the previous bucket if any.
+func fillPrevious(group []*filledBucket, previous *filledBucket) {
+	for _, b := range group {
+		if !b.empty {
+			previous = b
+			continue
+		} else if previous == nil {
+			continue
+		}
+
+		b.points = nil
+		for _, p := range previous.points {
+			point := &protocol.Point{Values: append([]*protocol.FieldValue(nil), p.Values...)}
+			point.SetTimestampInMicroseconds(b.timestamp)
+			b.points = append(b.points, point)
+		}
+	}
+}
+
+// fillLinear interpolates the values of empty buckets between the buckets
+// with points around them, including the previous and next buckets if any.
+// Buckets at either end of the group stay empty, as do buckets of
+// aggregates which return several points per bucket.
+func fillLinear(group []*filledBucket, columns int, previous, next *filledBucket) {
+	// The next bucket with points after each bucket.
+	nexts := make([]*filledBucket, len(group))
+	for i := len(group) - 1; i >= 0; i-- {
+		nexts[i] = next
+		if !group[i].empty {
+			next = group[i]
+		}
+	}
+
+	for i, b := range group {
+		if !b.empty {
+			previous = b
+			continue
+		}
+		next := nexts[i]
+		if previous == nil || next == nil || len(previous.points) != 1 || len(next.points) != 1 || len(b.points) != 1 {
+			continue
+		}
+
+		fraction := float64(b.timestamp-previous.timestamp) / float64(next.timestamp-previous.timestamp)
+		values := append([]*protocol.FieldValue(nil), b.points[0].Values...)
+		for j := 0; j < columns; j++ {
+			values[j] = interpolate(previous.points[0].Values[j], next.points[0].Values[j], fraction)
+		}
+		b.points[0].Values = values
+	}
+}
+
+// interpolate returns the value at fraction between two values. Integers
+// stay integers and values that aren't numbers are null.
+func interpolate(a, b *protocol.FieldValue, fraction float64) *protocol.FieldValue {
+	if a == nil || b == nil {
+		return nil
+	}
+	if a.Int64Value != nil && b.Int64Value != nil {
+		v := *a.Int64Value + int64(float64(*b.Int64Value-*a.Int64Value)*fraction)
+		return &protocol.FieldValue{Int64Value: &v}
+	}
+
+	var x, y float64
+	if a.Int64Value != nil {
+		x = float64(*a.Int64Value)
+	} else if a.DoubleValue != nil {
+		x = *a.DoubleValue
+	} else {
+		return nil
+	}
+	if b.Int64Value != nil {
+		y = float64(*b.Int64Value)
+	} else if b.DoubleValue != nil {
+		y = *b.DoubleValue
+	} else {
+		return nil
+	}
+	v := x + (y-x)*fraction
+	return &protocol.FieldValue{DoubleValue: &v}
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+	. "launchpad.net/gocheck"
+)
+
+type FillSuite struct{}
+
+var _ = Suite(&FillSuite{})
+
+// seriesRecorder records the series yielded to it.
+type seriesRecorder struct {
+	series []*protocol.Series
+}
+
+func (self *seriesRecorder) Yield(s *protocol.Series) (bool, error) {
+	self.series = append(self.series, s)
+	return true, nil
+}
+func (self *seriesRecorder) Close() error    { return nil }
+func (self *seriesRecorder) Name() string    { return "seriesRecorder" }
+func (self *seriesRecorder) Next() Processor { return nil }
+
+// values returns the values of the recorded points with a null value as nil.
+func (self *seriesRecorder) values() [][]interface{} {
+	var values [][]interface{}
+	for _, s := range self.series {
+		for _, p := range s.Points {
+			var row []interface{}
+			for _, v := range p.Values {
+				var value interface{}
+				if v != nil {
+					value, _ = v.GetValue()
+				}
+				row = append(row, value)
+			}
+			values = append(values, row)
+		}
+	}
+	return values
+}
+
+// runFillQuery aggregates the values of host a and b at the given minutes.
+func runFillQuery(c *C, query string, points map[int][]interface{}) [][]interface{} {
+	q, err := parser.ParseSelectQuery(query)
+	c.Assert(err, IsNil)
+	r := &seriesRecorder{}
+	e, err := NewAggregatorEngine(q, r)
+	c.Assert(err, IsNil)
+
+	series := &protocol.Series{Name: protocol.String("cpu"), Fields: []string{"value", "host"}}
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano() / 1000
+	for minute := 0; minute < 10; minute++ {
+		values, ok := points[minute]
+		if !ok {
+			continue
+		}
+		p := &protocol.Point{Values: []*protocol.FieldValue{
+			{DoubleValue: protocol.Float64(values[0].(float64))},
+			{StringValue: protocol.String(values[1].(string))},
+		}}
+		p.SetTimestampInMicroseconds(start + int64(minute)*int64(time.Minute/time.Microsecond))
+		series.Points = append(series.Points, p)
+	}
+	if !q.Ascending {
+		for i, j := 0, len(series.Points)-1; i < j; i, j = i+1, j-1 {
+			series.Points[i], series.Points[j] = series.Points[j], series.Points[i]
+		}
+	}
+
+	_, err = e.Yield(series)
+	c.Assert(err, IsNil)
+	c.Assert(e.Close(), IsNil)
+	return r.values()
+}
+
+// Ensure empty buckets are omitted or filled with null, a number or a string.
+func (self *FillSuite) TestFillValue(c *C) {
+	points := map[int][]interface{}{0: {1.0, "a"}, 2: {5.0, "a"}}
+	c.Assert(runFillQuery(c, "select mean(value) from cpu group by time(1m) fill(none) order asc", points), DeepEquals, [][]interface{}{{1.0}, {5.0}})
+	c.Assert(runFillQuery(c, "select mean(value) from cpu group by time(1m) fill(null) order asc", points), DeepEquals, [][]interface{}{{1.0}, {nil}, {5.0}})
+	c.Assert(runFillQuery(c, "select mean(value) from cpu group by time(1m) fill(-0.5) order asc", points), DeepEquals, [][]interface{}{{1.0}, {-0.5}, {5.0}})
+	c.Assert(runFillQuery(c, "select count(value) from cpu group by time(1m) fill(0) order asc", points), DeepEquals, [][]interface{}{{int64(1)}, {int64(0)}, {int64(1)}})
+	c.Assert(runFillQuery(c, "select mean(value) from cpu group by time(1m) fill('n/a') order asc", points), DeepEquals, [][]interface{}{{1.0}, {"n/a"}, {5.0}})
+}
+
+// Ensure empty buckets have the values of the previous bucket of their
+// group in either order.
+func (self *FillSuite) TestFillPrevious(c *C) {
+	points := map[int][]interface{}{0: {1.0, "a"}, 1: {2.0, "b"}, 3: {4.0, "a"}}
+	c.Assert(runFillQuery(c, "select mean(value) from cpu group by time(1m), host fill(previous) order asc", points), DeepEquals, [][]interface{}{
+		{1.0, "a"}, {nil, "b"},
+		{1.0, "a"}, {2.0, "b"},
+		{1.0, "a"}, {2.0, "b"},
+		{4.0, "a"}, {2.0, "b"},
+	})
+	c.Assert(runFillQuery(c, "select mean(value) from cpu group by time(1m), host fill(previous)", points), DeepEquals, [][]interface{}{
+		{4.0, "a"}, {2.0, "b"},
+		{1.0, "a"}, {2.0, "b"},
+		{1.0, "a"}, {2.0, "b"},
+		{1.0, "a"}, {nil, "b"},
+	})
+}
+
+// Ensure empty buckets have values interpolated between the buckets of
+// their group before and after them and integers stay integers.
+func (self *FillSuite) TestFillLinear(c *C) {
+	points := map[int][]interface{}{0: {1.0, "a"}, 1: {10.0, "a"}, 4: {4.0, "a"}, 5: {8.0, "b"}}
+	for _, query := range []string{
+		"select mean(value), count(value) from cpu group by time(1m) fill(linear) order asc",
+		"select mean(value), count(value) from cpu group by time(1m) fill(linear)",
+	} {
+		values := runFillQuery(c, query, points)
+		if query[len(query)-3:] != "asc" {
+			for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+				values[i], values[j] = values[j], values[i]
+			}
+		}
+		c.Assert(values, DeepEquals, [][]interface{}{
+			{1.0, int64(1)}, {10.0, int64(1)}, {8.0, int64(1)}, {6.0, int64(1)}, {4.0, int64(1)}, {8.0, int64(1)},
+		}, Commentf(query))
+	}
+
+	// The buckets of host b before its first point can't be interpolated.
+	c.Assert(runFillQuery(c, "select mean(value) from cpu group by time(1m), host fill(linear) order asc", points), DeepEquals, [][]interface{}{
+		{1.0, "a"}, {nil, "b"},
+		{10.0, "a"}, {nil, "b"},
+		{8.0, "a"}, {nil, "b"},
+		{6.0, "a"}, {nil, "b"},
+		{4.0, "a"}, {nil, "b"},
+		{nil, "a"}, {8.0, "b"},
+	})
+}
+
+// Ensure empty buckets at the start of a flush are filled from the last
+// bucket with points of the previous flush.
+func (self *FillSuite) TestFillAcrossFlushes(c *C) {
+	bucket := func(timestamp int64, value interface{}) *filledBucket {
+		b := &filledBucket{group: "[a]", timestamp: timestamp, empty: value == nil}
+		p := &protocol.Point{Values: []*protocol.FieldValue{nil}}
+		if value != nil {
+			p.Values[0] = &protocol.FieldValue{DoubleValue: protocol.Float64(value.(float64))}
+		}
+		p.SetTimestampInMicroseconds(timestamp)
+		b.points = []*protocol.Point{p}
+		return b
+	}
+	values := func(points []*protocol.Point) []interface{} {
+		var a []interface{}
+		for _, p := range points {
+			var value interface{}
+			if v := p.Values[0]; v != nil {
+				value, _ = v.GetValue()
+			}
+			a = append(a, value)
+		}
+		return a
+	}
+
+	for _, test := range []struct {
+		fillType  parser.FillType
+		ascending bool
+		flushes   [][]*filledBucket
+		expected  [][]interface{}
+	}{
+		{
+			fillType:  parser.FillPrevious,
+			ascending: true,
+			flushes:   [][]*filledBucket{{bucket(0, 1.0), bucket(1, 2.0)}, {bucket(2, nil), bucket(3, nil)}},
+			expected:  [][]interface{}{{1.0, 2.0}, {2.0, 2.0}},
+		},
+		{
+			fillType:  parser.FillLinear,
+			ascending: true,
+			flushes:   [][]*filledBucket{{bucket(0, 2.0), bucket(1, nil)}, {bucket(2, nil), bucket(3, 8.0)}},
+			expected:  [][]interface{}{{2.0, nil}, {6.0, 8.0}},
+		},
+		{
+			fillType:  parser.FillLinear,
+			ascending: false,
+			flushes:   [][]*filledBucket{{bucket(3, 8.0), bucket(2, nil)}, {bucket(1, nil), bucket(0, 2.0)}},
+			expected:  [][]interface{}{{8.0, nil}, {4.0, 2.0}},
+		},
+	} {
+		last := map[string]*filledBucket{}
+		for i, buckets := range test.flushes {
+			points := fillBuckets(buckets, test.fillType, 1, test.ascending, last)
+			c.Assert(values(points), DeepEquals, test.expected[i], Commentf("%v %d", test.fillType, i))
+		}
+	}
+}
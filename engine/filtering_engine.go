@@ -11,11 +11,12 @@ type FilteringEngine struct {
 	query        *parser.SelectQuery
 	processor    Processor
 	shouldFilter bool
+	columns      map[string]struct{} // columns of the result
 }
 
 func NewFilteringEngine(query *parser.SelectQuery, processor Processor) *FilteringEngine {
 	shouldFilter := query.GetWhereCondition() != nil
-	return &FilteringEngine{query, processor, shouldFilter}
+	return &FilteringEngine{query, processor, shouldFilter, resultColumns(query)}
 }
 
 func (self *FilteringEngine) Yield(seriesIncoming *p.Series) (bool, error) {
@@ -23,7 +24,7 @@ func (self *FilteringEngine) Yield(seriesIncoming *p.Series) (bool, error) {
 		return self.processor.Yield(seriesIncoming)
 	}
 
-	series, err := Filter(self.query, seriesIncoming)
+	series, err := filterSeries(self.query.GetWhereCondition(), self.columns, seriesIncoming)
 	if err != nil {
 		return false, fmt.Errorf("Error while filtering points: %s [query = %s]", err, self.query.GetQueryString())
 	}
@@ -13,7 +13,13 @@ func getExpressionValue(values []*parser.Value, fields []string, point *protocol
 	for _, value := range values {
 		switch value.Type {
 		case parser.ValueFunctionCall:
-			return nil, fmt.Errorf("Cannot process function call %s in expression", value.Name)
+			// aggregated rows have a column for each aggregate of the
+			// having condition, named after the aggregate
+			fieldIdx := fieldIndex(fields, value.GetString())
+			if fieldIdx == -1 {
+				return nil, fmt.Errorf("Cannot process function call %s in expression", value.Name)
+			}
+			fieldValues = append(fieldValues, point.Values[fieldIdx])
 		case parser.ValueInt:
 			value, err := strconv.ParseInt(value.Name, 10, 64)
 			if err == nil {
@@ -65,43 +71,115 @@ func getExpressionValue(values []*parser.Value, fields []string, point *protocol
 	return fieldValues, nil
 }
 
-func matchesExpression(expr *parser.Value, fields []string, point *protocol.Point) (bool, error) {
-	leftValue, err := getExpressionValue(expr.Elems[:1], fields, point)
+// compiledCondition is a where condition resolved against the fields of a
+// series. Columns are looked up and literals are parsed once for a batch of
+// points instead of once for every point.
+type compiledCondition struct {
+	// AND or OR of two conditions.
+	operation   string
+	left, right *compiledCondition
+
+	// Comparison of a boolean expression. The values of the operands are
+	// reused for each point.
+	operator BooleanOperation
+	operands []*operand
+	values   []*protocol.FieldValue
+}
+
+// operand is a value of a boolean expression. Its value is either a column
+// of the point, a constant or an expression evaluated for each point.
+type operand struct {
+	index int
+	value *protocol.FieldValue
+	expr  *parser.Value
+}
+
+func compileCondition(condition *parser.WhereCondition, fields []string) (*compiledCondition, error) {
+	if expr, ok := condition.GetBoolExpression(); ok {
+		return compileExpression(expr, fields)
+	}
+
+	left, _ := condition.GetLeftWhereCondition()
+	l, err := compileCondition(left, fields)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	rightValue, err := getExpressionValue(expr.Elems[1:], fields, point)
+	r, err := compileCondition(condition.Right, fields)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	return &compiledCondition{operation: condition.Operation, left: l, right: r}, nil
+}
 
-	operator := registeredOperators[expr.Name]
-	if operator == nil {
-		return false, fmt.Errorf("Invalid boolean operator %s", expr.Name)
+func compileExpression(expr *parser.Value, fields []string) (*compiledCondition, error) {
+	c := &compiledCondition{operator: registeredOperators[expr.Name]}
+	for _, value := range expr.Elems {
+		o := &operand{index: -1}
+		switch value.Type {
+		case parser.ValueFunctionCall:
+			// aggregated rows have a column for each aggregate of the
+			// having condition, named after the aggregate
+			if o.index = fieldIndex(fields, value.GetString()); o.index == -1 {
+				return nil, fmt.Errorf("Cannot process function call %s in expression", value.Name)
+			}
+		case parser.ValueInt, parser.ValueFloat, parser.ValueBool, parser.ValueString, parser.ValueRegex:
+			v, err := getExpressionValue([]*parser.Value{value}, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			o.value = v[0]
+		case parser.ValueTableName, parser.ValueSimpleName:
+			if o.index = fieldIndex(fields, value.Name); o.index == -1 {
+				return nil, fmt.Errorf("Cannot find column %s", value.Name)
+			}
+		case parser.ValueExpression:
+			o.expr = value
+		default:
+			return nil, fmt.Errorf("Cannot evaluate expression")
+		}
+		c.operands = append(c.operands, o)
 	}
 
-	ok, err := operator(leftValue[0], rightValue)
-	return ok == MATCH, err
+	if c.operator == nil {
+		return nil, fmt.Errorf("Invalid boolean operator %s", expr.Name)
+	}
+	c.values = make([]*protocol.FieldValue, len(c.operands))
+	return c, nil
 }
 
-func matches(condition *parser.WhereCondition, fields []string, point *protocol.Point) (bool, error) {
-	if expr, ok := condition.GetBoolExpression(); ok {
-		return matchesExpression(expr, fields, point)
-	}
+func (c *compiledCondition) matches(fields []string, point *protocol.Point) (bool, error) {
+	if c.operator == nil {
+		leftResult, err := c.left.matches(fields, point)
+		if err != nil {
+			return false, err
+		}
 
-	left, _ := condition.GetLeftWhereCondition()
-	leftResult, err := matches(left, fields, point)
-	if err != nil {
-		return false, err
+		// short circuit
+		if !leftResult && c.operation == "AND" ||
+			leftResult && c.operation == "OR" {
+			return leftResult, nil
+		}
+
+		return c.right.matches(fields, point)
 	}
 
-	// short circuit
-	if !leftResult && condition.Operation == "AND" ||
-		leftResult && condition.Operation == "OR" {
-		return leftResult, nil
+	for i, o := range c.operands {
+		switch {
+		case o.index >= 0:
+			c.values[i] = point.Values[o.index]
+		case o.expr != nil:
+			v, err := GetValue(o.expr, fields, point)
+			if err != nil {
+				return false, err
+			}
+			c.values[i] = v
+		default:
+			c.values[i] = o.value
+		}
 	}
 
-	return matches(condition.Right, fields, point)
+	ok, err := c.operator(c.values[0], c.values[1:])
+	return ok == MATCH, err
 }
 
 func getColumns(values []*parser.Value, columns map[string]bool) {
@@ -118,29 +196,25 @@ func getColumns(values []*parser.Value, columns map[string]bool) {
 	}
 }
 
-func filterColumns(columns map[string]struct{}, fields []string, point *protocol.Point) {
+// filterColumns returns the indexes of the fields that are part of the
+// result. Returns nil if every field is.
+func filterColumns(columns map[string]struct{}, fields []string) []int {
 	if _, ok := columns["*"]; ok {
-		return
+		return nil
 	}
 
-	newValues := []*protocol.FieldValue{}
-	newFields := []string{}
+	indexes := []int{}
 	for idx, f := range fields {
-		if _, ok := columns[f]; !ok {
-			continue
+		if _, ok := columns[f]; ok {
+			indexes = append(indexes, idx)
 		}
-
-		newValues = append(newValues, point.Values[idx])
-		newFields = append(newFields, f)
 	}
-	point.Values = newValues
+	return indexes
 }
 
-func Filter(query *parser.SelectQuery, series *protocol.Series) (*protocol.Series, error) {
-	if query.GetWhereCondition() == nil {
-		return series, nil
-	}
-
+// resultColumns returns the columns of the query's result. Fields that
+// aren't part of the result are removed once a point is filtered.
+func resultColumns(query *parser.SelectQuery) map[string]struct{} {
 	columns := map[string]struct{}{}
 	if query.GetFromClause().Type == parser.FromClauseInnerJoin {
 	outer:
@@ -163,18 +237,52 @@ func Filter(query *parser.SelectQuery, series *protocol.Series) (*protocol.Serie
 			}
 		}
 	}
+	return columns
+}
+
+func Filter(query *parser.SelectQuery, series *protocol.Series) (*protocol.Series, error) {
+	if query.GetWhereCondition() == nil {
+		return series, nil
+	}
+	return filterSeries(query.GetWhereCondition(), resultColumns(query), series)
+}
 
+// filterSeries removes the points of a series that don't match the
+// condition and the fields that aren't part of the result. The condition is
+// resolved against the fields once for the whole batch of points.
+func filterSeries(condition *parser.WhereCondition, columns map[string]struct{}, series *protocol.Series) (*protocol.Series, error) {
 	points := series.Points
 	series.Points = nil
-	for _, point := range points {
-		ok, err := matches(query.GetWhereCondition(), series.Fields, point)
-
+	if len(points) > 0 {
+		c, err := compileCondition(condition, series.Fields)
 		if err != nil {
 			return nil, err
 		}
+		indexes := filterColumns(columns, series.Fields)
+
+		// The values of the filtered points share a single allocation.
+		var values []*protocol.FieldValue
+		if indexes != nil {
+			values = make([]*protocol.FieldValue, 0, len(indexes)*len(points))
+		}
 
-		if ok {
-			filterColumns(columns, series.Fields, point)
+		series.Points = make([]*protocol.Point, 0, len(points))
+		for _, point := range points {
+			ok, err := c.matches(series.Fields, point)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			if indexes != nil {
+				n := len(values)
+				for _, idx := range indexes {
+					values = append(values, point.Values[idx])
+				}
+				point.Values = values[n:len(values):len(values)]
+			}
 			series.Points = append(series.Points, point)
 		}
 	}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/influxdb/influxdb/common"
 	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
 	. "launchpad.net/gocheck"
 )
 
@@ -272,3 +273,25 @@ func (self *FilteringSuite) TestInequalityFiltering(c *C) {
 	c.Assert(*result.Points[0].Values[0].Int64Value, Equals, int64(100))
 	c.Assert(*result.Points[0].Values[1].Int64Value, Equals, int64(7))
 }
+
+func (self *FilteringSuite) TestPredicates(c *C) {
+	queryStr := "select * from t where column_one >= 100 and column_two in (5, 6) and (column_three = 'a' or column_three = 'b') and column_one > column_two;"
+	query, err := parser.ParseSelectQuery(queryStr)
+	c.Assert(err, IsNil)
+
+	// Only the comparisons with constants joined by AND are predicates.
+	predicates := Predicates(query.GetWhereCondition())
+	c.Assert(predicates, HasLen, 2)
+	c.Assert(predicates[0].Column, Equals, "column_one")
+	c.Assert(predicates[0].Match(&protocol.FieldValue{Int64Value: protocol.Int64(100)}), Equals, true)
+	c.Assert(predicates[0].Match(&protocol.FieldValue{DoubleValue: protocol.Float64(99.5)}), Equals, false)
+	c.Assert(predicates[0].Match(&protocol.FieldValue{}), Equals, false)
+	c.Assert(predicates[1].Column, Equals, "column_two")
+	c.Assert(predicates[1].Match(&protocol.FieldValue{Int64Value: protocol.Int64(6)}), Equals, true)
+	c.Assert(predicates[1].Match(&protocol.FieldValue{Int64Value: protocol.Int64(7)}), Equals, false)
+
+	// A disjunction can't be split into predicates.
+	query, err = parser.ParseSelectQuery("select * from t where column_one >= 100 or column_two = 5;")
+	c.Assert(err, IsNil)
+	c.Assert(Predicates(query.GetWhereCondition()), HasLen, 0)
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+// HavingEngine filters the rows of an aggregate query by its having
+// condition. The condition can reference the columns of the query by their
+// name or alias, the group by columns and aggregates, e.g. mean(value) in
+// having mean(value) > 80. Aggregates are computed by the query returned by
+// NewHavingEngine in a column named after them, which is dropped once the
+// rows are filtered.
+type HavingEngine struct {
+	next      Processor
+	condition *parser.WhereCondition
+	hidden    map[string]bool // the columns of the aggregates of the condition
+}
+
+// NewHavingEngine returns the engine for the having condition of a query
+// and the query that has to be run for it. The limit of the query is
+// applied after the rows are filtered.
+func NewHavingEngine(query *parser.SelectQuery, next Processor) (*HavingEngine, *parser.SelectQuery, error) {
+	if !query.HasAggregates() {
+		return nil, nil, parser.NewQueryError(parser.InvalidArgument, "having requires aggregate columns")
+	}
+
+	inner := *query
+	inner.ColumnNames = append([]*parser.Value(nil), query.GetColumnNames()...)
+	inner.Having = nil
+	inner.Limit = 0
+
+	e := &HavingEngine{
+		next:      next,
+		condition: query.GetHavingCondition(),
+		hidden:    map[string]bool{},
+	}
+
+	for _, value := range havingFunctionCalls(e.condition, nil) {
+		name := value.GetString()
+		if e.hidden[name] {
+			continue
+		}
+		e.hidden[name] = true
+
+		aliased := *value
+		aliased.Alias = name
+		inner.ColumnNames = append(inner.ColumnNames, &aliased)
+	}
+
+	return e, &inner, nil
+}
+
+// havingFunctionCalls appends the function calls of a condition which
+// aren't nested in another function call.
+func havingFunctionCalls(condition *parser.WhereCondition, calls []*parser.Value) []*parser.Value {
+	if expr, ok := condition.GetBoolExpression(); ok {
+		return valueFunctionCalls(expr, calls)
+	}
+
+	left, _ := condition.GetLeftWhereCondition()
+	calls = havingFunctionCalls(left, calls)
+	return havingFunctionCalls(condition.Right, calls)
+}
+
+func valueFunctionCalls(value *parser.Value, calls []*parser.Value) []*parser.Value {
+	switch value.Type {
+	case parser.ValueFunctionCall:
+		return append(calls, value)
+	case parser.ValueExpression:
+		for _, elem := range value.Elems {
+			calls = valueFunctionCalls(elem, calls)
+		}
+	}
+	return calls
+}
+
+func (self *HavingEngine) Yield(s *protocol.Series) (bool, error) {
+	var columns []int
+	var fields []string
+	for i, field := range s.Fields {
+		if !self.hidden[field] {
+			columns = append(columns, i)
+			fields = append(fields, field)
+		}
+	}
+
+	var points []*protocol.Point
+	var condition *compiledCondition
+	if len(s.Points) > 0 {
+		var err error
+		if condition, err = compileCondition(self.condition, s.Fields); err != nil {
+			return false, err
+		}
+	}
+	for _, point := range s.Points {
+		ok, err := condition.matches(s.Fields, point)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+
+		values := make([]*protocol.FieldValue, len(columns))
+		for i, idx := range columns {
+			values[i] = point.Values[idx]
+		}
+		points = append(points, &protocol.Point{
+			Timestamp:      point.Timestamp,
+			SequenceNumber: point.SequenceNumber,
+			Values:         values,
+		})
+	}
+
+	if len(points) == 0 {
+		return true, nil
+	}
+	return self.next.Yield(&protocol.Series{Name: s.Name, Fields: fields, Points: points})
+}
+
+func (self *HavingEngine) Close() error {
+	return self.next.Close()
+}
+
+func (self *HavingEngine) Name() string {
+	return "HavingEngine"
+}
+
+func (self *HavingEngine) Next() Processor {
+	return self.next
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"github.com/influxdb/influxdb/parser"
+	. "launchpad.net/gocheck"
+)
+
+type HavingSuite struct{}
+
+var _ = Suite(&HavingSuite{})
+
+var havingPoints = [][]interface{}{{1, "a"}, {10, "b"}, {3, "a"}, {20, "b"}, {5, "a"}, {60, "b"}}
+
+// Ensure aggregated rows are filtered by the aggregates of the condition,
+// whether they are columns of the query or not.
+func (self *HavingSuite) TestAggregates(c *C) {
+	fields, values := runWindowQuery(c, "select mean(value), host from cpu group by time(2m), host having mean(value) > 4 order asc", havingPoints)
+	c.Assert(fields, DeepEquals, []string{"mean", "host"})
+	c.Assert(values, DeepEquals, [][]interface{}{{10.0, "b"}, {20.0, "b"}, {5.0, "a"}, {60.0, "b"}})
+
+	fields, values = runWindowQuery(c, "select count(value), host from cpu group by time(2m), host having sum(value) * 2 >= 40 order asc", havingPoints)
+	c.Assert(fields, DeepEquals, []string{"count", "host"})
+	c.Assert(values, DeepEquals, [][]interface{}{{int64(1), "b"}, {int64(1), "b"}})
+}
+
+// Ensure aliases and group by columns can be used in the condition.
+func (self *HavingSuite) TestAliasesAndGroups(c *C) {
+	fields, values := runWindowQuery(c, "select max(value) as m, host from cpu group by time(2m), host having m > 3 and host = 'a' order asc", havingPoints)
+	c.Assert(fields, DeepEquals, []string{"m", "host"})
+	c.Assert(values, DeepEquals, [][]interface{}{{5.0, "a"}})
+}
+
+// Ensure the condition is evaluated after the window functions and before
+// the limit.
+func (self *HavingSuite) TestWindowFunctionsAndLimit(c *C) {
+	_, values := runWindowQuery(c, "select cumulative_sum(sum(value)) as total, host from cpu group by time(2m), host having total > 10 order asc", havingPoints)
+	c.Assert(values, DeepEquals, [][]interface{}{{30.0, "b"}, {90.0, "b"}})
+
+	_, values = runWindowQuery(c, "select mean(value), host from cpu group by time(2m), host having mean(value) > 4 limit 2", havingPoints)
+	c.Assert(values, DeepEquals, [][]interface{}{{5.0, "a"}, {60.0, "b"}})
+}
+
+// Ensure conditions of queries without aggregates are rejected.
+func (self *HavingSuite) TestRawValues(c *C) {
+	q, err := parser.ParseSelectQuery("select value from cpu having value > 1")
+	c.Assert(err, IsNil)
+	_, err = NewQueryEngine(&seriesRecorder{}, q, nil)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,185 @@
+package engine
+
+import "math"
+
+// holtWinters forecasts evenly spaced values with additive triple
+// exponential smoothing. The level, trend and seasonal component of the
+// values are smoothed by alpha, beta and gamma, which are fitted to
+// minimize the squared error of forecasting each value from the values
+// before it. A season shorter than two values smooths the level and trend
+// only.
+type holtWinters struct {
+	season   int
+	level    float64
+	trend    float64
+	seasonal []float64
+	n        int
+}
+
+// fitHoltWinters fits a model to the values. Returns nil if there are less
+// than two seasons of values, or two values without a season.
+func fitHoltWinters(values []float64, season int) *holtWinters {
+	if season < 2 {
+		season = 0
+	}
+	if len(values) < 2 || len(values) < 2*season {
+		return nil
+	}
+
+	parameters := []float64{0.3, 0.1}
+	if season > 0 {
+		parameters = append(parameters, 0.1)
+	}
+	parameters = nelderMead(func(p []float64) float64 {
+		sse, _, _, _ := smoothHoltWinters(values, season, p)
+		return sse
+	}, parameters)
+
+	h := &holtWinters{season: season, n: len(values)}
+	_, h.level, h.trend, h.seasonal = smoothHoltWinters(values, season, parameters)
+	return h
+}
+
+// smoothHoltWinters returns the squared error of forecasting each value
+// from the values before it and the final level, trend and seasonal
+// components. Parameters outside of [0, 1] are clamped.
+func smoothHoltWinters(values []float64, season int, parameters []float64) (sse, level, trend float64, seasonal []float64) {
+	alpha, beta := clampUnit(parameters[0]), clampUnit(parameters[1])
+
+	// The initial level and trend are those of the first value, or of the
+	// last value of the first season given the means of the first two.
+	start := 1
+	level, trend = values[0], values[1]-values[0]
+	var gamma float64
+	if season > 0 {
+		gamma = clampUnit(parameters[2])
+		var first, second float64
+		for i := 0; i < season; i++ {
+			first += values[i]
+			second += values[season+i]
+		}
+		first, second = first/float64(season), second/float64(season)
+
+		trend = (second - first) / float64(season)
+		middle := float64(season-1) / 2
+		level = first + trend*middle
+		seasonal = make([]float64, season)
+		for i := range seasonal {
+			seasonal[i] = values[i] - (first + trend*(float64(i)-middle))
+		}
+		start = season
+	}
+
+	for t := start; t < len(values); t++ {
+		var s float64
+		if season > 0 {
+			s = seasonal[t%season]
+		}
+		e := values[t] - (level + trend + s)
+		sse += e * e
+
+		previous := level
+		level = alpha*(values[t]-s) + (1-alpha)*(level+trend)
+		trend = beta*(level-previous) + (1-beta)*trend
+		if season > 0 {
+			seasonal[t%season] = gamma*(values[t]-level) + (1-gamma)*s
+		}
+	}
+	return sse, level, trend, seasonal
+}
+
+// forecast returns the value the given number of steps after the last
+// value.
+func (h *holtWinters) forecast(steps int) float64 {
+	v := h.level + float64(steps)*h.trend
+	if h.season > 0 {
+		v += h.seasonal[(h.n-1+steps)%h.season]
+	}
+	return v
+}
+
+func clampUnit(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
+
+// nelderMead returns the point minimizing f found by the Nelder-Mead
+// simplex method starting from x.
+func nelderMead(f func([]float64) float64, x []float64) []float64 {
+	n := len(x)
+	points := make([][]float64, n+1)
+	values := make([]float64, n+1)
+	points[0] = x
+	for i := 0; i < n; i++ {
+		p := append([]float64(nil), x...)
+		p[i] += 0.1
+		points[i+1] = p
+	}
+	for i, p := range points {
+		values[i] = f(p)
+	}
+
+	// at returns the point t times the distance from the centroid to the
+	// worst point, on the other side of the centroid if t is negative.
+	centroid := make([]float64, n)
+	at := func(t float64) []float64 {
+		p := make([]float64, n)
+		for j := range p {
+			p[j] = centroid[j] + t*(points[n][j]-centroid[j])
+		}
+		return p
+	}
+
+	// order sorts the points from the best to the worst.
+	order := func() {
+		for i := 1; i <= n; i++ {
+			for j := i; j > 0 && values[j] < values[j-1]; j-- {
+				points[j], points[j-1] = points[j-1], points[j]
+				values[j], values[j-1] = values[j-1], values[j]
+			}
+		}
+	}
+
+	for iteration := 0; iteration < 1000; iteration++ {
+		order()
+		if values[n]-values[0] <= 1e-10*math.Abs(values[0]) {
+			break
+		}
+
+		for j := range centroid {
+			centroid[j] = 0
+			for _, p := range points[:n] {
+				centroid[j] += p[j] / float64(n)
+			}
+		}
+
+		reflected := at(-1)
+		fr := f(reflected)
+		switch {
+		case fr < values[0]:
+			expanded := at(-2)
+			if fe := f(expanded); fe < fr {
+				points[n], values[n] = expanded, fe
+			} else {
+				points[n], values[n] = reflected, fr
+			}
+		case fr < values[n-1]:
+			points[n], values[n] = reflected, fr
+		default:
+			contracted := at(0.5)
+			if fc := f(contracted); fc < values[n] {
+				points[n], values[n] = contracted, fc
+				break
+			}
+
+			// Shrink the simplex towards the best point.
+			for i := 1; i <= n; i++ {
+				for j := range points[i] {
+					points[i][j] = points[0][j] + (points[i][j]-points[0][j])/2
+				}
+				values[i] = f(points[i])
+			}
+		}
+	}
+	order()
+	return points[0]
+}
@@ -0,0 +1,327 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+const (
+	// The column holding the aggregate forecasted by holt_winters().
+	holtWintersColumnName = "_holt_winters"
+
+	// The column set for the points forecasted by holt_winters().
+	holtWintersFlagName = "forecast"
+)
+
+// HasHoltWinters returns true if a column of the query is holt_winters().
+func HasHoltWinters(query *parser.SelectQuery) bool {
+	for _, value := range query.GetColumnNames() {
+		if value.IsFunctionCall() && strings.ToLower(value.Name) == "holt_winters" {
+			return true
+		}
+	}
+	return false
+}
+
+// HoltWintersEngine forecasts the buckets of an aggregate, e.g.
+// holt_winters(mean(value), 10, 24) returns the mean of each bucket
+// followed by 10 forecasted buckets given a season of 24 buckets. The
+// aggregate is computed by the query returned by NewHoltWintersEngine and
+// replaced by holt_winters(). Forecasted points have the forecast column
+// set and null values in other columns but the group by columns.
+//
+// Each group is fitted separately once every series is buffered. Buckets
+// without a value after the fill of the query are filled for the fit the way
+// the fill of the query does: with the fill number, with the previous value
+// or, by default, with values interpolated as fill(linear) does.
+type HoltWintersEngine struct {
+	next      Processor
+	ascending bool
+	name      string // the name of the holt_winters() column
+	forecasts int
+	season    int
+	elems     []string          // the group by columns other than time()
+	buckets   *AggregatorEngine // steps through the buckets of the query
+	names     []string          // the series names in the order they were yielded
+	series    map[string][]*protocol.Series
+
+	fillType  parser.FillType
+	fillValue *float64 // the fill number, nil if it isn't a number
+}
+
+// NewHoltWintersEngine returns the engine for holt_winters() and the query
+// that has to be run for it. The limit of the query is applied after the
+// forecasts.
+func NewHoltWintersEngine(query *parser.SelectQuery, next Processor) (*HoltWintersEngine, *parser.SelectQuery, error) {
+	inner := *query
+	inner.ColumnNames = nil
+	inner.Limit = 0
+
+	e := &HoltWintersEngine{
+		next:      next,
+		ascending: query.Ascending,
+		series:    map[string][]*protocol.Series{},
+	}
+
+	for _, value := range query.GetColumnNames() {
+		if !value.IsFunctionCall() || strings.ToLower(value.Name) != "holt_winters" {
+			inner.ColumnNames = append(inner.ColumnNames, value)
+			continue
+		}
+
+		if e.name != "" {
+			return nil, nil, parser.NewQueryError(parser.InvalidArgument, "function holt_winters() can only be used once in a query")
+		}
+		if err := e.initialize(value); err != nil {
+			return nil, nil, parser.NewQueryError(parser.InvalidArgument, err.Error())
+		}
+
+		aliased := *value.Elems[0]
+		aliased.Alias = holtWintersColumnName
+		inner.ColumnNames = append(inner.ColumnNames, &aliased)
+	}
+
+	// Forecasts are a number of buckets after the last one.
+	groupBy := inner.GetGroupByClause()
+	if groupBy == nil {
+		return nil, nil, parser.NewQueryError(parser.InvalidArgument, "function holt_winters() requires a group by time()")
+	}
+	var err error
+	e.buckets = &AggregatorEngine{location: groupBy.Location}
+	if e.buckets.duration, e.buckets.irregularInterval, err = groupBy.GetGroupByTime(); err != nil {
+		return nil, nil, err
+	} else if e.buckets.duration == nil {
+		return nil, nil, parser.NewQueryError(parser.InvalidArgument, "function holt_winters() requires a group by time()")
+	}
+	if e.buckets.offset, err = groupBy.GetGroupByTimeOffset(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, elem := range groupBy.Elems {
+		if !elem.IsFunctionCall() {
+			e.elems = append(e.elems, elem.Name)
+		}
+	}
+
+	e.fillType = groupBy.FillType
+	if e.fillType == parser.FillNumber {
+		value, err := wrapDefaultValue(groupBy.FillValue)
+		if err != nil {
+			return nil, nil, err
+		}
+		if v, ok := windowFloat(value); ok {
+			e.fillValue = &v
+		}
+	}
+
+	return e, &inner, nil
+}
+
+// initialize validates the arguments of holt_winters(), an aggregate, the
+// number of buckets to forecast and the number of buckets of a season.
+func (self *HoltWintersEngine) initialize(value *parser.Value) error {
+	if len(value.Elems) != 3 {
+		return fmt.Errorf("function holt_winters() requires exactly three arguments")
+	}
+	if !value.Elems[0].IsFunctionCall() {
+		return fmt.Errorf("function holt_winters() requires an aggregate as the first argument")
+	}
+
+	forecasts, err := strconv.Atoi(value.Elems[1].Name)
+	if value.Elems[1].Type != parser.ValueInt || err != nil || forecasts < 1 {
+		return fmt.Errorf("function holt_winters() requires the number of forecasts to be a positive integer")
+	}
+	season, err := strconv.Atoi(value.Elems[2].Name)
+	if value.Elems[2].Type != parser.ValueInt || err != nil || season < 0 {
+		return fmt.Errorf("function holt_winters() requires the season to be a non-negative integer")
+	}
+
+	self.name = value.Name
+	if value.Alias != "" {
+		self.name = value.Alias
+	}
+	self.forecasts, self.season = forecasts, season
+	return nil
+}
+
+func (self *HoltWintersEngine) Yield(s *protocol.Series) (bool, error) {
+	name := s.GetName()
+	if _, ok := self.series[name]; !ok {
+		self.names = append(self.names, name)
+	}
+	self.series[name] = append(self.series[name], s)
+	return true, nil
+}
+
+// holtWintersGroup holds the buckets of a group.
+type holtWintersGroup struct {
+	values  []*protocol.FieldValue // the group by values
+	buckets map[int64]float64
+	first   int64
+	last    int64
+}
+
+// forecast returns the points of the series of a name followed, or
+// preceded in a descending query, by the forecasted points of each group.
+func (self *HoltWintersEngine) forecast(name string) *protocol.Series {
+	var fields []string
+	var points []*protocol.Point
+	for _, s := range self.series[name] {
+		fields = s.Fields
+		points = append(points, s.Points...)
+	}
+
+	fields = append([]string(nil), fields...)
+	argument := fieldIndex(fields, holtWintersColumnName)
+	if argument >= 0 {
+		fields[argument] = self.name
+	}
+	flag := len(fields)
+	fields = append(fields, holtWintersFlagName)
+
+	var groupColumns []int
+	for _, elem := range self.elems {
+		if idx := fieldIndex(fields, elem); idx >= 0 {
+			groupColumns = append(groupColumns, idx)
+		}
+	}
+
+	var keys []string
+	groups := map[string]*holtWintersGroup{}
+	observed := make([]*protocol.Point, len(points))
+	for i, point := range points {
+		values := make([]*protocol.FieldValue, len(fields))
+		copy(values, point.Values)
+		forecast := false
+		values[flag] = &protocol.FieldValue{BoolValue: &forecast}
+		observed[i] = &protocol.Point{
+			Timestamp:      point.Timestamp,
+			SequenceNumber: point.SequenceNumber,
+			Values:         values,
+		}
+
+		if argument < 0 {
+			continue
+		}
+		value, ok := windowFloat(point.Values[argument])
+		if !ok {
+			continue
+		}
+
+		group := make([]*protocol.FieldValue, len(groupColumns))
+		for j, idx := range groupColumns {
+			group[j] = point.Values[idx]
+		}
+		key := fmt.Sprint(group)
+		g := groups[key]
+		timestamp := point.GetTimestampInMicroseconds()
+		if g == nil {
+			g = &holtWintersGroup{values: group, buckets: map[int64]float64{}, first: *timestamp, last: *timestamp}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.buckets[*timestamp] = value
+		if *timestamp < g.first {
+			g.first = *timestamp
+		} else if *timestamp > g.last {
+			g.last = *timestamp
+		}
+	}
+
+	// The forecasted points of each group by the number of steps after
+	// the last bucket.
+	forecasted := make([][]*protocol.Point, self.forecasts)
+	for _, key := range keys {
+		g := groups[key]
+		model := fitHoltWinters(self.bucketValues(g), self.season)
+		if model == nil {
+			continue
+		}
+
+		bucket := g.last
+		for step := 1; step <= self.forecasts; step++ {
+			bucket = self.buckets.getNextBucket(bucket)
+			values := make([]*protocol.FieldValue, len(fields))
+			for j, idx := range groupColumns {
+				values[idx] = g.values[j]
+			}
+			v, forecast := model.forecast(step), true
+			values[argument] = &protocol.FieldValue{DoubleValue: &v}
+			values[flag] = &protocol.FieldValue{BoolValue: &forecast}
+
+			point := &protocol.Point{Values: values}
+			point.SetTimestampInMicroseconds(bucket)
+			forecasted[step-1] = append(forecasted[step-1], point)
+		}
+	}
+
+	if self.ascending {
+		points = observed
+		for _, step := range forecasted {
+			points = append(points, step...)
+		}
+	} else {
+		points = nil
+		for i := len(forecasted) - 1; i >= 0; i-- {
+			points = append(points, forecasted[i]...)
+		}
+		points = append(points, observed...)
+	}
+	return &protocol.Series{Name: &name, Fields: fields, Points: points}
+}
+
+// bucketValues returns the values of each bucket from the first to the
+// last bucket of a group. Buckets without a value have the fill number or
+// the previous value for fill(number) and fill(previous) and are otherwise
+// interpolated.
+func (self *HoltWintersEngine) bucketValues(g *holtWintersGroup) []float64 {
+	var values []float64
+	previous := -1
+	for bucket := g.first; bucket <= g.last; bucket = self.buckets.getNextBucket(bucket) {
+		value, ok := g.buckets[bucket]
+		if !ok {
+			switch {
+			case self.fillType == parser.FillNumber && self.fillValue != nil:
+				value, ok = *self.fillValue, true
+			case self.fillType == parser.FillPrevious && previous >= 0:
+				value, ok = values[previous], true
+			}
+		}
+		values = append(values, value)
+		if !ok {
+			continue
+		}
+
+		// Interpolate the buckets since the previous one with a value.
+		i := len(values) - 1
+		for j := previous + 1; j < i; j++ {
+			fraction := float64(j-previous) / float64(i-previous)
+			values[j] = values[previous] + (value-values[previous])*fraction
+		}
+		previous = i
+	}
+	return values[:previous+1]
+}
+
+func (self *HoltWintersEngine) Close() error {
+	for _, name := range self.names {
+		if ok, err := self.next.Yield(self.forecast(name)); err != nil {
+			return err
+		} else if !ok {
+			break
+		}
+	}
+	return self.next.Close()
+}
+
+func (self *HoltWintersEngine) Name() string {
+	return "HoltWintersEngine"
+}
+
+func (self *HoltWintersEngine) Next() Processor {
+	return self.next
+}
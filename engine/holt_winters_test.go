@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"math"
+	"time"
+
+	"github.com/influxdb/influxdb/parser"
+	. "launchpad.net/gocheck"
+)
+
+type HoltWintersSuite struct{}
+
+var _ = Suite(&HoltWintersSuite{})
+
+// Ensure a trend and a season are forecast exactly when the values follow
+// them exactly.
+func (self *HoltWintersSuite) TestForecast(c *C) {
+	var linear, seasonal []float64
+	pattern := []float64{3, -1, -3, 1}
+	for t := 0; t < 24; t++ {
+		linear = append(linear, 2*float64(t)+1)
+		seasonal = append(seasonal, 10+float64(t)+pattern[t%4])
+	}
+
+	h := fitHoltWinters(linear, 0)
+	c.Assert(h, NotNil)
+	for step := 1; step <= 5; step++ {
+		c.Assert(math.Abs(h.forecast(step)-(2*float64(23+step)+1)) < 1e-9, Equals, true, Commentf("step %d: %f", step, h.forecast(step)))
+	}
+
+	h = fitHoltWinters(seasonal, 4)
+	c.Assert(h, NotNil)
+	for step := 1; step <= 8; step++ {
+		t := 23 + step
+		c.Assert(math.Abs(h.forecast(step)-(10+float64(t)+pattern[t%4])) < 1e-9, Equals, true, Commentf("step %d: %f", step, h.forecast(step)))
+	}
+}
+
+// Ensure models need two seasons of values, or two values without a
+// season.
+func (self *HoltWintersSuite) TestTooFewValues(c *C) {
+	c.Assert(fitHoltWinters([]float64{1}, 0), IsNil)
+	c.Assert(fitHoltWinters([]float64{1, 2}, 1), NotNil)
+	c.Assert(fitHoltWinters([]float64{1, 2, 3, 4, 5}, 3), IsNil)
+	c.Assert(fitHoltWinters([]float64{1, 2, 3, 4, 5, 6}, 3), NotNil)
+}
+
+// Ensure the simplex converges to the minimum of a function.
+func (self *HoltWintersSuite) TestNelderMead(c *C) {
+	x := nelderMead(func(p []float64) float64 {
+		return (p[0]-0.25)*(p[0]-0.25) + 2*(p[1]-0.75)*(p[1]-0.75) + 1
+	}, []float64{0.5, 0.5})
+	c.Assert(math.Abs(x[0]-0.25) < 1e-3, Equals, true, Commentf("%v", x))
+	c.Assert(math.Abs(x[1]-0.75) < 1e-3, Equals, true, Commentf("%v", x))
+}
+
+// Ensure forecasts are appended after the last bucket with the forecast
+// column set.
+func (self *HoltWintersSuite) TestEngine(c *C) {
+	points := [][]interface{}{{1, "a"}, {2, "a"}, {3, "a"}, {4, "a"}}
+	fields, values := runWindowQuery(c, "select holt_winters(mean(value), 2, 0) from cpu group by time(1m) order asc", points)
+	c.Assert(fields, DeepEquals, []string{"holt_winters", "forecast"})
+	c.Assert(values, DeepEquals, [][]interface{}{
+		{1.0, false}, {2.0, false}, {3.0, false}, {4.0, false},
+		{5.0, true}, {6.0, true},
+	})
+}
+
+// Ensure each group is forecast from its own buckets, buckets without a
+// value are interpolated and forecasts come first in descending queries.
+func (self *HoltWintersSuite) TestEngineGroups(c *C) {
+	points := [][]interface{}{{1, "a"}, {10, "b"}, {3, "a"}, {20, "b"}, {5, "a"}, {30, "b"}}
+	fields, values := runWindowQuery(c, "select holt_winters(max(value), 1, 0) as f, host from cpu group by time(1m), host", points)
+	c.Assert(fields, DeepEquals, []string{"f", "host", "forecast"})
+	c.Assert(values, DeepEquals, [][]interface{}{
+		{35.0, "b", true},
+		{6.0, "a", true},
+		{30.0, "b", false},
+		{5.0, "a", false},
+		{20.0, "b", false},
+		{3.0, "a", false},
+		{10.0, "b", false},
+		{1.0, "a", false},
+	})
+}
+
+// Ensure buckets without a value are filled for the fit as the fill of the
+// query fills them.
+func (self *HoltWintersSuite) TestBucketValuesFill(c *C) {
+	for query, expected := range map[string][]float64{
+		"select holt_winters(mean(value), 1, 0) from cpu group by time(1m)":                {1, 3, 5, 7},
+		"select holt_winters(mean(value), 1, 0) from cpu group by time(1m) fill(null)":     {1, 3, 5, 7},
+		"select holt_winters(mean(value), 1, 0) from cpu group by time(1m) fill(linear)":   {1, 3, 5, 7},
+		"select holt_winters(mean(value), 1, 0) from cpu group by time(1m) fill(previous)": {1, 1, 1, 7},
+		"select holt_winters(mean(value), 1, 0) from cpu group by time(1m) fill(-1)":       {1, -1, -1, 7},
+		"select holt_winters(mean(value), 1, 0) from cpu group by time(1m) fill('n/a')":    {1, 3, 5, 7},
+	} {
+		q, err := parser.ParseSelectQuery(query)
+		c.Assert(err, IsNil)
+		e, _, err := NewHoltWintersEngine(q, &seriesRecorder{})
+		c.Assert(err, IsNil)
+
+		minute := int64(time.Minute / time.Microsecond)
+		g := &holtWintersGroup{buckets: map[int64]float64{0: 1, 3 * minute: 7}, first: 0, last: 3 * minute}
+		c.Assert(e.bucketValues(g), DeepEquals, expected, Commentf(query))
+	}
+}
+
+// Ensure invalid arguments are rejected.
+func (self *HoltWintersSuite) TestInvalidArguments(c *C) {
+	for _, query := range []string{
+		"select holt_winters(mean(value), 1, 0) from cpu",
+		"select holt_winters(value, 1, 0) from cpu group by time(1m)",
+		"select holt_winters(mean(value), 0, 0) from cpu group by time(1m)",
+		"select holt_winters(mean(value), 1, 1.5) from cpu group by time(1m)",
+		"select holt_winters(mean(value), 1, 0), holt_winters(max(value), 1, 0) from cpu group by time(1m)",
+	} {
+		q, err := parser.ParseSelectQuery(query)
+		c.Assert(err, IsNil)
+		_, err = NewQueryEngine(&seriesRecorder{}, q, nil)
+		c.Assert(err, NotNil, Commentf(query))
+	}
+}
@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/influxdb/influxdb/protocol"
+)
+
+const (
+	// The range and default of the number of bits used to select a
+	// register. A precision p uses 2^p registers and has a standard error
+	// of about 1.04/sqrt(2^p), e.g. 0.81% for the default.
+	minHyperLogLogPrecision     = 4
+	maxHyperLogLogPrecision     = 16
+	defaultHyperLogLogPrecision = 14
+)
+
+// hyperLogLog estimates the number of distinct values using a fixed number
+// of registers. Each value is hashed, the first bits of the hash select a
+// register and the register keeps the longest run of leading zeros seen in
+// the remaining bits. Sketches with the same precision are merged by
+// keeping the maximum of each register.
+type hyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint8) *hyperLogLog {
+	return &hyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}
+}
+
+// add adds a value to the sketch. Integers and floats with the same value
+// are the same value, as in distinct().
+func (h *hyperLogLog) add(v *protocol.FieldValue) {
+	var b []byte
+	if v.Int64Value != nil {
+		b = hyperLogLogFloat(float64(*v.Int64Value))
+	} else if v.DoubleValue != nil {
+		b = hyperLogLogFloat(*v.DoubleValue)
+	} else if v.BoolValue != nil {
+		b = []byte{'b', 0}
+		if *v.BoolValue {
+			b[1] = 1
+		}
+	} else if v.StringValue != nil {
+		b = append([]byte{'s'}, *v.StringValue...)
+	} else {
+		return
+	}
+
+	hash := fnv.New64a()
+	hash.Write(b)
+	x := mix64(hash.Sum64())
+
+	idx := x >> (64 - h.precision)
+	rho := uint8(leadingZeros64(x<<h.precision|1<<(h.precision-1)) + 1)
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+func hyperLogLogFloat(f float64) []byte {
+	b := make([]byte, 9)
+	b[0] = 'f'
+	binary.BigEndian.PutUint64(b[1:], math.Float64bits(f))
+	return b
+}
+
+// merge sets each register to the maximum of both sketches.
+func (h *hyperLogLog) merge(other *hyperLogLog) error {
+	if h.precision != other.precision {
+		return fmt.Errorf("cannot merge hyperloglog sketches with precision %d and %d", h.precision, other.precision)
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// count returns the estimated number of distinct values.
+func (h *hyperLogLog) count() int64 {
+	m := float64(len(h.registers))
+
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+
+	// Use linear counting for small cardinalities where the estimate is
+	// biased.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
+
+// marshal encodes the precision followed by the registers.
+func (h *hyperLogLog) marshal() []byte {
+	return append([]byte{h.precision}, h.registers...)
+}
+
+// unmarshalHyperLogLog decodes a sketch encoded by marshal.
+func unmarshalHyperLogLog(data []byte) (*hyperLogLog, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("unmarshal hyperloglog: empty sketch")
+	}
+	precision := data[0]
+	if precision < minHyperLogLogPrecision || precision > maxHyperLogLogPrecision {
+		return nil, fmt.Errorf("unmarshal hyperloglog: invalid precision: %d", precision)
+	} else if len(data)-1 != 1<<precision {
+		return nil, fmt.Errorf("unmarshal hyperloglog: expected %d registers, got %d", 1<<precision, len(data)-1)
+	}
+	return &hyperLogLog{precision: precision, registers: append([]uint8(nil), data[1:]...)}, nil
+}
+
+// String returns the sketch encoded as a base64 string so it can be stored
+// as a string value, e.g. by a continuous query.
+func (h *hyperLogLog) String() string {
+	return base64.StdEncoding.EncodeToString(h.marshal())
+}
+
+// parseHyperLogLog decodes a sketch returned by String.
+func parseHyperLogLog(s string) (*hyperLogLog, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hyperloglog sketch: %s", err)
+	}
+	return unmarshalHyperLogLog(data)
+}
+
+// mix64 scrambles the bits of a hash so that similar values, which FNV
+// hashes to similar high bits, are spread over all registers.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// leadingZeros64 returns the number of leading zero bits of a non-zero x.
+func leadingZeros64(x uint64) int {
+	n := 0
+	for x&(1<<63) == 0 {
+		x <<= 1
+		n++
+	}
+	return n
+}
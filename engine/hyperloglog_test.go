@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/influxdb/influxdb/protocol"
+	. "launchpad.net/gocheck"
+)
+
+type HyperLogLogSuite struct{}
+
+var _ = Suite(&HyperLogLogSuite{})
+
+// Ensure distinct values are counted within the expected error.
+func (self *HyperLogLogSuite) TestCount(c *C) {
+	for _, n := range []int{0, 1, 10, 1000, 100000} {
+		h := newHyperLogLog(defaultHyperLogLogPrecision)
+		for i := 0; i < n; i++ {
+			// Add each value twice.
+			h.add(&protocol.FieldValue{StringValue: protocol.String(fmt.Sprintf("user%d", i))})
+			h.add(&protocol.FieldValue{StringValue: protocol.String(fmt.Sprintf("user%d", i))})
+		}
+		c.Assert(math.Abs(float64(h.count()-int64(n))) <= float64(n)/50, Equals, true, Commentf("n=%d count=%d", n, h.count()))
+	}
+}
+
+// Ensure integers and floats with the same value are the same value and
+// null values are ignored.
+func (self *HyperLogLogSuite) TestValueTypes(c *C) {
+	h := newHyperLogLog(defaultHyperLogLogPrecision)
+	h.add(&protocol.FieldValue{Int64Value: protocol.Int64(1)})
+	h.add(&protocol.FieldValue{DoubleValue: protocol.Float64(1)})
+	h.add(&protocol.FieldValue{StringValue: protocol.String("1")})
+	h.add(&protocol.FieldValue{BoolValue: &[]bool{true}[0]})
+	h.add(&protocol.FieldValue{IsNull: &[]bool{true}[0]})
+	c.Assert(h.count(), Equals, int64(3))
+}
+
+// Ensure sketches can be serialized and merged into the sketch of all values.
+func (self *HyperLogLogSuite) TestMerge(c *C) {
+	all := newHyperLogLog(12)
+	merged := newHyperLogLog(12)
+	for day := 0; day < 7; day++ {
+		h := newHyperLogLog(12)
+		for i := 0; i < 1000; i++ {
+			v := &protocol.FieldValue{Int64Value: protocol.Int64(int64(day*500 + i))}
+			h.add(v)
+			all.add(v)
+		}
+
+		other, err := parseHyperLogLog(h.String())
+		c.Assert(err, IsNil)
+		c.Assert(merged.merge(other), IsNil)
+	}
+	c.Assert(merged.registers, DeepEquals, all.registers)
+	c.Assert(math.Abs(float64(merged.count()-4000)) <= 4000*0.05, Equals, true, Commentf("count=%d", merged.count()))
+
+	// Sketches with different precisions can't be merged.
+	c.Assert(merged.merge(newHyperLogLog(14)), NotNil)
+}
+
+// Ensure invalid sketches are rejected.
+func (self *HyperLogLogSuite) TestParseInvalid(c *C) {
+	for _, s := range []string{"", "not base64!", newHyperLogLog(10).String()[:20]} {
+		_, err := parseHyperLogLog(s)
+		c.Assert(err, NotNil, Commentf("%q", s))
+	}
+}
@@ -0,0 +1,299 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/parser"
+)
+
+// NewQueryFromInfluxQL converts an InfluxQL query into the query model used
+// by the engine. This allows queries parsed by the influxql package to be
+// executed by the same processor chains as queries from the C grammar of
+// the parser package, which isn't needed to plan them.
+func NewQueryFromInfluxQL(q influxql.Query) (*parser.Query, error) {
+	switch q := q.(type) {
+	case *influxql.SelectQuery:
+		sq, err := newSelectQueryFromInfluxQL(q)
+		if err != nil {
+			return nil, err
+		}
+		return parser.NewQueryFromSelectQuery(sq), nil
+
+	case *influxql.DeleteQuery:
+		dq, err := newDeleteQueryFromInfluxQL(q)
+		if err != nil {
+			return nil, err
+		}
+		return parser.NewQueryFromDeleteQuery(dq), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported query type: %T", q)
+	}
+}
+
+// newSelectQueryFromInfluxQL converts an InfluxQL select query.
+func newSelectQueryFromInfluxQL(q *influxql.SelectQuery) (*parser.SelectQuery, error) {
+	// Convert the source and condition. The time range is extracted from
+	// the condition the same way as it is for the C grammar.
+	from, err := newFromClauseFromInfluxQL(q.Source)
+	if err != nil {
+		return nil, err
+	}
+	condition, err := newWhereConditionFromInfluxQL(q.Condition)
+	if err != nil {
+		return nil, err
+	}
+	sq, err := parser.NewSelectQuery(from, condition)
+	if err != nil {
+		return nil, err
+	}
+	sq.Limit = q.Limit
+	sq.Ascending = q.Ascending
+	groupBy := sq.GetGroupByClause()
+
+	// Convert fields to columns.
+	for _, f := range q.Fields {
+		v, err := newValueFromInfluxQL(f.Expr)
+		if err != nil {
+			return nil, err
+		}
+		v.Alias = f.Alias
+		sq.ColumnNames = append(sq.ColumnNames, v)
+	}
+
+	// Convert dimensions to the group by clause.
+	// A bare duration is shorthand for grouping by time.
+	for _, d := range q.Dimensions {
+		expr := d.Expr
+		if lit, ok := expr.(*influxql.DurationLiteral); ok {
+			expr = &influxql.Call{Name: "time", Args: []influxql.Expr{lit}}
+		}
+
+		v, err := newValueFromInfluxQL(expr)
+		if err != nil {
+			return nil, err
+		}
+		v.Alias = d.Alias
+		groupBy.Elems = append(groupBy.Elems, v)
+	}
+
+	// Convert the fill option to the value of the old fill() function.
+	switch q.Fill {
+	case influxql.NullFill:
+		groupBy.FillType = parser.FillNull
+		groupBy.FillValue = &parser.Value{Name: "null", Type: parser.ValueSimpleName}
+	case influxql.NumberFill:
+		v, err := newValueFromInfluxQL(q.FillValue)
+		if err != nil {
+			return nil, err
+		}
+		groupBy.FillType = parser.FillNumber
+		groupBy.FillValue = v
+	case influxql.PreviousFill:
+		groupBy.FillType = parser.FillPrevious
+		groupBy.FillValue = &parser.Value{Name: "previous", Type: parser.ValueSimpleName}
+	case influxql.LinearFill:
+		groupBy.FillType = parser.FillLinear
+		groupBy.FillValue = &parser.Value{Name: "linear", Type: parser.ValueSimpleName}
+	}
+	groupBy.FillWithZero = groupBy.FillType != parser.FillNone
+	groupBy.Location = q.Location
+
+	// Convert the condition on the aggregated rows.
+	if sq.Having, err = newWhereConditionFromInfluxQL(q.Having); err != nil {
+		return nil, err
+	}
+
+	return sq, nil
+}
+
+// newDeleteQueryFromInfluxQL converts an InfluxQL delete query.
+func newDeleteQueryFromInfluxQL(q *influxql.DeleteQuery) (*parser.DeleteQuery, error) {
+	from, err := newFromClauseFromInfluxQL(q.Source)
+	if err != nil {
+		return nil, err
+	}
+	condition, err := newWhereConditionFromInfluxQL(q.Condition)
+	if err != nil {
+		return nil, err
+	}
+	return parser.NewDeleteQuery(from, condition)
+}
+
+// newFromClauseFromInfluxQL converts an InfluxQL join into a from clause.
+func newFromClauseFromInfluxQL(j influxql.Join) (*parser.FromClause, error) {
+	switch j := j.(type) {
+	case *influxql.ImplicitJoin:
+		c := &parser.FromClause{Type: parser.FromClauseArray}
+		for _, s := range j.Sources {
+			c.Names = append(c.Names, newTableNameFromInfluxQL(s))
+		}
+		return c, nil
+
+	case *influxql.MergeJoin:
+		if j.Condition != nil {
+			return nil, fmt.Errorf("merge conditions are not supported")
+		}
+
+		c := &parser.FromClause{Type: parser.FromClauseMerge}
+		for _, s := range j.Sources {
+			if s.Regex != nil {
+				return nil, fmt.Errorf("merge does not accept regex sources")
+			}
+			c.Names = append(c.Names, newTableNameFromInfluxQL(s))
+		}
+		return c, nil
+
+	case *influxql.InnerJoin:
+		if j.Condition != nil {
+			return nil, fmt.Errorf("join conditions are not supported")
+		} else if j.LHS.Regex != nil || j.RHS.Regex != nil {
+			return nil, fmt.Errorf("inner join does not accept regex sources")
+		}
+		return &parser.FromClause{
+			Type:  parser.FromClauseInnerJoin,
+			Names: []*parser.TableName{newTableNameFromInfluxQL(j.LHS), newTableNameFromInfluxQL(j.RHS)},
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported source: %T", j)
+	}
+}
+
+// newTableNameFromInfluxQL converts an InfluxQL series into a table name.
+func newTableNameFromInfluxQL(s *influxql.Series) *parser.TableName {
+	if s.Regex != nil {
+		return &parser.TableName{Name: parser.NewRegexValue(s.Regex), Alias: s.Alias}
+	}
+	return &parser.TableName{Name: newNameValue(s.Name), Alias: s.Alias}
+}
+
+// newWhereConditionFromInfluxQL converts an InfluxQL condition expression.
+// Returns nil if the expression is nil.
+func newWhereConditionFromInfluxQL(expr influxql.Expr) (*parser.WhereCondition, error) {
+	if expr == nil {
+		return nil, nil
+	}
+
+	// Logical operators become nested conditions.
+	if bin, ok := expr.(*influxql.BinaryExpr); ok && (bin.Op == influxql.AND || bin.Op == influxql.OR) {
+		lhs, err := newWhereConditionFromInfluxQL(bin.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := newWhereConditionFromInfluxQL(bin.RHS)
+		if err != nil {
+			return nil, err
+		}
+		return &parser.WhereCondition{Left: lhs, Operation: bin.Op.String(), Right: rhs}, nil
+	}
+
+	// Everything else is a boolean expression.
+	v, err := newValueFromInfluxQL(expr)
+	if err != nil {
+		return nil, err
+	}
+	return parser.NewBoolExpression(v), nil
+}
+
+// newValueFromInfluxQL converts an InfluxQL expression into a value.
+func newValueFromInfluxQL(expr influxql.Expr) (*parser.Value, error) {
+	switch expr := expr.(type) {
+	case *influxql.VarRef:
+		return newNameValue(expr.Val), nil
+	case *influxql.Wildcard:
+		return &parser.Value{Name: "*", Type: parser.ValueWildcard}, nil
+	case *influxql.IntegerLiteral:
+		return &parser.Value{Name: strconv.FormatInt(expr.Val, 10), Type: parser.ValueInt}, nil
+	case *influxql.FloatLiteral:
+		return &parser.Value{Name: strconv.FormatFloat(expr.Val, 'f', -1, 64), Type: parser.ValueFloat}, nil
+	case *influxql.StringLiteral:
+		return &parser.Value{Name: expr.Val, Type: parser.ValueString}, nil
+	case *influxql.BooleanLiteral:
+		return &parser.Value{Name: strconv.FormatBool(expr.Val), Type: parser.ValueBool}, nil
+	case *influxql.TimeLiteral:
+		// Times are passed as nanoseconds since the epoch.
+		return &parser.Value{Name: strconv.FormatInt(expr.Val.UnixNano(), 10), Type: parser.ValueInt}, nil
+	case *influxql.DurationLiteral:
+		return &parser.Value{Name: formatDuration(expr.Val), Type: parser.ValueDuration}, nil
+
+	case *influxql.Call:
+		v := &parser.Value{Name: expr.Name, Type: parser.ValueFunctionCall}
+		for _, arg := range expr.Args {
+			elem, err := newValueFromInfluxQL(arg)
+			if err != nil {
+				return nil, err
+			}
+			v.Elems = append(v.Elems, elem)
+		}
+		return v, nil
+
+	case *influxql.BinaryExpr:
+		op, ok := valueOperators[expr.Op]
+		if !ok {
+			return nil, fmt.Errorf("unsupported operator in expression: %s", expr.Op)
+		}
+		lhs, err := newValueFromInfluxQL(expr.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := newValueFromInfluxQL(expr.RHS)
+		if err != nil {
+			return nil, err
+		}
+		return &parser.Value{Name: op, Type: parser.ValueExpression, Elems: []*parser.Value{lhs, rhs}}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported expression: %T", expr)
+	}
+}
+
+// valueOperators maps InfluxQL operators to the operator names used by the
+// arithmetic and filtering engines.
+var valueOperators = map[influxql.Token]string{
+	influxql.ADD: "+",
+	influxql.SUB: "-",
+	influxql.MUL: "*",
+	influxql.DIV: "/",
+	influxql.EQ:  "=",
+	influxql.NE:  "<>",
+	influxql.LT:  "<",
+	influxql.LE:  "<=",
+	influxql.GT:  ">",
+	influxql.GE:  ">=",
+}
+
+// newNameValue returns a value for a column or series name.
+// Names containing a period are table names, the same as in the C grammar.
+func newNameValue(name string) *parser.Value {
+	if strings.Contains(name, ".") {
+		return &parser.Value{Name: name, Type: parser.ValueTableName}
+	}
+	return &parser.Value{Name: name, Type: parser.ValueSimpleName}
+}
+
+// formatDuration formats a duration for ParseTimeDuration. Unlike
+// FormatTimeDuration, only regular intervals are used so that a day
+// multiple is never treated as an irregular week, month or year.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0s"
+	case d%(24*time.Hour) == 0:
+		return strconv.FormatInt(int64(d/(24*time.Hour)), 10) + "d"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	case d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	case d%time.Millisecond == 0:
+		return strconv.FormatInt(int64(d/time.Millisecond), 10) + "ms"
+	default:
+		return strconv.FormatInt(int64(d/time.Microsecond), 10) + "u"
+	}
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/parser"
+	. "launchpad.net/gocheck"
+)
+
+type InfluxQLSuite struct{}
+
+var _ = Suite(&InfluxQLSuite{})
+
+// Ensure InfluxQL queries convert to the same query model as the C grammar.
+func (self *InfluxQLSuite) TestNewQueryFromInfluxQL(c *C) {
+	for s, legacy := range map[string]string{
+		`SELECT value FROM cpu`:  "select value from cpu",
+		`SELECT * FROM cpu.idle`: "select * from cpu.idle",
+		`SELECT value AS v FROM cpu WHERE host = 'a' AND value > 90.5`:       "select value as v from cpu where host = 'a' and value > 90.5",
+		`SELECT count(value) FROM cpu GROUP BY time(10m) LIMIT 10 ORDER ASC`: "select count(value) from cpu group by time(10m) limit 10 order asc",
+		`SELECT mean(value) FROM cpu GROUP BY 1h, host`:                      "select mean(value) from cpu group by time(1h), host",
+		`SELECT a + b * 2 FROM cpu`:                                          "select a + b * 2 from cpu",
+		`SELECT count(value) FROM cpu GROUP BY time(1h) FILL(0)`:             "select count(value) from cpu group by time(1h) fill(0)",
+		`SELECT mean(value) FROM cpu GROUP BY 1h, host FILL(previous)`:       "select mean(value) from cpu group by time(1h), host fill(previous)",
+		`SELECT mean(value) FROM cpu GROUP BY 1h FILL(null)`:                 "select mean(value) from cpu group by time(1h) fill(null)",
+		`SELECT count(value) FROM cpu GROUP BY time(1d, 6h) TZ('UTC')`:       "select count(value) from cpu group by time(1d, 6h) tz('UTC')",
+		`SELECT mean(value) AS m FROM cpu GROUP BY host HAVING m > 80`:       "select mean(value) as m from cpu group by host having m > 80",
+		`SELECT * FROM /^cpu\./`:                                             "select * from /^cpu\\./",
+		`SELECT * FROM foo MERGE bar`:                                        "select * from foo merge bar",
+		`SELECT * FROM foo INNER JOIN bar`:                                   "select * from foo inner join bar",
+	} {
+		q, err := influxql.ParseQuery(s)
+		c.Assert(err, IsNil)
+		actual, err := NewQueryFromInfluxQL(q)
+		c.Assert(err, IsNil)
+
+		expected, err := parser.ParseQuery(legacy)
+		c.Assert(err, IsNil)
+		c.Assert(actual.Type(), Equals, expected[0].Type())
+		c.Assert(actual.GetQueryString(), Equals, expected[0].GetQueryString(), Commentf(s))
+	}
+}
+
+// Ensure the time range is extracted from an InfluxQL condition.
+func (self *InfluxQLSuite) TestNewQueryFromInfluxQL_TimeRange(c *C) {
+	q, err := influxql.ParseQuery(`SELECT value FROM cpu WHERE time > '2014-01-01' AND time < '2014-01-02 12:00:00' AND host = 'a'`)
+	c.Assert(err, IsNil)
+	pq, err := NewQueryFromInfluxQL(q)
+	c.Assert(err, IsNil)
+
+	sq := pq.SelectQuery
+	c.Assert(sq.GetStartTime(), Equals, time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	c.Assert(sq.GetEndTime(), Equals, time.Date(2014, 1, 2, 12, 0, 0, 0, time.UTC))
+	c.Assert(sq.IsStartTimeSpecified(), Equals, true)
+	c.Assert(sq.GetWhereCondition().GetString(), Equals, "host = 'a'")
+}
+
+// Ensure fill options convert to the same fill types as the fill() function.
+func (self *InfluxQLSuite) TestNewQueryFromInfluxQL_Fill(c *C) {
+	for fill, expected := range map[string]parser.FillType{
+		"null":     parser.FillNull,
+		"none":     parser.FillNone,
+		"previous": parser.FillPrevious,
+		"linear":   parser.FillLinear,
+		"-1.5":     parser.FillNumber,
+		"'n/a'":    parser.FillNumber,
+	} {
+		q, err := influxql.ParseQuery("SELECT mean(value) FROM cpu GROUP BY time(1m) FILL(" + fill + ")")
+		c.Assert(err, IsNil)
+		actual, err := NewQueryFromInfluxQL(q)
+		c.Assert(err, IsNil)
+		legacy, err := parser.ParseSelectQuery("select mean(value) from cpu group by time(1m) fill(" + fill + ")")
+		c.Assert(err, IsNil)
+
+		for _, groupBy := range []*parser.GroupByClause{actual.SelectQuery.GetGroupByClause(), legacy.GetGroupByClause()} {
+			c.Assert(groupBy.FillType, Equals, expected, Commentf(fill))
+			c.Assert(groupBy.FillWithZero, Equals, expected != parser.FillNone, Commentf(fill))
+		}
+	}
+}
+
+// Ensure unsupported InfluxQL constructs return an error.
+func (self *InfluxQLSuite) TestNewQueryFromInfluxQL_Unsupported(c *C) {
+	for s, msg := range map[string]string{
+		`SELECT * FROM a INNER JOIN b ON a.x = b.x`: "join conditions are not supported",
+		`SELECT * FROM a MERGE b ON a.x = b.x`:      "merge conditions are not supported",
+		`SELECT * FROM a INNER JOIN /b/`:            "inner join does not accept regex sources",
+		`SELECT * FROM a MERGE /b/`:                 "merge does not accept regex sources",
+		`SELECT a AND b FROM cpu`:                   "unsupported operator in expression: AND",
+	} {
+		q, err := influxql.ParseQuery(s)
+		c.Assert(err, IsNil)
+		_, err = NewQueryFromInfluxQL(q)
+		c.Assert(err, ErrorMatches, msg)
+	}
+}
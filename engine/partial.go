@@ -0,0 +1,407 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"code.google.com/p/goprotobuf/proto"
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+// PartialAggregator is an Aggregator whose state can be computed separately
+// for parts of the data, e.g. on each shard, and merged afterwards. Partial
+// states are serialized so they can be sent to the node merging them.
+type PartialAggregator interface {
+	Aggregator
+
+	// MarshalState returns the serialized form of a non-nil state.
+	MarshalState(state interface{}) ([]byte, error)
+
+	// UnmarshalState returns a state from its serialized form.
+	UnmarshalState(data []byte) (interface{}, error)
+
+	// MergeStates merges two states, either of which may be nil. States
+	// are merged in the order their points were read.
+	MergeStates(a, b interface{}) (interface{}, error)
+}
+
+// CanAggregatePartially returns true if every aggregate of the query can be
+// computed as partial states which are merged later.
+func CanAggregatePartially(query *parser.SelectQuery) bool {
+	if !query.HasAggregates() {
+		return false
+	}
+
+	ae, err := NewAggregatorEngine(query, nil)
+	if err != nil {
+		return false
+	}
+	for _, aggregator := range ae.aggregators {
+		if _, ok := aggregator.(PartialAggregator); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// NewPartialAggregatorEngine returns an engine which yields the partial
+// state of each aggregate instead of its value. Each point holds the states
+// of a single time bucket and group. Buckets are never filled since the
+// engine merging the states fills them.
+func NewPartialAggregatorEngine(query *parser.SelectQuery, next Processor) (*AggregatorEngine, error) {
+	ae, err := NewAggregatorEngine(query, next)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, aggregator := range ae.aggregators {
+		if _, ok := aggregator.(PartialAggregator); !ok {
+			return nil, fmt.Errorf("aggregate cannot be computed partially: %s", aggregator.ColumnNames())
+		}
+	}
+
+	ae.partial = true
+	ae.isFillQuery = false
+	ae.startTimeSpecified = false
+	ae.fields = nil
+	ae.initializeFields()
+	return ae, nil
+}
+
+// NewPartialMergeEngine returns a query engine which merges the partial
+// states yielded by a partial aggregator engine into the final values.
+func NewPartialMergeEngine(next Processor, query *parser.SelectQuery) (Processor, error) {
+	ae, err := NewAggregatorEngine(query, NewPassthroughEngineWithLimit(next, 1, query.Limit))
+	if err != nil {
+		return nil, err
+	}
+	ae.merge = true
+	return ae, nil
+}
+
+// partialColumnName returns the name of the column holding the partial
+// state of the aggregator at index i.
+func partialColumnName(i int) string {
+	return fmt.Sprintf("_partial_%d", i)
+}
+
+// marshalPartialState returns a field value holding the serialized state.
+// Nil states are returned as null values.
+func marshalPartialState(aggregator PartialAggregator, state interface{}) (*protocol.FieldValue, error) {
+	if state == nil {
+		return &protocol.FieldValue{IsNull: proto.Bool(true)}, nil
+	}
+	data, err := aggregator.MarshalState(state)
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.FieldValue{StringValue: protocol.String(string(data))}, nil
+}
+
+// unmarshalPartialState returns the state held by a field value.
+func unmarshalPartialState(aggregator PartialAggregator, v *protocol.FieldValue) (interface{}, error) {
+	if v == nil || v.StringValue == nil {
+		return nil, nil
+	}
+	return aggregator.UnmarshalState([]byte(v.GetStringValue()))
+}
+
+// mergePartialState merges the state held by a field value into state.
+func mergePartialState(aggregator PartialAggregator, state interface{}, v *protocol.FieldValue) (interface{}, error) {
+	other, err := unmarshalPartialState(aggregator, v)
+	if err != nil {
+		return nil, err
+	}
+	return aggregator.MergeStates(state, other)
+}
+
+// marshalNumbers encodes a list of int64 and float64 values.
+func marshalNumbers(values ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, v := range values {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalNumbers decodes values encoded by marshalNumbers into pointers.
+func unmarshalNumbers(data []byte, values ...interface{}) error {
+	r := bytes.NewReader(data)
+	for _, v := range values {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return fmt.Errorf("unmarshal partial state: %s", err)
+		}
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("unmarshal partial state: %d trailing bytes", r.Len())
+	}
+	return nil
+}
+
+// Max, min and sum states are the running value.
+
+func (self *CumulativeArithmeticAggregator) MarshalState(state interface{}) ([]byte, error) {
+	return marshalNumbers(state.(float64))
+}
+
+func (self *CumulativeArithmeticAggregator) UnmarshalState(data []byte) (interface{}, error) {
+	var v float64
+	if err := unmarshalNumbers(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (self *CumulativeArithmeticAggregator) MergeStates(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	} else if b == nil {
+		return a, nil
+	}
+	return self.operation(a.(float64), &protocol.FieldValue{DoubleValue: protocol.Float64(b.(float64))}), nil
+}
+
+// Count states are the number of points.
+
+func (self *CountAggregator) MarshalState(state interface{}) ([]byte, error) {
+	return marshalNumbers(state.(int64))
+}
+
+func (self *CountAggregator) UnmarshalState(data []byte) (interface{}, error) {
+	var v int64
+	if err := unmarshalNumbers(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (self *CountAggregator) MergeStates(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	} else if b == nil {
+		return a, nil
+	}
+	return a.(int64) + b.(int64), nil
+}
+
+// Mean states are merged by weighting each mean with its count.
+
+func (self *MeanAggregator) MarshalState(state interface{}) ([]byte, error) {
+	s := state.(*MeanAggregatorState)
+	return marshalNumbers(s.mean, s.count)
+}
+
+func (self *MeanAggregator) UnmarshalState(data []byte) (interface{}, error) {
+	s := &MeanAggregatorState{}
+	if err := unmarshalNumbers(data, &s.mean, &s.count); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (self *MeanAggregator) MergeStates(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	} else if b == nil {
+		return a, nil
+	}
+	sa, sb := a.(*MeanAggregatorState), b.(*MeanAggregatorState)
+	if count := sa.count + sb.count; count > 0 {
+		sa.mean = sa.mean*(sa.count/count) + sb.mean*(sb.count/count)
+		sa.count = count
+	}
+	return sa, nil
+}
+
+// Standard deviation states are the running sums of values and their squares.
+
+func (self *StandardDeviationAggregator) MarshalState(state interface{}) ([]byte, error) {
+	r := state.(*StandardDeviationRunning)
+	return marshalNumbers(int64(r.count), r.totalX, r.totalX2)
+}
+
+func (self *StandardDeviationAggregator) UnmarshalState(data []byte) (interface{}, error) {
+	var count int64
+	r := &StandardDeviationRunning{}
+	if err := unmarshalNumbers(data, &count, &r.totalX, &r.totalX2); err != nil {
+		return nil, err
+	}
+	r.count = int(count)
+	return r, nil
+}
+
+func (self *StandardDeviationAggregator) MergeStates(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	} else if b == nil {
+		return a, nil
+	}
+	ra, rb := a.(*StandardDeviationRunning), b.(*StandardDeviationRunning)
+	ra.count += rb.count
+	ra.totalX += rb.totalX
+	ra.totalX2 += rb.totalX2
+	return ra, nil
+}
+
+// Histogram states are encoded as bucket and count pairs.
+
+func (self *HistogramAggregator) MarshalState(state interface{}) ([]byte, error) {
+	buckets := state.(HistogramAggregatorState)
+
+	keys := make([]int, 0, len(buckets))
+	for bucket := range buckets {
+		keys = append(keys, bucket)
+	}
+	sort.Ints(keys)
+
+	values := make([]interface{}, 0, 2*len(keys))
+	for _, bucket := range keys {
+		values = append(values, int64(bucket), int64(buckets[bucket]))
+	}
+	return marshalNumbers(values...)
+}
+
+func (self *HistogramAggregator) UnmarshalState(data []byte) (interface{}, error) {
+	if len(data)%16 != 0 {
+		return nil, fmt.Errorf("unmarshal partial state: invalid histogram length: %d", len(data))
+	}
+
+	pairs := make([]int64, len(data)/8)
+	values := make([]interface{}, len(pairs))
+	for i := range pairs {
+		values[i] = &pairs[i]
+	}
+	if err := unmarshalNumbers(data, values...); err != nil {
+		return nil, err
+	}
+
+	buckets := make(HistogramAggregatorState)
+	for i := 0; i < len(pairs); i += 2 {
+		buckets[int(pairs[i])] += int(pairs[i+1])
+	}
+	return buckets, nil
+}
+
+func (self *HistogramAggregator) MergeStates(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	} else if b == nil {
+		return a, nil
+	}
+	ba := a.(HistogramAggregatorState)
+	for bucket, count := range b.(HistogramAggregatorState) {
+		ba[bucket] += count
+	}
+	return ba, nil
+}
+
+// First and last states are the selected value. Since states are merged in
+// the order they were read, first keeps the earlier state and last the later.
+
+func (self *FirstOrLastAggregator) MarshalState(state interface{}) ([]byte, error) {
+	return proto.Marshal((*protocol.FieldValue)(state.(FirstOrLastAggregatorState)))
+}
+
+func (self *FirstOrLastAggregator) UnmarshalState(data []byte) (interface{}, error) {
+	v := &protocol.FieldValue{}
+	if err := proto.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("unmarshal partial state: %s", err)
+	}
+	return FirstOrLastAggregatorState(v), nil
+}
+
+func (self *FirstOrLastAggregator) MergeStates(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	} else if b == nil {
+		return a, nil
+	} else if self.isFirst {
+		return a, nil
+	}
+	return b, nil
+}
+
+// Approximate percentile states are t-digests.
+
+func (self *ApproxPercentileAggregator) MarshalState(state interface{}) ([]byte, error) {
+	return state.(*tdigest).marshal()
+}
+
+func (self *ApproxPercentileAggregator) UnmarshalState(data []byte) (interface{}, error) {
+	return unmarshalTDigest(data)
+}
+
+func (self *ApproxPercentileAggregator) MergeStates(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	} else if b == nil {
+		return a, nil
+	}
+	a.(*tdigest).merge(b.(*tdigest))
+	return a, nil
+}
+
+// Approximate count distinct states are hyperloglog sketches.
+
+func (self *HyperLogLogAggregator) MarshalState(state interface{}) ([]byte, error) {
+	return state.(*hyperLogLog).marshal(), nil
+}
+
+func (self *HyperLogLogAggregator) UnmarshalState(data []byte) (interface{}, error) {
+	return unmarshalHyperLogLog(data)
+}
+
+func (self *HyperLogLogAggregator) MergeStates(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	} else if b == nil {
+		return a, nil
+	}
+	if err := a.(*hyperLogLog).merge(b.(*hyperLogLog)); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Counter rate states are the first and last values and the increase
+// between them. Since states are computed for separate time ranges, the
+// interval between the ranges is added when they are merged.
+
+func (self *CounterRateAggregator) MarshalState(state interface{}) ([]byte, error) {
+	s := state.(*CounterRateAggregatorState)
+	return marshalNumbers(s.firstTimestamp, s.firstValue, s.lastTimestamp, s.lastValue, s.increase, s.elapsed)
+}
+
+func (self *CounterRateAggregator) UnmarshalState(data []byte) (interface{}, error) {
+	s := &CounterRateAggregatorState{}
+	if err := unmarshalNumbers(data, &s.firstTimestamp, &s.firstValue, &s.lastTimestamp, &s.lastValue, &s.increase, &s.elapsed); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (self *CounterRateAggregator) MergeStates(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	} else if b == nil {
+		return a, nil
+	}
+	sa, sb := a.(*CounterRateAggregatorState), b.(*CounterRateAggregatorState)
+	if sb.firstTimestamp < sa.firstTimestamp {
+		sa, sb = sb, sa
+	}
+	sa.increase += sb.increase
+	sa.elapsed += sb.elapsed
+	if sb.firstTimestamp >= sa.lastTimestamp {
+		self.addInterval(sa, sa.lastTimestamp, sa.lastValue, sb.firstTimestamp, sb.firstValue)
+	}
+	if sb.lastTimestamp > sa.lastTimestamp {
+		sa.lastTimestamp, sa.lastValue = sb.lastTimestamp, sb.lastValue
+	}
+	return sa, nil
+}
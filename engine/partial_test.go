@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+	. "launchpad.net/gocheck"
+)
+
+type PartialSuite struct{}
+
+var _ = Suite(&PartialSuite{})
+
+// Ensure states aggregated separately and merged return the same values as
+// aggregating all points at once.
+func (self *PartialSuite) TestMergeStates(c *C) {
+	series := &protocol.Series{
+		Name:   protocol.String("foo"),
+		Fields: []string{"value"},
+	}
+	for i := 0; i < 10; i++ {
+		series.Points = append(series.Points, &protocol.Point{
+			Values: []*protocol.FieldValue{{Int64Value: protocol.Int64(int64(i * i % 7))}},
+		})
+	}
+
+	for _, fn := range []string{"count", "sum", "min", "max", "mean", "stddev", "first", "last", "histogram", "count_distinct_approx", "count_distinct_sketch"} {
+		q, err := parser.ParseSelectQuery(fmt.Sprintf("select %s(value) from foo", fn))
+		c.Assert(err, IsNil)
+		c.Assert(CanAggregatePartially(q), Equals, true)
+
+		ae, err := NewAggregatorEngine(q, nil)
+		c.Assert(err, IsNil)
+		aggregator := ae.aggregators[0].(PartialAggregator)
+		c.Assert(aggregator.InitializeFieldsMetadata(series), IsNil)
+
+		// Aggregate all points and each half of them.
+		var all, a, b interface{}
+		for i, p := range series.Points {
+			all, err = aggregator.AggregatePoint(all, p)
+			c.Assert(err, IsNil)
+			if i < 4 {
+				a, err = aggregator.AggregatePoint(a, p)
+			} else {
+				b, err = aggregator.AggregatePoint(b, p)
+			}
+			c.Assert(err, IsNil)
+		}
+
+		// Send the second half as a serialized state.
+		value, err := marshalPartialState(aggregator, b)
+		c.Assert(err, IsNil)
+		merged, err := mergePartialState(aggregator, a, value)
+		c.Assert(err, IsNil)
+
+		aggregator.CalculateSummaries(all)
+		aggregator.CalculateSummaries(merged)
+		c.Assert(valueRows(aggregator.GetValues(merged)), DeepEquals, valueRows(aggregator.GetValues(all)), Commentf(fn))
+	}
+}
+
+// valueRows returns the rows of an aggregator's values as sorted strings
+// since aggregators like histogram don't return rows in a fixed order.
+func valueRows(values [][]*protocol.FieldValue) []string {
+	var rows []string
+	for _, row := range values {
+		rows = append(rows, fmt.Sprint(row))
+	}
+	sort.Strings(rows)
+	return rows
+}
+
+// Ensure nil states are sent as null values and don't change merged states.
+func (self *PartialSuite) TestMergeNilStates(c *C) {
+	q, err := parser.ParseSelectQuery("select sum(value) from foo")
+	c.Assert(err, IsNil)
+	ae, err := NewAggregatorEngine(q, nil)
+	c.Assert(err, IsNil)
+	aggregator := ae.aggregators[0].(PartialAggregator)
+
+	value, err := marshalPartialState(aggregator, nil)
+	c.Assert(err, IsNil)
+	c.Assert(value.GetIsNull(), Equals, true)
+
+	state, err := mergePartialState(aggregator, 3.0, value)
+	c.Assert(err, IsNil)
+	c.Assert(state, Equals, 3.0)
+}
+
+// Ensure aggregates which can't be merged are computed from raw points.
+func (self *PartialSuite) TestCanAggregatePartially(c *C) {
+	for query, expected := range map[string]bool{
+		"select value from foo":                                false,
+		"select mean(value), max(value) from foo":              true,
+		"select percentile(value, 90) from foo":                false,
+		"select mean(value), median(value) from foo":           false,
+		"select percentile_approx(value, 99, 200) from foo":    true,
+		"select median_approx(value) from foo":                 true,
+		"select count_distinct_approx(value, 12) from foo":     true,
+		"select count(distinct(value)) from foo":               false,
+		"select count(value) from foo group by time(1h), host": true,
+	} {
+		q, err := parser.ParseSelectQuery(query)
+		c.Assert(err, IsNil)
+		c.Assert(CanAggregatePartially(q), Equals, expected, Commentf(query))
+	}
+}
@@ -47,7 +47,7 @@ func (self *Passthrough) Yield(seriesIncoming *protocol.Series) (bool, error) {
 
 	self.limiter.calculateLimitAndSlicePoints(seriesIncoming)
 	if len(seriesIncoming.Points) == 0 {
-		return false, nil
+		return !self.limiter.hitLimit(seriesIncoming.GetName()), nil
 	}
 
 	if self.series == nil {
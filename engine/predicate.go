@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+// Predicate is a comparison between a single column and constant values.
+// Predicates are extracted from a where condition so that storage can
+// discard points before they are fully read.
+type Predicate struct {
+	Column string
+
+	operation BooleanOperation
+	values    []*protocol.FieldValue
+}
+
+// Match returns true if the column's value satisfies the predicate. Null or
+// missing values never match, the same as in Filter().
+func (p *Predicate) Match(v *protocol.FieldValue) bool {
+	ok, err := p.operation(v, p.values)
+	return err == nil && ok == MATCH
+}
+
+// Predicates returns the predicates that must all match for a point to
+// satisfy the condition. Only comparisons of a column with constants that
+// are joined by AND are returned, so the full condition still has to be
+// evaluated after a point is read.
+func Predicates(condition *parser.WhereCondition) []*Predicate {
+	if condition == nil {
+		return nil
+	}
+
+	if expr, ok := condition.GetBoolExpression(); ok {
+		if p := newPredicate(expr); p != nil {
+			return []*Predicate{p}
+		}
+		return nil
+	}
+
+	// Only conjunctions can be split into separate predicates.
+	if condition.Operation != "AND" {
+		return nil
+	}
+	left, _ := condition.GetLeftWhereCondition()
+	return append(Predicates(left), Predicates(condition.Right)...)
+}
+
+// newPredicate returns a predicate for a boolean expression or nil if the
+// expression doesn't compare a column with constants.
+func newPredicate(expr *parser.Value) *Predicate {
+	operation := registeredOperators[expr.Name]
+	if operation == nil || len(expr.Elems) < 2 || expr.Elems[0].Type != parser.ValueSimpleName {
+		return nil
+	} else if expr.Name != "in" && len(expr.Elems) != 2 {
+		return nil
+	}
+
+	for _, v := range expr.Elems[1:] {
+		switch v.Type {
+		case parser.ValueInt, parser.ValueFloat, parser.ValueBool, parser.ValueString, parser.ValueRegex:
+		default:
+			return nil
+		}
+	}
+
+	values, err := getExpressionValue(expr.Elems[1:], nil, nil)
+	if err != nil {
+		return nil
+	}
+	return &Predicate{Column: expr.Elems[0].Name, operation: operation, values: values}
+}
@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// tdigest is a sketch of a distribution that estimates quantiles using
+// memory bounded by its compression. Values are grouped into centroids
+// which are smaller near the tails, so extreme quantiles such as the 99th
+// percentile stay accurate. Digests can be merged, which allows them to be
+// computed on each shard separately.
+//
+// See "Computing Extremely Accurate Quantiles Using t-Digests" by Ted
+// Dunning and Otmar Ertl.
+type tdigest struct {
+	compression float64
+	centroids   centroids // merged centroids, sorted by mean
+	count       float64   // the total weight of centroids
+	unmerged    centroids // values added since the last compression
+	min, max    float64
+}
+
+type centroid struct {
+	mean  float64
+	count float64
+}
+
+type centroids []centroid
+
+func (a centroids) Len() int           { return len(a) }
+func (a centroids) Less(i, j int) bool { return a[i].mean < a[j].mean }
+func (a centroids) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+func newTDigest(compression float64) *tdigest {
+	return &tdigest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// add adds a value with the given weight.
+func (t *tdigest) add(value, count float64) {
+	t.unmerged = append(t.unmerged, centroid{value, count})
+	if value < t.min {
+		t.min = value
+	}
+	if value > t.max {
+		t.max = value
+	}
+
+	// Buffer values so they are sorted and merged in batches.
+	if float64(len(t.unmerged)) > 5*t.compression {
+		t.compress()
+	}
+}
+
+// merge adds the centroids of another digest.
+func (t *tdigest) merge(other *tdigest) {
+	other.compress()
+	if len(other.centroids) == 0 {
+		return
+	}
+	t.unmerged = append(t.unmerged, other.centroids...)
+	t.min = math.Min(t.min, other.min)
+	t.max = math.Max(t.max, other.max)
+	t.compress()
+}
+
+// compress merges added values into the centroids. Neighbouring centroids
+// are combined while the combined centroid spans at most one unit of the
+// scale function k.
+func (t *tdigest) compress() {
+	if len(t.unmerged) == 0 {
+		return
+	}
+
+	all := append(t.centroids, t.unmerged...)
+	sort.Sort(all)
+
+	total := 0.0
+	for _, c := range all {
+		total += c.count
+	}
+
+	merged := make(centroids, 0, len(t.centroids)+1)
+	cur := all[0]
+	before := 0.0
+	for _, c := range all[1:] {
+		count := cur.count + c.count
+		if t.k((before+count)/total)-t.k(before/total) <= 1 {
+			cur.mean += (c.mean - cur.mean) * c.count / count
+			cur.count = count
+			continue
+		}
+		merged = append(merged, cur)
+		before += cur.count
+		cur = c
+	}
+	merged = append(merged, cur)
+
+	t.centroids = merged
+	t.count = total
+	t.unmerged = nil
+}
+
+// k is the scale function which maps a quantile to the index of its
+// centroid.
+func (t *tdigest) k(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// quantile returns the estimated value at quantile q, between 0 and 1.
+func (t *tdigest) quantile(q float64) float64 {
+	t.compress()
+
+	if len(t.centroids) == 0 {
+		return math.NaN()
+	} else if len(t.centroids) == 1 {
+		return t.centroids[0].mean
+	} else if q <= 0 {
+		return t.min
+	} else if q >= 1 {
+		return t.max
+	}
+
+	// Each centroid's mean is treated as the value at the middle of its
+	// weight. Values between centroids are interpolated.
+	index := q * t.count
+	first, last := t.centroids[0], t.centroids[len(t.centroids)-1]
+	if index < first.count/2 {
+		return t.min + (first.mean-t.min)*index/(first.count/2)
+	} else if index > t.count-last.count/2 {
+		return last.mean + (t.max-last.mean)*(index-(t.count-last.count/2))/(last.count/2)
+	}
+
+	center := first.count / 2
+	for i := 1; i < len(t.centroids); i++ {
+		prev, c := t.centroids[i-1], t.centroids[i]
+		next := center + (prev.count+c.count)/2
+		if index <= next {
+			return prev.mean + (c.mean-prev.mean)*(index-center)/(next-center)
+		}
+		center = next
+	}
+	return last.mean
+}
+
+// marshal encodes the digest's compression, bounds and centroids.
+func (t *tdigest) marshal() ([]byte, error) {
+	t.compress()
+
+	values := []interface{}{t.compression, t.min, t.max, int64(len(t.centroids))}
+	for _, c := range t.centroids {
+		values = append(values, c.mean, c.count)
+	}
+	return marshalNumbers(values...)
+}
+
+// unmarshalTDigest decodes a digest encoded by marshal.
+func unmarshalTDigest(data []byte) (*tdigest, error) {
+	if len(data) < 32 || (len(data)-32)%16 != 0 {
+		return nil, fmt.Errorf("unmarshal t-digest: invalid length: %d", len(data))
+	}
+
+	var n int64
+	t := &tdigest{centroids: make(centroids, (len(data)-32)/16)}
+	values := []interface{}{&t.compression, &t.min, &t.max, &n}
+	for i := range t.centroids {
+		values = append(values, &t.centroids[i].mean, &t.centroids[i].count)
+	}
+	if err := unmarshalNumbers(data, values...); err != nil {
+		return nil, err
+	} else if int(n) != len(t.centroids) {
+		return nil, fmt.Errorf("unmarshal t-digest: expected %d centroids, got %d", n, len(t.centroids))
+	}
+
+	for _, c := range t.centroids {
+		t.count += c.count
+	}
+	return t, nil
+}
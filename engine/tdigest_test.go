@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	. "launchpad.net/gocheck"
+)
+
+type TDigestSuite struct{}
+
+var _ = Suite(&TDigestSuite{})
+
+// Ensure quantiles are estimated within a small error of the exact values
+// and memory stays bounded.
+func (self *TDigestSuite) TestQuantile(c *C) {
+	rng := rand.New(rand.NewSource(0))
+	values := make([]float64, 100000)
+	t := newTDigest(100)
+	for i := range values {
+		values[i] = rng.ExpFloat64()
+		t.add(values[i], 1)
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0.001, 0.01, 0.25, 0.5, 0.75, 0.99, 0.999} {
+		// Compare the ranks since values in the tail are far apart.
+		rank := float64(sort.SearchFloat64s(values, t.quantile(q))) / float64(len(values))
+		c.Assert(math.Abs(rank-q) < 0.005, Equals, true, Commentf("q=%v rank=%v", q, rank))
+	}
+	c.Assert(len(t.centroids) < 200, Equals, true, Commentf("centroids=%d", len(t.centroids)))
+	c.Assert(t.quantile(0), Equals, values[0])
+	c.Assert(t.quantile(1), Equals, values[len(values)-1])
+}
+
+// Ensure digests of separate values can be serialized and merged.
+func (self *TDigestSuite) TestMerge(c *C) {
+	rng := rand.New(rand.NewSource(1))
+	all := newTDigest(100)
+	merged := newTDigest(100)
+	for i := 0; i < 10; i++ {
+		part := newTDigest(100)
+		for j := 0; j < 10000; j++ {
+			// Each part has a different range of values.
+			v := float64(i)*1000 + rng.Float64()*1000
+			part.add(v, 1)
+			all.add(v, 1)
+		}
+
+		data, err := part.marshal()
+		c.Assert(err, IsNil)
+		other, err := unmarshalTDigest(data)
+		c.Assert(err, IsNil)
+		merged.merge(other)
+	}
+
+	c.Assert(merged.count, Equals, 100000.0)
+	for _, q := range []float64{0.01, 0.5, 0.99} {
+		c.Assert(math.Abs(merged.quantile(q)-q*10000) < 50, Equals, true, Commentf("q=%v value=%v", q, merged.quantile(q)))
+		c.Assert(math.Abs(merged.quantile(q)-all.quantile(q)) < 50, Equals, true, Commentf("q=%v", q))
+	}
+}
+
+// Ensure a digest with a single value returns it for every quantile.
+func (self *TDigestSuite) TestSingleValue(c *C) {
+	t := newTDigest(100)
+	c.Assert(math.IsNaN(t.quantile(0.5)), Equals, true)
+	t.add(42, 1)
+	c.Assert(t.quantile(0.01), Equals, 42.0)
+	c.Assert(t.quantile(0.99), Equals, 42.0)
+}
@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+// windowColumnName returns the name of the column holding the argument of
+// the window function in the i-th column of a query, e.g. mean(value) in
+// moving_average(mean(value), 5).
+func windowColumnName(i int) string {
+	return fmt.Sprintf("_window_%d", i)
+}
+
+// HasWindowFunctions returns true if a column of the query is a window
+// function.
+func HasWindowFunctions(query *parser.SelectQuery) bool {
+	for _, value := range query.GetColumnNames() {
+		if value.IsFunctionCall() && registeredWindowFunctions[strings.ToLower(value.Name)] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+type windowColumn struct {
+	name     string // the name of the column in the output
+	argument string // the column holding the function's argument
+	replace  bool   // the window column replaces the argument column
+	function WindowFunction
+}
+
+// WindowEngine computes the window functions of a query over the output of
+// the query without them, which is returned by NewWindowEngine. Aggregate
+// and expression arguments are computed by the inner query in a column of
+// their own which is replaced by the window function, column arguments are
+// kept and the window function is appended.
+//
+// The state of each function is kept for each series and group across
+// buckets. Functions see the rows from the oldest to the newest, so the
+// series of descending queries are buffered until the engine is closed.
+type WindowEngine struct {
+	next      Processor
+	ascending bool
+	columns   []*windowColumn
+	elems     []string                 // the group by columns other than time()
+	states    map[string][]interface{} // the state of each column by series and group
+	series    []*protocol.Series       // the series of a descending query
+}
+
+// NewWindowEngine returns the engine for the window functions of a query
+// and the query that has to be run for it. The limit of the query is
+// applied after the window functions.
+func NewWindowEngine(query *parser.SelectQuery, next Processor) (*WindowEngine, *parser.SelectQuery, error) {
+	inner := *query
+	inner.ColumnNames = nil
+	inner.Limit = 0
+
+	e := &WindowEngine{
+		next:      next,
+		ascending: query.Ascending,
+		states:    map[string][]interface{}{},
+	}
+
+	for i, value := range query.GetColumnNames() {
+		initializer := registeredWindowFunctions[strings.ToLower(value.Name)]
+		if !value.IsFunctionCall() || initializer == nil {
+			inner.ColumnNames = append(inner.ColumnNames, value)
+			continue
+		}
+
+		function, err := initializer(value)
+		if err != nil {
+			return nil, nil, parser.NewQueryError(parser.InvalidArgument, err.Error())
+		}
+		column := &windowColumn{name: value.Name, function: function}
+		if value.Alias != "" {
+			column.name = value.Alias
+		}
+
+		argument := value.Elems[0]
+		switch argument.Type {
+		case parser.ValueSimpleName:
+			column.argument = argument.Name
+		case parser.ValueFunctionCall, parser.ValueExpression:
+			if argument.IsFunctionCall() && registeredWindowFunctions[strings.ToLower(argument.Name)] != nil {
+				return nil, nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("function %s() doesn't work with window functions", value.Name))
+			}
+			aliased := *argument
+			aliased.Alias = windowColumnName(i)
+			argument = &aliased
+			column.argument, column.replace = aliased.Alias, true
+		default:
+			return nil, nil, parser.NewQueryError(parser.InvalidArgument, fmt.Sprintf("function %s() requires a column, an expression or an aggregate as the first argument", value.Name))
+		}
+		inner.ColumnNames = append(inner.ColumnNames, argument)
+		e.columns = append(e.columns, column)
+	}
+
+	// Aggregated series have a row for each group of each bucket.
+	if groupBy := inner.GetGroupByClause(); groupBy != nil && inner.HasAggregates() {
+		for _, elem := range groupBy.Elems {
+			if !elem.IsFunctionCall() {
+				e.elems = append(e.elems, elem.Name)
+			}
+		}
+	}
+
+	return e, &inner, nil
+}
+
+func (self *WindowEngine) Yield(s *protocol.Series) (bool, error) {
+	if !self.ascending {
+		self.series = append(self.series, s)
+		return true, nil
+	}
+
+	s, err := self.window(s, false)
+	if err != nil {
+		return false, err
+	}
+	return self.next.Yield(s)
+}
+
+// window returns a copy of a series with the window columns. The points of
+// a descending series are read in reverse.
+func (self *WindowEngine) window(s *protocol.Series, reverse bool) (*protocol.Series, error) {
+	fields := append([]string(nil), s.Fields...)
+	arguments := make([]int, len(self.columns))
+	outputs := make([]int, len(self.columns))
+	for i, column := range self.columns {
+		arguments[i] = fieldIndex(s.Fields, column.argument)
+		if column.replace && arguments[i] >= 0 {
+			outputs[i] = arguments[i]
+			fields[outputs[i]] = column.name
+			continue
+		}
+		outputs[i] = len(fields)
+		fields = append(fields, column.name)
+	}
+
+	var groups []int
+	for _, elem := range self.elems {
+		if idx := fieldIndex(s.Fields, elem); idx >= 0 {
+			groups = append(groups, idx)
+		}
+	}
+
+	points := make([]*protocol.Point, len(s.Points))
+	for k := range s.Points {
+		idx := k
+		if reverse {
+			idx = len(s.Points) - 1 - k
+		}
+		point := s.Points[idx]
+
+		group := make([]*protocol.FieldValue, len(groups))
+		for i, g := range groups {
+			group[i] = point.Values[g]
+		}
+		key := s.GetName() + fmt.Sprint(group)
+		states := self.states[key]
+		if states == nil {
+			states = make([]interface{}, len(self.columns))
+			self.states[key] = states
+		}
+
+		values := make([]*protocol.FieldValue, len(fields))
+		copy(values, point.Values)
+		for i, column := range self.columns {
+			var argument *protocol.FieldValue
+			if arguments[i] >= 0 {
+				argument = point.Values[arguments[i]]
+			}
+			value, state, err := column.function.Next(states[i], argument)
+			if err != nil {
+				return nil, err
+			}
+			states[i] = state
+			values[outputs[i]] = value
+		}
+
+		points[idx] = &protocol.Point{
+			Timestamp:      point.Timestamp,
+			SequenceNumber: point.SequenceNumber,
+			Values:         values,
+		}
+	}
+
+	return &protocol.Series{Name: s.Name, Fields: fields, Points: points}, nil
+}
+
+// fieldIndex returns the index of a field or -1 if it is missing.
+func fieldIndex(fields []string, name string) int {
+	for i, field := range fields {
+		if field == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (self *WindowEngine) Close() error {
+	// Compute the buffered series from the oldest to the newest point and
+	// yield them in their original order.
+	if !self.ascending {
+		series := make([]*protocol.Series, len(self.series))
+		for i := len(self.series) - 1; i >= 0; i-- {
+			s, err := self.window(self.series[i], true)
+			if err != nil {
+				return err
+			}
+			series[i] = s
+		}
+
+		for _, s := range series {
+			if ok, err := self.next.Yield(s); err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+		}
+	}
+	return self.next.Close()
+}
+
+func (self *WindowEngine) Name() string {
+	return "WindowEngine"
+}
+
+func (self *WindowEngine) Next() Processor {
+	return self.next
+}
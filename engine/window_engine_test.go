@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+	. "launchpad.net/gocheck"
+)
+
+type WindowSuite struct{}
+
+var _ = Suite(&WindowSuite{})
+
+// runWindowQuery runs a query over a point of host a or b every minute and
+// returns the recorded fields and values.
+func runWindowQuery(c *C, query string, points [][]interface{}) ([]string, [][]interface{}) {
+	q, err := parser.ParseSelectQuery(query)
+	c.Assert(err, IsNil)
+	r := &seriesRecorder{}
+	e, err := NewQueryEngine(r, q, nil)
+	c.Assert(err, IsNil)
+
+	// Yield the points in the order of the query, a series at a time.
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano() / 1000
+	for i := range points {
+		minute := i
+		if !q.Ascending {
+			minute = len(points) - 1 - i
+		}
+		p := &protocol.Point{Values: []*protocol.FieldValue{nil, {StringValue: protocol.String(points[minute][1].(string))}}}
+		switch v := points[minute][0].(type) {
+		case int:
+			p.Values[0] = &protocol.FieldValue{Int64Value: protocol.Int64(int64(v))}
+		case float64:
+			p.Values[0] = &protocol.FieldValue{DoubleValue: protocol.Float64(v)}
+		}
+		p.SetTimestampInMicroseconds(start + int64(minute)*60*1000*1000)
+		_, err := e.Yield(&protocol.Series{Name: protocol.String("cpu"), Fields: []string{"value", "host"}, Points: []*protocol.Point{p}})
+		c.Assert(err, IsNil)
+	}
+	c.Assert(e.Close(), IsNil)
+
+	var fields []string
+	if len(r.series) > 0 {
+		fields = r.series[0].Fields
+	}
+	return fields, r.values()
+}
+
+// Ensure window functions are computed from each value and the state of
+// the values before it.
+func (self *WindowSuite) TestFunctions(c *C) {
+	values := []*protocol.FieldValue{
+		{Int64Value: protocol.Int64(1)},
+		{Int64Value: protocol.Int64(3)},
+		nil,
+		{DoubleValue: protocol.Float64(5)},
+		{StringValue: protocol.String("a")},
+		{Int64Value: protocol.Int64(7)},
+	}
+
+	for query, expected := range map[string][]interface{}{
+		"select moving_average(value, 2) from cpu": {nil, 2.0, nil, 4.0, nil, 6.0},
+		"select moving_average(value, 1) from cpu": {1.0, 3.0, nil, 5.0, nil, 7.0},
+		"select cumulative_sum(value) from cpu":    {int64(1), int64(4), nil, 9.0, nil, 16.0},
+		"select ewma(value, 0.5) from cpu":         {1.0, 2.0, nil, 3.5, nil, 5.25},
+		"select ewma(value, 1) from cpu":           {1.0, 3.0, nil, 5.0, nil, 7.0},
+	} {
+		q, err := parser.ParseSelectQuery(query)
+		c.Assert(err, IsNil)
+		value := q.GetColumnNames()[0]
+		function, err := registeredWindowFunctions[value.Name](value)
+		c.Assert(err, IsNil)
+
+		var state interface{}
+		var actual []interface{}
+		for _, v := range values {
+			var result *protocol.FieldValue
+			result, state, err = function.Next(state, v)
+			c.Assert(err, IsNil)
+			if result == nil {
+				actual = append(actual, nil)
+				continue
+			}
+			r, _ := result.GetValue()
+			actual = append(actual, r)
+		}
+		c.Assert(actual, DeepEquals, expected, Commentf(query))
+	}
+}
+
+// Ensure invalid arguments are rejected.
+func (self *WindowSuite) TestInvalidArguments(c *C) {
+	for _, query := range []string{
+		"select moving_average(value, 0) from cpu",
+		"select moving_average(value, 1.5) from cpu",
+		"select moving_average(value, 'a') from cpu",
+		"select ewma(value, 0) from cpu",
+		"select ewma(value, 1.5) from cpu",
+		"select ewma(value, 'a') from cpu",
+		"select cumulative_sum(ewma(value, 0.5)) from cpu",
+	} {
+		q, err := parser.ParseSelectQuery(query)
+		c.Assert(err, IsNil)
+		_, err = NewQueryEngine(&seriesRecorder{}, q, nil)
+		c.Assert(err, NotNil, Commentf(query))
+	}
+}
+
+// Ensure window functions of raw values are appended to the columns of
+// each point.
+func (self *WindowSuite) TestRawValues(c *C) {
+	points := [][]interface{}{{1, "a"}, {2, "a"}, {3.5, "a"}, {4, "a"}}
+
+	fields, values := runWindowQuery(c, "select value, cumulative_sum(value) from cpu order asc", points)
+	c.Assert(fields, DeepEquals, []string{"value", "host", "cumulative_sum"})
+	c.Assert(values, DeepEquals, [][]interface{}{
+		{int64(1), "a", int64(1)},
+		{int64(2), "a", int64(3)},
+		{3.5, "a", 6.5},
+		{int64(4), "a", 10.5},
+	})
+
+	// Expressions are replaced by the window function.
+	fields, values = runWindowQuery(c, "select moving_average(value * 2, 2) as m from cpu order asc", points)
+	c.Assert(fields, DeepEquals, []string{"m"})
+	c.Assert(values, DeepEquals, [][]interface{}{{nil}, {3.0}, {5.5}, {7.5}})
+}
+
+// Ensure window functions of descending queries are computed from the
+// oldest to the newest point and the limit is applied to their output.
+func (self *WindowSuite) TestDescending(c *C) {
+	points := [][]interface{}{{1, "a"}, {2, "a"}, {3, "a"}, {4, "a"}}
+
+	_, values := runWindowQuery(c, "select cumulative_sum(value) from cpu", points)
+	c.Assert(values, DeepEquals, [][]interface{}{
+		{int64(4), "a", int64(10)},
+		{int64(3), "a", int64(6)},
+		{int64(2), "a", int64(3)},
+		{int64(1), "a", int64(1)},
+	})
+
+	_, values = runWindowQuery(c, "select cumulative_sum(value) from cpu limit 2", points)
+	c.Assert(values, DeepEquals, [][]interface{}{{int64(4), "a", int64(10)}, {int64(3), "a", int64(6)}})
+}
+
+// Ensure window functions of aggregates are computed over the buckets of
+// each group and replace the aggregate.
+func (self *WindowSuite) TestAggregates(c *C) {
+	points := [][]interface{}{{1, "a"}, {10, "b"}, {3, "a"}, {20, "b"}, {5, "a"}, {60, "b"}}
+
+	fields, values := runWindowQuery(c, "select moving_average(max(value), 2) from cpu group by time(2m) order asc", points)
+	c.Assert(fields, DeepEquals, []string{"moving_average"})
+	c.Assert(values, DeepEquals, [][]interface{}{{nil}, {15.0}, {40.0}})
+
+	fields, values = runWindowQuery(c, "select cumulative_sum(sum(value)) as total, host from cpu group by time(2m), host order asc", points)
+	c.Assert(fields, DeepEquals, []string{"total", "host"})
+	c.Assert(values, DeepEquals, [][]interface{}{
+		{1.0, "a"}, {10.0, "b"},
+		{4.0, "a"}, {30.0, "b"},
+		{9.0, "a"}, {90.0, "b"},
+	})
+
+	// Descending buckets are computed in ascending order.
+	_, values = runWindowQuery(c, "select cumulative_sum(count(value)), host from cpu group by time(2m), host", points)
+	c.Assert(values, DeepEquals, [][]interface{}{
+		{int64(3), "a"}, {int64(3), "b"},
+		{int64(2), "a"}, {int64(2), "b"},
+		{int64(1), "a"}, {int64(1), "b"},
+	})
+}
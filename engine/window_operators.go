@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/influxdb/influxdb/parser"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+// WindowFunction computes a value for each row of the ordered output of a
+// query from the rows before it, e.g. a moving average. The first argument
+// of a window function is a column or an aggregate, the state of the
+// previous rows is kept for each series and group by the WindowEngine.
+type WindowFunction interface {
+	// Next returns the function's value for a row given the value of its
+	// argument and the state of the previous rows, and the new state.
+	Next(state interface{}, value *protocol.FieldValue) (*protocol.FieldValue, interface{}, error)
+}
+
+// Initialize a new window function given its function call.
+type WindowFunctionInitializer func(*parser.Value) (WindowFunction, error)
+
+var registeredWindowFunctions = make(map[string]WindowFunctionInitializer)
+
+func init() {
+	registeredWindowFunctions["moving_average"] = NewMovingAverageFunction
+	registeredWindowFunctions["cumulative_sum"] = NewCumulativeSumFunction
+	registeredWindowFunctions["ewma"] = NewExponentialMovingAverageFunction
+}
+
+// The minimum and maximum number of arguments of window functions.
+var windowFunctionArgs = map[string][2]int{
+	"moving_average": {2, 2},
+	"cumulative_sum": {1, 1},
+	"ewma":           {2, 2},
+}
+
+// windowFloat returns the value of a number, values that aren't numbers
+// are ignored.
+func windowFloat(value *protocol.FieldValue) (float64, bool) {
+	if value == nil {
+		return 0, false
+	} else if value.Int64Value != nil {
+		return float64(*value.Int64Value), true
+	} else if value.DoubleValue != nil {
+		return *value.DoubleValue, true
+	}
+	return 0, false
+}
+
+//
+// Moving Average
+//
+
+type MovingAverageState struct {
+	values []float64 // the last n values, values[next] is the oldest once full
+	next   int
+	sum    float64
+}
+
+// MovingAverageFunction returns the mean of the last n values. Rows before
+// the window is full are null.
+type MovingAverageFunction struct {
+	n int
+}
+
+func (self *MovingAverageFunction) Next(state interface{}, value *protocol.FieldValue) (*protocol.FieldValue, interface{}, error) {
+	s, ok := state.(*MovingAverageState)
+	if !ok {
+		s = &MovingAverageState{values: make([]float64, 0, self.n)}
+	}
+
+	v, ok := windowFloat(value)
+	if !ok {
+		return nil, s, nil
+	}
+
+	if len(s.values) < self.n {
+		s.values = append(s.values, v)
+		s.sum += v
+	} else {
+		s.sum += v - s.values[s.next]
+		s.values[s.next] = v
+		s.next = (s.next + 1) % self.n
+	}
+
+	if len(s.values) < self.n {
+		return nil, s, nil
+	}
+	mean := s.sum / float64(self.n)
+	return &protocol.FieldValue{DoubleValue: &mean}, s, nil
+}
+
+func NewMovingAverageFunction(value *parser.Value) (WindowFunction, error) {
+	if len(value.Elems) != 2 {
+		return nil, fmt.Errorf("function moving_average() requires exactly two arguments")
+	}
+	if value.Elems[1].Type != parser.ValueInt {
+		return nil, fmt.Errorf("function moving_average() requires the window size to be an integer")
+	}
+	n, err := strconv.Atoi(value.Elems[1].Name)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("function moving_average() requires the window size to be a positive integer")
+	}
+	return &MovingAverageFunction{n: n}, nil
+}
+
+//
+// Cumulative Sum
+//
+
+// CumulativeSumFunction returns the sum of all values so far. The sum of
+// integers is an integer.
+type CumulativeSumFunction struct{}
+
+func (self *CumulativeSumFunction) Next(state interface{}, value *protocol.FieldValue) (*protocol.FieldValue, interface{}, error) {
+	if value == nil || (value.Int64Value == nil && value.DoubleValue == nil) {
+		return nil, state, nil
+	}
+
+	switch sum := state.(type) {
+	case nil:
+		if value.Int64Value != nil {
+			v := *value.Int64Value
+			return &protocol.FieldValue{Int64Value: &v}, v, nil
+		}
+		v := *value.DoubleValue
+		return &protocol.FieldValue{DoubleValue: &v}, v, nil
+	case int64:
+		if value.Int64Value != nil {
+			v := sum + *value.Int64Value
+			return &protocol.FieldValue{Int64Value: &v}, v, nil
+		}
+		v := float64(sum) + *value.DoubleValue
+		return &protocol.FieldValue{DoubleValue: &v}, v, nil
+	default:
+		f, _ := windowFloat(value)
+		v := sum.(float64) + f
+		return &protocol.FieldValue{DoubleValue: &v}, v, nil
+	}
+}
+
+func NewCumulativeSumFunction(value *parser.Value) (WindowFunction, error) {
+	if len(value.Elems) != 1 {
+		return nil, fmt.Errorf("function cumulative_sum() requires exactly one argument")
+	}
+	return &CumulativeSumFunction{}, nil
+}
+
+//
+// Exponentially Weighted Moving Average
+//
+
+// ExponentialMovingAverageFunction returns alpha * value + (1 - alpha) *
+// the previous average. The first average is the first value.
+type ExponentialMovingAverageFunction struct {
+	alpha float64
+}
+
+func (self *ExponentialMovingAverageFunction) Next(state interface{}, value *protocol.FieldValue) (*protocol.FieldValue, interface{}, error) {
+	v, ok := windowFloat(value)
+	if !ok {
+		return nil, state, nil
+	}
+
+	if previous, ok := state.(float64); ok {
+		v = self.alpha*v + (1-self.alpha)*previous
+	}
+	return &protocol.FieldValue{DoubleValue: &v}, v, nil
+}
+
+func NewExponentialMovingAverageFunction(value *parser.Value) (WindowFunction, error) {
+	if len(value.Elems) != 2 {
+		return nil, fmt.Errorf("function ewma() requires exactly two arguments")
+	}
+	if value.Elems[1].Type != parser.ValueInt && value.Elems[1].Type != parser.ValueFloat {
+		return nil, fmt.Errorf("function ewma() requires the smoothing factor to be a number")
+	}
+	alpha, err := strconv.ParseFloat(value.Elems[1].Name, 64)
+	if err != nil || alpha <= 0 || alpha > 1 {
+		return nil, fmt.Errorf("function ewma() requires the smoothing factor to be greater than 0 and at most 1")
+	}
+	return &ExponentialMovingAverageFunction{alpha: alpha}, nil
+}
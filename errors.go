@@ -54,6 +54,23 @@ var (
 	// ErrShardNotFound is returned writing to a non-existent shard.
 	ErrShardNotFound = errors.New("shard not found")
 
+	// ErrShardStoreNotFound is returned when using an unregistered shard store.
+	ErrShardStoreNotFound = errors.New("shard store not found")
+
+	// ErrSeriesNotFound is returned when looking up a non-existent series.
+	ErrSeriesNotFound = errors.New("series not found")
+
+	// ErrContinuousQueryNotFound is returned when dropping a non-existent continuous query.
+	ErrContinuousQueryNotFound = errors.New("continuous query not found")
+
+	// ErrInvalidContinuousQuery is returned when creating a continuous query
+	// without a target series or a GROUP BY time interval.
+	ErrInvalidContinuousQuery = errors.New("invalid continuous query")
+
+	// ErrContinuousQueryLeaseHeld is returned when requesting the continuous
+	// query lease while another server holds it.
+	ErrContinuousQueryLeaseHeld = errors.New("continuous query lease held")
+
 	// ErrReadAccessDenied is returned when a user attempts to read
 	// data that he or she does not have permission to read.
 	ErrReadAccessDenied = errors.New("read access denied")
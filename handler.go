@@ -3,14 +3,17 @@ package influxdb
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"sort"
+	"strings"
 
 	"code.google.com/p/log4go"
 	"github.com/bmizerany/pat"
 	"github.com/influxdb/influxdb/engine"
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/parser"
 	"github.com/influxdb/influxdb/protocol"
 )
@@ -22,20 +25,31 @@ import (
 
 // Handler represents an HTTP handler for the InfluxDB server.
 type Handler struct {
-	server *Server
-	mux    *pat.PatternServeMux
+	server   *Server
+	mux      *pat.PatternServeMux
+	analyzer *influxql.Analyzer
 
 	// The InfluxDB verion returned by the HTTP response header.
 	Version string
+
+	// Parses queries with the influxql package instead of the cgo grammar
+	// in the parser package. Used to compare the two query paths until
+	// InfluxQL supports every query of the grammar. Queries are always
+	// parsed with InfluxQL if the server is built without the grammar.
+	InfluxQL bool
 }
 
 // NewHandler returns a new instance of Handler.
 func NewHandler(s *Server) *Handler {
 	h := &Handler{
-		server: s,
-		mux:    pat.New(),
+		server:   s,
+		mux:      pat.New(),
+		analyzer: influxql.NewAnalyzer(engine.Functions()),
 	}
 
+	// Query routes.
+	h.mux.Get("/query", http.HandlerFunc(h.serveQuery))
+
 	// Series routes.
 	h.mux.Get("/db/:db/series", http.HandlerFunc(h.serveQuery))
 	h.mux.Post("/db/:db/series", http.HandlerFunc(h.serveWriteSeries))
@@ -101,21 +115,57 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // serveQuery parses an incoming query and returns the results.
 func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request) {
-	// TODO: Authentication.
+	// TODO: Authentication of database users.
 
-	// Parse query from query string.
+	// The database is part of the route for series queries. Queries sent to
+	// the server-wide endpoint pass it as a parameter, if at all.
 	values := r.URL.Query()
-	queries, err := parser.ParseQuery(values.Get("q"))
-	if err != nil {
-		h.error(w, "parse error: "+err.Error(), http.StatusBadRequest)
-		return
+	name := values.Get(":db")
+	if name == "" {
+		name = values.Get("db")
 	}
 
-	// Retrieve database from server.
-	db := h.server.Database(values.Get(":db"))
-	if db == nil {
-		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
-		return
+	// Parse query from query string.
+	var execute func(engine.Processor) error
+	if !h.InfluxQL && parser.Available {
+		queries, err := parser.ParseQuery(values.Get("q"))
+		if err != nil {
+			h.error(w, "parse error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Retrieve database from server.
+		db := h.server.Database(name)
+		if db == nil {
+			h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		execute = func(p engine.Processor) error {
+			for _, q := range queries {
+				if err := db.ExecuteQuery(nil, q, p); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	} else {
+		q, err := h.parseQuery(values.Get("q"))
+		if err != nil {
+			h.error(w, "parse error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Check the credentials of statements managing the cluster or
+		// removing or writing data.
+		if err := h.authorize(r, q, name); err != nil {
+			h.authError(w, err)
+			return
+		}
+
+		execute = func(p engine.Processor) error {
+			return h.server.ExecuteQuery(q, name, p)
+		}
 	}
 
 	// Parse the time precision from the query params.
@@ -133,12 +183,13 @@ func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request) {
 		p = &pointsWriterProcessor{make(map[string]*protocol.Series), w, precision, (values.Get("pretty") == "true")}
 	}
 
-	// Execute query against the database.
-	for _, q := range queries {
-		if err := db.ExecuteQuery(nil, q, p); err != nil {
-			h.error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// Execute query.
+	if err := execute(p); err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	// Mark processor as complete. Print error, if applicable.
@@ -147,6 +198,153 @@ func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parseQuery parses an InfluxQL query string and validates it before any
+// shards are queried.
+func (h *Handler) parseQuery(s string) (influxql.Query, error) {
+	p := influxql.NewParser(strings.NewReader(s))
+	q, err := p.ParseQuery()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.analyzer.Analyze(q, p.Positions()); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// isAdminQuery returns true if the query manages databases, users or shard
+// spaces.
+func isAdminQuery(q influxql.Query) bool {
+	switch q.(type) {
+	case *influxql.CreateDatabaseQuery, *influxql.DropDatabaseQuery,
+		*influxql.GrantQuery, *influxql.RevokeQuery,
+		*influxql.CreateUserQuery, *influxql.SetPasswordQuery, *influxql.DropUserQuery,
+		*influxql.CreateShardSpaceQuery, *influxql.AlterShardSpaceQuery, *influxql.DropShardSpaceQuery:
+		return true
+	}
+	return false
+}
+
+// authorize returns an error if the credentials of the request don't allow
+// the query to be run against the database. Statements managing databases,
+// users and shard spaces can only be run by cluster admins. Statements
+// removing data or writing the results of a selection into a series can also
+// be run by the database's admins and by users with write access to every
+// series they change. Other statements don't require credentials.
+func (h *Handler) authorize(r *http.Request, q influxql.Query, database string) error {
+	// Find the series changed by the statement. Statements which don't name
+	// the series they change, such as deletions from a regex, are limited to
+	// admins.
+	var names []string
+	switch q := q.(type) {
+	case *influxql.DropSeriesQuery, *influxql.DropContinuousQueryQuery:
+	case *influxql.DeleteQuery:
+		names = seriesNames(q.Source)
+	case *influxql.SelectQuery:
+		if q.Target == "" {
+			return nil
+		}
+		names = []string{q.Target}
+	default:
+		if !isAdminQuery(q) {
+			return nil
+		}
+	}
+
+	// Cluster admins can run every statement.
+	username, password := credentials(r)
+	if _, err := h.server.AuthenticateClusterAdmin(username, password); err == nil {
+		return nil
+	} else if isAdminQuery(q) {
+		return err
+	}
+
+	// Otherwise the user must be a user of the database.
+	var u *DBUser
+	if db := h.server.Database(database); db != nil {
+		u = db.User(username)
+	}
+	if u == nil || !u.isValidPwd(password) {
+		return NewAuthenticationError("invalid username or password")
+	} else if u.IsDBAdmin(database) {
+		return nil
+	} else if len(names) == 0 {
+		return NewAuthorizationError("user %q is not an admin of database %q", username, database)
+	}
+	for _, name := range names {
+		if !u.HasWriteAccess(name) {
+			return NewAuthorizationError("user %q has no write access to series %q", username, name)
+		}
+	}
+	return nil
+}
+
+// seriesNames returns the names of the series of a join. Returns nil if any
+// of the series is a regex.
+func seriesNames(j influxql.Join) []string {
+	var sources []*influxql.Series
+	switch j := j.(type) {
+	case *influxql.ImplicitJoin:
+		sources = j.Sources
+	case *influxql.MergeJoin:
+		sources = j.Sources
+	case *influxql.InnerJoin:
+		sources = []*influxql.Series{j.LHS, j.RHS}
+	}
+
+	var names []string
+	for _, s := range sources {
+		if s.Regex != nil {
+			return nil
+		}
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// authenticateClusterAdmin returns the cluster admin sending the request.
+// Writes an error to the response and returns nil if the request isn't sent
+// by a cluster admin.
+func (h *Handler) authenticateClusterAdmin(w http.ResponseWriter, r *http.Request) *ClusterAdmin {
+	u, err := h.server.AuthenticateClusterAdmin(credentials(r))
+	if err != nil {
+		h.authError(w, err)
+		return nil
+	}
+	return u
+}
+
+// authError writes an authentication or authorization error to the response.
+func (h *Handler) authError(w http.ResponseWriter, err error) {
+	if _, ok := err.(AuthorizationError); ok {
+		h.error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	w.Header().Add("WWW-Authenticate", `Basic realm="influxdb"`)
+	h.error(w, err.Error(), http.StatusUnauthorized)
+}
+
+// credentials returns the username and password of a request. They are
+// passed as the u and p query parameters or with basic authentication.
+func credentials(r *http.Request) (username, password string) {
+	if q := r.URL.Query(); q.Get("u") != "" {
+		return q.Get("u"), q.Get("p")
+	}
+
+	auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(auth) != 2 || auth[0] != "Basic" {
+		return "", ""
+	}
+	b, err := base64.StdEncoding.DecodeString(auth[1])
+	if err != nil {
+		return "", ""
+	}
+	if i := bytes.IndexByte(b, ':'); i >= 0 {
+		return string(b[:i]), string(b[i+1:])
+	}
+	return "", ""
+}
+
 // serveWriteSeries receives incoming series data and writes it to the database.
 func (h *Handler) serveWriteSeries(w http.ResponseWriter, r *http.Request) {
 	// TODO: Authentication.
@@ -201,7 +399,26 @@ func (h *Handler) serveWriteSeries(w http.ResponseWriter, r *http.Request) {
 }
 
 // serveDeleteSeries deletes a given series.
-func (h *Handler) serveDeleteSeries(w http.ResponseWriter, r *http.Request) {}
+func (h *Handler) serveDeleteSeries(w http.ResponseWriter, r *http.Request) {
+	// TODO: Authentication.
+
+	q := r.URL.Query()
+	db := h.server.Database(q.Get(":db"))
+	if db == nil {
+		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Drop the series and all of its data.
+	if err := db.DropSeries(q.Get(":series")); err == ErrSeriesNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
 // serveDatabases returns a list of all databases on the server.
 func (h *Handler) serveDatabases(w http.ResponseWriter, r *http.Request) {
@@ -254,19 +471,102 @@ func (h *Handler) serveDeleteDatabase(w http.ResponseWriter, r *http.Request) {
 }
 
 // serveAuthenticateClusterAdmin authenticates a user as a ClusterAdmin.
-func (h *Handler) serveAuthenticateClusterAdmin(w http.ResponseWriter, r *http.Request) {}
+func (h *Handler) serveAuthenticateClusterAdmin(w http.ResponseWriter, r *http.Request) {
+	_ = h.authenticateClusterAdmin(w, r)
+}
+
+// serveClusterAdmins returns the names of all cluster admins.
+func (h *Handler) serveClusterAdmins(w http.ResponseWriter, r *http.Request) {
+	if h.authenticateClusterAdmin(w, r) == nil {
+		return
+	}
+
+	// Only return the names. The password hashes stay on the server.
+	type clusterAdmin struct {
+		Name string `json:"name"`
+	}
+	admins := []*clusterAdmin{}
+	for _, u := range h.server.ClusterAdmins() {
+		admins = append(admins, &clusterAdmin{Name: u.Name})
+	}
 
-// serveClusterAdmins returns data about a single cluster admin.
-func (h *Handler) serveClusterAdmins(w http.ResponseWriter, r *http.Request) {}
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(admins)
+}
 
 // serveCreateClusterAdmin creates a new cluster admin.
-func (h *Handler) serveCreateClusterAdmin(w http.ResponseWriter, r *http.Request) {}
+func (h *Handler) serveCreateClusterAdmin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+
+	if h.authenticateClusterAdmin(w, r) == nil {
+		return
+	}
+
+	// Decode the request from the body.
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Create the cluster admin.
+	if err := h.server.CreateClusterAdmin(req.Name, req.Password); err == ErrClusterAdminExists {
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveUpdateClusterAdmin changes the password of an existing cluster admin.
+func (h *Handler) serveUpdateClusterAdmin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
 
-// serveUpdateClusterAdmin updates an existing cluster admin.
-func (h *Handler) serveUpdateClusterAdmin(w http.ResponseWriter, r *http.Request) {}
+	if h.authenticateClusterAdmin(w, r) == nil {
+		return
+	}
+
+	// Decode the request from the body.
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Change the password.
+	username := r.URL.Query().Get(":user")
+	if err := h.server.SetClusterAdminPassword(username, req.Password); err == ErrClusterAdminNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
 // serveDeleteClusterAdmin removes an existing cluster admin.
-func (h *Handler) serveDeleteClusterAdmin(w http.ResponseWriter, r *http.Request) {}
+func (h *Handler) serveDeleteClusterAdmin(w http.ResponseWriter, r *http.Request) {
+	if h.authenticateClusterAdmin(w, r) == nil {
+		return
+	}
+
+	// Delete the cluster admin.
+	username := r.URL.Query().Get(":user")
+	if err := h.server.DeleteClusterAdmin(username); err == ErrClusterAdminNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
 // serveAuthenticateDBUser authenticates a user as a database user.
 func (h *Handler) serveAuthenticateDBUser(w http.ResponseWriter, r *http.Request) {}
@@ -0,0 +1,158 @@
+package influxdb_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure administrative statements require cluster admin credentials.
+func TestHandler_Query_ClusterAdminRequired(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.CreateClusterAdmin("susy", "pass"); err != nil {
+		t.Fatal(err)
+	}
+	h := influxdb.NewHandler(s.Server)
+	h.InfluxQL = true
+
+	// Statements without credentials or with invalid ones are rejected.
+	for _, params := range []string{"", "&u=susy&p=wrong", "&u=bob&p=pass"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, MustNewRequest("GET", "/query?q="+url.QueryEscape("CREATE DATABASE foo")+params, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("unexpected status for %q: %d", params, w.Code)
+		} else if s.Database("foo") != nil {
+			t.Fatalf("unexpected database for %q", params)
+		}
+	}
+
+	// Cluster admins can pass their credentials as parameters.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("GET", "/query?q="+url.QueryEscape("CREATE DATABASE foo")+"&u=susy&p=pass", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", w.Code, w.Body)
+	} else if s.Database("foo") == nil {
+		t.Fatal("database not created")
+	}
+
+	// Or with basic authentication.
+	r := MustNewRequest("GET", "/query?q="+url.QueryEscape("DROP DATABASE foo"), nil)
+	r.SetBasicAuth("susy", "pass")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", w.Code, w.Body)
+	} else if s.Database("foo") != nil {
+		t.Fatal("database not dropped")
+	}
+}
+
+// Ensure statements removing or writing data require write access.
+func TestHandler_Query_WriteAccessRequired(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateClusterAdmin("root", "pass")
+	s.CreateDatabase("foo")
+	s.Database("foo").CreateUser("susy", "pass", []string{".*", "cpu"})
+	h := influxdb.NewHandler(s.Server)
+	h.InfluxQL = true
+
+	for i, tt := range []struct {
+		q      string
+		params string
+		code   int
+	}{
+		// Anonymous users are rejected.
+		{q: `DELETE FROM cpu`, code: http.StatusUnauthorized},
+		{q: `DROP SERIES cpu`, code: http.StatusUnauthorized},
+		{q: `DROP CONTINUOUS QUERY 1`, code: http.StatusUnauthorized},
+		{q: `SELECT count(value) FROM cpu GROUP BY time(1h) INTO cpu.hourly`, code: http.StatusUnauthorized},
+		{q: `DELETE FROM cpu`, params: "&u=susy&p=wrong", code: http.StatusUnauthorized},
+
+		// Users can only change the series they have write access to.
+		{q: `DELETE FROM cpu`, params: "&u=susy&p=pass", code: http.StatusOK},
+		{q: `DELETE FROM mem`, params: "&u=susy&p=pass", code: http.StatusForbidden},
+		{q: `DELETE FROM /.*/`, params: "&u=susy&p=pass", code: http.StatusForbidden},
+		{q: `SELECT count(value) FROM mem GROUP BY time(1h) INTO mem.hourly`, params: "&u=susy&p=pass", code: http.StatusForbidden},
+
+		// Removing series and continuous queries requires an admin.
+		{q: `DROP SERIES cpu`, params: "&u=susy&p=pass", code: http.StatusForbidden},
+		{q: `DROP CONTINUOUS QUERY 1`, params: "&u=susy&p=pass", code: http.StatusForbidden},
+		{q: `DELETE FROM cpu`, params: "&u=root&p=pass", code: http.StatusOK},
+
+		// Selections without a target don't require credentials.
+		{q: `SELECT value FROM cpu`, code: http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, MustNewRequest("GET", "/query?db=foo&q="+url.QueryEscape(tt.q)+tt.params, nil))
+		if w.Code != tt.code {
+			t.Errorf("%d. %s: unexpected status: %d: %s", i, tt.q, w.Code, w.Body)
+		}
+	}
+}
+
+// Ensure cluster admins can be managed through the HTTP API.
+func TestHandler_ClusterAdmins(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateClusterAdmin("root", "pass")
+	h := influxdb.NewHandler(s.Server)
+
+	// Requests without cluster admin credentials are rejected.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("POST", "/cluster_admins", strings.NewReader(`{"name":"susy","password":"pass"}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", w.Code)
+	} else if s.ClusterAdmin("susy") != nil {
+		t.Fatal("unexpected admin")
+	}
+
+	// Create an admin.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("POST", "/cluster_admins?u=root&p=pass", strings.NewReader(`{"name":"susy","password":"pass"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status: %d: %s", w.Code, w.Body)
+	}
+
+	// Change the new admin's password.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("POST", "/cluster_admins/susy?u=root&p=pass", strings.NewReader(`{"password":"newpass"}`)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", w.Code, w.Body)
+	} else if _, err := s.AuthenticateClusterAdmin("susy", "newpass"); err != nil {
+		t.Fatal(err)
+	}
+
+	// List the admins.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("GET", "/cluster_admins?u=susy&p=newpass", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", w.Code, w.Body)
+	} else if body := strings.TrimSpace(w.Body.String()); body != `[{"name":"root"},{"name":"susy"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Delete the admin.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("DELETE", "/cluster_admins/susy?u=root&p=pass", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", w.Code, w.Body)
+	} else if s.ClusterAdmin("susy") != nil {
+		t.Fatal("admin not deleted")
+	}
+}
+
+// MustNewRequest returns a new HTTP request. Panic on error.
+func MustNewRequest(method, urlStr string, body io.Reader) *http.Request {
+	r, err := http.NewRequest(method, urlStr, body)
+	if err != nil {
+		panic(err.Error())
+	}
+	return r
+}
@@ -0,0 +1,272 @@
+package influxql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Function describes a function that can be called from a query.
+type Function struct {
+	Name string
+
+	// Minimum and maximum number of arguments accepted.
+	// A negative MaxArgs allows any number of arguments.
+	MinArgs int
+	MaxArgs int
+
+	// Set if the function aggregates points into a single value.
+	Aggregate bool
+}
+
+// Analyzer validates the semantics of parsed queries.
+type Analyzer struct {
+	// Functions that can be used in fields, keyed by lowercase name.
+	Functions map[string]*Function
+}
+
+// NewAnalyzer returns a new instance of Analyzer with a set of functions.
+func NewAnalyzer(functions []*Function) *Analyzer {
+	a := &Analyzer{Functions: make(map[string]*Function)}
+	for _, fn := range functions {
+		a.Functions[strings.ToLower(fn.Name)] = fn
+	}
+	return a
+}
+
+// Analyze validates a query. Positions are used to locate errors and may
+// be nil if the query was not read by a parser.
+func (a *Analyzer) Analyze(q Query, pos Positions) error {
+	v := &analyzer{Analyzer: a, pos: pos}
+	switch q := q.(type) {
+	case *SelectQuery:
+		return v.analyzeSelectQuery(q)
+	case *DeleteQuery:
+		return v.analyzeCondition(q.Condition)
+	default:
+		return nil
+	}
+}
+
+// analyzer holds the state for a single call to Analyze.
+type analyzer struct {
+	*Analyzer
+	pos Positions
+}
+
+// analyzeSelectQuery validates the fields, dimensions and condition.
+func (a *analyzer) analyzeSelectQuery(q *SelectQuery) error {
+	// Validate each field and track whether aggregate and raw fields are mixed.
+	var aggregate, raw Node
+	for _, f := range q.Fields {
+		if err := a.analyzeFieldExpr(f.Expr); err != nil {
+			return err
+		}
+
+		if aggregate == nil && a.isAggregate(f.Expr) {
+			aggregate = f
+		}
+		if raw == nil && a.isRaw(f.Expr) {
+			raw = f
+		}
+	}
+	if aggregate != nil && raw != nil && len(q.Dimensions) == 0 {
+		return a.error(raw, "mixing aggregate and non-aggregate fields requires GROUP BY")
+	}
+
+	// The having condition filters the aggregated rows by their fields,
+	// aliases or aggregates.
+	if q.Having != nil {
+		if aggregate == nil {
+			return a.error(q.Having, "HAVING requires aggregate fields")
+		} else if err := a.analyzeFieldExpr(q.Having); err != nil {
+			return err
+		}
+	}
+
+	// Validate dimensions.
+	for _, d := range q.Dimensions {
+		if err := a.analyzeDimension(d); err != nil {
+			return err
+		}
+	}
+
+	// Continuous queries are run once per interval so they must group by time.
+	if q.Target != "" && q.GroupByInterval() == 0 {
+		return a.error(q, "continuous query requires a GROUP BY time interval")
+	}
+
+	return a.analyzeCondition(q.Condition)
+}
+
+// analyzeFieldExpr validates the function calls within a field expression.
+func (a *analyzer) analyzeFieldExpr(expr Expr) error {
+	switch expr := expr.(type) {
+	case *Call:
+		fn := a.Functions[strings.ToLower(expr.Name)]
+		if fn == nil {
+			return a.error(expr, "undefined function: %s()", expr.Name)
+		} else if len(expr.Args) < fn.MinArgs || (fn.MaxArgs >= 0 && len(expr.Args) > fn.MaxArgs) {
+			return a.error(expr, "invalid number of arguments for %s(), expected %s, got %d", expr.Name, arity(fn), len(expr.Args))
+		}
+
+		// Validate nested calls.
+		for _, arg := range expr.Args {
+			if err := a.analyzeFieldExpr(arg); err != nil {
+				return err
+			}
+		}
+
+	case *BinaryExpr:
+		if err := a.analyzeFieldExpr(expr.LHS); err != nil {
+			return err
+		}
+		return a.analyzeFieldExpr(expr.RHS)
+	}
+	return nil
+}
+
+// isAggregate returns true if the expression contains an aggregate call.
+func (a *analyzer) isAggregate(expr Expr) bool {
+	var found bool
+	WalkFunc(expr, func(n Node) {
+		if c, ok := n.(*Call); ok {
+			if fn := a.Functions[strings.ToLower(c.Name)]; fn != nil && fn.Aggregate {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// isRaw returns true if the expression references fields outside of an
+// aggregate call.
+func (a *analyzer) isRaw(expr Expr) bool {
+	switch expr := expr.(type) {
+	case *VarRef, *Wildcard:
+		return true
+	case *Call:
+		if fn := a.Functions[strings.ToLower(expr.Name)]; fn != nil && fn.Aggregate {
+			return false
+		}
+		for _, arg := range expr.Args {
+			if a.isRaw(arg) {
+				return true
+			}
+		}
+	case *BinaryExpr:
+		return a.isRaw(expr.LHS) || a.isRaw(expr.RHS)
+	}
+	return false
+}
+
+// analyzeDimension validates a single GROUP BY dimension.
+func (a *analyzer) analyzeDimension(d *Dimension) error {
+	switch expr := d.Expr.(type) {
+	case *VarRef, *DurationLiteral:
+		return nil
+	case *Call:
+		if strings.ToLower(expr.Name) != "time" {
+			return a.error(expr, "invalid dimension: %s()", expr.Name)
+		} else if len(expr.Args) != 1 {
+			return a.error(expr, "invalid number of arguments for time(), expected 1, got %d", len(expr.Args))
+		} else if _, ok := expr.Args[0].(*DurationLiteral); !ok {
+			return a.error(expr.Args[0], "time() dimension requires a duration, got %s", expr.Args[0])
+		}
+		return nil
+	default:
+		return a.error(d, "invalid dimension: %s", d.Expr)
+	}
+}
+
+// analyzeCondition validates the calls and time comparisons in a condition.
+func (a *analyzer) analyzeCondition(expr Expr) error {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		switch expr.Op {
+		case AND, OR:
+			if err := a.analyzeCondition(expr.LHS); err != nil {
+				return err
+			}
+			return a.analyzeCondition(expr.RHS)
+		case EQ, NE, LT, LE, GT, GE:
+			// The query planner only extracts time ranges from <, > and =.
+			if (isTimeRef(expr.LHS) || isTimeRef(expr.RHS)) && expr.Op != EQ && expr.Op != LT && expr.Op != GT {
+				return a.error(expr, "invalid operator in time comparison: %s, expected <, > or =", expr.Op)
+			}
+			if isTimeRef(expr.LHS) {
+				return a.analyzeTimeExpr(expr.RHS)
+			} else if isTimeRef(expr.RHS) {
+				return a.analyzeTimeExpr(expr.LHS)
+			}
+		}
+		if err := a.analyzeCondition(expr.LHS); err != nil {
+			return err
+		}
+		return a.analyzeCondition(expr.RHS)
+
+	case *Call:
+		if strings.ToLower(expr.Name) == "now" {
+			if len(expr.Args) != 0 {
+				return a.error(expr, "invalid number of arguments for now(), expected 0, got %d", len(expr.Args))
+			}
+			return nil
+		} else if fn := a.Functions[strings.ToLower(expr.Name)]; fn != nil && fn.Aggregate {
+			return a.error(expr, "aggregate function not allowed in condition: %s()", expr.Name)
+		}
+		return a.error(expr, "undefined function: %s()", expr.Name)
+	}
+	return nil
+}
+
+// analyzeTimeExpr validates an expression that is compared against time.
+// Only times, durations, integer timestamps and now() are allowed.
+func (a *analyzer) analyzeTimeExpr(expr Expr) error {
+	switch expr := expr.(type) {
+	case *TimeLiteral, *DurationLiteral, *IntegerLiteral:
+		return nil
+	case *Call:
+		return a.analyzeCondition(expr)
+	case *BinaryExpr:
+		if expr.Op != ADD && expr.Op != SUB {
+			return a.error(expr, "invalid operator in time expression: %s", expr.Op)
+		}
+		if err := a.analyzeTimeExpr(expr.LHS); err != nil {
+			return err
+		}
+		return a.analyzeTimeExpr(expr.RHS)
+	default:
+		return a.error(expr, "invalid time comparison: %s is not a time", expr)
+	}
+}
+
+// error returns a validation error positioned at a node.
+func (a *analyzer) error(n Node, format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...), Pos: a.pos.Pos(n)}
+}
+
+// isTimeRef returns true if the expression is a reference to the time column.
+func isTimeRef(expr Expr) bool {
+	ref, ok := expr.(*VarRef)
+	return ok && strings.ToLower(ref.Val) == "time"
+}
+
+// arity returns a string representation of a function's argument count.
+func arity(fn *Function) string {
+	if fn.MinArgs == fn.MaxArgs {
+		return fmt.Sprintf("%d", fn.MinArgs)
+	} else if fn.MaxArgs < 0 {
+		return fmt.Sprintf("at least %d", fn.MinArgs)
+	}
+	return fmt.Sprintf("%d to %d", fn.MinArgs, fn.MaxArgs)
+}
+
+// ValidationError represents a semantic error found in a parsed query.
+type ValidationError struct {
+	Message string
+	Pos     Pos
+}
+
+// Error returns the string representation of the error.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s at line %d, char %d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
+}
@@ -0,0 +1,94 @@
+package influxql_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure the analyzer rejects invalid queries with positioned errors.
+func TestAnalyzer_Analyze(t *testing.T) {
+	var tests = []struct {
+		s   string
+		err string
+	}{
+		// Valid queries
+		{s: `SELECT * FROM cpu`},
+		{s: `SELECT count(*), MEAN(value) FROM cpu WHERE time > now() - 1h`},
+		{s: `SELECT percentile(value, 90) FROM cpu GROUP BY time(10m), host`},
+		{s: `SELECT host, max(value) FROM cpu GROUP BY host`},
+		{s: `SELECT value FROM cpu WHERE time > '2000-01-01' AND time < '2000-01-02' + 1d AND host = 'a'`},
+		{s: `SELECT value FROM cpu WHERE time > 1000000000 OR 10 < value`},
+		{s: `DELETE FROM cpu WHERE time < '2000-01-01'`},
+		{s: `SELECT mean(value) FROM cpu GROUP BY 1h INTO daily.cpu NO BACKFILL`},
+		{s: `SELECT host, mean(value) FROM cpu GROUP BY host, time(5m) HAVING mean(value) > 80`},
+
+		// Functions
+		{s: `SELECT foo(value) FROM cpu`, err: `undefined function: foo() at line 1, char 8`},
+		{s: `SELECT count(value, 1) FROM cpu`, err: `invalid number of arguments for count(), expected 1, got 2 at line 1, char 8`},
+		{s: `SELECT percentile(value) FROM cpu`, err: `invalid number of arguments for percentile(), expected 2, got 1 at line 1, char 8`},
+		{s: `SELECT histogram() FROM cpu`, err: `invalid number of arguments for histogram(), expected 1 to 4, got 0 at line 1, char 8`},
+		{s: `SELECT count(bar(value)) FROM cpu`, err: `undefined function: bar() at line 1, char 14`},
+
+		// Mixing aggregate and raw fields
+		{s: `SELECT value, count(value) FROM cpu`, err: `mixing aggregate and non-aggregate fields requires GROUP BY at line 1, char 8`},
+		{s: `SELECT count(value), value * 2 FROM cpu`, err: `mixing aggregate and non-aggregate fields requires GROUP BY at line 1, char 22`},
+		{s: `SELECT count(value) + value FROM cpu`, err: `mixing aggregate and non-aggregate fields requires GROUP BY at line 1, char 8`},
+
+		// Conditions on the aggregated rows
+		{s: `SELECT value FROM cpu HAVING value > 1`, err: `HAVING requires aggregate fields at line 1, char 36`},
+		{s: `SELECT count(value) FROM cpu GROUP BY host HAVING foo(value) > 1`, err: `undefined function: foo() at line 1, char 51`},
+
+		// Dimensions
+		{s: `SELECT count(value) FROM cpu GROUP BY time(host)`, err: `time() dimension requires a duration, got host at line 1, char 44`},
+		{s: `SELECT count(value) FROM cpu GROUP BY time(10)`, err: `time() dimension requires a duration, got 10 at line 1, char 44`},
+		{s: `SELECT count(value) FROM cpu GROUP BY time()`, err: `invalid number of arguments for time(), expected 1, got 0 at line 1, char 39`},
+		{s: `SELECT count(value) FROM cpu GROUP BY mean(value)`, err: `invalid dimension: mean() at line 1, char 39`},
+		{s: `SELECT count(value) FROM cpu GROUP BY 'host'`, err: `invalid dimension: 'host' at line 1, char 39`},
+
+		// Continuous queries
+		{s: `SELECT mean(value) FROM cpu GROUP BY host INTO daily.cpu`, err: `continuous query requires a GROUP BY time interval at line 1, char 1`},
+
+		// Time comparisons
+		{s: `SELECT value FROM cpu WHERE time > 'yesterday'`, err: `invalid time comparison: 'yesterday' is not a time at line 1, char 36`},
+		{s: `SELECT value FROM cpu WHERE true = time`, err: `invalid time comparison: true is not a time at line 1, char 29`},
+		{s: `SELECT value FROM cpu WHERE host = 'a' AND time < now() - 1.5`, err: `invalid time comparison: 1.5 is not a time at line 1, char 59`},
+		{s: "SELECT value FROM cpu\nWHERE time > now() * 2", err: `invalid operator in time expression: * at line 2, char 20`},
+		{s: `SELECT value FROM cpu WHERE time > later()`, err: `undefined function: later() at line 1, char 36`},
+		{s: `SELECT value FROM cpu WHERE count(value) > 1`, err: `aggregate function not allowed in condition: count() at line 1, char 29`},
+		{s: `DELETE FROM cpu WHERE time < 'foo'`, err: `invalid time comparison: 'foo' is not a time at line 1, char 30`},
+
+		// Time comparison operators
+		{s: `SELECT value FROM cpu WHERE time = '2000-01-01'`},
+		{s: `SELECT value FROM cpu WHERE time < '2000-01-01'`},
+		{s: `SELECT value FROM cpu WHERE time > '2000-01-01'`},
+		{s: `SELECT value FROM cpu WHERE '2000-01-01' < time`},
+		{s: `SELECT value FROM cpu WHERE time != '2000-01-01'`, err: `invalid operator in time comparison: !=, expected <, > or = at line 1, char 34`},
+		{s: `SELECT value FROM cpu WHERE time <= '2000-01-01'`, err: `invalid operator in time comparison: <=, expected <, > or = at line 1, char 34`},
+		{s: `SELECT value FROM cpu WHERE time >= now() - 1h`, err: `invalid operator in time comparison: >=, expected <, > or = at line 1, char 34`},
+		{s: `SELECT value FROM cpu WHERE host = 'a' AND now() >= time`, err: `invalid operator in time comparison: >=, expected <, > or = at line 1, char 50`},
+		{s: `DELETE FROM cpu WHERE time <= '2000-01-01'`, err: `invalid operator in time comparison: <=, expected <, > or = at line 1, char 28`},
+	}
+
+	a := influxql.NewAnalyzer([]*influxql.Function{
+		{Name: "count", MinArgs: 1, MaxArgs: 1, Aggregate: true},
+		{Name: "mean", MinArgs: 1, MaxArgs: 1, Aggregate: true},
+		{Name: "max", MinArgs: 1, MaxArgs: 1, Aggregate: true},
+		{Name: "percentile", MinArgs: 2, MaxArgs: 2, Aggregate: true},
+		{Name: "histogram", MinArgs: 1, MaxArgs: 4, Aggregate: true},
+	})
+
+	for i, tt := range tests {
+		p := influxql.NewParser(strings.NewReader(tt.s))
+		q, err := p.ParseQuery()
+		if err != nil {
+			t.Errorf("%d. %q: parse error: %s", i, tt.s, err)
+			continue
+		}
+
+		if err := a.Analyze(q, p.Positions()); errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s", i, tt.s, tt.err, errstring(err))
+		}
+	}
+}
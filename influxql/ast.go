@@ -1,6 +1,11 @@
 package influxql
 
 import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,34 +24,76 @@ const (
 // Node represents a node in the InfluxDB abstract syntax tree.
 type Node interface {
 	node()
+	String() string
 }
 
-func (_ *SelectQuery) node()     {}
-func (_ *DeleteQuery) node()     {}
-func (_ Fields) node()           {}
-func (_ *Field) node()           {}
-func (_ Dimensions) node()       {}
-func (_ *Dimension) node()       {}
-func (_ *ImplicitJoin) node()    {}
-func (_ *InnerJoin) node()       {}
-func (_ *MergeJoin) node()       {}
-func (_ *VarRef) node()          {}
-func (_ *Call) node()            {}
-func (_ *IntegerLiteral) node()  {}
-func (_ *FloatLiteral) node()    {}
-func (_ *StringLiteral) node()   {}
-func (_ *BooleanLiteral) node()  {}
-func (_ *TimeLiteral) node()     {}
-func (_ *DurationLiteral) node() {}
-func (_ *BinaryExpr) node()      {}
+func (_ *SelectQuery) node()                {}
+func (_ *DeleteQuery) node()                {}
+func (_ *CreateDatabaseQuery) node()        {}
+func (_ *DropDatabaseQuery) node()          {}
+func (_ *CreateUserQuery) node()            {}
+func (_ *SetPasswordQuery) node()           {}
+func (_ *DropUserQuery) node()              {}
+func (_ *GrantQuery) node()                 {}
+func (_ *CreateShardSpaceQuery) node()      {}
+func (_ *AlterShardSpaceQuery) node()       {}
+func (_ *DropShardSpaceQuery) node()        {}
+func (_ *ListDatabasesQuery) node()         {}
+func (_ *ListSeriesQuery) node()            {}
+func (_ *ListFieldsQuery) node()            {}
+func (_ *ListShardSpacesQuery) node()       {}
+func (_ *ListShardsQuery) node()            {}
+func (_ *ListUsersQuery) node()             {}
+func (_ *ListContinuousQueriesQuery) node() {}
+func (_ *DropContinuousQueryQuery) node()   {}
+func (_ *DropSeriesQuery) node()            {}
+func (_ *RevokeQuery) node()                {}
+func (_ Fields) node()                      {}
+func (_ *Field) node()                      {}
+func (_ Dimensions) node()                  {}
+func (_ *Dimension) node()                  {}
+func (_ *ImplicitJoin) node()               {}
+func (_ *InnerJoin) node()                  {}
+func (_ *MergeJoin) node()                  {}
+func (_ *Series) node()                     {}
+func (_ *VarRef) node()                     {}
+func (_ *Wildcard) node()                   {}
+func (_ *Call) node()                       {}
+func (_ *IntegerLiteral) node()             {}
+func (_ *FloatLiteral) node()               {}
+func (_ *StringLiteral) node()              {}
+func (_ *BooleanLiteral) node()             {}
+func (_ *TimeLiteral) node()                {}
+func (_ *DurationLiteral) node()            {}
+func (_ *BinaryExpr) node()                 {}
 
 // Query represents a top-level query object.
 type Query interface {
+	Node
 	query()
 }
 
-func (_ *SelectQuery) query() {}
-func (_ *DeleteQuery) query() {}
+func (_ *SelectQuery) query()                {}
+func (_ *DeleteQuery) query()                {}
+func (_ *CreateDatabaseQuery) query()        {}
+func (_ *DropDatabaseQuery) query()          {}
+func (_ *CreateUserQuery) query()            {}
+func (_ *SetPasswordQuery) query()           {}
+func (_ *DropUserQuery) query()              {}
+func (_ *GrantQuery) query()                 {}
+func (_ *CreateShardSpaceQuery) query()      {}
+func (_ *AlterShardSpaceQuery) query()       {}
+func (_ *DropShardSpaceQuery) query()        {}
+func (_ *ListDatabasesQuery) query()         {}
+func (_ *ListSeriesQuery) query()            {}
+func (_ *ListFieldsQuery) query()            {}
+func (_ *ListShardSpacesQuery) query()       {}
+func (_ *ListShardsQuery) query()            {}
+func (_ *ListUsersQuery) query()             {}
+func (_ *ListContinuousQueriesQuery) query() {}
+func (_ *DropContinuousQueryQuery) query()   {}
+func (_ *DropSeriesQuery) query()            {}
+func (_ *RevokeQuery) query()                {}
 
 // Expr represents an expression that can be evaluated to a value.
 type Expr interface {
@@ -55,6 +102,7 @@ type Expr interface {
 }
 
 func (_ *VarRef) expr()          {}
+func (_ *Wildcard) expr()        {}
 func (_ *Call) expr()            {}
 func (_ *IntegerLiteral) expr()  {}
 func (_ *FloatLiteral) expr()    {}
@@ -82,6 +130,17 @@ type SelectQuery struct {
 	// Expressions used for grouping the selection.
 	Dimensions Dimensions
 
+	// How time buckets without points are filled and the value of
+	// NumberFill buckets.
+	Fill      FillOption
+	FillValue Expr
+
+	// Time zone that time buckets are aligned to. UTC if nil.
+	Location *time.Location
+
+	// An expression evaluated on the aggregated rows.
+	Having Expr
+
 	// Data source that fields are extracted from.
 	Source Join
 
@@ -94,6 +153,101 @@ type SelectQuery struct {
 
 	// Sort order.
 	Ascending bool
+
+	// Series that results are written into. Setting a target makes the
+	// query a continuous query.
+	Target string
+
+	// If set, a continuous query is only run on new data.
+	NoBackfill bool
+}
+
+// String returns a string representation of the select query.
+func (q *SelectQuery) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("SELECT ")
+	_, _ = buf.WriteString(q.Fields.String())
+	_, _ = buf.WriteString(" FROM ")
+	_, _ = buf.WriteString(q.Source.String())
+	if q.Condition != nil {
+		_, _ = buf.WriteString(" WHERE ")
+		_, _ = buf.WriteString(q.Condition.String())
+	}
+	if len(q.Dimensions) > 0 {
+		_, _ = buf.WriteString(" GROUP BY ")
+		_, _ = buf.WriteString(q.Dimensions.String())
+	}
+	switch q.Fill {
+	case NullFill:
+		_, _ = buf.WriteString(" FILL(null)")
+	case NumberFill:
+		_, _ = buf.WriteString(" FILL(" + q.FillValue.String() + ")")
+	case PreviousFill:
+		_, _ = buf.WriteString(" FILL(previous)")
+	case LinearFill:
+		_, _ = buf.WriteString(" FILL(linear)")
+	}
+	if q.Location != nil {
+		_, _ = buf.WriteString(" TZ(" + QuoteString(q.Location.String()) + ")")
+	}
+	if q.Having != nil {
+		_, _ = buf.WriteString(" HAVING ")
+		_, _ = buf.WriteString(q.Having.String())
+	}
+	if q.Limit > 0 {
+		_, _ = fmt.Fprintf(&buf, " LIMIT %d", q.Limit)
+	}
+	if q.Ascending {
+		_, _ = buf.WriteString(" ORDER ASC")
+	}
+	if q.Target != "" {
+		_, _ = buf.WriteString(" INTO " + QuoteIdent(q.Target))
+		if q.NoBackfill {
+			_, _ = buf.WriteString(" NO BACKFILL")
+		}
+	}
+	return buf.String()
+}
+
+// FillOption represents how a query grouped by time fills the time buckets
+// without points.
+type FillOption int
+
+const (
+	// NoFill omits buckets without points. This is the default and is also
+	// set by FILL(none).
+	NoFill FillOption = iota
+
+	// NullFill returns null values for buckets without points.
+	NullFill
+
+	// NumberFill returns the query's FillValue, a number or a string, for
+	// buckets without points.
+	NumberFill
+
+	// PreviousFill returns the values of the previous bucket.
+	PreviousFill
+
+	// LinearFill interpolates values between the previous and next buckets.
+	LinearFill
+)
+
+// GroupByInterval returns the duration of the time dimension of the query.
+// Returns zero if the query is not grouped by time.
+func (q *SelectQuery) GroupByInterval() time.Duration {
+	for _, d := range q.Dimensions {
+		switch expr := d.Expr.(type) {
+		case *DurationLiteral:
+			return expr.Val
+		case *Call:
+			if strings.ToLower(expr.Name) == "time" && (len(expr.Args) == 1 || len(expr.Args) == 2) {
+				if lit, ok := expr.Args[0].(*DurationLiteral); ok {
+					return lit.Val
+				}
+			}
+		}
+	}
+	return 0
 }
 
 // DeleteQuery represents a query for removing data from the database.
@@ -105,50 +259,457 @@ type DeleteQuery struct {
 	Condition Expr
 }
 
+// String returns a string representation of the delete query.
+func (q *DeleteQuery) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("DELETE FROM ")
+	_, _ = buf.WriteString(q.Source.String())
+	if q.Condition != nil {
+		_, _ = buf.WriteString(" WHERE ")
+		_, _ = buf.WriteString(q.Condition.String())
+	}
+	return buf.String()
+}
+
+// DropSeriesQuery represents a command for removing a series and all of its data.
+type DropSeriesQuery struct {
+	// Name of the series to be dropped.
+	Name string
+}
+
+// String returns a string representation of the drop series query.
+func (q *DropSeriesQuery) String() string { return "DROP SERIES " + QuoteIdent(q.Name) }
+
+// CreateDatabaseQuery represents a command for creating a new database.
+type CreateDatabaseQuery struct {
+	// Name of the database to be created.
+	Name string
+}
+
+// String returns a string representation of the create database query.
+func (q *CreateDatabaseQuery) String() string {
+	return "CREATE DATABASE " + QuoteIdent(q.Name)
+}
+
+// DropDatabaseQuery represents a command to drop a database.
+type DropDatabaseQuery struct {
+	// Name of the database to be dropped.
+	Name string
+}
+
+// String returns a string representation of the drop database query.
+func (q *DropDatabaseQuery) String() string {
+	return "DROP DATABASE " + QuoteIdent(q.Name)
+}
+
+// CreateUserQuery represents a command for creating a new user.
+// Users belong to the database that the query is executed against.
+type CreateUserQuery struct {
+	// Name of the user to be created.
+	Name string
+
+	// User's password.
+	Password string
+}
+
+// String returns a string representation of the create user query.
+func (q *CreateUserQuery) String() string {
+	return fmt.Sprintf("CREATE USER %s WITH PASSWORD %s", QuoteIdent(q.Name), QuoteString(q.Password))
+}
+
+// SetPasswordQuery represents a command for changing a user's password.
+type SetPasswordQuery struct {
+	// Name of the user whose password is changed.
+	Name string
+
+	// User's new password.
+	Password string
+}
+
+// String returns a string representation of the set password query.
+func (q *SetPasswordQuery) String() string {
+	return fmt.Sprintf("SET PASSWORD FOR %s = %s", QuoteIdent(q.Name), QuoteString(q.Password))
+}
+
+// DropUserQuery represents a command for dropping a user.
+type DropUserQuery struct {
+	// Name of the user to be dropped.
+	Name string
+}
+
+// String returns a string representation of the drop user query.
+func (q *DropUserQuery) String() string {
+	return "DROP USER " + QuoteIdent(q.Name)
+}
+
+// Privilege is a type of action a user can be granted the right to use.
+type Privilege int
+
+const (
+	ReadPrivilege Privilege = iota
+	WritePrivilege
+	AllPrivileges
+)
+
+// String returns a string representation of a privilege.
+func (p Privilege) String() string {
+	switch p {
+	case ReadPrivilege:
+		return "READ"
+	case WritePrivilege:
+		return "WRITE"
+	case AllPrivileges:
+		return "ALL"
+	}
+	return ""
+}
+
+// GrantQuery represents a command for granting a privilege to a user.
+type GrantQuery struct {
+	// The privilege to be granted.
+	Privilege Privilege
+
+	// Database to grant the privilege on.
+	On string
+
+	// Who to grant the privilege to.
+	User string
+}
+
+// String returns a string representation of the grant query.
+func (q *GrantQuery) String() string {
+	return fmt.Sprintf("GRANT %s ON %s TO %s", q.Privilege, QuoteIdent(q.On), QuoteIdent(q.User))
+}
+
+// RevokeQuery represents a command for revoking a privilege from a user.
+type RevokeQuery struct {
+	// The privilege to be revoked.
+	Privilege Privilege
+
+	// Database to revoke the privilege on.
+	On string
+
+	// Who to revoke the privilege from.
+	User string
+}
+
+// String returns a string representation of the revoke query.
+func (q *RevokeQuery) String() string {
+	return fmt.Sprintf("REVOKE %s ON %s FROM %s", q.Privilege, QuoteIdent(q.On), QuoteIdent(q.User))
+}
+
+// CreateShardSpaceQuery represents a command for creating a shard space.
+type CreateShardSpaceQuery struct {
+	// Name of the shard space to be created.
+	Name string
+
+	// Database the shard space belongs to.
+	Database string
+
+	// Series matched by the shard space. Defaults to all series if nil.
+	Regex *regexp.Regexp
+
+	// Time period held by each shard. Uses the default duration if zero.
+	Duration time.Duration
+
+	// Length of time data is retained. Retained forever if zero.
+	Retention time.Duration
+
+	// Number of replicas and splits. Use the defaults if zero.
+	ReplicaN int
+	SplitN   int
+}
+
+// String returns a string representation of the create shard space query.
+func (q *CreateShardSpaceQuery) String() string {
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "CREATE SHARD SPACE %s ON %s", QuoteIdent(q.Name), QuoteIdent(q.Database))
+	if q.Regex != nil {
+		_, _ = buf.WriteString(" MATCHING " + quoteRegex(q.Regex))
+	}
+	if q.Duration != 0 {
+		_, _ = buf.WriteString(" DURATION " + FormatDuration(q.Duration))
+	}
+	if q.Retention != 0 {
+		_, _ = buf.WriteString(" RETENTION " + FormatDuration(q.Retention))
+	}
+	if q.ReplicaN != 0 {
+		_, _ = fmt.Fprintf(&buf, " REPLICATION %d", q.ReplicaN)
+	}
+	if q.SplitN != 0 {
+		_, _ = fmt.Fprintf(&buf, " SPLIT %d", q.SplitN)
+	}
+	return buf.String()
+}
+
+// AlterShardSpaceQuery represents a command for changing the settings of
+// an existing shard space. Only non-nil settings are changed.
+type AlterShardSpaceQuery struct {
+	// Name of the shard space to be altered.
+	Name string
+
+	// Database the shard space belongs to.
+	Database string
+
+	// Updated settings.
+	Regex     *regexp.Regexp
+	Duration  *time.Duration
+	Retention *time.Duration
+	ReplicaN  *int
+	SplitN    *int
+}
+
+// String returns a string representation of the alter shard space query.
+func (q *AlterShardSpaceQuery) String() string {
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "ALTER SHARD SPACE %s ON %s", QuoteIdent(q.Name), QuoteIdent(q.Database))
+	if q.Regex != nil {
+		_, _ = buf.WriteString(" MATCHING " + quoteRegex(q.Regex))
+	}
+	if q.Duration != nil {
+		_, _ = buf.WriteString(" DURATION " + FormatDuration(*q.Duration))
+	}
+	if q.Retention != nil {
+		_, _ = buf.WriteString(" RETENTION " + FormatDuration(*q.Retention))
+	}
+	if q.ReplicaN != nil {
+		_, _ = fmt.Fprintf(&buf, " REPLICATION %d", *q.ReplicaN)
+	}
+	if q.SplitN != nil {
+		_, _ = fmt.Fprintf(&buf, " SPLIT %d", *q.SplitN)
+	}
+	return buf.String()
+}
+
+// DropShardSpaceQuery represents a command for removing a shard space.
+type DropShardSpaceQuery struct {
+	// Name of the shard space to be dropped.
+	Name string
+
+	// Database the shard space belongs to.
+	Database string
+}
+
+// String returns a string representation of the drop shard space query.
+func (q *DropShardSpaceQuery) String() string {
+	return fmt.Sprintf("DROP SHARD SPACE %s ON %s", QuoteIdent(q.Name), QuoteIdent(q.Database))
+}
+
+// ListDatabasesQuery represents a command for listing all databases.
+type ListDatabasesQuery struct{}
+
+// String returns a string representation of the list databases query.
+func (q *ListDatabasesQuery) String() string { return "LIST DATABASES" }
+
+// ListSeriesQuery represents a command for listing series in the database.
+type ListSeriesQuery struct {
+	// Expression used to filter series by name. Lists all series if nil.
+	Regex *regexp.Regexp
+}
+
+// String returns a string representation of the list series query.
+func (q *ListSeriesQuery) String() string {
+	if q.Regex != nil {
+		return "LIST SERIES FROM " + quoteRegex(q.Regex)
+	}
+	return "LIST SERIES"
+}
+
+// ListFieldsQuery represents a command for listing the fields of a series.
+type ListFieldsQuery struct {
+	// Name of the series.
+	Series string
+}
+
+// String returns a string representation of the list fields query.
+func (q *ListFieldsQuery) String() string { return "LIST FIELDS FROM " + QuoteIdent(q.Series) }
+
+// ListShardSpacesQuery represents a command for listing shard spaces in the database.
+type ListShardSpacesQuery struct{}
+
+// String returns a string representation of the list shard spaces query.
+func (q *ListShardSpacesQuery) String() string { return "LIST SHARD SPACES" }
+
+// ListShardsQuery represents a command for listing shards in the database.
+type ListShardsQuery struct{}
+
+// String returns a string representation of the list shards query.
+func (q *ListShardsQuery) String() string { return "LIST SHARDS" }
+
+// ListUsersQuery represents a command for listing users in the database.
+type ListUsersQuery struct{}
+
+// String returns a string representation of the list users query.
+func (q *ListUsersQuery) String() string { return "LIST USERS" }
+
+// ListContinuousQueriesQuery represents a command for listing continuous queries.
+type ListContinuousQueriesQuery struct{}
+
+// String returns a string representation of the list continuous queries query.
+func (q *ListContinuousQueriesQuery) String() string { return "LIST CONTINUOUS QUERIES" }
+
+// DropContinuousQueryQuery represents a command for removing a continuous query.
+type DropContinuousQueryQuery struct {
+	// Identifier of the continuous query to be dropped.
+	ID uint64
+}
+
+// String returns a string representation of the drop continuous query query.
+func (q *DropContinuousQueryQuery) String() string {
+	return fmt.Sprintf("DROP CONTINUOUS QUERY %d", q.ID)
+}
+
 // Fields represents a list of fields.
 type Fields []*Field
 
+// String returns a string representation of the fields.
+func (a Fields) String() string {
+	var str []string
+	for _, f := range a {
+		str = append(str, f.String())
+	}
+	return strings.Join(str, ", ")
+}
+
 // Field represents an expression retrieved from a select query.
 type Field struct {
 	Expr  Expr
 	Alias string
 }
 
+// String returns a string representation of the field.
+func (f *Field) String() string {
+	if f.Alias == "" {
+		return f.Expr.String()
+	}
+	return fmt.Sprintf("%s AS %s", f.Expr.String(), QuoteIdent(f.Alias))
+}
+
 // Dimensions represents a list of dimensions.
 type Dimensions []*Dimension
 
+// String returns a string representation of the dimensions.
+func (a Dimensions) String() string {
+	var str []string
+	for _, d := range a {
+		str = append(str, d.String())
+	}
+	return strings.Join(str, ", ")
+}
+
 // Dimension represents an expression that a select query is grouped by.
 type Dimension struct {
 	Expr  Expr
 	Alias string
 }
 
+// String returns a string representation of the dimension.
+func (d *Dimension) String() string {
+	if d.Alias == "" {
+		return d.Expr.String()
+	}
+	return fmt.Sprintf("%s AS %s", d.Expr.String(), QuoteIdent(d.Alias))
+}
+
 // ImplicitJoin represents a list of tables to join on time.
+// A query against a single series uses an implicit join with one source.
 type ImplicitJoin struct {
-	// TODO: Add sources.
+	Sources []*Series
+}
+
+// String returns a string representation of the join.
+func (j *ImplicitJoin) String() string {
+	var str []string
+	for _, s := range j.Sources {
+		str = append(str, s.String())
+	}
+	return strings.Join(str, ", ")
 }
 
 // InnerJoin represents a join between two series.
 type InnerJoin struct {
-	// TODO: Add sources.
+	LHS       *Series
+	RHS       *Series
 	Condition Expr
 }
 
+// String returns a string representation of the join.
+func (j *InnerJoin) String() string {
+	str := fmt.Sprintf("%s INNER JOIN %s", j.LHS.String(), j.RHS.String())
+	if j.Condition != nil {
+		str += " ON " + j.Condition.String()
+	}
+	return str
+}
+
 // MergeJoin represents a merging join between two series.
 type MergeJoin struct {
-	// TODO: Add sources.
+	Sources   []*Series
 	Condition Expr
 }
 
+// String returns a string representation of the join.
+func (j *MergeJoin) String() string {
+	var str []string
+	for _, s := range j.Sources {
+		str = append(str, s.String())
+	}
+	if j.Condition != nil {
+		return strings.Join(str, " MERGE ") + " ON " + j.Condition.String()
+	}
+	return strings.Join(str, " MERGE ")
+}
+
+// Series represents a single series, or a regex matching a set of
+// series, used as a data source.
+type Series struct {
+	Name  string
+	Regex *regexp.Regexp
+	Alias string
+}
+
+// String returns a string representation of the series.
+func (s *Series) String() string {
+	str := QuoteIdent(s.Name)
+	if s.Regex != nil {
+		str = quoteRegex(s.Regex)
+	}
+	if s.Alias != "" {
+		str += " AS " + QuoteIdent(s.Alias)
+	}
+	return str
+}
+
 // VarRef represents a reference to a variable in the query.
 type VarRef struct {
 	Val string
 }
 
+// String returns a string representation of the variable reference.
+func (r *VarRef) String() string { return QuoteIdent(r.Val) }
+
+// Wildcard represents a wild card expression.
+type Wildcard struct{}
+
+// String returns a string representation of the wildcard.
+func (w *Wildcard) String() string { return "*" }
+
 // Call represents a function call.
 type Call struct {
 	Name string
-	Expr Expr
+	Args []Expr
+}
+
+// String returns a string representation of the call.
+func (c *Call) String() string {
+	// Join arguments.
+	var str []string
+	for _, arg := range c.Args {
+		str = append(str, arg.String())
+	}
+
+	// Write function name and args.
+	return fmt.Sprintf("%s(%s)", QuoteIdent(c.Name), strings.Join(str, ", "))
 }
 
 // IntegerLiteral represents an integer literal in the query.
@@ -156,31 +717,68 @@ type IntegerLiteral struct {
 	Val int64
 }
 
+// String returns a string representation of the literal.
+func (l *IntegerLiteral) String() string { return strconv.FormatInt(l.Val, 10) }
+
 // FloatLiteral represents a floating-point literal in the query.
 type FloatLiteral struct {
 	Val float64
 }
 
+// String returns a string representation of the literal.
+// A decimal point is always included so the value is not read as an integer.
+func (l *FloatLiteral) String() string {
+	str := strconv.FormatFloat(l.Val, 'f', -1, 64)
+	if !strings.Contains(str, ".") {
+		str += ".0"
+	}
+	return str
+}
+
 // BooleanLiteral represents a boolean literal in the query.
 type BooleanLiteral struct {
 	Val bool
 }
 
+// String returns a string representation of the literal.
+func (l *BooleanLiteral) String() string {
+	if l.Val {
+		return "true"
+	}
+	return "false"
+}
+
 // StringLiteral represents a string literal in the query.
 type StringLiteral struct {
 	Val string
 }
 
+// String returns a string representation of the literal.
+func (l *StringLiteral) String() string { return QuoteString(l.Val) }
+
 // TimeLiteral represents a point-in-time literal in the query.
 type TimeLiteral struct {
 	Val time.Time
 }
 
+// String returns a string representation of the literal.
+// Times with sub-microsecond precision are formatted as RFC3339.
+func (l *TimeLiteral) String() string {
+	t := l.Val.UTC()
+	if t.Nanosecond()%int(time.Microsecond) != 0 {
+		return QuoteString(t.Format(time.RFC3339Nano))
+	}
+	return QuoteString(t.Format(DateTimeFormat))
+}
+
 // DurationLiteral represents a duration literal in the query.
 type DurationLiteral struct {
 	Val time.Duration
 }
 
+// String returns a string representation of the literal.
+func (l *DurationLiteral) String() string { return FormatDuration(l.Val) }
+
 // BinaryExpr represents an operation between two expressions.
 type BinaryExpr struct {
 	Op  Token
@@ -188,6 +786,56 @@ type BinaryExpr struct {
 	RHS Expr
 }
 
+// String returns a string representation of the binary expression.
+// Operands are only wrapped in parentheses when required by precedence.
+// Operators of equal precedence are left-associative so a right-hand side
+// with the same precedence is always wrapped.
+func (e *BinaryExpr) String() string {
+	lhs, rhs := e.LHS.String(), e.RHS.String()
+	if bin, ok := e.LHS.(*BinaryExpr); ok && bin.Op.Precedence() < e.Op.Precedence() {
+		lhs = "(" + lhs + ")"
+	}
+	if bin, ok := e.RHS.(*BinaryExpr); ok && bin.Op.Precedence() <= e.Op.Precedence() {
+		rhs = "(" + rhs + ")"
+	}
+	return fmt.Sprintf("%s %s %s", lhs, e.Op.String(), rhs)
+}
+
+// quoteRegex returns a regex literal with forward slashes escaped.
+func quoteRegex(re *regexp.Regexp) string {
+	return "/" + strings.Replace(re.String(), "/", `\/`, -1) + "/"
+}
+
+// QuoteString returns a quoted string.
+func QuoteString(s string) string {
+	return `'` + strings.NewReplacer("\\", `\\`, "'", `\'`, "\n", `\n`, "\t", `\t`).Replace(s) + `'`
+}
+
+// QuoteIdent returns a quoted identifier, if necessary.
+// Identifiers are quoted if they contain characters that are not valid in an
+// unquoted identifier or if they match a keyword.
+func QuoteIdent(ident string) string {
+	if !isBareIdent(ident) {
+		return `"` + strings.NewReplacer("\\", `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(ident) + `"`
+	}
+	return ident
+}
+
+// isBareIdent returns true if the identifier can be used without quotes.
+func isBareIdent(ident string) bool {
+	if ident == "" || Lookup(ident) != IDENT {
+		return false
+	}
+	for i, ch := range ident {
+		if i == 0 && !isLetter(ch) && ch != '_' {
+			return false
+		} else if !isIdentChar(ch) {
+			return false
+		}
+	}
+	return true
+}
+
 // Visitor can be called by Walk to traverse an AST hierarchy.
 // The Visit() function is called once per node.
 type Visitor interface {
@@ -196,7 +844,9 @@ type Visitor interface {
 
 // Walk traverses a node hierarchy in depth-first order.
 func Walk(v Visitor, node Node) {
-	if v = v.Visit(node); v == nil {
+	if node == nil {
+		return
+	} else if v = v.Visit(node); v == nil {
 		return
 	}
 
@@ -207,6 +857,13 @@ func Walk(v Visitor, node Node) {
 			Walk(v, n.Dimensions)
 			Walk(v, n.Source)
 			Walk(v, n.Condition)
+			Walk(v, n.Having)
+		}
+
+	case *DeleteQuery:
+		if n != nil {
+			Walk(v, n.Source)
+			Walk(v, n.Condition)
 		}
 
 	case Fields:
@@ -225,12 +882,30 @@ func Walk(v Visitor, node Node) {
 	case *Dimension:
 		Walk(v, n.Expr)
 
+	case *ImplicitJoin:
+		for _, c := range n.Sources {
+			Walk(v, c)
+		}
+
+	case *InnerJoin:
+		Walk(v, n.LHS)
+		Walk(v, n.RHS)
+		Walk(v, n.Condition)
+
+	case *MergeJoin:
+		for _, c := range n.Sources {
+			Walk(v, c)
+		}
+		Walk(v, n.Condition)
+
 	case *BinaryExpr:
 		Walk(v, n.LHS)
 		Walk(v, n.RHS)
 
 	case *Call:
-		Walk(v, n.Expr)
+		for _, expr := range n.Args {
+			Walk(v, expr)
+		}
 	}
 }
 
@@ -0,0 +1,148 @@
+package influxql_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure queries can be formatted to canonical InfluxQL and parsed back
+// into the same AST.
+func TestQuery_String(t *testing.T) {
+	var tests = []struct {
+		s   string
+		str string
+	}{
+		{s: `select * from cpu`, str: `SELECT * FROM cpu`},
+		{s: `SELECT value AS "my value", count(*) FROM "cpu load"`, str: `SELECT value AS "my value", count(*) FROM "cpu load"`},
+		{s: `SELECT a, b AS c FROM cpu.idle AS x WHERE x.value > 10 AND host = 'server\'s' GROUP BY time(1h), region LIMIT 10 ORDER ASC;`, str: `SELECT a, b AS c FROM cpu.idle AS x WHERE x.value > 10 AND host = 'server\'s' GROUP BY time(1h), region LIMIT 10 ORDER ASC`},
+		{s: `SELECT "select", "a b" AS "from" FROM "series" ORDER DESC`, str: `SELECT "select", "a b" AS "from" FROM "series"`},
+		{s: `SELECT value FROM /^cpu\./i`, str: `SELECT value FROM /(?i)^cpu\./`},
+		{s: `SELECT value FROM /a\/b/`, str: `SELECT value FROM /a\/b/`},
+		{s: `SELECT value FROM a, b`, str: `SELECT value FROM a, b`},
+		{s: `SELECT value FROM a merge b merge c on a.x = b.x`, str: `SELECT value FROM a MERGE b MERGE c ON a.x = b.x`},
+		{s: `SELECT value FROM a x INNER JOIN b y ON x.v > y.v`, str: `SELECT value FROM a AS x INNER JOIN b AS y ON x.v > y.v`},
+		{s: `SELECT mean(value) FROM cpu WHERE time > now() - 60m GROUP BY 1d`, str: `SELECT mean(value) FROM cpu WHERE time > now() - 1h GROUP BY 1d`},
+		{s: `SELECT value FROM cpu WHERE time > '2000-01-01' AND time < '2000-01-01T00:00:00.000000001Z'`, str: `SELECT value FROM cpu WHERE time > '2000-01-01 00:00:00' AND time < '2000-01-01T00:00:00.000000001Z'`},
+		{s: `SELECT 1.0, -2, 3.5, true, 'a\nb' FROM cpu`, str: `SELECT 1.0, -2, 3.5, true, 'a\nb' FROM cpu`},
+		{s: `DELETE FROM cpu WHERE time < '2000-01-01 12:30:00.5'`, str: `DELETE FROM cpu WHERE time < '2000-01-01 12:30:00.5'`},
+		{s: `create database "my db"`, str: `CREATE DATABASE "my db"`},
+		{s: `drop database mydb`, str: `DROP DATABASE mydb`},
+		{s: `create user "user" with password 'it\'s'`, str: `CREATE USER "user" WITH PASSWORD 'it\'s'`},
+		{s: `set password for bob = 'secret'`, str: `SET PASSWORD FOR bob = 'secret'`},
+		{s: `drop user bob`, str: `DROP USER bob`},
+		{s: `grant write on mydb to bob`, str: `GRANT WRITE ON mydb TO bob`},
+		{s: `revoke all on mydb from bob`, str: `REVOKE ALL ON mydb FROM bob`},
+		{s: `create shard space raw on mydb split 4 matching /a\/b/ duration 168h`, str: `CREATE SHARD SPACE raw ON mydb MATCHING /a\/b/ DURATION 1w SPLIT 4`},
+		{s: `alter shard space raw on mydb retention 0s replication 3`, str: `ALTER SHARD SPACE raw ON mydb RETENTION 0s REPLICATION 3`},
+		{s: `drop shard space raw on mydb`, str: `DROP SHARD SPACE raw ON mydb`},
+		{s: `list databases`, str: `LIST DATABASES`},
+		{s: `list series from /^cpu\./`, str: `LIST SERIES FROM /^cpu\./`},
+		{s: `list fields from "cpu load"`, str: `LIST FIELDS FROM "cpu load"`},
+		{s: `list shard spaces`, str: `LIST SHARD SPACES`},
+		{s: `select mean(value) from cpu group by time(1h) into "daily.cpu" no backfill`, str: `SELECT mean(value) FROM cpu GROUP BY time(1h) INTO daily.cpu NO BACKFILL`},
+		{s: `select mean(value) from cpu group by time(1h), host fill(linear) limit 10`, str: `SELECT mean(value) FROM cpu GROUP BY time(1h), host FILL(linear) LIMIT 10`},
+		{s: `select mean(value) from cpu group by 1h fill(2.5)`, str: `SELECT mean(value) FROM cpu GROUP BY 1h FILL(2.5)`},
+		{s: `select mean(value) from cpu group by 1h fill(none)`, str: `SELECT mean(value) FROM cpu GROUP BY 1h`},
+		{s: `select count(value) from cpu group by time(1d, 6h) tz('America/New_York')`, str: `SELECT count(value) FROM cpu GROUP BY time(1d, 6h) TZ('America/New_York')`},
+		{s: `select mean(value) as m from cpu group by host having m > 80 limit 10`, str: `SELECT mean(value) AS m FROM cpu GROUP BY host HAVING m > 80 LIMIT 10`},
+		{s: `list continuous queries`, str: `LIST CONTINUOUS QUERIES`},
+		{s: `drop continuous query 12`, str: `DROP CONTINUOUS QUERY 12`},
+		{s: `drop series "cpu load"`, str: `DROP SERIES "cpu load"`},
+	}
+
+	for i, tt := range tests {
+		// Parse the original query.
+		q, err := influxql.ParseQuery(tt.s)
+		if err != nil {
+			t.Errorf("%d. %q: parse error: %s", i, tt.s, err)
+			continue
+		}
+
+		// Verify the canonical string.
+		if str := q.String(); str != tt.str {
+			t.Errorf("%d. %q: string mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.s, tt.str, str)
+			continue
+		}
+
+		// Parse the canonical string and verify the AST matches.
+		other, err := influxql.ParseQuery(q.String())
+		if err != nil {
+			t.Errorf("%d. %q: round trip parse error: %s", i, tt.str, err)
+		} else if !reflect.DeepEqual(q, other) {
+			t.Errorf("%d. %q: round trip mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.str, q, other)
+		}
+	}
+}
+
+// Ensure binary expressions are formatted with the minimum parentheses.
+func TestBinaryExpr_String(t *testing.T) {
+	var tests = []struct {
+		s   string
+		str string
+	}{
+		{s: `1 + 2 * 3`, str: `1 + 2 * 3`},
+		{s: `(1 + 2) * 3`, str: `(1 + 2) * 3`},
+		{s: `1 * (2 + 3)`, str: `1 * (2 + 3)`},
+		{s: `(1 * 2) + 3`, str: `1 * 2 + 3`},
+		{s: `1 - 2 - 3`, str: `1 - 2 - 3`},
+		{s: `1 - (2 - 3)`, str: `1 - (2 - 3)`},
+		{s: `a = 1 OR b = 2 AND c = 3`, str: `a = 1 OR b = 2 AND c = 3`},
+		{s: `(a = 1 OR b = 2) AND c = 3`, str: `(a = 1 OR b = 2) AND c = 3`},
+		{s: `a <> -1`, str: `a != -1`},
+		{s: `value / 100.0 >= sum(x, y * 2)`, str: `value / 100.0 >= sum(x, y * 2)`},
+	}
+
+	for i, tt := range tests {
+		expr, err := influxql.ParseExpr(tt.s)
+		if err != nil {
+			t.Errorf("%d. %q: parse error: %s", i, tt.s, err)
+			continue
+		}
+
+		if str := expr.String(); str != tt.str {
+			t.Errorf("%d. %q: string mismatch: exp=%s, got=%s", i, tt.s, tt.str, str)
+		} else if other, err := influxql.ParseExpr(str); err != nil {
+			t.Errorf("%d. %q: round trip parse error: %s", i, str, err)
+		} else if !reflect.DeepEqual(expr, other) {
+			t.Errorf("%d. %q: round trip mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, str, expr, other)
+		}
+	}
+}
+
+// Ensure literals round trip through their string representation.
+func TestLiteral_String(t *testing.T) {
+	var tests = []struct {
+		expr influxql.Expr
+		str  string
+	}{
+		{expr: &influxql.IntegerLiteral{Val: -100}, str: `-100`},
+		{expr: &influxql.FloatLiteral{Val: 100}, str: `100.0`},
+		{expr: &influxql.FloatLiteral{Val: 0.25}, str: `0.25`},
+		{expr: &influxql.BooleanLiteral{Val: false}, str: `false`},
+		{expr: &influxql.StringLiteral{Val: "it's \\ a\ttab"}, str: `'it\'s \\ a\ttab'`},
+		{expr: &influxql.TimeLiteral{Val: time.Date(2000, 1, 2, 3, 4, 5, 6000, time.UTC)}, str: `'2000-01-02 03:04:05.000006'`},
+		{expr: &influxql.DurationLiteral{Val: 0}, str: `0s`},
+		{expr: &influxql.DurationLiteral{Val: 2 * 7 * 24 * time.Hour}, str: `2w`},
+		{expr: &influxql.DurationLiteral{Val: 36 * time.Hour}, str: `36h`},
+		{expr: &influxql.DurationLiteral{Val: 1500 * time.Millisecond}, str: `1500ms`},
+		{expr: &influxql.DurationLiteral{Val: 1500 * time.Nanosecond}, str: `1.5u`},
+		{expr: &influxql.VarRef{Val: "cpu.load"}, str: `cpu.load`},
+		{expr: &influxql.VarRef{Val: "_x1"}, str: `_x1`},
+		{expr: &influxql.VarRef{Val: "1x"}, str: `"1x"`},
+		{expr: &influxql.VarRef{Val: "limit"}, str: `"limit"`},
+		{expr: &influxql.VarRef{Val: `a "b"`}, str: `"a \"b\""`},
+	}
+
+	for i, tt := range tests {
+		if str := tt.expr.String(); str != tt.str {
+			t.Errorf("%d. string mismatch: exp=%s, got=%s", i, tt.str, str)
+		} else if other, err := influxql.ParseExpr(str); err != nil {
+			t.Errorf("%d. %q: round trip parse error: %s", i, str, err)
+		} else if !reflect.DeepEqual(tt.expr, other) {
+			t.Errorf("%d. %q: round trip mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, str, tt.expr, other)
+		}
+	}
+}
@@ -25,6 +25,33 @@ Limits and ordering can be set on selection queries as well:
 
 	SELECT value FROM cpu_load LIMIT 100 ORDER DESC;
 
+Queries grouped by time only return the time buckets that have points unless
+FILL is set. Empty buckets can then have null values, a number or string, the
+values of the previous bucket or values interpolated between the buckets
+around them:
+
+	SELECT mean(value) FROM cpu_load GROUP BY time(5m) FILL(null)
+	SELECT mean(value) FROM cpu_load GROUP BY time(5m) FILL(0)
+	SELECT mean(value) FROM cpu_load GROUP BY time(5m) FILL(previous)
+	SELECT mean(value) FROM cpu_load GROUP BY time(5m) FILL(linear)
+
+Time buckets start at multiples of the interval since the Unix epoch. An
+offset shifts the start of each bucket and TZ aligns the buckets to a time
+zone so daily buckets start at local midnight, even on days that are 23 or
+25 hours long because of daylight saving time:
+
+	SELECT count(value) FROM page_views GROUP BY time(1d, 6h)
+	SELECT count(value) FROM page_views GROUP BY time(1d) TZ('America/New_York')
+
+FILL and TZ can follow the GROUP BY clause in either order.
+
+HAVING filters the rows of an aggregate query after they are aggregated. The
+condition can use the aggregates, the aliases of the fields or the GROUP BY
+dimensions:
+
+	SELECT host, mean(cpu) FROM load GROUP BY host, time(5m) HAVING mean(cpu) > 80
+	SELECT mean(cpu) AS m FROM load GROUP BY host HAVING m > 80 AND host != 'db1'
+
 
 Removing data
 
@@ -33,17 +60,80 @@ database. This query will delete "cpu_load" values older than an hour:
 
 	DELETE FROM cpu_load WHERE time < now() - 1h
 
+A series can be removed along with all of its data and fields with the DROP
+SERIES statement:
+
+	DROP SERIES cpu_load
+
+
+Listing metadata
+
+The LIST statements return information about the server and the database the
+query is executed against. Series can be filtered by a regular expression:
+
+	LIST DATABASES
+	LIST SERIES FROM /^cpu\./
+	LIST FIELDS FROM cpu_load
+	LIST SHARD SPACES
+	LIST SHARDS
+	LIST USERS
+
+
+Managing databases and users
+
+Databases are created and removed with the CREATE DATABASE and DROP DATABASE
+statements:
+
+	CREATE DATABASE mydb
+	DROP DATABASE mydb
+
+Users belong to the database that the query is executed against:
+
+	CREATE USER susy WITH PASSWORD 'pass'
+	SET PASSWORD FOR susy = 'newpass'
+	DROP USER susy
+
+Read and write access to a database can be granted and revoked separately or
+together with ALL:
+
+	GRANT READ ON mydb TO susy
+	REVOKE ALL ON mydb FROM susy
+
+
+Shard spaces
+
+Shard spaces control how long data is retained for the series they match and
+how that data is split across the cluster. Settings that are left out use the
+server defaults:
+
+	CREATE SHARD SPACE raw ON mydb MATCHING /^cpu\./
+	DURATION 7d RETENTION 90d REPLICATION 2 SPLIT 4
+
+Settings can be changed without dropping data. New settings apply to shards
+created afterward:
+
+	ALTER SHARD SPACE raw ON mydb RETENTION 30d
+
+	DROP SHARD SPACE raw ON mydb
+
 
 Continuous Queries
 
 Queries can be run indefinitely on the server in order to generate new series.
 This is done by running a "SELECT INTO" query. For example, this query computes
-the hourly mean for cpu_load and stores it into a "cpu_load" series in the
-"daily" shard space.
+the hourly mean for cpu_load and stores it into the "daily.cpu_load" series.
 
 	SELECT mean(value) AS value FROM cpu_load GROUP BY 1h
 	INTO daily.cpu_load
 
+The target series is stored in the shard space that matches its name so a
+"daily" shard space can be created for the results:
+
+	CREATE SHARD SPACE daily ON mydb MATCHING /^daily\./ RETENTION 365d
+
+The query is run each time a GROUP BY interval completes. In a cluster, only one
+server runs continuous queries at a time.
+
 If there is existing data on the source series then this query will be run for
 all historic data. To only execute the query on new incoming data you can append
 "NO BACKFILL" to the end of the query:
@@ -60,5 +150,19 @@ You can also list all continuous queries by running:
 
 	LIST CONTINUOUS QUERIES
 
+Keywords
+
+Keywords are case insensitive. The following keywords are reserved and must be
+double quoted to be used as series or field names:
+
+	ALTER AS ASC BY CONTINUOUS CREATE DELETE DESC DROP EXPLAIN FROM GRANT
+	GROUP HAVING INNER INTO JOIN LIMIT LIST MERGE ON ORDER QUERIES REVOKE
+	SELECT SERIES SET WHERE
+
+Other keywords, such as DURATION, FILL, READ, WRITE or USER, are identifiers
+wherever a statement doesn't expect them:
+
+	SELECT duration FROM jobs WHERE user = 'bob'
+
 */
 package influxql
@@ -0,0 +1,1400 @@
+package influxql
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DateFormat represents the format for date literals.
+	DateFormat = "2006-01-02"
+
+	// DateTimeFormat represents the format for date time literals.
+	DateTimeFormat = "2006-01-02 15:04:05.999999"
+)
+
+// ErrInvalidDuration is returned when parsing a malformed duration.
+var ErrInvalidDuration = errors.New("invalid duration")
+
+// Parser represents an InfluxQL parser.
+type Parser struct {
+	s   *bufScanner
+	pos Positions
+}
+
+// NewParser returns a new instance of Parser.
+func NewParser(r io.Reader) *Parser {
+	return &Parser{s: newBufScanner(r), pos: make(Positions)}
+}
+
+// Positions returns the positions of the nodes read by the parser.
+func (p *Parser) Positions() Positions { return p.pos }
+
+// Positions maps nodes to the position of their first token in the query.
+// Binary expressions are mapped to the position of their operator.
+type Positions map[Node]Pos
+
+// Pos returns the position of a node. Returns a zero position if unknown.
+func (m Positions) Pos(n Node) Pos {
+	switch n.(type) {
+	case Fields, Dimensions:
+		// Slices cannot be used as map keys.
+		return Pos{}
+	}
+	return m[n]
+}
+
+// ParseQuery parses a query string and returns its AST representation.
+func ParseQuery(s string) (Query, error) { return NewParser(strings.NewReader(s)).ParseQuery() }
+
+// ParseExpr parses an expression string and returns its AST representation.
+func ParseExpr(s string) (Expr, error) { return NewParser(strings.NewReader(s)).ParseExpr() }
+
+// ParseQuery parses a single query from the reader. The query may be
+// followed by an optional semicolon but nothing else.
+func (p *Parser) ParseQuery() (Query, error) {
+	q, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	// Allow an optional trailing semicolon.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != SEMICOLON {
+		p.unscan()
+	}
+
+	// The query must be followed by EOF.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EOF {
+		return nil, newParseError(tokstr(tok, lit), []string{"EOF"}, pos)
+	}
+
+	return q, nil
+}
+
+// parseQuery parses the query based on its leading keyword.
+func (p *Parser) parseQuery() (Query, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case SELECT:
+		return p.parseSelectQuery()
+	case DELETE:
+		return p.parseDeleteQuery()
+	case SET:
+		return p.parseSetPasswordQuery()
+	case GRANT:
+		return p.parseGrantQuery()
+	case REVOKE:
+		return p.parseRevokeQuery()
+	case LIST:
+		return p.parseListQuery()
+	case CREATE:
+		return p.parseCreateQuery()
+	case DROP:
+		return p.parseDropQuery()
+	case ALTER:
+		return p.parseAlterQuery()
+	default:
+		return nil, newParseError(tokstr(tok, lit), []string{"SELECT", "DELETE", "LIST", "CREATE", "DROP", "ALTER", "SET", "GRANT", "REVOKE"}, pos)
+	}
+}
+
+// parseListQuery parses a LIST query based on the object being listed.
+// This function assumes the LIST token has already been consumed.
+func (p *Parser) parseListQuery() (Query, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case DATABASES:
+		return &ListDatabasesQuery{}, nil
+	case SERIES:
+		return p.parseListSeriesQuery()
+	case FIELDS:
+		return p.parseListFieldsQuery()
+	case SHARD:
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != SPACES {
+			return nil, newParseError(tokstr(tok, lit), []string{"SPACES"}, pos)
+		}
+		return &ListShardSpacesQuery{}, nil
+	case SHARDS:
+		return &ListShardsQuery{}, nil
+	case USERS:
+		return &ListUsersQuery{}, nil
+	case CONTINUOUS:
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != QUERIES {
+			return nil, newParseError(tokstr(tok, lit), []string{"QUERIES"}, pos)
+		}
+		return &ListContinuousQueriesQuery{}, nil
+	default:
+		return nil, newParseError(tokstr(tok, lit), []string{"DATABASES", "SERIES", "FIELDS", "SHARD", "SHARDS", "USERS", "CONTINUOUS"}, pos)
+	}
+}
+
+// parseListSeriesQuery parses a list series query.
+// This function assumes the "LIST SERIES" tokens have already been consumed.
+func (p *Parser) parseListSeriesQuery() (*ListSeriesQuery, error) {
+	q := &ListSeriesQuery{}
+
+	// Parse optional "FROM REGEX".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != FROM {
+		p.unscan()
+		return q, nil
+	}
+	re, err := p.parseRegex()
+	if err != nil {
+		return nil, err
+	}
+	q.Regex = re
+
+	return q, nil
+}
+
+// parseListFieldsQuery parses a list fields query.
+// This function assumes the "LIST FIELDS" tokens have already been consumed.
+func (p *Parser) parseListFieldsQuery() (*ListFieldsQuery, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
+		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
+	}
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return &ListFieldsQuery{Series: name}, nil
+}
+
+// parseDropContinuousQueryQuery parses a drop continuous query query.
+// This function assumes the "DROP CONTINUOUS" tokens have already been consumed.
+func (p *Parser) parseDropContinuousQueryQuery() (*DropContinuousQueryQuery, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != QUERY {
+		return nil, newParseError(tokstr(tok, lit), []string{"QUERY"}, pos)
+	}
+
+	// Parse the query identifier.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != INTEGER {
+		return nil, newParseError(tokstr(tok, lit), []string{"number"}, pos)
+	}
+	id, err := strconv.ParseUint(lit, 10, 64)
+	if err != nil {
+		return nil, &ParseError{Message: "invalid continuous query id: " + lit, Pos: pos}
+	}
+
+	return &DropContinuousQueryQuery{ID: id}, nil
+}
+
+// parseDropSeriesQuery parses a drop series query.
+// This function assumes the "DROP SERIES" tokens have already been consumed.
+func (p *Parser) parseDropSeriesQuery() (*DropSeriesQuery, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return &DropSeriesQuery{Name: name}, nil
+}
+
+// parseCreateQuery parses a CREATE query based on the object being created.
+// This function assumes the CREATE token has already been consumed.
+func (p *Parser) parseCreateQuery() (Query, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case DATABASE:
+		return p.parseCreateDatabaseQuery()
+	case USER:
+		return p.parseCreateUserQuery()
+	case SHARD:
+		return p.parseCreateShardSpaceQuery()
+	default:
+		return nil, newParseError(tokstr(tok, lit), []string{"DATABASE", "USER", "SHARD"}, pos)
+	}
+}
+
+// parseDropQuery parses a DROP query based on the object being dropped.
+// This function assumes the DROP token has already been consumed.
+func (p *Parser) parseDropQuery() (Query, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case DATABASE:
+		return p.parseDropDatabaseQuery()
+	case USER:
+		return p.parseDropUserQuery()
+	case SHARD:
+		return p.parseDropShardSpaceQuery()
+	case CONTINUOUS:
+		return p.parseDropContinuousQueryQuery()
+	case SERIES:
+		return p.parseDropSeriesQuery()
+	default:
+		return nil, newParseError(tokstr(tok, lit), []string{"DATABASE", "USER", "SHARD", "CONTINUOUS", "SERIES"}, pos)
+	}
+}
+
+// parseAlterQuery parses an ALTER query based on the object being altered.
+// This function assumes the ALTER token has already been consumed.
+func (p *Parser) parseAlterQuery() (Query, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case SHARD:
+		return p.parseAlterShardSpaceQuery()
+	default:
+		return nil, newParseError(tokstr(tok, lit), []string{"SHARD"}, pos)
+	}
+}
+
+// parseCreateDatabaseQuery parses a create database query.
+// This function assumes the "CREATE DATABASE" tokens have already been consumed.
+func (p *Parser) parseCreateDatabaseQuery() (*CreateDatabaseQuery, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return &CreateDatabaseQuery{Name: name}, nil
+}
+
+// parseDropDatabaseQuery parses a drop database query.
+// This function assumes the "DROP DATABASE" tokens have already been consumed.
+func (p *Parser) parseDropDatabaseQuery() (*DropDatabaseQuery, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return &DropDatabaseQuery{Name: name}, nil
+}
+
+// parseCreateUserQuery parses a create user query.
+// This function assumes the "CREATE USER" tokens have already been consumed.
+func (p *Parser) parseCreateUserQuery() (*CreateUserQuery, error) {
+	q := &CreateUserQuery{}
+
+	// Parse the user name.
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	q.Name = name
+
+	// Parse "WITH PASSWORD STRING".
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != WITH {
+		return nil, newParseError(tokstr(tok, lit), []string{"WITH"}, pos)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != PASSWORD {
+		return nil, newParseError(tokstr(tok, lit), []string{"PASSWORD"}, pos)
+	}
+	password, err := p.parseString()
+	if err != nil {
+		return nil, err
+	}
+	q.Password = password
+
+	return q, nil
+}
+
+// parseSetPasswordQuery parses a set password query.
+// This function assumes the SET token has already been consumed.
+func (p *Parser) parseSetPasswordQuery() (*SetPasswordQuery, error) {
+	q := &SetPasswordQuery{}
+
+	// Parse "PASSWORD FOR IDENT".
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != PASSWORD {
+		return nil, newParseError(tokstr(tok, lit), []string{"PASSWORD"}, pos)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FOR {
+		return nil, newParseError(tokstr(tok, lit), []string{"FOR"}, pos)
+	}
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	q.Name = name
+
+	// Parse "= STRING".
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EQ {
+		return nil, newParseError(tokstr(tok, lit), []string{"="}, pos)
+	}
+	password, err := p.parseString()
+	if err != nil {
+		return nil, err
+	}
+	q.Password = password
+
+	return q, nil
+}
+
+// parseDropUserQuery parses a drop user query.
+// This function assumes the "DROP USER" tokens have already been consumed.
+func (p *Parser) parseDropUserQuery() (*DropUserQuery, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return &DropUserQuery{Name: name}, nil
+}
+
+// parseGrantQuery parses a grant query.
+// This function assumes the GRANT token has already been consumed.
+func (p *Parser) parseGrantQuery() (*GrantQuery, error) {
+	privilege, on, err := p.parsePrivilegeOn()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse "TO IDENT".
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != TO {
+		return nil, newParseError(tokstr(tok, lit), []string{"TO"}, pos)
+	}
+	user, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &GrantQuery{Privilege: privilege, On: on, User: user}, nil
+}
+
+// parseRevokeQuery parses a revoke query.
+// This function assumes the REVOKE token has already been consumed.
+func (p *Parser) parseRevokeQuery() (*RevokeQuery, error) {
+	privilege, on, err := p.parsePrivilegeOn()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse "FROM IDENT".
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
+		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
+	}
+	user, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RevokeQuery{Privilege: privilege, On: on, User: user}, nil
+}
+
+// parsePrivilegeOn parses the "READ|WRITE|ALL ON IDENT" portion of a grant
+// or revoke query.
+func (p *Parser) parsePrivilegeOn() (Privilege, string, error) {
+	var privilege Privilege
+	switch tok, pos, lit := p.scanIgnoreWhitespace(); tok {
+	case READ:
+		privilege = ReadPrivilege
+	case WRITE:
+		privilege = WritePrivilege
+	case ALL:
+		privilege = AllPrivileges
+	default:
+		return 0, "", newParseError(tokstr(tok, lit), []string{"READ", "WRITE", "ALL"}, pos)
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != ON {
+		return 0, "", newParseError(tokstr(tok, lit), []string{"ON"}, pos)
+	}
+	on, err := p.parseIdent()
+	if err != nil {
+		return 0, "", err
+	}
+
+	return privilege, on, nil
+}
+
+// parseCreateShardSpaceQuery parses a create shard space query.
+// This function assumes the "CREATE SHARD" tokens have already been consumed.
+func (p *Parser) parseCreateShardSpaceQuery() (*CreateShardSpaceQuery, error) {
+	name, database, err := p.parseShardSpaceName()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the optional settings. Unset values are left as zero.
+	opt := &AlterShardSpaceQuery{}
+	if err := p.parseShardSpaceOptions(opt, false); err != nil {
+		return nil, err
+	}
+
+	q := &CreateShardSpaceQuery{Name: name, Database: database, Regex: opt.Regex}
+	if opt.Duration != nil {
+		q.Duration = *opt.Duration
+	}
+	if opt.Retention != nil {
+		q.Retention = *opt.Retention
+	}
+	if opt.ReplicaN != nil {
+		q.ReplicaN = *opt.ReplicaN
+	}
+	if opt.SplitN != nil {
+		q.SplitN = *opt.SplitN
+	}
+	return q, nil
+}
+
+// parseAlterShardSpaceQuery parses an alter shard space query.
+// This function assumes the "ALTER SHARD" tokens have already been consumed.
+func (p *Parser) parseAlterShardSpaceQuery() (*AlterShardSpaceQuery, error) {
+	name, database, err := p.parseShardSpaceName()
+	if err != nil {
+		return nil, err
+	}
+
+	// At least one setting must be changed.
+	q := &AlterShardSpaceQuery{Name: name, Database: database}
+	if err := p.parseShardSpaceOptions(q, true); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// parseDropShardSpaceQuery parses a drop shard space query.
+// This function assumes the "DROP SHARD" tokens have already been consumed.
+func (p *Parser) parseDropShardSpaceQuery() (*DropShardSpaceQuery, error) {
+	name, database, err := p.parseShardSpaceName()
+	if err != nil {
+		return nil, err
+	}
+	return &DropShardSpaceQuery{Name: name, Database: database}, nil
+}
+
+// parseShardSpaceName parses the "SPACE IDENT ON IDENT" portion of a shard
+// space query and returns the space and database names.
+func (p *Parser) parseShardSpaceName() (name, database string, err error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != SPACE {
+		return "", "", newParseError(tokstr(tok, lit), []string{"SPACE"}, pos)
+	}
+	if name, err = p.parseIdent(); err != nil {
+		return "", "", err
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != ON {
+		return "", "", newParseError(tokstr(tok, lit), []string{"ON"}, pos)
+	}
+	if database, err = p.parseIdent(); err != nil {
+		return "", "", err
+	}
+
+	return name, database, nil
+}
+
+// parseShardSpaceOptions parses the settings of a shard space in any order.
+// If required is true then at least one setting must be present.
+func (p *Parser) parseShardSpaceOptions(q *AlterShardSpaceQuery, required bool) error {
+	for i := 0; ; i++ {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		switch tok {
+		case MATCHING:
+			re, err := p.parseRegex()
+			if err != nil {
+				return err
+			}
+			q.Regex = re
+
+		case DURATION, RETENTION:
+			tok0, pos, lit := p.scanIgnoreWhitespace()
+			if tok0 != DURATION_VAL {
+				return newParseError(tokstr(tok0, lit), []string{"duration"}, pos)
+			}
+			d, err := ParseDuration(lit)
+			if err != nil {
+				return &ParseError{Message: "unable to parse duration", Pos: pos}
+			}
+			if tok == DURATION {
+				if d <= 0 {
+					return &ParseError{Message: "invalid shard duration: " + lit, Pos: pos}
+				}
+				q.Duration = &d
+			} else {
+				q.Retention = &d
+			}
+
+		case REPLICATION, SPLIT:
+			tok0, pos, lit := p.scanIgnoreWhitespace()
+			if tok0 != INTEGER {
+				return newParseError(tokstr(tok0, lit), []string{"number"}, pos)
+			}
+			n, err := strconv.Atoi(lit)
+			if err != nil || n <= 0 {
+				return &ParseError{Message: fmt.Sprintf("invalid %s: %s", strings.ToLower(tok.String()), lit), Pos: pos}
+			}
+			if tok == REPLICATION {
+				q.ReplicaN = &n
+			} else {
+				q.SplitN = &n
+			}
+
+		default:
+			if required && i == 0 {
+				return newParseError(tokstr(tok, lit), []string{"MATCHING", "DURATION", "RETENTION", "REPLICATION", "SPLIT"}, pos)
+			}
+			p.unscan()
+			return nil
+		}
+	}
+}
+
+// parseRegex parses a regular expression delimited by forward slashes.
+func (p *Parser) parseRegex() (*regexp.Regexp, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != DIV {
+		return nil, newParseError(tokstr(tok, lit), []string{"regex"}, pos)
+	}
+	if tok, pos, lit = p.s.ScanRegex(); tok != REGEX {
+		return nil, &ParseError{Message: "unterminated regex", Pos: pos}
+	}
+	re, err := regexp.Compile(lit)
+	if err != nil {
+		return nil, &ParseError{Message: "invalid regex: " + err.Error(), Pos: pos}
+	}
+	return re, nil
+}
+
+// parseIdent parses a single identifier.
+func (p *Parser) parseIdent() (string, error) {
+	tok, pos, lit := p.scanIdent()
+	if tok != IDENT {
+		return "", newParseError(tokstr(tok, lit), []string{"identifier"}, pos)
+	}
+	return lit, nil
+}
+
+// parseString parses a single string literal.
+func (p *Parser) parseString() (string, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != STRING {
+		return "", newParseError(tokstr(tok, lit), []string{"string"}, pos)
+	}
+	return lit, nil
+}
+
+// parseSelectQuery parses a select query.
+// This function assumes the SELECT token has already been consumed.
+func (p *Parser) parseSelectQuery() (*SelectQuery, error) {
+	q := &SelectQuery{}
+
+	// Parse fields: "SELECT FIELD+".
+	fields, err := p.parseFields()
+	if err != nil {
+		return nil, err
+	}
+	q.Fields = fields
+
+	// Parse source.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
+		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
+	}
+	source, err := p.parseSource()
+	if err != nil {
+		return nil, err
+	}
+	q.Source = source
+
+	// Parse condition: "WHERE EXPR".
+	condition, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	q.Condition = condition
+
+	// Parse dimensions: "GROUP BY DIMENSION+".
+	dimensions, err := p.parseDimensions()
+	if err != nil {
+		return nil, err
+	}
+	q.Dimensions = dimensions
+
+	// Parse fill and time zone in either order:
+	// "FILL(null|none|previous|linear|NUMBER|STRING)" and "TZ(STRING)".
+	if len(dimensions) > 0 {
+		if err := p.parseGroupByOptions(q); err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse having: "HAVING EXPR".
+	having, err := p.parseHaving()
+	if err != nil {
+		return nil, err
+	}
+	q.Having = having
+
+	// Parse limit: "LIMIT INTEGER".
+	limit, err := p.parseLimit()
+	if err != nil {
+		return nil, err
+	}
+	q.Limit = limit
+
+	// Parse ordering: "ORDER ASC|DESC".
+	ascending, err := p.parseOrder()
+	if err != nil {
+		return nil, err
+	}
+	q.Ascending = ascending
+
+	// Parse target: "INTO IDENT [NO BACKFILL]".
+	if err := p.parseTarget(q); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// parseTarget parses the "INTO" clause of a continuous query, if it exists.
+func (p *Parser) parseTarget(q *SelectQuery) error {
+	// Check if the INTO token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != INTO {
+		p.unscan()
+		return nil
+	}
+
+	// Parse the target series name.
+	target, err := p.parseIdent()
+	if err != nil {
+		return err
+	}
+	q.Target = target
+
+	// Parse the optional "NO BACKFILL".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != NO {
+		p.unscan()
+		return nil
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != BACKFILL {
+		return newParseError(tokstr(tok, lit), []string{"BACKFILL"}, pos)
+	}
+	q.NoBackfill = true
+
+	return nil
+}
+
+// parseDeleteQuery parses a delete query.
+// This function assumes the DELETE token has already been consumed.
+func (p *Parser) parseDeleteQuery() (*DeleteQuery, error) {
+	q := &DeleteQuery{}
+
+	// Parse source.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
+		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
+	}
+	source, err := p.parseSource()
+	if err != nil {
+		return nil, err
+	}
+	q.Source = source
+
+	// Parse condition: "WHERE EXPR".
+	condition, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	q.Condition = condition
+
+	return q, nil
+}
+
+// parseFields parses a list of one or more fields.
+func (p *Parser) parseFields() (Fields, error) {
+	var fields Fields
+
+	// Check for "*" (i.e., "all fields")
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == MUL {
+		fields = append(fields, &Field{Expr: &Wildcard{}})
+		return fields, nil
+	}
+	p.unscan()
+
+	for {
+		// Parse the field.
+		f, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+
+		// Add new field.
+		fields = append(fields, f)
+
+		// If there's not a comma next then stop parsing fields.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+	return fields, nil
+}
+
+// parseField parses a single field.
+func (p *Parser) parseField() (*Field, error) {
+	f := &Field{}
+
+	// Parse the expression first.
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	f.Expr = expr
+	p.pos[f] = pos
+
+	// Parse the alias if the current and next tokens are "AS IDENT".
+	alias, err := p.parseAlias()
+	if err != nil {
+		return nil, err
+	}
+	f.Alias = alias
+
+	return f, nil
+}
+
+// parseAlias parses the "AS (IDENT|STRING)" alias for fields and dimensions.
+// Returns a blank string if no alias is present.
+func (p *Parser) parseAlias() (string, error) {
+	// Check if the next token is "AS". If not, then unscan and exit.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != AS {
+		p.unscan()
+		return "", nil
+	}
+
+	// Then we should have the alias identifier.
+	tok, pos, lit := p.scanIdent()
+	if tok != IDENT && tok != STRING {
+		return "", newParseError(tokstr(tok, lit), []string{"identifier"}, pos)
+	}
+	return lit, nil
+}
+
+// parseSource parses the "FROM" clause of the query.
+func (p *Parser) parseSource() (Join, error) {
+	// Read the first series.
+	lhs, err := p.parseSeries()
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine the type of join from the next token.
+	switch tok, _, _ := p.scanIgnoreWhitespace(); tok {
+	case INNER:
+		// Parse "INNER JOIN SERIES [ON EXPR]".
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != JOIN {
+			return nil, newParseError(tokstr(tok, lit), []string{"JOIN"}, pos)
+		}
+		rhs, err := p.parseSeries()
+		if err != nil {
+			return nil, err
+		}
+		cond, err := p.parseJoinCondition()
+		if err != nil {
+			return nil, err
+		}
+		return &InnerJoin{LHS: lhs, RHS: rhs, Condition: cond}, nil
+
+	case MERGE:
+		// Parse "MERGE SERIES [MERGE SERIES]* [ON EXPR]".
+		j := &MergeJoin{Sources: []*Series{lhs}}
+		for {
+			s, err := p.parseSeries()
+			if err != nil {
+				return nil, err
+			}
+			j.Sources = append(j.Sources, s)
+
+			if tok, _, _ := p.scanIgnoreWhitespace(); tok != MERGE {
+				p.unscan()
+				break
+			}
+		}
+		if j.Condition, err = p.parseJoinCondition(); err != nil {
+			return nil, err
+		}
+		return j, nil
+
+	case COMMA:
+		// Parse "SERIES [, SERIES]*".
+		j := &ImplicitJoin{Sources: []*Series{lhs}}
+		for {
+			s, err := p.parseSeries()
+			if err != nil {
+				return nil, err
+			}
+			j.Sources = append(j.Sources, s)
+
+			if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+				p.unscan()
+				break
+			}
+		}
+		return j, nil
+
+	default:
+		p.unscan()
+		return &ImplicitJoin{Sources: []*Series{lhs}}, nil
+	}
+}
+
+// parseSeries parses a series name or regex with an optional alias.
+func (p *Parser) parseSeries() (*Series, error) {
+	s := &Series{}
+
+	// Read the series name or regex.
+	tok, pos, lit := p.scanIdent()
+	p.pos[s] = pos
+	switch tok {
+	case IDENT:
+		s.Name = lit
+	case DIV:
+		p.unscan()
+		re, err := p.parseRegex()
+		if err != nil {
+			return nil, err
+		}
+		s.Regex = re
+	default:
+		return nil, newParseError(tokstr(tok, lit), []string{"identifier", "regex"}, pos)
+	}
+
+	// Read an alias in the form of "[AS] IDENT".
+	switch tok, pos, lit := p.scanIgnoreWhitespace(); tok {
+	case AS:
+		if tok, pos, lit = p.scanIdent(); tok != IDENT {
+			return nil, newParseError(tokstr(tok, lit), []string{"identifier"}, pos)
+		}
+		s.Alias = lit
+	case IDENT:
+		s.Alias = lit
+	default:
+		p.unscan()
+	}
+
+	return s, nil
+}
+
+// parseJoinCondition parses the optional "ON EXPR" condition of a join.
+func (p *Parser) parseJoinCondition() (Expr, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != ON {
+		p.unscan()
+		return nil, nil
+	}
+	return p.ParseExpr()
+}
+
+// parseCondition parses the "WHERE" clause of the query, if it exists.
+func (p *Parser) parseCondition() (Expr, error) {
+	// Check if the WHERE token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != WHERE {
+		p.unscan()
+		return nil, nil
+	}
+
+	// Scan the identifier for the source.
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	return expr, nil
+}
+
+// parseHaving parses the "HAVING" clause of the query, if it exists.
+func (p *Parser) parseHaving() (Expr, error) {
+	// Check if the HAVING token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != HAVING {
+		p.unscan()
+		return nil, nil
+	}
+	return p.ParseExpr()
+}
+
+// parseDimensions parses the "GROUP BY" clause of the query, if it exists.
+func (p *Parser) parseDimensions() (Dimensions, error) {
+	// If the next token is not GROUP then exit.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != GROUP {
+		p.unscan()
+		return nil, nil
+	}
+
+	// Now the next token should be "BY".
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != BY {
+		return nil, newParseError(tokstr(tok, lit), []string{"BY"}, pos)
+	}
+
+	var dimensions Dimensions
+	for {
+		// Parse the dimension.
+		d, err := p.parseDimension()
+		if err != nil {
+			return nil, err
+		}
+
+		// Add new dimension.
+		dimensions = append(dimensions, d)
+
+		// If there's not a comma next then stop parsing dimensions.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+	return dimensions, nil
+}
+
+// parseDimension parses a single dimension.
+func (p *Parser) parseDimension() (*Dimension, error) {
+	// Parse the expression first.
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the alias if the current and next tokens are "AS IDENT".
+	alias, err := p.parseAlias()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dimension{Expr: expr, Alias: alias}
+	p.pos[d] = pos
+	return d, nil
+}
+
+// parseGroupByOptions parses the "FILL" and "TZ" options of the "GROUP BY"
+// clause. Each option may appear once, in any order.
+func (p *Parser) parseGroupByOptions(q *SelectQuery) error {
+	var fill, tz bool
+	for {
+		tok, _, _ := p.scanIgnoreWhitespace()
+		p.unscan()
+
+		switch {
+		case tok == FILL && !fill:
+			fill = true
+			if err := p.parseFill(q); err != nil {
+				return err
+			}
+		case tok == TZ && !tz:
+			tz = true
+			if err := p.parseLocation(q); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// parseFill parses the "FILL" option of the "GROUP BY" clause, if it exists.
+func (p *Parser) parseFill(q *SelectQuery) error {
+	// Check if the FILL token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != FILL {
+		p.unscan()
+		return nil
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+
+	// Read the fill option or value.
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return err
+	}
+	switch expr := expr.(type) {
+	case *VarRef:
+		switch strings.ToLower(expr.Val) {
+		case "null":
+			q.Fill = NullFill
+		case "none":
+			q.Fill = NoFill
+		case "previous":
+			q.Fill = PreviousFill
+		case "linear":
+			q.Fill = LinearFill
+		default:
+			return newParseError(expr.Val, []string{"null", "none", "previous", "linear", "number", "string"}, pos)
+		}
+	case *IntegerLiteral, *FloatLiteral, *StringLiteral:
+		q.Fill, q.FillValue = NumberFill, expr
+	default:
+		return newParseError(expr.String(), []string{"null", "none", "previous", "linear", "number", "string"}, pos)
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return nil
+}
+
+// parseLocation parses the "TZ" option of the "GROUP BY" clause, if it exists.
+func (p *Parser) parseLocation(q *SelectQuery) error {
+	// Check if the TZ token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != TZ {
+		p.unscan()
+		return nil
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+
+	// Read the time zone name and load its location.
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+	name, err := p.parseString()
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return &ParseError{Message: "unknown time zone: " + name, Pos: pos}
+	}
+	q.Location = loc
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return nil
+}
+
+// parseLimit parses the "LIMIT" clause of the query, if it exists.
+func (p *Parser) parseLimit() (int, error) {
+	// Check if the LIMIT token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != LIMIT {
+		p.unscan()
+		return 0, nil
+	}
+
+	// Scan the limit number.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != INTEGER {
+		return 0, newParseError(tokstr(tok, lit), []string{"number"}, pos)
+	}
+
+	// Parse number.
+	n, err := strconv.Atoi(lit)
+	if err != nil || n < 0 {
+		return 0, &ParseError{Message: "invalid limit: " + lit, Pos: pos}
+	}
+
+	return n, nil
+}
+
+// parseOrder parses the "ORDER" clause of the query, if it exists.
+// Returns true if the ordering is ascending. The default order is descending.
+func (p *Parser) parseOrder() (bool, error) {
+	// Check if the ORDER token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != ORDER {
+		p.unscan()
+		return false, nil
+	}
+
+	// Then read the direction.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case ASC:
+		return true, nil
+	case DESC:
+		return false, nil
+	default:
+		return false, newParseError(tokstr(tok, lit), []string{"ASC", "DESC"}, pos)
+	}
+}
+
+// ParseExpr parses an expression.
+func (p *Parser) ParseExpr() (Expr, error) {
+	return p.parseBinaryExpr(1)
+}
+
+// parseBinaryExpr parses a binary expression using precedence climbing.
+// Only operators with a precedence of at least minPrec are consumed.
+// Operators of equal precedence are left-associative.
+func (p *Parser) parseBinaryExpr(minPrec int) (Expr, error) {
+	// Parse a non-binary expression type to start.
+	expr, err := p.parseUnaryExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		// If the next token is not an operator of a high enough precedence
+		// then return the expression built so far.
+		op, pos, _ := p.scanIgnoreWhitespace()
+		if !op.IsOperator() || op.Precedence() < minPrec {
+			p.unscan()
+			return expr, nil
+		}
+
+		// Otherwise parse the right-hand side with a higher minimum precedence.
+		rhs, err := p.parseBinaryExpr(op.Precedence() + 1)
+		if err != nil {
+			return nil, err
+		}
+		expr = &BinaryExpr{Op: op, LHS: expr, RHS: rhs}
+		p.pos[expr] = pos
+	}
+}
+
+// parseUnaryExpr parses an non-binary expression and records its position.
+func (p *Parser) parseUnaryExpr() (Expr, error) {
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+
+	expr, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parenthesized expressions keep the position of their inner expression.
+	if _, ok := p.pos[expr]; !ok {
+		p.pos[expr] = pos
+	}
+	return expr, nil
+}
+
+// parseOperand parses a non-binary expression.
+func (p *Parser) parseOperand() (Expr, error) {
+	// If we have a parenthesis then parse the inner expression.
+	tok, pos, lit := p.scanIdent()
+	switch tok {
+	case LPAREN:
+		expr, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		// Expect an RPAREN at the end.
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+			return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+		}
+		return expr, nil
+
+	case IDENT:
+		// If the next immediate token is a left parentheses then parse as function call.
+		// Otherwise parse as a variable reference.
+		if tok0, _, _ := p.scan(); tok0 == LPAREN {
+			return p.parseCall(lit)
+		}
+		p.unscan()
+		return &VarRef{Val: lit}, nil
+
+	case STRING:
+		// If literal looks like a date time then parse it as a time literal.
+		if t, ok := parseTimeLiteral(lit); ok {
+			return &TimeLiteral{Val: t}, nil
+		}
+		return &StringLiteral{Val: lit}, nil
+
+	case INTEGER:
+		v, err := strconv.ParseInt(lit, 10, 64)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse integer", Pos: pos}
+		}
+		return &IntegerLiteral{Val: v}, nil
+
+	case FLOAT:
+		v, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse float", Pos: pos}
+		}
+		return &FloatLiteral{Val: v}, nil
+
+	case DURATION_VAL:
+		v, err := ParseDuration(lit)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse duration", Pos: pos}
+		}
+		return &DurationLiteral{Val: v}, nil
+
+	case TRUE, FALSE:
+		return &BooleanLiteral{Val: (tok == TRUE)}, nil
+
+	case SUB:
+		// Negate a numeric literal.
+		expr, err := p.parseUnaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		switch lit := expr.(type) {
+		case *IntegerLiteral:
+			lit.Val = -lit.Val
+		case *FloatLiteral:
+			lit.Val = -lit.Val
+		case *DurationLiteral:
+			lit.Val = -lit.Val
+		default:
+			return nil, &ParseError{Message: "unary minus requires a numeric literal", Pos: pos}
+		}
+		p.pos[expr] = pos
+		return expr, nil
+
+	default:
+		return nil, newParseError(tokstr(tok, lit), []string{"identifier", "string", "number", "bool"}, pos)
+	}
+}
+
+// parseCall parses a function call.
+// This function assumes the function name and LPAREN have been consumed.
+func (p *Parser) parseCall(name string) (*Call, error) {
+	c := &Call{Name: name}
+
+	// If there's a right paren then just return immediately.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == RPAREN {
+		return c, nil
+	}
+	p.unscan()
+
+	// Otherwise parse function call arguments.
+	for {
+		// Parse a wildcard or an expression.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok == MUL {
+			c.Args = append(c.Args, &Wildcard{})
+		} else {
+			p.unscan()
+			expr, err := p.ParseExpr()
+			if err != nil {
+				return nil, err
+			}
+			c.Args = append(c.Args, expr)
+		}
+
+		// If there's not a comma next then stop parsing arguments.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+
+	// There should be a right parentheses at the end.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+
+	return c, nil
+}
+
+// scan returns the next token from the underlying scanner.
+func (p *Parser) scan() (tok Token, pos Pos, lit string) { return p.s.Scan() }
+
+// scanIgnoreWhitespace scans the next non-whitespace token.
+func (p *Parser) scanIgnoreWhitespace() (tok Token, pos Pos, lit string) {
+	tok, pos, lit = p.scan()
+	if tok == WS {
+		tok, pos, lit = p.scan()
+	}
+	return
+}
+
+// scanIdent scans the next non-whitespace token and returns keywords that
+// aren't reserved as identifiers.
+func (p *Parser) scanIdent() (tok Token, pos Pos, lit string) {
+	tok, pos, lit = p.scanIgnoreWhitespace()
+	if tok.IsKeyword() && !tok.IsReserved() {
+		tok = IDENT
+	}
+	return
+}
+
+// unscan pushes the previously read token back onto the buffer.
+func (p *Parser) unscan() { p.s.Unscan() }
+
+// parseTimeLiteral parses a string as a point in time.
+// Returns false if the string does not match a supported time format.
+func parseTimeLiteral(s string) (time.Time, bool) {
+	for _, layout := range []string{DateTimeFormat, DateFormat, time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// durationRegexp matches a number followed by a duration unit.
+var durationRegexp = regexp.MustCompile(`^(\d*\.?\d*)(u|µ|ms|s|m|h|d|w)$`)
+
+// ParseDuration parses a time duration from a string.
+// Supported units are u (or µ), ms, s, m, h, d and w.
+func ParseDuration(s string) (time.Duration, error) {
+	m := durationRegexp.FindStringSubmatch(s)
+	if m == nil || m[1] == "" || m[1] == "." {
+		return 0, ErrInvalidDuration
+	}
+
+	// Parse the numeric portion.
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, ErrInvalidDuration
+	}
+
+	// Multiply by the unit.
+	var unit time.Duration
+	switch m[2] {
+	case "u", "µ":
+		unit = time.Microsecond
+	case "ms":
+		unit = time.Millisecond
+	case "s":
+		unit = time.Second
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	case "d":
+		unit = 24 * time.Hour
+	case "w":
+		unit = 7 * 24 * time.Hour
+	}
+	return time.Duration(n * float64(unit)), nil
+}
+
+// FormatDuration formats a duration to a string using the largest unit
+// that represents it exactly.
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0s"
+	case d%(7*24*time.Hour) == 0:
+		return fmt.Sprintf("%dw", d/(7*24*time.Hour))
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	case d%time.Millisecond == 0:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	case d%time.Microsecond == 0:
+		return fmt.Sprintf("%du", d/time.Microsecond)
+	default:
+		return strconv.FormatFloat(float64(d)/float64(time.Microsecond), 'f', -1, 64) + "u"
+	}
+}
+
+// tokstr returns a literal if provided, otherwise returns the token string.
+func tokstr(tok Token, lit string) string {
+	if lit != "" {
+		return lit
+	}
+	return tok.String()
+}
+
+// ParseError represents an error that occurred during parsing.
+type ParseError struct {
+	Message  string
+	Found    string
+	Expected []string
+	Pos      Pos
+}
+
+// newParseError returns a new instance of ParseError.
+func newParseError(found string, expected []string, pos Pos) *ParseError {
+	return &ParseError{Found: found, Expected: expected, Pos: pos}
+}
+
+// Error returns the string representation of the error.
+func (e *ParseError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s at line %d, char %d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
+	}
+	return fmt.Sprintf("found %s, expected %s at line %d, char %d", e.Found, strings.Join(e.Expected, ", "), e.Pos.Line+1, e.Pos.Char+1)
+}
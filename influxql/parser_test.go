@@ -0,0 +1,641 @@
+package influxql_test
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure the parser can parse a multi-statement query.
+func TestParser_ParseQuery(t *testing.T) {
+	var tests = []struct {
+		s     string
+		query influxql.Query
+		err   string
+	}{
+		// SELECT * statement
+		{
+			s: `SELECT * FROM myseries`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.Wildcard{}}},
+				Source: &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "myseries"}}},
+			},
+		},
+
+		// SELECT statement
+		{
+			s: `SELECT field1, count(field2) AS total FROM myseries WHERE host = 'hosta.influxdb.org' GROUP BY time(10m), region LIMIT 20 ORDER ASC;`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{
+					&influxql.Field{Expr: &influxql.VarRef{Val: "field1"}},
+					&influxql.Field{Expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.VarRef{Val: "field2"}}}, Alias: "total"},
+				},
+				Source: &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "myseries"}}},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "host"},
+					RHS: &influxql.StringLiteral{Val: "hosta.influxdb.org"},
+				},
+				Dimensions: influxql.Dimensions{
+					&influxql.Dimension{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: 10 * time.Minute}}}},
+					&influxql.Dimension{Expr: &influxql.VarRef{Val: "region"}},
+				},
+				Limit:     20,
+				Ascending: true,
+			},
+		},
+
+		// SELECT with keywords that aren't reserved as identifiers.
+		{
+			s: `SELECT duration, read AS write FROM user WHERE to = 'x' GROUP BY all`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{
+					&influxql.Field{Expr: &influxql.VarRef{Val: "duration"}},
+					&influxql.Field{Expr: &influxql.VarRef{Val: "read"}, Alias: "write"},
+				},
+				Source: &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "user"}}},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "to"},
+					RHS: &influxql.StringLiteral{Val: "x"},
+				},
+				Dimensions: influxql.Dimensions{&influxql.Dimension{Expr: &influxql.VarRef{Val: "all"}}},
+			},
+		},
+
+		// SELECT with a count of all points and a time range.
+		{
+			s: `select count(*) from cpu where time > '2000-01-01 00:00:00' and time < now() - 1h`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.Wildcard{}}}}},
+				Source: &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "cpu"}}},
+				Condition: &influxql.BinaryExpr{
+					Op: influxql.AND,
+					LHS: &influxql.BinaryExpr{
+						Op:  influxql.GT,
+						LHS: &influxql.VarRef{Val: "time"},
+						RHS: &influxql.TimeLiteral{Val: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)},
+					},
+					RHS: &influxql.BinaryExpr{
+						Op:  influxql.LT,
+						LHS: &influxql.VarRef{Val: "time"},
+						RHS: &influxql.BinaryExpr{
+							Op:  influxql.SUB,
+							LHS: &influxql.Call{Name: "now"},
+							RHS: &influxql.DurationLiteral{Val: time.Hour},
+						},
+					},
+				},
+			},
+		},
+
+		// SELECT from a regex.
+		{
+			s: `SELECT value FROM /^cpu\./ AS c`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "value"}}},
+				Source: &influxql.ImplicitJoin{Sources: []*influxql.Series{{Regex: regexp.MustCompile(`^cpu\.`), Alias: "c"}}},
+			},
+		},
+
+		// SELECT from an implicit join.
+		{
+			s: `SELECT value FROM a, b x`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "value"}}},
+				Source: &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "a"}, {Name: "b", Alias: "x"}}},
+			},
+		},
+
+		// SELECT from an inner join.
+		{
+			s: `SELECT x.value FROM a AS x INNER JOIN b AS y ON x.value > y.value`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "x.value"}}},
+				Source: &influxql.InnerJoin{
+					LHS: &influxql.Series{Name: "a", Alias: "x"},
+					RHS: &influxql.Series{Name: "b", Alias: "y"},
+					Condition: &influxql.BinaryExpr{
+						Op:  influxql.GT,
+						LHS: &influxql.VarRef{Val: "x.value"},
+						RHS: &influxql.VarRef{Val: "y.value"},
+					},
+				},
+			},
+		},
+
+		// SELECT from a merge join.
+		{
+			s: `SELECT value FROM a MERGE b MERGE c`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "value"}}},
+				Source: &influxql.MergeJoin{Sources: []*influxql.Series{{Name: "a"}, {Name: "b"}, {Name: "c"}}},
+			},
+		},
+
+		// SELECT with a duration group by and descending order.
+		{
+			s: `SELECT mean(value) FROM cpu GROUP BY 1h ORDER DESC`,
+			query: &influxql.SelectQuery{
+				Fields:     influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "mean", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}}},
+				Source:     &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "cpu"}}},
+				Dimensions: influxql.Dimensions{&influxql.Dimension{Expr: &influxql.DurationLiteral{Val: time.Hour}}},
+			},
+		},
+
+		// SELECT with fill options for empty time buckets.
+		{
+			s: `SELECT mean(value) FROM cpu GROUP BY time(1h), host FILL(previous) ORDER ASC`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "mean", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}}},
+				Source: &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "cpu"}}},
+				Dimensions: influxql.Dimensions{
+					&influxql.Dimension{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: time.Hour}}}},
+					&influxql.Dimension{Expr: &influxql.VarRef{Val: "host"}},
+				},
+				Fill:      influxql.PreviousFill,
+				Ascending: true,
+			},
+		},
+		{
+			s: `SELECT count(value) FROM cpu GROUP BY 1h fill(-1)`,
+			query: &influxql.SelectQuery{
+				Fields:     influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}}},
+				Source:     &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "cpu"}}},
+				Dimensions: influxql.Dimensions{&influxql.Dimension{Expr: &influxql.DurationLiteral{Val: time.Hour}}},
+				Fill:       influxql.NumberFill,
+				FillValue:  &influxql.IntegerLiteral{Val: -1},
+			},
+		},
+		{
+			s: `SELECT count(value) FROM cpu GROUP BY 1h FILL('n/a')`,
+			query: &influxql.SelectQuery{
+				Fields:     influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}}},
+				Source:     &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "cpu"}}},
+				Dimensions: influxql.Dimensions{&influxql.Dimension{Expr: &influxql.DurationLiteral{Val: time.Hour}}},
+				Fill:       influxql.NumberFill,
+				FillValue:  &influxql.StringLiteral{Val: "n/a"},
+			},
+		},
+
+		// SELECT with time buckets shifted by an offset and aligned to a time zone.
+		{
+			s: `SELECT count(value) FROM cpu GROUP BY time(1d, 6h) FILL(0) TZ('America/New_York')`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}}},
+				Source: &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "cpu"}}},
+				Dimensions: influxql.Dimensions{
+					&influxql.Dimension{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: 24 * time.Hour}, &influxql.DurationLiteral{Val: 6 * time.Hour}}}},
+				},
+				Fill:      influxql.NumberFill,
+				FillValue: &influxql.IntegerLiteral{Val: 0},
+				Location:  mustLoadLocation("America/New_York"),
+			},
+		},
+		{
+			s: `SELECT count(value) FROM cpu GROUP BY 1h TZ('UTC') FILL(previous)`,
+			query: &influxql.SelectQuery{
+				Fields:     influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}}},
+				Source:     &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "cpu"}}},
+				Dimensions: influxql.Dimensions{&influxql.Dimension{Expr: &influxql.DurationLiteral{Val: time.Hour}}},
+				Fill:       influxql.PreviousFill,
+				Location:   mustLoadLocation("UTC"),
+			},
+		},
+		{
+			s: `SELECT count(value) FROM cpu GROUP BY 1h FILL(none)`,
+			query: &influxql.SelectQuery{
+				Fields:     influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}}},
+				Source:     &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "cpu"}}},
+				Dimensions: influxql.Dimensions{&influxql.Dimension{Expr: &influxql.DurationLiteral{Val: time.Hour}}},
+			},
+		},
+
+		// SELECT with a condition on the aggregated rows.
+		{
+			s: `SELECT host, mean(cpu) FROM load GROUP BY host, time(5m) HAVING mean(cpu) > 80 LIMIT 10`,
+			query: &influxql.SelectQuery{
+				Fields: influxql.Fields{
+					&influxql.Field{Expr: &influxql.VarRef{Val: "host"}},
+					&influxql.Field{Expr: &influxql.Call{Name: "mean", Args: []influxql.Expr{&influxql.VarRef{Val: "cpu"}}}},
+				},
+				Source: &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "load"}}},
+				Dimensions: influxql.Dimensions{
+					&influxql.Dimension{Expr: &influxql.VarRef{Val: "host"}},
+					&influxql.Dimension{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: 5 * time.Minute}}}},
+				},
+				Having: &influxql.BinaryExpr{
+					Op:  influxql.GT,
+					LHS: &influxql.Call{Name: "mean", Args: []influxql.Expr{&influxql.VarRef{Val: "cpu"}}},
+					RHS: &influxql.IntegerLiteral{Val: 80},
+				},
+				Limit: 10,
+			},
+		},
+
+		// SELECT INTO statement
+		{
+			s: `SELECT mean(value) AS value FROM cpu_load GROUP BY 1h INTO daily.cpu_load NO BACKFILL`,
+			query: &influxql.SelectQuery{
+				Fields:     influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "mean", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}, Alias: "value"}},
+				Source:     &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "cpu_load"}}},
+				Dimensions: influxql.Dimensions{&influxql.Dimension{Expr: &influxql.DurationLiteral{Val: time.Hour}}},
+				Target:     "daily.cpu_load",
+				NoBackfill: true,
+			},
+		},
+
+		// DELETE statement
+		{
+			s: `DELETE FROM myseries WHERE host = 'hosta.influxdb.org'`,
+			query: &influxql.DeleteQuery{
+				Source: &influxql.ImplicitJoin{Sources: []*influxql.Series{{Name: "myseries"}}},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "host"},
+					RHS: &influxql.StringLiteral{Val: "hosta.influxdb.org"},
+				},
+			},
+		},
+
+		// CREATE DATABASE statement
+		{
+			s:     `CREATE DATABASE testdb`,
+			query: &influxql.CreateDatabaseQuery{Name: "testdb"},
+		},
+
+		// DROP DATABASE statement
+		{
+			s:     `DROP DATABASE testdb`,
+			query: &influxql.DropDatabaseQuery{Name: "testdb"},
+		},
+
+		// CREATE USER statement
+		{
+			s:     `CREATE USER testuser WITH PASSWORD 'pwd1337'`,
+			query: &influxql.CreateUserQuery{Name: "testuser", Password: "pwd1337"},
+		},
+
+		// SET PASSWORD statement
+		{
+			s:     `SET PASSWORD FOR testuser = 'pwd1337'`,
+			query: &influxql.SetPasswordQuery{Name: "testuser", Password: "pwd1337"},
+		},
+
+		// DROP USER statement
+		{
+			s:     `DROP USER testuser`,
+			query: &influxql.DropUserQuery{Name: "testuser"},
+		},
+		{
+			s:     `DROP USER User`,
+			query: &influxql.DropUserQuery{Name: "User"},
+		},
+
+		// GRANT statements
+		{
+			s:     `GRANT READ ON testdb TO testuser`,
+			query: &influxql.GrantQuery{Privilege: influxql.ReadPrivilege, On: "testdb", User: "testuser"},
+		},
+		{
+			s:     `GRANT ALL ON testdb TO testuser`,
+			query: &influxql.GrantQuery{Privilege: influxql.AllPrivileges, On: "testdb", User: "testuser"},
+		},
+
+		// REVOKE statement
+		{
+			s:     `REVOKE WRITE ON testdb FROM testuser`,
+			query: &influxql.RevokeQuery{Privilege: influxql.WritePrivilege, On: "testdb", User: "testuser"},
+		},
+
+		// CREATE SHARD SPACE statement
+		{
+			s: `CREATE SHARD SPACE raw ON mydb MATCHING /^cpu\./ DURATION 7d RETENTION 90d REPLICATION 2 SPLIT 4`,
+			query: &influxql.CreateShardSpaceQuery{
+				Name:      "raw",
+				Database:  "mydb",
+				Regex:     regexp.MustCompile(`^cpu\.`),
+				Duration:  7 * 24 * time.Hour,
+				Retention: 90 * 24 * time.Hour,
+				ReplicaN:  2,
+				SplitN:    4,
+			},
+		},
+
+		// CREATE SHARD SPACE statement with defaults
+		{
+			s:     `CREATE SHARD SPACE raw ON mydb`,
+			query: &influxql.CreateShardSpaceQuery{Name: "raw", Database: "mydb"},
+		},
+
+		// ALTER SHARD SPACE statement
+		{
+			s: `ALTER SHARD SPACE raw ON mydb RETENTION 30d SPLIT 2`,
+			query: &influxql.AlterShardSpaceQuery{
+				Name:      "raw",
+				Database:  "mydb",
+				Retention: durationptr(30 * 24 * time.Hour),
+				SplitN:    intptr(2),
+			},
+		},
+
+		// DROP SHARD SPACE statement
+		{
+			s:     `DROP SHARD SPACE raw ON mydb`,
+			query: &influxql.DropShardSpaceQuery{Name: "raw", Database: "mydb"},
+		},
+
+		// Continuous query statements
+		{s: `LIST CONTINUOUS QUERIES`, query: &influxql.ListContinuousQueriesQuery{}},
+		{s: `DROP CONTINUOUS QUERY 12`, query: &influxql.DropContinuousQueryQuery{ID: 12}},
+
+		// DROP SERIES statement
+		{s: `DROP SERIES cpu_load`, query: &influxql.DropSeriesQuery{Name: "cpu_load"}},
+
+		// LIST statements
+		{s: `LIST DATABASES`, query: &influxql.ListDatabasesQuery{}},
+		{s: `LIST SERIES`, query: &influxql.ListSeriesQuery{}},
+		{s: `LIST SERIES FROM /^cpu/`, query: &influxql.ListSeriesQuery{Regex: regexp.MustCompile(`^cpu`)}},
+		{s: `LIST FIELDS FROM cpu.load`, query: &influxql.ListFieldsQuery{Series: "cpu.load"}},
+		{s: `LIST SHARD SPACES`, query: &influxql.ListShardSpacesQuery{}},
+		{s: `LIST SHARDS`, query: &influxql.ListShardsQuery{}},
+		{s: `LIST USERS`, query: &influxql.ListUsersQuery{}},
+
+		// Errors
+		{s: ``, err: `found EOF, expected SELECT, DELETE, LIST, CREATE, DROP, ALTER, SET, GRANT, REVOKE at line 1, char 1`},
+		{s: `LIST`, err: `found EOF, expected DATABASES, SERIES, FIELDS, SHARD, SHARDS, USERS, CONTINUOUS at line 1, char 5`},
+		{s: `LIST SERIES FROM cpu`, err: `found cpu, expected regex at line 1, char 18`},
+		{s: `LIST FIELDS cpu`, err: `found cpu, expected FROM at line 1, char 13`},
+		{s: `LIST SHARD`, err: `found EOF, expected SPACES at line 1, char 11`},
+		{s: `LIST CONTINUOUS`, err: `found EOF, expected QUERIES at line 1, char 16`},
+		{s: `DROP SERIES`, err: `found EOF, expected identifier at line 1, char 12`},
+		{s: `DROP CONTINUOUS QUERY`, err: `found EOF, expected number at line 1, char 22`},
+		{s: `DROP CONTINUOUS QUERIES 1`, err: `found QUERIES, expected QUERY at line 1, char 17`},
+		{s: `SELECT x FROM a GROUP BY 1h INTO`, err: `found EOF, expected identifier at line 1, char 33`},
+		{s: `SELECT x FROM a GROUP BY 1h INTO b NO`, err: `found EOF, expected BACKFILL at line 1, char 38`},
+		{s: `SELECT`, err: `found EOF, expected identifier, string, number, bool at line 1, char 7`},
+		{s: `SELECT from FROM cpu`, err: `found FROM, expected identifier, string, number, bool at line 1, char 8`},
+		{s: `SELECT field1 X`, err: `found X, expected FROM at line 1, char 15`},
+		{s: `SELECT field1 FROM "series" WHERE X +;`, err: `found ;, expected identifier, string, number, bool at line 1, char 38`},
+		{s: `SELECT field1 FROM myseries GROUP`, err: `found EOF, expected BY at line 1, char 34`},
+		{s: `SELECT field1 FROM myseries GROUP BY 1h FILL`, err: `found EOF, expected ( at line 1, char 45`},
+		{s: `SELECT field1 FROM myseries GROUP BY 1h FILL(zero)`, err: `found zero, expected null, none, previous, linear, number, string at line 1, char 46`},
+		{s: `SELECT field1 FROM myseries GROUP BY 1h FILL(true)`, err: `found true, expected null, none, previous, linear, number, string at line 1, char 46`},
+		{s: `SELECT field1 FROM myseries GROUP BY 1h FILL(0`, err: `found EOF, expected ) at line 1, char 47`},
+		{s: `SELECT field1 FROM myseries FILL(0)`, err: `found FILL, expected EOF at line 1, char 29`},
+		{s: `SELECT field1 FROM myseries GROUP BY 1h TZ`, err: `found EOF, expected ( at line 1, char 43`},
+		{s: `SELECT field1 FROM myseries GROUP BY 1h TZ(utc)`, err: `found utc, expected string at line 1, char 44`},
+		{s: `SELECT field1 FROM myseries GROUP BY 1h TZ('Nowhere/Town')`, err: `unknown time zone: Nowhere/Town at line 1, char 44`},
+		{s: `SELECT field1 FROM myseries GROUP BY 1h TZ('UTC'`, err: `found EOF, expected ) at line 1, char 49`},
+		{s: `SELECT field1 FROM myseries GROUP BY 1h TZ('UTC') FILL(0) TZ('UTC')`, err: `found TZ, expected EOF at line 1, char 59`},
+		{s: `SELECT field1 FROM myseries GROUP BY 1h FILL(0) TZ('UTC') FILL(0)`, err: `found FILL, expected EOF at line 1, char 59`},
+		{s: `SELECT count(value) FROM cpu GROUP BY host HAVING`, err: `found EOF, expected identifier, string, number, bool at line 1, char 50`},
+		{s: `SELECT field1 FROM myseries LIMIT`, err: `found EOF, expected number at line 1, char 34`},
+		{s: `SELECT field1 FROM myseries LIMIT 10.5`, err: `found 10.5, expected number at line 1, char 35`},
+		{s: `SELECT field1 FROM myseries ORDER`, err: `found EOF, expected ASC, DESC at line 1, char 34`},
+		{s: `SELECT field1 FROM myseries ORDER BY`, err: `found BY, expected ASC, DESC at line 1, char 35`},
+		{s: `SELECT field1 AS`, err: `found EOF, expected identifier at line 1, char 17`},
+		{s: `SELECT field1 FROM /cpu`, err: `unterminated regex at line 1, char 20`},
+		{s: `SELECT field1 FROM /(cpu/`, err: "invalid regex: error parsing regexp: missing closing ): `(cpu` at line 1, char 20"},
+		{s: `SELECT field1 FROM a INNER b`, err: `found b, expected JOIN at line 1, char 28`},
+		{s: `SELECT count( FROM a`, err: `found FROM, expected identifier, string, number, bool at line 1, char 15`},
+		{s: `SELECT count(x FROM a`, err: `found FROM, expected ) at line 1, char 16`},
+		{s: `SELECT x FROM a; SELECT y FROM b`, err: `found SELECT, expected EOF at line 1, char 18`},
+		{s: `DELETE`, err: `found EOF, expected FROM at line 1, char 7`},
+		{s: `DELETE FROM`, err: `found EOF, expected identifier, regex at line 1, char 12`},
+		{s: `CREATE`, err: `found EOF, expected DATABASE, USER, SHARD at line 1, char 7`},
+		{s: `CREATE SHARD raw`, err: `found raw, expected SPACE at line 1, char 14`},
+		{s: `CREATE SHARD SPACE raw`, err: `found EOF, expected ON at line 1, char 23`},
+		{s: `CREATE SHARD SPACE raw ON mydb MATCHING cpu`, err: `found cpu, expected regex at line 1, char 41`},
+		{s: `CREATE SHARD SPACE raw ON mydb DURATION 10`, err: `found 10, expected duration at line 1, char 41`},
+		{s: `CREATE SHARD SPACE raw ON mydb DURATION 0s`, err: `invalid shard duration: 0s at line 1, char 41`},
+		{s: `CREATE SHARD SPACE raw ON mydb REPLICATION 0`, err: `invalid replication: 0 at line 1, char 44`},
+		{s: `CREATE SHARD SPACE raw ON mydb SPLIT x`, err: `found x, expected number at line 1, char 38`},
+		{s: `ALTER SHARD SPACE raw ON mydb`, err: `found EOF, expected MATCHING, DURATION, RETENTION, REPLICATION, SPLIT at line 1, char 30`},
+		{s: `DROP INDEX x`, err: `found INDEX, expected DATABASE, USER, SHARD, CONTINUOUS, SERIES at line 1, char 6`},
+		{s: `ALTER USER bob`, err: `found USER, expected SHARD at line 1, char 7`},
+		{s: `CREATE DATABASE`, err: `found EOF, expected identifier at line 1, char 16`},
+		{s: `DROP USER 'bob'`, err: `found bob, expected identifier at line 1, char 11`},
+		{s: `CREATE USER testuser`, err: `found EOF, expected WITH at line 1, char 21`},
+		{s: `CREATE USER testuser WITH PASSWORD pwd`, err: `found pwd, expected string at line 1, char 36`},
+		{s: `SET PASSWORD testuser = 'pwd'`, err: `found testuser, expected FOR at line 1, char 14`},
+		{s: `SET PASSWORD FOR testuser 'pwd'`, err: `found pwd, expected = at line 1, char 27`},
+		{s: `GRANT EXECUTE ON testdb TO testuser`, err: `found EXECUTE, expected READ, WRITE, ALL at line 1, char 7`},
+		{s: `GRANT READ testdb TO testuser`, err: `found testdb, expected ON at line 1, char 12`},
+		{s: `GRANT READ ON testdb FROM testuser`, err: `found FROM, expected TO at line 1, char 22`},
+		{s: `REVOKE READ ON testdb TO testuser`, err: `found TO, expected FROM at line 1, char 23`},
+		{s: "SELECT x\nFROM a WHERE", err: `found EOF, expected identifier, string, number, bool at line 2, char 13`},
+	}
+
+	for i, tt := range tests {
+		query, err := influxql.ParseQuery(tt.s)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		} else if tt.err == "" && !reflect.DeepEqual(tt.query, query) {
+			t.Errorf("%d. %q\n\nquery mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.s, tt.query, query)
+		}
+	}
+}
+
+// Ensure the parser can parse expressions into an AST.
+func TestParser_ParseExpr(t *testing.T) {
+	var tests = []struct {
+		s    string
+		expr influxql.Expr
+		err  string
+	}{
+		// Primitives
+		{s: `100`, expr: &influxql.IntegerLiteral{Val: 100}},
+		{s: `-100`, expr: &influxql.IntegerLiteral{Val: -100}},
+		{s: `100.5`, expr: &influxql.FloatLiteral{Val: 100.5}},
+		{s: `-0.5`, expr: &influxql.FloatLiteral{Val: -0.5}},
+		{s: `'foo bar'`, expr: &influxql.StringLiteral{Val: "foo bar"}},
+		{s: `true`, expr: &influxql.BooleanLiteral{Val: true}},
+		{s: `false`, expr: &influxql.BooleanLiteral{Val: false}},
+		{s: `my_ident`, expr: &influxql.VarRef{Val: "my_ident"}},
+		{s: `"my ident"`, expr: &influxql.VarRef{Val: "my ident"}},
+		{s: `'2000-01-01'`, expr: &influxql.TimeLiteral{Val: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{s: `'2000-01-01 00:00:00.232'`, expr: &influxql.TimeLiteral{Val: time.Date(2000, 1, 1, 0, 0, 0, 232000000, time.UTC)}},
+		{s: `'2000-01-01T12:00:00Z'`, expr: &influxql.TimeLiteral{Val: time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)}},
+		{s: `10h`, expr: &influxql.DurationLiteral{Val: 10 * time.Hour}},
+
+		// Simple binary expression
+		{
+			s: `1 + 2`,
+			expr: &influxql.BinaryExpr{
+				Op:  influxql.ADD,
+				LHS: &influxql.IntegerLiteral{Val: 1},
+				RHS: &influxql.IntegerLiteral{Val: 2},
+			},
+		},
+
+		// Binary expression with LHS precedence
+		{
+			s: `1 * 2 + 3`,
+			expr: &influxql.BinaryExpr{
+				Op: influxql.ADD,
+				LHS: &influxql.BinaryExpr{
+					Op:  influxql.MUL,
+					LHS: &influxql.IntegerLiteral{Val: 1},
+					RHS: &influxql.IntegerLiteral{Val: 2},
+				},
+				RHS: &influxql.IntegerLiteral{Val: 3},
+			},
+		},
+
+		// Binary expression with RHS precedence
+		{
+			s: `1 + 2 * 3`,
+			expr: &influxql.BinaryExpr{
+				Op:  influxql.ADD,
+				LHS: &influxql.IntegerLiteral{Val: 1},
+				RHS: &influxql.BinaryExpr{
+					Op:  influxql.MUL,
+					LHS: &influxql.IntegerLiteral{Val: 2},
+					RHS: &influxql.IntegerLiteral{Val: 3},
+				},
+			},
+		},
+
+		// Binary expressions of equal precedence are left associative.
+		{
+			s: `1 - 2 - 3`,
+			expr: &influxql.BinaryExpr{
+				Op: influxql.SUB,
+				LHS: &influxql.BinaryExpr{
+					Op:  influxql.SUB,
+					LHS: &influxql.IntegerLiteral{Val: 1},
+					RHS: &influxql.IntegerLiteral{Val: 2},
+				},
+				RHS: &influxql.IntegerLiteral{Val: 3},
+			},
+		},
+
+		// Parenthesized binary expression overrides precedence.
+		{
+			s: `(1 + 2) * 3`,
+			expr: &influxql.BinaryExpr{
+				Op: influxql.MUL,
+				LHS: &influxql.BinaryExpr{
+					Op:  influxql.ADD,
+					LHS: &influxql.IntegerLiteral{Val: 1},
+					RHS: &influxql.IntegerLiteral{Val: 2},
+				},
+				RHS: &influxql.IntegerLiteral{Val: 3},
+			},
+		},
+
+		// Complex binary expression across three precedence levels.
+		{
+			s: `a OR b AND c = 1`,
+			expr: &influxql.BinaryExpr{
+				Op:  influxql.OR,
+				LHS: &influxql.VarRef{Val: "a"},
+				RHS: &influxql.BinaryExpr{
+					Op:  influxql.AND,
+					LHS: &influxql.VarRef{Val: "b"},
+					RHS: &influxql.BinaryExpr{
+						Op:  influxql.EQ,
+						LHS: &influxql.VarRef{Val: "c"},
+						RHS: &influxql.IntegerLiteral{Val: 1},
+					},
+				},
+			},
+		},
+
+		// Function call (empty)
+		{
+			s:    `my_func()`,
+			expr: &influxql.Call{Name: "my_func"},
+		},
+
+		// Function call (multi-arg)
+		{
+			s: `my_func(1, 2 + 3)`,
+			expr: &influxql.Call{
+				Name: "my_func",
+				Args: []influxql.Expr{
+					&influxql.IntegerLiteral{Val: 1},
+					&influxql.BinaryExpr{
+						Op:  influxql.ADD,
+						LHS: &influxql.IntegerLiteral{Val: 2},
+						RHS: &influxql.IntegerLiteral{Val: 3},
+					},
+				},
+			},
+		},
+
+		// Errors
+		{s: `(1 + 2`, err: `found EOF, expected ) at line 1, char 7`},
+		{s: `-foo`, err: `unary minus requires a numeric literal at line 1, char 1`},
+		{s: `99999999999999999999`, err: `unable to parse integer at line 1, char 1`},
+	}
+
+	for i, tt := range tests {
+		expr, err := influxql.NewParser(strings.NewReader(tt.s)).ParseExpr()
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		} else if tt.err == "" && !reflect.DeepEqual(tt.expr, expr) {
+			t.Errorf("%d. %q\n\nexpr mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.s, tt.expr, expr)
+		}
+	}
+}
+
+// Ensure a time duration can be parsed.
+func TestParseDuration(t *testing.T) {
+	var tests = []struct {
+		s   string
+		d   time.Duration
+		err string
+	}{
+		{s: `3u`, d: 3 * time.Microsecond},
+		{s: `1000µ`, d: time.Millisecond},
+		{s: `10ms`, d: 10 * time.Millisecond},
+		{s: `10s`, d: 10 * time.Second},
+		{s: `1.5m`, d: 90 * time.Second},
+		{s: `10h`, d: 10 * time.Hour},
+		{s: `2d`, d: 2 * 24 * time.Hour},
+		{s: `2w`, d: 2 * 7 * 24 * time.Hour},
+
+		{s: ``, err: "invalid duration"},
+		{s: `w`, err: "invalid duration"},
+		{s: `1.2w.5d`, err: "invalid duration"},
+		{s: `10x`, err: "invalid duration"},
+	}
+
+	for i, tt := range tests {
+		d, err := influxql.ParseDuration(tt.s)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s", i, tt.s, tt.err, errstring(err))
+		} else if d != tt.d {
+			t.Errorf("%d. %q: duration mismatch: exp=%s, got=%s", i, tt.s, tt.d, d)
+		}
+	}
+}
+
+// errstring converts an error to its string representation.
+func errstring(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// durationptr returns a pointer to a duration.
+func durationptr(d time.Duration) *time.Duration { return &d }
+
+// intptr returns a pointer to an int.
+func intptr(n int) *int { return &n }
+
+// mustLoadLocation returns the location with the given name. Panic on error.
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
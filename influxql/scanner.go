@@ -1 +1,461 @@
 package influxql
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Scanner represents a lexical scanner for InfluxQL.
+type Scanner struct {
+	r *reader
+}
+
+// NewScanner returns a new instance of Scanner.
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{r: &reader{r: bufio.NewReader(r)}}
+}
+
+// Scan returns the next token and position from the underlying reader.
+// Also returns the literal text read for strings, numbers, and duration tokens
+// since these token types can have different literal representations.
+func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
+	// Read next code point.
+	ch0, pos := s.r.read()
+
+	// If we see whitespace then consume all contiguous whitespace.
+	// If we see a letter, or certain acceptable special characters, then consume
+	// as an ident or reserved word.
+	if isWhitespace(ch0) {
+		return s.scanWhitespace()
+	} else if isLetter(ch0) || ch0 == '_' {
+		s.r.unread()
+		return s.scanIdent()
+	} else if isDigit(ch0) {
+		s.r.unread()
+		return s.scanNumber()
+	}
+
+	// Otherwise parse individual characters.
+	switch ch0 {
+	case eof:
+		return EOF, pos, ""
+	case '"':
+		s.r.unread()
+		return s.scanQuotedIdent()
+	case '\'':
+		s.r.unread()
+		return s.scanString()
+	case '.':
+		if ch1, _ := s.r.read(); isDigit(ch1) {
+			s.r.unread()
+			s.r.unread()
+			return s.scanNumber()
+		}
+		s.r.unread()
+		return ILLEGAL, pos, string(ch0)
+	case '+':
+		return ADD, pos, ""
+	case '-':
+		return SUB, pos, ""
+	case '*':
+		return MUL, pos, ""
+	case '/':
+		return DIV, pos, ""
+	case '=':
+		if ch1, _ := s.r.read(); ch1 != '=' {
+			s.r.unread()
+		}
+		return EQ, pos, ""
+	case '!':
+		if ch1, _ := s.r.read(); ch1 == '=' {
+			return NE, pos, ""
+		}
+		s.r.unread()
+	case '<':
+		if ch1, _ := s.r.read(); ch1 == '=' {
+			return LE, pos, ""
+		} else if ch1 == '>' {
+			return NE, pos, ""
+		}
+		s.r.unread()
+		return LT, pos, ""
+	case '>':
+		if ch1, _ := s.r.read(); ch1 == '=' {
+			return GE, pos, ""
+		}
+		s.r.unread()
+		return GT, pos, ""
+	case '(':
+		return LPAREN, pos, ""
+	case ')':
+		return RPAREN, pos, ""
+	case ',':
+		return COMMA, pos, ""
+	case ';':
+		return SEMICOLON, pos, ""
+	}
+
+	return ILLEGAL, pos, string(ch0)
+}
+
+// ScanRegex consumes a regular expression delimited by forward slashes.
+// The opening slash must have already been read by Scan() as a DIV token.
+// The literal returned is the expression between the slashes with escaped
+// slashes unescaped. A trailing "i" flag is returned as a "(?i)" prefix on
+// the literal so the result can be compiled directly.
+func (s *Scanner) ScanRegex() (tok Token, pos Pos, lit string) {
+	_, pos = s.r.curr()
+
+	var buf bytes.Buffer
+	for {
+		ch0, _ := s.r.read()
+		if ch0 == eof || ch0 == '\n' {
+			return ILLEGAL, pos, buf.String()
+		} else if ch0 == '\\' {
+			// Only unescape forward slashes. Leave other escapes for the regex.
+			ch1, _ := s.r.read()
+			if ch1 == eof || ch1 == '\n' {
+				return ILLEGAL, pos, buf.String()
+			} else if ch1 != '/' {
+				_, _ = buf.WriteRune(ch0)
+			}
+			_, _ = buf.WriteRune(ch1)
+		} else if ch0 == '/' {
+			break
+		} else {
+			_, _ = buf.WriteRune(ch0)
+		}
+	}
+
+	// Check for the case-insensitive flag.
+	if ch, _ := s.r.read(); ch == 'i' {
+		return REGEX, pos, "(?i)" + buf.String()
+	}
+	s.r.unread()
+
+	return REGEX, pos, buf.String()
+}
+
+// scanWhitespace consumes the current rune and all contiguous whitespace.
+func (s *Scanner) scanWhitespace() (tok Token, pos Pos, lit string) {
+	// Create a buffer and read the current character into it.
+	var buf bytes.Buffer
+	ch, pos := s.r.curr()
+	_, _ = buf.WriteRune(ch)
+
+	// Read every subsequent whitespace character into the buffer.
+	// Non-whitespace characters and EOF will cause the loop to exit.
+	for {
+		ch, _ = s.r.read()
+		if ch == eof {
+			break
+		} else if !isWhitespace(ch) {
+			s.r.unread()
+			break
+		} else {
+			_, _ = buf.WriteRune(ch)
+		}
+	}
+
+	return WS, pos, buf.String()
+}
+
+// scanIdent consumes an identifier or keyword. Identifiers may contain
+// letters, digits, underscores and periods so that series names such as
+// "daily.cpu_load" can be used without quoting.
+func (s *Scanner) scanIdent() (tok Token, pos Pos, lit string) {
+	// Save the starting position of the identifier.
+	_, pos = s.r.read()
+	s.r.unread()
+
+	var buf bytes.Buffer
+	for {
+		if ch, _ := s.r.read(); ch == eof {
+			break
+		} else if !isIdentChar(ch) {
+			s.r.unread()
+			break
+		} else {
+			_, _ = buf.WriteRune(ch)
+		}
+	}
+	lit = buf.String()
+
+	// If the literal matches a keyword then return that keyword. Keywords
+	// that aren't reserved keep their literal for use as identifiers.
+	if tok = Lookup(lit); tok.IsKeyword() && !tok.IsReserved() {
+		return tok, pos, lit
+	} else if tok != IDENT {
+		return tok, pos, ""
+	}
+	return IDENT, pos, lit
+}
+
+// scanQuotedIdent consumes an identifier wrapped in double quotes.
+// Quoted identifiers are never treated as keywords.
+func (s *Scanner) scanQuotedIdent() (tok Token, pos Pos, lit string) {
+	ch, pos := s.r.read()
+	lit, err := scanDelimited(s.r, ch)
+	if err != nil {
+		return ILLEGAL, pos, lit
+	}
+	return IDENT, pos, lit
+}
+
+// scanString consumes a single-quoted string literal.
+func (s *Scanner) scanString() (tok Token, pos Pos, lit string) {
+	ch, pos := s.r.read()
+	lit, err := scanDelimited(s.r, ch)
+	if err != nil {
+		return ILLEGAL, pos, lit
+	}
+	return STRING, pos, lit
+}
+
+// scanNumber consumes anything that looks like the start of a number.
+// Numbers immediately followed by a duration unit are returned as durations.
+func (s *Scanner) scanNumber() (tok Token, pos Pos, lit string) {
+	var buf bytes.Buffer
+
+	// Save the starting position of the number.
+	_, pos = s.r.read()
+	s.r.unread()
+
+	// Read the integer portion of the number.
+	_, _ = buf.WriteString(s.scanDigits())
+
+	// If next code point is a period then read the fractional portion.
+	isFloat := false
+	if ch0, _ := s.r.read(); ch0 == '.' {
+		isFloat = true
+		_, _ = buf.WriteRune(ch0)
+		_, _ = buf.WriteString(s.scanDigits())
+	} else {
+		s.r.unread()
+	}
+
+	// Read as a duration if the number is followed by a duration unit.
+	if unit := s.scanDurationUnit(); unit != "" {
+		return DURATION_VAL, pos, buf.String() + unit
+	}
+
+	if isFloat {
+		return FLOAT, pos, buf.String()
+	}
+	return INTEGER, pos, buf.String()
+}
+
+// scanDigits consumes a contiguous series of digits.
+func (s *Scanner) scanDigits() string {
+	var buf bytes.Buffer
+	for {
+		ch, _ := s.r.read()
+		if !isDigit(ch) {
+			s.r.unread()
+			break
+		}
+		_, _ = buf.WriteRune(ch)
+	}
+	return buf.String()
+}
+
+// scanDurationUnit consumes a duration unit if one follows the current position.
+// Returns a blank string and leaves the reader untouched if no unit is found.
+func (s *Scanner) scanDurationUnit() string {
+	ch0, _ := s.r.read()
+	switch ch0 {
+	case 'u', 'µ', 's', 'h', 'd', 'w':
+		if ch1, _ := s.r.read(); !isIdentChar(ch1) {
+			s.r.unread()
+			return string(ch0)
+		}
+		s.r.unread()
+	case 'm':
+		ch1, _ := s.r.read()
+		if ch1 == 's' {
+			if ch2, _ := s.r.read(); !isIdentChar(ch2) {
+				s.r.unread()
+				return "ms"
+			}
+			s.r.unread()
+		} else if !isIdentChar(ch1) {
+			s.r.unread()
+			return "m"
+		}
+		s.r.unread()
+	}
+	s.r.unread()
+	return ""
+}
+
+// isWhitespace returns true if the rune is a space, tab, or newline.
+func isWhitespace(ch rune) bool { return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' }
+
+// isLetter returns true if the rune is a letter.
+func isLetter(ch rune) bool { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') }
+
+// isDigit returns true if the rune is a digit.
+func isDigit(ch rune) bool { return (ch >= '0' && ch <= '9') }
+
+// isIdentChar returns true if the rune can be used in an unquoted identifier.
+func isIdentChar(ch rune) bool { return isLetter(ch) || isDigit(ch) || ch == '_' || ch == '.' }
+
+// bufScanner represents a wrapper for scanner to add a buffer.
+// It provides a fixed-length circular buffer that can be unread.
+type bufScanner struct {
+	s   *Scanner
+	i   int // buffer index
+	n   int // buffer size
+	buf [3]struct {
+		tok Token
+		pos Pos
+		lit string
+	}
+}
+
+// newBufScanner returns a new buffered scanner for a reader.
+func newBufScanner(r io.Reader) *bufScanner {
+	return &bufScanner{s: NewScanner(r)}
+}
+
+// Scan reads the next token from the scanner.
+func (s *bufScanner) Scan() (tok Token, pos Pos, lit string) {
+	return s.scanFunc(s.s.Scan)
+}
+
+// ScanRegex reads a regular expression from the scanner. This must be
+// called directly after a DIV token has been read and not unscanned.
+func (s *bufScanner) ScanRegex() (tok Token, pos Pos, lit string) {
+	return s.scanFunc(s.s.ScanRegex)
+}
+
+// scanFunc uses the provided function to scan the next token.
+func (s *bufScanner) scanFunc(scan func() (Token, Pos, string)) (tok Token, pos Pos, lit string) {
+	// If we have unread tokens then read them off the buffer first.
+	if s.n > 0 {
+		s.n--
+		return s.curr()
+	}
+
+	// Move buffer position forward and save the token.
+	s.i = (s.i + 1) % len(s.buf)
+	buf := &s.buf[s.i]
+	buf.tok, buf.pos, buf.lit = scan()
+
+	return s.curr()
+}
+
+// Unscan pushes the previously token back onto the buffer.
+func (s *bufScanner) Unscan() { s.n++ }
+
+// curr returns the last read token.
+func (s *bufScanner) curr() (tok Token, pos Pos, lit string) {
+	buf := &s.buf[(s.i-s.n+len(s.buf))%len(s.buf)]
+	return buf.tok, buf.pos, buf.lit
+}
+
+// reader represents a buffered rune reader used by the scanner.
+// It provides a fixed-length circular buffer that can be unread.
+type reader struct {
+	r   io.RuneScanner
+	i   int // buffer index
+	n   int // buffer char count
+	pos Pos // last read rune position
+	buf [3]struct {
+		ch  rune
+		pos Pos
+	}
+}
+
+// read reads the next rune from the reader.
+func (r *reader) read() (ch rune, pos Pos) {
+	// If we have unread characters then read them off the buffer first.
+	if r.n > 0 {
+		r.n--
+		return r.curr()
+	}
+
+	// Read next rune from underlying reader.
+	// Any error (including io.EOF) should return as EOF.
+	ch, _, err := r.r.ReadRune()
+	if err != nil {
+		ch = eof
+	} else if ch == '\r' {
+		if ch, _, err := r.r.ReadRune(); err != nil {
+			// nop
+		} else if ch != '\n' {
+			_ = r.r.UnreadRune()
+		}
+		ch = '\n'
+	}
+
+	// Save character and position to the buffer.
+	r.i = (r.i + 1) % len(r.buf)
+	buf := &r.buf[r.i]
+	buf.ch, buf.pos = ch, r.pos
+
+	// Update position.
+	// EOF does not advance the position so it is always reported just
+	// past the last character no matter how many times it is read.
+	if ch == '\n' {
+		r.pos.Line++
+		r.pos.Char = 0
+	} else if ch != eof {
+		r.pos.Char++
+	}
+
+	return r.curr()
+}
+
+// unread pushes the previously read rune back onto the buffer.
+func (r *reader) unread() {
+	r.n++
+}
+
+// curr returns the last read character and position.
+func (r *reader) curr() (ch rune, pos Pos) {
+	i := (r.i - r.n + len(r.buf)) % len(r.buf)
+	buf := &r.buf[i]
+	return buf.ch, buf.pos
+}
+
+// eof is a marker code point to signify that the reader can't read any more.
+const eof = rune(0)
+
+// scanDelimited reads a delimited set of runes, such as a quoted string.
+// The opening delimiter must already have been read. Backslash escapes the
+// delimiter, another backslash, and the common "\n" and "\t" sequences.
+func scanDelimited(r *reader, delim rune) (string, error) {
+	var buf bytes.Buffer
+	for {
+		ch0, _ := r.read()
+		if ch0 == eof || ch0 == '\n' {
+			return buf.String(), errBadString
+		} else if ch0 == delim {
+			return buf.String(), nil
+		} else if ch0 == '\\' {
+			ch1, _ := r.read()
+			switch ch1 {
+			case 'n':
+				_, _ = buf.WriteRune('\n')
+			case 't':
+				_, _ = buf.WriteRune('\t')
+			case '\\':
+				_, _ = buf.WriteRune('\\')
+			case delim:
+				_, _ = buf.WriteRune(delim)
+			default:
+				return string(ch0) + string(ch1), errBadEscape
+			}
+		} else {
+			_, _ = buf.WriteRune(ch0)
+		}
+	}
+}
+
+var (
+	errBadString = errors.New("bad string")
+	errBadEscape = errors.New("bad escape")
+)
@@ -0,0 +1,211 @@
+package influxql_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure the scanner can scan tokens correctly.
+func TestScanner_Scan(t *testing.T) {
+	var tests = []struct {
+		s   string
+		tok influxql.Token
+		lit string
+		pos influxql.Pos
+	}{
+		// Special tokens (EOF, ILLEGAL, WS)
+		{s: ``, tok: influxql.EOF},
+		{s: `#`, tok: influxql.ILLEGAL, lit: `#`},
+		{s: ` `, tok: influxql.WS, lit: " "},
+		{s: "\t", tok: influxql.WS, lit: "\t"},
+		{s: "\n", tok: influxql.WS, lit: "\n"},
+		{s: "\r", tok: influxql.WS, lit: "\n"},
+		{s: "\r\n", tok: influxql.WS, lit: "\n"},
+		{s: "\rX", tok: influxql.WS, lit: "\n"},
+		{s: "\n\r", tok: influxql.WS, lit: "\n\n"},
+		{s: " \n\t \r\n\t", tok: influxql.WS, lit: " \n\t \n\t"},
+		{s: " foo", tok: influxql.WS, lit: " "},
+
+		// Logical operators
+		{s: `AND`, tok: influxql.AND},
+		{s: `and`, tok: influxql.AND},
+		{s: `OR`, tok: influxql.OR},
+		{s: `or`, tok: influxql.OR},
+
+		// Operators
+		{s: `+`, tok: influxql.ADD},
+		{s: `-`, tok: influxql.SUB},
+		{s: `*`, tok: influxql.MUL},
+		{s: `/`, tok: influxql.DIV},
+		{s: `=`, tok: influxql.EQ},
+		{s: `==`, tok: influxql.EQ},
+		{s: `!=`, tok: influxql.NE},
+		{s: `<>`, tok: influxql.NE},
+		{s: `!`, tok: influxql.ILLEGAL, lit: "!"},
+		{s: `<`, tok: influxql.LT},
+		{s: `<=`, tok: influxql.LE},
+		{s: `>`, tok: influxql.GT},
+		{s: `>=`, tok: influxql.GE},
+
+		// Misc tokens
+		{s: `(`, tok: influxql.LPAREN},
+		{s: `)`, tok: influxql.RPAREN},
+		{s: `,`, tok: influxql.COMMA},
+		{s: `;`, tok: influxql.SEMICOLON},
+
+		// Identifiers
+		{s: `foo`, tok: influxql.IDENT, lit: `foo`},
+		{s: `Zx12_3U_-`, tok: influxql.IDENT, lit: `Zx12_3U_`},
+		{s: `cpu.load`, tok: influxql.IDENT, lit: `cpu.load`},
+		{s: `"foo bar"`, tok: influxql.IDENT, lit: `foo bar`},
+		{s: `"foo\"bar"`, tok: influxql.IDENT, lit: `foo"bar`},
+		{s: `"foo`, tok: influxql.ILLEGAL, lit: `foo`},
+
+		// Booleans
+		{s: `true`, tok: influxql.TRUE},
+		{s: `false`, tok: influxql.FALSE},
+
+		// Strings
+		{s: `'testing 123!'`, tok: influxql.STRING, lit: `testing 123!`},
+		{s: `'foo\nbar'`, tok: influxql.STRING, lit: "foo\nbar"},
+		{s: `'foo\\bar'`, tok: influxql.STRING, lit: `foo\bar`},
+		{s: `'foo\'bar'`, tok: influxql.STRING, lit: `foo'bar`},
+		{s: `'test`, tok: influxql.ILLEGAL, lit: `test`},
+		{s: "'test\nfoo", tok: influxql.ILLEGAL, lit: `test`},
+		{s: `'test\g'`, tok: influxql.ILLEGAL, lit: `\g`},
+
+		// Numbers
+		{s: `100`, tok: influxql.INTEGER, lit: `100`},
+		{s: `100.23`, tok: influxql.FLOAT, lit: `100.23`},
+		{s: `.23`, tok: influxql.FLOAT, lit: `.23`},
+		{s: `10.3s`, tok: influxql.DURATION_VAL, lit: `10.3s`},
+
+		// Durations
+		{s: `10u`, tok: influxql.DURATION_VAL, lit: `10u`},
+		{s: `10µ`, tok: influxql.DURATION_VAL, lit: `10µ`},
+		{s: `10ms`, tok: influxql.DURATION_VAL, lit: `10ms`},
+		{s: `-1s`, tok: influxql.SUB},
+		{s: `10m`, tok: influxql.DURATION_VAL, lit: `10m`},
+		{s: `10h`, tok: influxql.DURATION_VAL, lit: `10h`},
+		{s: `10d`, tok: influxql.DURATION_VAL, lit: `10d`},
+		{s: `10w`, tok: influxql.DURATION_VAL, lit: `10w`},
+		{s: `10x`, tok: influxql.INTEGER, lit: `10`},
+
+		// Keywords
+		{s: `AS`, tok: influxql.AS},
+		{s: `ASC`, tok: influxql.ASC},
+		{s: `BY`, tok: influxql.BY},
+		{s: `DELETE`, tok: influxql.DELETE},
+		{s: `DESC`, tok: influxql.DESC},
+		{s: `FROM`, tok: influxql.FROM},
+		{s: `GROUP`, tok: influxql.GROUP},
+		{s: `INNER`, tok: influxql.INNER},
+		{s: `JOIN`, tok: influxql.JOIN},
+		{s: `LIMIT`, tok: influxql.LIMIT},
+		{s: `MERGE`, tok: influxql.MERGE},
+		{s: `ON`, tok: influxql.ON},
+		{s: `ORDER`, tok: influxql.ORDER},
+		{s: `SELECT`, tok: influxql.SELECT},
+		{s: `WHERE`, tok: influxql.WHERE},
+		{s: `Duration`, tok: influxql.DURATION, lit: `Duration`},
+		{s: `seLECT`, tok: influxql.SELECT}, // case insensitive
+	}
+
+	for i, tt := range tests {
+		s := influxql.NewScanner(strings.NewReader(tt.s))
+		tok, pos, lit := s.Scan()
+		if tt.tok != tok {
+			t.Errorf("%d. %q token mismatch: exp=%q got=%q <%q>", i, tt.s, tt.tok, tok, lit)
+		} else if tt.pos.Line != pos.Line || tt.pos.Char != pos.Char {
+			t.Errorf("%d. %q pos mismatch: exp=%#v got=%#v", i, tt.s, tt.pos, pos)
+		} else if tt.lit != lit {
+			t.Errorf("%d. %q literal mismatch: exp=%q got=%q", i, tt.s, tt.lit, lit)
+		}
+	}
+}
+
+// Ensure the scanner can scan a series of tokens correctly.
+func TestScanner_Scan_Multi(t *testing.T) {
+	type result struct {
+		tok influxql.Token
+		pos influxql.Pos
+		lit string
+	}
+	exp := []result{
+		{tok: influxql.SELECT, pos: influxql.Pos{Line: 0, Char: 0}, lit: ""},
+		{tok: influxql.WS, pos: influxql.Pos{Line: 0, Char: 6}, lit: " "},
+		{tok: influxql.IDENT, pos: influxql.Pos{Line: 0, Char: 7}, lit: "value"},
+		{tok: influxql.WS, pos: influxql.Pos{Line: 0, Char: 12}, lit: " "},
+		{tok: influxql.FROM, pos: influxql.Pos{Line: 0, Char: 13}, lit: ""},
+		{tok: influxql.WS, pos: influxql.Pos{Line: 0, Char: 17}, lit: " "},
+		{tok: influxql.IDENT, pos: influxql.Pos{Line: 0, Char: 18}, lit: "myseries"},
+		{tok: influxql.WS, pos: influxql.Pos{Line: 0, Char: 26}, lit: "\n"},
+		{tok: influxql.WHERE, pos: influxql.Pos{Line: 1, Char: 0}, lit: ""},
+		{tok: influxql.WS, pos: influxql.Pos{Line: 1, Char: 5}, lit: " "},
+		{tok: influxql.IDENT, pos: influxql.Pos{Line: 1, Char: 6}, lit: "a"},
+		{tok: influxql.WS, pos: influxql.Pos{Line: 1, Char: 7}, lit: " "},
+		{tok: influxql.EQ, pos: influxql.Pos{Line: 1, Char: 8}, lit: ""},
+		{tok: influxql.WS, pos: influxql.Pos{Line: 1, Char: 9}, lit: " "},
+		{tok: influxql.STRING, pos: influxql.Pos{Line: 1, Char: 10}, lit: "b"},
+		{tok: influxql.EOF, pos: influxql.Pos{Line: 1, Char: 13}, lit: ""},
+	}
+
+	// Create a scanner.
+	v := "SELECT value FROM myseries\nWHERE a = 'b'"
+	s := influxql.NewScanner(strings.NewReader(v))
+
+	// Continually scan until we reach the end.
+	var act []result
+	for {
+		tok, pos, lit := s.Scan()
+		act = append(act, result{tok, pos, lit})
+		if tok == influxql.EOF {
+			break
+		}
+	}
+
+	// Verify the token counts match.
+	if len(exp) != len(act) {
+		t.Fatalf("token count mismatch: exp=%d, got=%d", len(exp), len(act))
+	}
+
+	// Verify each token matches.
+	for i := range exp {
+		if !reflect.DeepEqual(exp[i], act[i]) {
+			t.Fatalf("%d. token mismatch:\n\nexp=%#v\n\ngot=%#v", i, exp[i], act[i])
+		}
+	}
+}
+
+// Ensure the scanner can scan regular expressions after a slash.
+func TestScanner_ScanRegex(t *testing.T) {
+	var tests = []struct {
+		s   string
+		tok influxql.Token
+		lit string
+	}{
+		{s: `/^cpu\./`, tok: influxql.REGEX, lit: `^cpu\.`},
+		{s: `/a\/b/`, tok: influxql.REGEX, lit: `a/b`},
+		{s: `/a\\/`, tok: influxql.REGEX, lit: `a\\`},
+		{s: `/CPU/i`, tok: influxql.REGEX, lit: `(?i)CPU`},
+		{s: `/foo`, tok: influxql.ILLEGAL, lit: `foo`},
+		{s: "/foo\nbar/", tok: influxql.ILLEGAL, lit: `foo`},
+	}
+
+	for i, tt := range tests {
+		s := influxql.NewScanner(strings.NewReader(tt.s))
+		if tok, _, _ := s.Scan(); tok != influxql.DIV {
+			t.Errorf("%d. %q expected leading slash, got %q", i, tt.s, tok)
+			continue
+		}
+		tok, _, lit := s.ScanRegex()
+		if tt.tok != tok {
+			t.Errorf("%d. %q token mismatch: exp=%q got=%q <%q>", i, tt.s, tt.tok, tok, lit)
+		} else if tt.lit != lit {
+			t.Errorf("%d. %q literal mismatch: exp=%q got=%q", i, tt.s, tt.lit, lit)
+		}
+	}
+}
@@ -1,5 +1,9 @@
 package influxql
 
+import (
+	"strings"
+)
+
 // Token is a lexical token of the InfluxQL language.
 type Token int
 
@@ -7,15 +11,18 @@ const (
 	// Special tokens
 	ILLEGAL Token = iota
 	EOF
+	WS
 
 	literal_beg
 	// Literals
-	IDENT   // main
-	INTEGER // 12345
-	FLOAT   // 123.45
-	STRING  // "abc"
-	TRUE    // true
-	FALSE   // false
+	IDENT        // main
+	INTEGER      // 12345
+	FLOAT        // 123.45
+	STRING       // "abc"
+	DURATION_VAL // 13h
+	REGEX        // /^cpu\./
+	TRUE         // true
+	FALSE        // false
 	literal_end
 
 	operator_beg
@@ -28,7 +35,7 @@ const (
 	AND // AND
 	OR  // OR
 
-	EQ // ==
+	EQ // =
 	NE // !=
 	LT // <
 	LE // <=
@@ -36,31 +43,67 @@ const (
 	GE // >=
 	operator_end
 
-	LPAREN // (
-	RPAREN // )
-	COMMA  // ,
+	LPAREN    // (
+	RPAREN    // )
+	COMMA     // ,
+	SEMICOLON // ;
 
 	keyword_beg
 	// Keywords
+	ALL
+	ALTER
 	AS
 	ASC
+	BACKFILL
 	BY
 	CONTINUOUS
+	CREATE
+	DATABASE
+	DATABASES
 	DELETE
 	DESC
 	DROP
+	DURATION
 	EXPLAIN
+	FIELDS
+	FILL
+	FOR
 	FROM
+	GRANT
+	GROUP
+	HAVING
 	INNER
+	INTO
 	JOIN
 	LIMIT
 	LIST
+	MATCHING
 	MERGE
+	NO
+	ON
 	ORDER
+	PASSWORD
 	QUERIES
+	QUERY
+	READ
+	REPLICATION
+	RETENTION
+	REVOKE
 	SELECT
 	SERIES
+	SET
+	SHARD
+	SHARDS
+	SPACE
+	SPACES
+	SPLIT
+	TO
+	TZ
+	USER
+	USERS
 	WHERE
+	WITH
+	WRITE
 	keyword_end
 )
 
@@ -68,13 +111,16 @@ var tokens = [...]string{
 	ILLEGAL: "ILLEGAL",
 
 	EOF: "EOF",
+	WS:  "WS",
 
-	IDENT:   "IDENT",
-	INTEGER: "INTEGER",
-	FLOAT:   "FLOAT",
-	STRING:  "STRING",
-	TRUE:    "TRUE",
-	FALSE:   "FALSE",
+	IDENT:        "IDENT",
+	INTEGER:      "INTEGER",
+	FLOAT:        "FLOAT",
+	STRING:       "STRING",
+	DURATION_VAL: "DURATION_VAL",
+	REGEX:        "REGEX",
+	TRUE:         "TRUE",
+	FALSE:        "FALSE",
 
 	ADD: "+",
 	SUB: "-",
@@ -84,40 +130,86 @@ var tokens = [...]string{
 	AND: "AND",
 	OR:  "OR",
 
-	EQ: "==",
+	EQ: "=",
 	NE: "!=",
 	LT: "<",
 	LE: "<=",
 	GT: ">",
 	GE: ">=",
 
-	LPAREN: "(",
-	RPAREN: ")",
-	COMMA:  ",",
-
-	AS:         "AS",
-	ASC:        "ASC",
-	BY:         "BY",
-	CONTINUOUS: "CONTINUOUS",
-	DELETE:     "DELETE",
-	DESC:       "DESC",
-	DROP:       "DROP",
-	EXPLAIN:    "EXPLAIN",
-	FROM:       "FROM",
-	INNER:      "INNER",
-	JOIN:       "JOIN",
-	LIMIT:      "LIMIT",
-	LIST:       "LIST",
-	MERGE:      "MERGE",
-	ORDER:      "ORDER",
-	QUERIES:    "QUERIES",
-	SELECT:     "SELECT",
-	SERIES:     "SERIES",
-	WHERE:      "WHERE",
+	LPAREN:    "(",
+	RPAREN:    ")",
+	COMMA:     ",",
+	SEMICOLON: ";",
+
+	ALL:         "ALL",
+	ALTER:       "ALTER",
+	AS:          "AS",
+	ASC:         "ASC",
+	BACKFILL:    "BACKFILL",
+	BY:          "BY",
+	CONTINUOUS:  "CONTINUOUS",
+	CREATE:      "CREATE",
+	DATABASE:    "DATABASE",
+	DATABASES:   "DATABASES",
+	DELETE:      "DELETE",
+	DESC:        "DESC",
+	DROP:        "DROP",
+	DURATION:    "DURATION",
+	EXPLAIN:     "EXPLAIN",
+	FIELDS:      "FIELDS",
+	FILL:        "FILL",
+	FOR:         "FOR",
+	FROM:        "FROM",
+	GRANT:       "GRANT",
+	GROUP:       "GROUP",
+	HAVING:      "HAVING",
+	INNER:       "INNER",
+	INTO:        "INTO",
+	JOIN:        "JOIN",
+	LIMIT:       "LIMIT",
+	LIST:        "LIST",
+	MATCHING:    "MATCHING",
+	MERGE:       "MERGE",
+	NO:          "NO",
+	ON:          "ON",
+	ORDER:       "ORDER",
+	PASSWORD:    "PASSWORD",
+	QUERIES:     "QUERIES",
+	QUERY:       "QUERY",
+	READ:        "READ",
+	REPLICATION: "REPLICATION",
+	RETENTION:   "RETENTION",
+	REVOKE:      "REVOKE",
+	SELECT:      "SELECT",
+	SERIES:      "SERIES",
+	SET:         "SET",
+	SHARD:       "SHARD",
+	SHARDS:      "SHARDS",
+	SPACE:       "SPACE",
+	SPACES:      "SPACES",
+	SPLIT:       "SPLIT",
+	TO:          "TO",
+	TZ:          "TZ",
+	USER:        "USER",
+	USERS:       "USERS",
+	WHERE:       "WHERE",
+	WITH:        "WITH",
+	WRITE:       "WRITE",
 }
 
 var keywords map[string]Token
 
+// reserved holds the keywords that start statements or clauses.
+var reserved = map[Token]bool{
+	ALTER: true, AS: true, ASC: true, BY: true, CONTINUOUS: true,
+	CREATE: true, DELETE: true, DESC: true, DROP: true, EXPLAIN: true,
+	FROM: true, GRANT: true, GROUP: true, HAVING: true, INNER: true,
+	INTO: true, JOIN: true, LIMIT: true, LIST: true, MERGE: true,
+	ON: true, ORDER: true, QUERIES: true, REVOKE: true, SELECT: true,
+	SERIES: true, SET: true, WHERE: true,
+}
+
 func init() {
 	keywords = make(map[string]Token)
 	for i := keyword_beg + 1; i < keyword_end; i++ {
@@ -125,8 +217,8 @@ func init() {
 	}
 	keywords[tokens[AND]] = AND
 	keywords[tokens[OR]] = OR
-	keywords["true"] = TRUE
-	keywords["false"] = FALSE
+	keywords["TRUE"] = TRUE
+	keywords["FALSE"] = FALSE
 }
 
 // String returns the string representation of the token.
@@ -163,9 +255,14 @@ func (tok Token) IsOperator() bool { return tok > operator_beg && tok < operator
 // IsKeyword returns true for keyword tokens.
 func (tok Token) IsKeyword() bool { return tok > keyword_beg && tok < keyword_end }
 
+// IsReserved returns true for keywords that can't be used as identifiers.
+// Other keywords are identifiers wherever a statement doesn't expect them.
+func (tok Token) IsReserved() bool { return reserved[tok] }
+
 // Lookup returns the token associated with a given string.
+// Keywords are matched case-insensitively.
 func Lookup(ident string) Token {
-	if tok, ok := keywords[ident]; ok {
+	if tok, ok := keywords[strings.ToUpper(ident)]; ok {
 		return tok
 	}
 	return IDENT
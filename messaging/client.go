@@ -156,6 +156,15 @@ func (c *Client) Publish(m *Message) (uint64, error) {
 	return index, nil
 }
 
+// Unsubscribe removes the client's replica from a topic.
+// Returns the broker index of the unsubscribe command or an error.
+func (c *Client) Unsubscribe(topicID uint64) (uint64, error) {
+	return c.Publish(&Message{
+		Type: UnsubscribeMessageType,
+		Data: mustMarshalJSON(&UnsubscribeCommand{Replica: c.name, TopicID: topicID}),
+	})
+}
+
 // streamer connects to a broker server and streams the replica's messages.
 func (c *Client) streamer(done chan chan struct{}) {
 	for {
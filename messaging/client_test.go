@@ -2,6 +2,7 @@ package messaging_test
 
 import (
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -123,6 +124,31 @@ func TestClient_Publish_ErrLogClosed(t *testing.T) {
 	}
 }
 
+// Ensure that a client can unsubscribe its replica from a topic.
+func TestClient_Unsubscribe(t *testing.T) {
+	c := OpenClient("node0")
+	defer c.Close()
+
+	// Subscribe replica to a topic.
+	b := c.Server.Handler.Broker()
+	if err := b.Subscribe("node0", 20); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Unsubscribe and wait for the command to apply.
+	index, err := c.Unsubscribe(20)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := b.Sync(index); err != nil {
+		t.Fatalf("unexpected sync error: %s", err)
+	}
+
+	// Verify the replica is only subscribed to the broadcast topic.
+	if topics := b.Replica("node0").Topics(); !reflect.DeepEqual(topics, []uint64{messaging.BroadcastTopicID}) {
+		t.Fatalf("unexpected topics: %v", topics)
+	}
+}
+
 // Client represents a test wrapper for the broker client.
 type Client struct {
 	*messaging.Client
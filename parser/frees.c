@@ -1,3 +1,5 @@
+// +build !noparser
+
 #include <stdlib.h>
 #include "query_types.h"
 
@@ -56,6 +58,9 @@ free_groupby_clause(groupby_clause *g)
   if (g->fill_function) {
     free_value(g->fill_function);
   }
+  if (g->tz_function) {
+    free_value(g->tz_function);
+  }
   free(g);
 }
 
@@ -99,6 +104,10 @@ free_select_query (select_query *q)
     free_condition(q->where_condition);
   }
 
+  if (q->having_condition) {
+    free_condition(q->having_condition);
+  }
+
   if (q->group_by) {
     free_groupby_clause(q->group_by);
   }
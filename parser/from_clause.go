@@ -1,23 +1,22 @@
 package parser
 
-// #include "query_types.h"
-// #include <stdlib.h>
-import "C"
 import (
 	"bytes"
+	"fmt"
 	"regexp"
 	"strings"
 )
-import "fmt"
 
 type FromClauseType int
 
+// The from clause types, in the order of the from_clause_type enum of
+// query_types.h.
 const (
-	FromClauseArray      FromClauseType = C.FROM_ARRAY
-	FromClauseMerge      FromClauseType = C.FROM_MERGE
-	FromClauseInnerJoin  FromClauseType = C.FROM_JOIN
-	FromClauseMergeRegex FromClauseType = C.FROM_MERGE_REGEX
-	FromClauseJoinRegex  FromClauseType = C.FROM_JOIN_REGEX
+	FromClauseArray FromClauseType = iota
+	FromClauseMerge
+	FromClauseInnerJoin
+	FromClauseMergeRegex
+	FromClauseJoinRegex
 )
 
 func (self *TableName) GetAlias() string {
@@ -0,0 +1,457 @@
+// +build !noparser
+
+package parser
+
+// #include "query_types.h"
+// #include <stdlib.h>
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// Available is true if the package is built with the cgo query grammar,
+// which is left out by the noparser build tag.
+const Available = true
+
+func setupSlice(hdr *reflect.SliceHeader, ptr unsafe.Pointer, size C.size_t) {
+	hdr.Cap = int(size)
+	hdr.Len = int(size)
+	hdr.Data = uintptr(ptr)
+}
+
+func GetGroupByClause(groupByClause *C.groupby_clause) (*GroupByClause, error) {
+	if groupByClause == nil {
+		return &GroupByClause{Elems: nil}, nil
+	}
+
+	values, err := GetValueArray((*C.value_array)(groupByClause.elems))
+	if err != nil {
+		return nil, err
+	}
+
+	fillWithZero := false
+	fillType := FillNone
+	var fillValue *Value
+	var location *time.Location
+
+	// the group by values can be followed by fill() and tz() in any order
+	for _, function := range []*C.value{groupByClause.fill_function, groupByClause.tz_function} {
+		if function == nil {
+			continue
+		}
+		fun, err := GetValue(function)
+		if err != nil {
+			return nil, err
+		}
+
+		switch fun.Name {
+		case "fill":
+			if fillValue != nil {
+				return nil, fmt.Errorf("`fill` can only be used once")
+			}
+			if len(fun.Elems) != 1 {
+				return nil, fmt.Errorf("`fill` accepts one argument only")
+			}
+
+			fillValue = fun.Elems[0]
+			fillType, err = parseFillType(fillValue)
+			if err != nil {
+				return nil, err
+			}
+			fillWithZero = fillType != FillNone
+		case "tz":
+			if location != nil {
+				return nil, fmt.Errorf("`tz` can only be used once")
+			}
+			if len(fun.Elems) != 1 {
+				return nil, fmt.Errorf("`tz` accepts one argument only")
+			}
+
+			location, err = parseTimeZone(fun.Elems[0])
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("You can't use %s with group by", fun.Name)
+		}
+	}
+
+	return &GroupByClause{
+		Elems:        values,
+		FillWithZero: fillWithZero,
+		FillType:     fillType,
+		FillValue:    fillValue,
+		Location:     location,
+	}, nil
+}
+
+func GetValueArray(array *C.value_array) ([]*Value, error) {
+	if array == nil {
+		return nil, nil
+	}
+
+	var values []*C.value
+	setupSlice((*reflect.SliceHeader)((unsafe.Pointer(&values))), unsafe.Pointer(array.elems), array.size)
+
+	valuesSlice := make([]*Value, 0, array.size)
+
+	for _, value := range values {
+		value, err := GetValue(value)
+		if err != nil {
+			return nil, err
+		}
+		valuesSlice = append(valuesSlice, value)
+	}
+	return valuesSlice, nil
+}
+
+func GetStringArray(array *C.array) []string {
+	if array == nil {
+		return nil
+	}
+
+	var values []*C.char
+	setupSlice((*reflect.SliceHeader)((unsafe.Pointer(&values))), unsafe.Pointer(array.elems), array.size)
+
+	stringSlice := make([]string, 0, array.size)
+
+	for _, value := range values {
+		stringSlice = append(stringSlice, C.GoString(value))
+	}
+	return stringSlice
+}
+
+func GetValue(value *C.value) (*Value, error) {
+	v := &Value{}
+	v.Name = C.GoString(value.name)
+	var err error
+	v.Elems, err = GetValueArray((*C.value_array)(value.args))
+	if err != nil {
+		return nil, err
+	}
+	v.Type = ValueType(value.value_type)
+	isCaseInsensitive := value.is_case_insensitive != 0
+	if v.Type == ValueRegex {
+		if isCaseInsensitive {
+			v.compiledRegex, err = regexp.Compile("(?i)" + v.Name)
+		} else {
+			v.compiledRegex, err = regexp.Compile(v.Name)
+		}
+		v.IsInsensitive = isCaseInsensitive
+	}
+	if value.alias != nil {
+		v.Alias = C.GoString(value.alias)
+	}
+	return v, err
+}
+
+func GetTableName(name *C.table_name) (*TableName, error) {
+	value, err := GetValue(name.name)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &TableName{Name: value}
+	if name.alias != nil {
+		table.Alias = C.GoString(name.alias)
+	}
+
+	return table, nil
+}
+
+func GetTableNameArray(array *C.table_name_array) ([]*TableName, error) {
+	var names []*C.table_name
+	setupSlice((*reflect.SliceHeader)((unsafe.Pointer(&names))), unsafe.Pointer(array.elems), array.size)
+
+	tableNamesSlice := make([]*TableName, 0, array.size)
+	for _, name := range names {
+		tableName, err := GetTableName(name)
+		if err != nil {
+			return nil, err
+		}
+		tableNamesSlice = append(tableNamesSlice, tableName)
+	}
+	return tableNamesSlice, nil
+}
+
+func GetFromClause(fromClause *C.from_clause) (*FromClause, error) {
+	t := FromClauseType(fromClause.from_clause_type)
+	var arr []*TableName
+	var regex *regexp.Regexp
+
+	switch t {
+	case FromClauseMergeRegex, FromClauseJoinRegex:
+		val, err := GetValue(fromClause.regex_value)
+		if err != nil {
+			return nil, err
+		}
+		if val.Type != ValueRegex {
+			return nil, fmt.Errorf("merge() accepts regex only")
+		}
+		regex = val.compiledRegex
+	default:
+		var err error
+		arr, err = GetTableNameArray(fromClause.names)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &FromClause{t, arr, regex}, nil
+}
+
+func GetIntoClause(intoClause *C.into_clause) (*IntoClause, error) {
+	if intoClause == nil {
+		return nil, nil
+	}
+
+	backfill := true
+	var backfillValue *Value = nil
+
+	target, err := GetValue(intoClause.target)
+	if err != nil {
+		return nil, err
+	}
+
+	if intoClause.backfill_function != nil {
+		fun, err := GetValue(intoClause.backfill_function)
+		if err != nil {
+			return nil, err
+		}
+		if fun.Name != "backfill" {
+			return nil, fmt.Errorf("You can't use %s with into", fun.Name)
+		}
+
+		if len(fun.Elems) != 1 {
+			return nil, fmt.Errorf("`backfill` accepts only one argument")
+		}
+
+		backfillValue = fun.Elems[0]
+		backfill, err = strconv.ParseBool(backfillValue.GetString())
+		if err != nil {
+			return nil, fmt.Errorf("`backfill` accepts only bool arguments")
+		}
+	}
+
+	return &IntoClause{
+		Target:        target,
+		Backfill:      backfill,
+		BackfillValue: backfillValue,
+	}, nil
+}
+
+func GetWhereCondition(condition *C.condition) (*WhereCondition, error) {
+	if condition.is_bool_expression != 0 {
+		expr, err := GetValue((*C.value)(condition.left))
+		if err != nil {
+			return nil, err
+		}
+		return &WhereCondition{
+			isBooleanExpression: true,
+			Left:                expr,
+			Operation:           "",
+			Right:               nil,
+		}, nil
+	}
+
+	c := &WhereCondition{}
+	var err error
+	c.Left, err = GetWhereCondition((*C.condition)(condition.left))
+	if err != nil {
+		return nil, err
+	}
+	c.Operation = C.GoString(condition.op)
+	c.Right, err = GetWhereCondition((*C.condition)(unsafe.Pointer(condition.right)))
+
+	return c, err
+}
+
+func parseSingleQuery(q *C.query) (*Query, error) {
+	if q.list_series_query != nil {
+		var value *Value
+		var err error
+		t := Series
+		if q.list_series_query.has_regex != 0 {
+			t = SeriesWithRegex
+			value, err = GetValue(q.list_series_query.regex)
+			if err != nil {
+				return nil, err
+			}
+		}
+		includeSpaces := false
+		if q.list_series_query.include_spaces != 0 {
+			includeSpaces = true
+		}
+		return &Query{ListQuery: &ListQuery{Type: t, value: value, IncludeSpaces: includeSpaces}, qType: ListSeries}, nil
+	}
+
+	if q.list_continuous_queries_query != 0 {
+		return &Query{ListQuery: &ListQuery{Type: ContinuousQueries}, qType: ListContinuousQueries}, nil
+	}
+
+	if q.select_query != nil {
+		selectQuery, err := parseSelectQuery(q.select_query)
+		if err != nil {
+			return nil, err
+		}
+
+		qType := Select
+		if selectQuery.IntoClause != nil {
+			qType = Continuous
+		}
+		return &Query{SelectQuery: selectQuery, qType: qType}, nil
+	} else if q.delete_query != nil {
+		deleteQuery, err := parseDeleteQuery(q.delete_query)
+		if err != nil {
+			return nil, err
+		}
+		return &Query{DeleteQuery: deleteQuery, qType: Delete}, nil
+	} else if q.drop_series_query != nil {
+		dropSeriesQuery, err := parseDropSeriesQuery(q.drop_series_query)
+		if err != nil {
+			return nil, err
+		}
+		return &Query{DropSeriesQuery: dropSeriesQuery, qType: DropSeries}, nil
+	} else if q.drop_query != nil {
+		return &Query{DropQuery: &DropQuery{Id: int(q.drop_query.id)}, qType: DropContinuousQuery}, nil
+	}
+	return nil, fmt.Errorf("Unknown query type encountered")
+}
+
+func ParseQuery(queryStr string) ([]*Query, error) {
+	queryString := C.CString(queryStr)
+	defer C.free(unsafe.Pointer(queryString))
+	q := C.parse_query(queryString)
+	defer C.close_queries(&q)
+
+	if q.error != nil {
+		str := C.GoString(q.error.err)
+		return nil, &ParseError{
+			firstLine:   int(q.error.first_line),
+			firstColumn: int(q.error.first_column) - 1,
+			lastLine:    int(q.error.last_line),
+			lastColumn:  int(q.error.last_column) - 1,
+			errorString: str,
+			queryString: queryStr,
+		}
+	}
+
+	var queries []*C.query
+	setupSlice((*reflect.SliceHeader)((unsafe.Pointer(&queries))), unsafe.Pointer(q.qs), q.size)
+
+	parsedQueries := make([]*Query, len(queries))
+	for i, query := range queries {
+		query, err := parseSingleQuery(query)
+		if err != nil {
+			return nil, err
+		}
+		parsedQueries[i] = query
+	}
+	return parsedQueries, nil
+}
+
+func parseDropSeriesQuery(dropSeriesQuery *C.drop_series_query) (*DropSeriesQuery, error) {
+	name, err := GetValue(dropSeriesQuery.name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DropSeriesQuery{
+		tableName: name.Name,
+	}, nil
+}
+
+func parseSelectDeleteCommonQuery(fromClause *C.from_clause, whereCondition *C.condition) (SelectDeleteCommonQuery, error) {
+	// get the from clause
+	from, err := GetFromClause(fromClause)
+	if err != nil {
+		return SelectDeleteCommonQuery{}, err
+	}
+
+	// get the where condition
+	var condition *WhereCondition
+	if whereCondition != nil {
+		condition, err = GetWhereCondition(whereCondition)
+		if err != nil {
+			return SelectDeleteCommonQuery{}, err
+		}
+	}
+
+	return newSelectDeleteCommonQuery(from, condition)
+}
+
+func parseSelectQuery(q *C.select_query) (*SelectQuery, error) {
+	limit := q.limit
+	if limit == -1 {
+		// no limit by default
+		limit = 0
+	}
+
+	basicQuery, err := parseSelectDeleteCommonQuery(q.from_clause, q.where_condition)
+	if err != nil {
+		return nil, err
+	}
+
+	goQuery := &SelectQuery{
+		SelectDeleteCommonQuery: basicQuery,
+		Limit:                   int(limit),
+		Ascending:               q.ascending != 0,
+		Explain:                 q.explain != 0,
+	}
+
+	// get the column names
+	goQuery.ColumnNames, err = GetValueArray((*C.value_array)(q.c))
+	if err != nil {
+		return nil, err
+	}
+
+	// get the group by clause
+	if q.group_by == nil {
+		goQuery.groupByClause = &GroupByClause{}
+	} else {
+		goQuery.groupByClause, err = GetGroupByClause(q.group_by)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// get the having condition
+	if q.having_condition != nil {
+		goQuery.Having, err = GetWhereCondition(q.having_condition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// get the into clause
+	goQuery.IntoClause, err = GetIntoClause(q.into_clause)
+	if err != nil {
+		return goQuery, err
+	}
+
+	return goQuery, nil
+}
+
+func parseDeleteQuery(query *C.delete_query) (*DeleteQuery, error) {
+	// get the from clause
+	from, err := GetFromClause(query.from_clause)
+	if err != nil {
+		return nil, err
+	}
+
+	// get the where condition
+	var condition *WhereCondition
+	if query.where_condition != nil {
+		condition, err = GetWhereCondition(query.where_condition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewDeleteQuery(from, condition)
+}
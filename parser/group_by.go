@@ -9,18 +9,65 @@ import (
 	log "code.google.com/p/log4go"
 )
 
+// FillType is how a query grouped by time() fills the buckets without
+// points.
+type FillType int
+
+const (
+	FillNone     FillType = iota // buckets without points are omitted
+	FillNull                     // buckets without points have null values
+	FillNumber                   // buckets without points have FillValue, a number or a string
+	FillPrevious                 // buckets without points have the previous bucket's values
+	FillLinear                   // buckets without points have values interpolated from their neighbours
+)
+
 type GroupByClause struct {
-	FillWithZero bool
+	FillWithZero bool // true if buckets without points are returned
+	FillType     FillType
 	FillValue    *Value
+	Location     *time.Location // the time zone of time() buckets, nil for UTC
 	Elems        []*Value
 }
 
+// parseFillType returns the fill type of a fill() argument which is null,
+// none, previous, linear or a constant number or string.
+func parseFillType(value *Value) (FillType, error) {
+	switch value.Type {
+	case ValueInt, ValueFloat, ValueString:
+		return FillNumber, nil
+	case ValueSimpleName:
+		switch strings.ToLower(value.Name) {
+		case "null":
+			return FillNull, nil
+		case "none":
+			return FillNone, nil
+		case "previous":
+			return FillPrevious, nil
+		case "linear":
+			return FillLinear, nil
+		}
+	}
+	return FillNone, fmt.Errorf("`fill` accepts null, none, previous, linear, a number or a string, got %s", value.GetString())
+}
+
+// parseTimeZone returns the location of a tz() argument which is a time
+// zone name, e.g. 'America/New_York'.
+func parseTimeZone(value *Value) (*time.Location, error) {
+	if value.Type != ValueString {
+		return nil, fmt.Errorf("`tz` accepts a time zone name only, got %s", value.GetString())
+	}
+	location, err := time.LoadLocation(value.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %s", value.GetString())
+	}
+	return location, nil
+}
+
 func (self GroupByClause) GetGroupByTime() (*time.Duration, bool, error) {
 	for _, groupBy := range self.Elems {
 		if groupBy.IsFunctionCall() && strings.ToLower(groupBy.Name) == "time" {
-			// TODO: check the number of arguments and return an error
-			if len(groupBy.Elems) != 1 {
-				return nil, false, NewQueryError(WrongNumberOfArguments, "time function only accepts one argument")
+			if len(groupBy.Elems) != 1 && len(groupBy.Elems) != 2 {
+				return nil, false, NewQueryError(WrongNumberOfArguments, "time function only accepts an interval and an optional offset")
 			}
 
 			if groupBy.Elems[0].Type != ValueDuration {
@@ -39,6 +86,29 @@ func (self GroupByClause) GetGroupByTime() (*time.Duration, bool, error) {
 	return nil, false, nil
 }
 
+// GetGroupByTimeOffset returns the offset of time() buckets, e.g. 6h for
+// time(1d, 6h). Returns zero if there's no offset.
+func (self GroupByClause) GetGroupByTimeOffset() (time.Duration, error) {
+	for _, groupBy := range self.Elems {
+		if groupBy.IsFunctionCall() && strings.ToLower(groupBy.Name) == "time" {
+			if len(groupBy.Elems) != 2 {
+				return 0, nil
+			}
+
+			arg := groupBy.Elems[1]
+			if arg.Type != ValueDuration {
+				return 0, NewQueryError(InvalidArgument, fmt.Sprintf("invalid offset %s to the time function", arg.GetString()))
+			}
+			offset, err := ParseTimeDuration(arg.Name)
+			if err != nil {
+				return 0, NewQueryError(InvalidArgument, fmt.Sprintf("invalid offset %s to the time function", arg.Name))
+			}
+			return time.Duration(offset), nil
+		}
+	}
+	return 0, nil
+}
+
 func (self *GroupByClause) GetString() string {
 	buffer := bytes.NewBufferString("")
 
@@ -47,5 +117,8 @@ func (self *GroupByClause) GetString() string {
 	if self.FillWithZero {
 		fmt.Fprintf(buffer, " fill(%s)", self.FillValue.GetString())
 	}
+	if self.Location != nil {
+		fmt.Fprintf(buffer, " tz('%s')", self.Location)
+	}
 	return buffer.String()
 }
@@ -0,0 +1,17 @@
+// +build noparser
+
+package parser
+
+import "errors"
+
+// Available is true if the package is built with the cgo query grammar,
+// which is left out by the noparser build tag.
+const Available = false
+
+// ErrUnavailable is returned by ParseQuery if the package is built without
+// the cgo query grammar.
+var ErrUnavailable = errors.New("the query parser isn't available in this build, use InfluxQL")
+
+func ParseQuery(queryStr string) ([]*Query, error) {
+	return nil, ErrUnavailable
+}
@@ -687,9 +687,79 @@ func (self *QueryParserSuite) TestParseSelectWithGroupByFillWithZero(c *C) {
 	c.Assert(groupBy.Elems[1].Elems[0].Name, Equals, "1h")
 }
 
+func (self *QueryParserSuite) TestParseSelectWithGroupByFillWithString(c *C) {
+	q, err := ParseSelectQuery("select count(*) from users.events group by time(1h) fill('n/a') where time>now()-1d;")
+	c.Assert(err, IsNil)
+
+	groupBy := q.GetGroupByClause()
+	c.Assert(groupBy.FillWithZero, Equals, true)
+	c.Assert(groupBy.FillType, Equals, FillNumber)
+	c.Assert(groupBy.FillValue.Type, Equals, ValueString)
+	c.Assert(groupBy.FillValue.Name, Equals, "n/a")
+}
+
+func (self *QueryParserSuite) TestParseSelectWithGroupByOffsetAndTimeZone(c *C) {
+	for _, query := range []string{
+		"select count(*) from users.events group by time(1d, 6h) fill(0) tz('America/New_York') where time>now()-1d;",
+		"select count(*) from users.events group by time(1d, 6h) tz('America/New_York') fill(0) where time>now()-1d;",
+	} {
+		q, err := ParseSelectQuery(query)
+		c.Assert(err, IsNil)
+
+		groupBy := q.GetGroupByClause()
+		c.Assert(groupBy.FillWithZero, Equals, true)
+		c.Assert(groupBy.FillValue.Name, Equals, "0")
+		c.Assert(groupBy.Location, NotNil)
+		c.Assert(groupBy.Location.String(), Equals, "America/New_York")
+
+		duration, irregular, err := groupBy.GetGroupByTime()
+		c.Assert(err, IsNil)
+		c.Assert(*duration, Equals, 24*time.Hour)
+		c.Assert(irregular, Equals, false)
+		offset, err := groupBy.GetGroupByTimeOffset()
+		c.Assert(err, IsNil)
+		c.Assert(offset, Equals, 6*time.Hour)
+
+		c.Assert(groupBy.GetString(), Equals, "time(1d,6h) fill(0) tz('America/New_York')")
+	}
+}
+
+func (self *QueryParserSuite) TestParseSelectWithHaving(c *C) {
+	for _, query := range []string{
+		"select host, mean(cpu) from load group by host, time(5m) having mean(cpu) > 80 and host <> 'a' limit 10;",
+		"select host, mean(cpu) from load where time > now() - 1d group by host, time(5m) having mean(cpu) > 80 and host <> 'a' limit 10;",
+	} {
+		q, err := ParseSelectQuery(query)
+		c.Assert(err, IsNil)
+		c.Assert(q.Limit, Equals, 10)
+		c.Assert(q.GetGroupByClause().Elems, HasLen, 2)
+
+		having := q.GetHavingCondition()
+		c.Assert(having, NotNil)
+		c.Assert(having.Operation, Equals, "AND")
+		left, ok := having.GetLeftWhereCondition()
+		c.Assert(ok, Equals, true)
+		expression, ok := left.GetBoolExpression()
+		c.Assert(ok, Equals, true)
+		c.Assert(expression.Name, Equals, ">")
+		c.Assert(expression.Elems[0].IsFunctionCall(), Equals, true)
+		c.Assert(expression.Elems[0].GetString(), Equals, "mean(cpu)")
+		c.Assert(q.GetQueryString(), Matches, ".* group by host,time\\(5m\\) having \\(mean\\(cpu\\) > 80\\) AND \\(host <> 'a'\\) limit 10")
+	}
+
+	q, err := ParseSelectQuery("select mean(cpu) from load group by host;")
+	c.Assert(err, IsNil)
+	c.Assert(q.GetHavingCondition(), IsNil)
+}
+
 func (self *QueryParserSuite) TestParseSelectWithGroupByWithInvalidFunctions(c *C) {
 	for _, query := range []string{
 		"select count(*) from users.events group by user_email,time(1h) foobar(0) where time>now()-1d;",
+		"select count(*) from users.events group by user_email,time(1h) fill(zero) where time>now()-1d;",
+		"select count(*) from users.events group by user_email,time(1h) fill(0) fill(0) where time>now()-1d;",
+		"select count(*) from users.events group by user_email,time(1h) tz(UTC) where time>now()-1d;",
+		"select count(*) from users.events group by user_email,time(1h) tz('Nowhere/Town') where time>now()-1d;",
+		"select count(*) from users.events group by user_email,time(1h) tz('UTC') tz('UTC') where time>now()-1d;",
 	} {
 		_, err := ParseSelectQuery(query)
 		c.Assert(err, NotNil)
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"sort"
 	"strconv"
@@ -359,6 +360,67 @@ func isNumericValue(value *Value) bool {
 	}
 }
 
+// newSelectDeleteCommonQuery returns the common part of a select or delete
+// query. The time range is extracted from the condition and the remaining
+// condition, if any, is set on the query.
+func newSelectDeleteCommonQuery(from *FromClause, condition *WhereCondition) (SelectDeleteCommonQuery, error) {
+	goQuery := SelectDeleteCommonQuery{
+		BasicQuery: BasicQuery{
+			startTime: time.Unix(math.MinInt64/1000000000, 0).UTC(),
+			endTime:   time.Now().UTC(),
+		},
+		FromClause: from,
+		Condition:  condition,
+	}
+
+	var err error
+	var startTime, endTime *time.Time
+	goQuery.Condition, endTime, err = getTime(goQuery.GetWhereCondition(), false)
+	if err != nil {
+		return goQuery, err
+	}
+
+	if endTime != nil {
+		goQuery.endTime = *endTime
+	}
+
+	goQuery.Condition, startTime, err = getTime(goQuery.GetWhereCondition(), true)
+	if err != nil {
+		return goQuery, err
+	}
+
+	if startTime != nil {
+		goQuery.startTime = *startTime
+		goQuery.startTimeSpecified = true
+	}
+
+	return goQuery, nil
+}
+
+// NewSelectQuery returns a select query of the series of a from clause
+// without columns or group by elements. The time range is extracted from
+// the condition the same way as it is for queries parsed by ParseQuery.
+func NewSelectQuery(from *FromClause, condition *WhereCondition) (*SelectQuery, error) {
+	common, err := newSelectDeleteCommonQuery(from, condition)
+	if err != nil {
+		return nil, err
+	}
+	return &SelectQuery{SelectDeleteCommonQuery: common, groupByClause: &GroupByClause{}}, nil
+}
+
+// NewDeleteQuery returns a delete query of the series of a from clause. The
+// condition can only reference time.
+func NewDeleteQuery(from *FromClause, condition *WhereCondition) (*DeleteQuery, error) {
+	common, err := newSelectDeleteCommonQuery(from, condition)
+	if err != nil {
+		return nil, err
+	}
+	if common.GetWhereCondition() != nil {
+		return nil, fmt.Errorf("Delete queries can't have where clause that don't reference time")
+	}
+	return &DeleteQuery{SelectDeleteCommonQuery: common}, nil
+}
+
 // parse the start time or end time from the where conditions and return the new condition
 // without the time clauses, or nil if there are no where conditions left
 func getTime(condition *WhereCondition, isParsingStartTime bool) (*WhereCondition, *time.Time, error) {
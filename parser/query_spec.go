@@ -237,5 +237,18 @@ func (self *QuerySpec) CanAggregateLocally(d time.Duration) bool {
 		}
 		return true
 	}
+
+	// Buckets without points are filled once all shards are aggregated,
+	// otherwise each shard would fill the whole time range.
+	groupBy := self.SelectQuery().GetGroupByClause()
+	if groupBy.FillWithZero {
+		return false
+	}
+
+	// Shards start at multiples of their duration since the epoch, buckets
+	// shifted by an offset or aligned to a time zone span several shards.
+	if offset, _ := groupBy.GetGroupByTimeOffset(); offset%*groupByInterval != 0 || groupBy.Location != nil {
+		return false
+	}
 	return (d%*groupByInterval == 0) && !self.GroupByIrregularInterval
 }
@@ -47,7 +47,8 @@ typedef struct condition_t {
 
 typedef struct groupby_clause_t {
   value_array *elems;
-  value *fill_function;
+  value *fill_function;                 /* fill() or tz() */
+  value *tz_function;                   /* tz() or fill() if both are set */
 } groupby_clause;
 
 typedef struct {
@@ -93,6 +94,7 @@ typedef struct {
   groupby_clause *group_by;
   into_clause *into_clause;
   condition *where_condition;
+  condition *having_condition;
   int limit;
   char ascending;
   char explain;
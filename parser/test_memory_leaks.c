@@ -29,6 +29,8 @@ main(int argc, char **argv)
     "select email from users.events as events where email === /gmail\\\\.com/i and time>now()-2d;",
     "select value from t where c = '5';",
     "select * from foo into bar;",
+    "select count(*) from users.events group by time(1d, 6h) fill(0) tz('America/New_York');",
+    "select host, mean(cpu) from load group by host, time(5m) having mean(cpu) > 80 limit 10;",
   };
 
   int i;
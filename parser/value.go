@@ -1,9 +1,5 @@
 package parser
 
-// #include "query_types.h"
-// #include <stdlib.h>
-import "C"
-
 import (
 	"bytes"
 	"fmt"
@@ -12,19 +8,20 @@ import (
 
 type ValueType int
 
+// The value types, in the order of the value_type enum of query_types.h.
 const (
-	ValueRegex        ValueType = C.VALUE_REGEX
-	ValueInt                    = C.VALUE_INT
-	ValueBool                   = C.VALUE_BOOLEAN
-	ValueFloat                  = C.VALUE_FLOAT
-	ValueString                 = C.VALUE_STRING
-	ValueIntoName               = C.VALUE_INTO_NAME
-	ValueTableName              = C.VALUE_TABLE_NAME
-	ValueSimpleName             = C.VALUE_SIMPLE_NAME
-	ValueDuration               = C.VALUE_DURATION
-	ValueWildcard               = C.VALUE_WILDCARD
-	ValueFunctionCall           = C.VALUE_FUNCTION_CALL
-	ValueExpression             = C.VALUE_EXPRESSION
+	ValueRegex ValueType = iota
+	ValueInt
+	ValueFloat
+	ValueBool
+	ValueString
+	ValueIntoName
+	ValueTableName
+	ValueSimpleName
+	ValueDuration
+	ValueWildcard
+	ValueFunctionCall
+	ValueExpression
 )
 
 type Value struct {
@@ -36,6 +33,12 @@ type Value struct {
 	IsInsensitive bool
 }
 
+// NewRegexValue returns a regex value matching a compiled regular
+// expression.
+func NewRegexValue(regex *regexp.Regexp) *Value {
+	return &Value{Name: regex.String(), Type: ValueRegex, compiledRegex: regex}
+}
+
 func (self *Value) IsFunctionCall() bool {
 	return self.Type == ValueFunctionCall
 }
@@ -11,6 +11,12 @@ type WhereCondition struct {
 	Right               *WhereCondition
 }
 
+// NewBoolExpression returns a condition matching the points for which a
+// boolean expression, e.g. value > 90, is true.
+func NewBoolExpression(expr *Value) *WhereCondition {
+	return &WhereCondition{isBooleanExpression: true, Left: expr}
+}
+
 func (self *WhereCondition) GetBoolExpression() (*Value, bool) {
 	if self.isBooleanExpression {
 		return self.Left.(*Value), true
@@ -3,6 +3,7 @@ package influxdb
 import (
 	"errors"
 	"fmt"
+	"sync"
 
 	"code.google.com/p/log4go"
 
@@ -51,19 +52,25 @@ func (p *ResponseChannelProcessor) Next() engine.Processor {
 	return nil
 }
 
-// A `ResponseProcessor' that wraps a go channel.
+// A `ResponseProcessor' that wraps a go channel. Yield returns false
+// once `done' is closed by the reader of the channel.
 type ResponseChannelWrapper struct {
-	c chan<- *protocol.Response
+	c    chan<- *protocol.Response
+	done <-chan struct{}
 }
 
-func NewResponseChannelWrapper(c chan<- *protocol.Response) ResponseChannel {
-	return &ResponseChannelWrapper{c}
+func NewResponseChannelWrapper(c chan<- *protocol.Response, done <-chan struct{}) ResponseChannel {
+	return &ResponseChannelWrapper{c, done}
 }
 
 func (w *ResponseChannelWrapper) Yield(r *protocol.Response) bool {
 	log4go.Debug("ResponseChannelWrapper: Yielding %s", r)
-	w.c <- r
-	return true
+	select {
+	case w.c <- r:
+		return true
+	case <-w.done:
+		return false
+	}
 }
 
 func (w *ResponseChannelWrapper) Name() string {
@@ -75,18 +82,37 @@ func (w *ResponseChannelWrapper) Name() string {
 // giving away `concurrency' channels at any given time. This is used
 // in the coordinator to merge the responses received from different
 // shards, which could be remote or local.
+//
+// When the next processor returns false for a series the series is
+// marked as stopped. Its remaining responses are skipped while the other
+// series in the channel are still processed, and callers can skip
+// querying shards for it. A channel is released as soon as all of the
+// series it carries are stopped.
 type MergeChannelProcessor struct {
 	next engine.Processor
-	c    chan (<-chan *protocol.Response)
+	c    chan *mergeChannel
 	e    chan error
+
+	mu      sync.Mutex
+	stopped map[string]bool
+}
+
+// mergeChannel is a response channel given away by NextChannel(). The
+// `done' channel is closed once the MergeChannelProcessor stops
+// reading responses, after which writers must not block on `c'.
+type mergeChannel struct {
+	c     chan *protocol.Response
+	done  chan struct{}
+	names []string // series carried by the channel
 }
 
 // Return a new MergeChannelProcessor that will yield to `next'
 func NewMergeChannelProcessor(next engine.Processor, concurrency int) *MergeChannelProcessor {
 	p := &MergeChannelProcessor{
-		next: next,
-		e:    make(chan error, concurrency),
-		c:    make(chan (<-chan *protocol.Response), concurrency),
+		next:    next,
+		e:       make(chan error, concurrency),
+		c:       make(chan *mergeChannel, concurrency),
+		stopped: make(map[string]bool),
 	}
 	// Fill `p.e' with `concurrency' nils, see NextChannel() for an
 	// explanation of why we do this.
@@ -96,11 +122,10 @@ func NewMergeChannelProcessor(next engine.Processor, concurrency int) *MergeChan
 	return p
 }
 
-// Closes MergeChannelProcessor, this method has to make sure that all
-// responses are received from the response channels. This is
-// important since the protobuf client may block trying to insert a
-// new response which will cause the entire node to stop receiving
-// remote responses.
+// Closes MergeChannelProcessor, this method has to make sure that
+// writers of all response channels are released. This is important
+// since the protobuf client may block trying to insert a new response
+// which will cause the entire node to stop receiving remote responses.
 func (p *MergeChannelProcessor) Close() (err error) {
 	// Close the channels' channel. This will cause NextChannel() to
 	// panic if it was called after Close() is called and will cause
@@ -117,37 +142,24 @@ func (p *MergeChannelProcessor) Close() (err error) {
 	}
 
 	// At this point ProcessChannels() has returned and NextChannel()
-	// cannot be called. Go over all channels that were returned and
-	// make sure we read all responses on those channels.
+	// cannot be called. Go over all channels that were never processed
+	// and tell their writers to stop.
 	for c := range p.c {
-	nextChannel:
-		for r := range c {
-			switch rt := r.GetType(); rt {
-			case protocol.Response_END_STREAM,
-				protocol.Response_HEARTBEAT:
-				break nextChannel
-			case protocol.Response_ERROR:
-				// If there were no errors from before, return this error
-				if err == nil {
-					err = errors.New(r.GetErrorMessage())
-				}
-				break nextChannel
-			case protocol.Response_QUERY:
-				continue // ignore the response, we're closing
-			default:
-				panic(fmt.Errorf("Unexpected response type: %s", rt))
-			}
-		}
+		close(c.done)
 	}
 	return err
 }
 
-// Returns a new channel with buffer size `bs'. This method will block
+// Returns a new channel with buffer size `bs' and a channel that is
+// closed when no more responses are needed. If the names of the series
+// sent on the channel are given, the channel is closed as soon as all
+// of them are stopped, without waiting for the end of the stream.
+// This method will block
 // until there are channels available to return. Remember
 // MergeChannelProcessor controls the concurrency of the query by
 // guaranteeing no more than `concurrency' channels are given away at
 // any given time.
-func (p *MergeChannelProcessor) NextChannel(bs int) (chan<- *protocol.Response, error) {
+func (p *MergeChannelProcessor) NextChannel(bs int, names ...string) (chan<- *protocol.Response, <-chan struct{}, error) {
 	// `p.e' serves two purpose in MergeChannelProcessor. To return
 	// errors received in ProcessChannels, and to control the
 	// concurrency. Initially `p.e' has `concurrency' nils in it which
@@ -157,11 +169,30 @@ func (p *MergeChannelProcessor) NextChannel(bs int) (chan<- *protocol.Response,
 	// `p.e'.
 	err := <-p.e
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	c := &mergeChannel{
+		c:     make(chan *protocol.Response, bs),
+		done:  make(chan struct{}),
+		names: names,
 	}
-	c := make(chan *protocol.Response, bs)
 	p.c <- c
-	return c, nil
+	return c.c, c.done, nil
+}
+
+// Returns true if the next processor doesn't need any more data for
+// all of the given series names. Since a channel is only handed out
+// after the previous one was processed, a concurrency of 1 guarantees
+// this is up to date when called after NextChannel().
+func (p *MergeChannelProcessor) Stopped(names ...string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range names {
+		if !p.stopped[name] {
+			return false
+		}
+	}
+	return len(names) > 0
 }
 
 func (p *MergeChannelProcessor) String() string {
@@ -182,8 +213,10 @@ func (p *MergeChannelProcessor) ProcessChannels() {
 
 // Process responses from the given channel. Returns true if
 // processing should stop for other channels. False otherwise.
-func (p *MergeChannelProcessor) processChannel(channel <-chan *protocol.Response) bool {
-	for response := range channel {
+func (p *MergeChannelProcessor) processChannel(channel *mergeChannel) bool {
+	defer close(channel.done)
+
+	for response := range channel.c {
 		log4go.Debug("%s received %s", p, response)
 
 		switch rt := response.GetType(); rt {
@@ -201,11 +234,27 @@ func (p *MergeChannelProcessor) processChannel(channel <-chan *protocol.Response
 
 		case protocol.Response_QUERY:
 			for _, s := range response.MultiSeries {
+				// The rest of a stopped series isn't needed.
+				if p.Stopped(s.GetName()) {
+					continue
+				}
+
 				log4go.Debug("Yielding to %s: %s", p.next.Name(), s)
-				_, err := p.next.Yield(s)
+				ok, err := p.next.Yield(s)
 				if err != nil {
 					p.e <- err
 					return true
+				} else if !ok {
+					log4go.Debug("%s stopped reading %s", p, s.GetName())
+					p.mu.Lock()
+					p.stopped[s.GetName()] = true
+					p.mu.Unlock()
+
+					// Release the writer once none of its series are needed.
+					if p.Stopped(channel.names...) {
+						p.e <- nil
+						return false
+					}
 				}
 			}
 
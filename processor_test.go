@@ -0,0 +1,93 @@
+package influxdb_test
+
+import (
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+// Ensure a stopped series is skipped while the other series of the same
+// channel are still processed.
+func TestMergeChannelProcessor_Stopped(t *testing.T) {
+	next := &stopProcessor{name: "cpu"}
+	p := influxdb.NewMergeChannelProcessor(next, 1)
+	go p.ProcessChannels()
+
+	// Send three responses holding both series.
+	c, _, err := p.NextChannel(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		c <- &protocol.Response{
+			Type:        protocol.Response_QUERY.Enum(),
+			MultiSeries: []*protocol.Series{{Name: proto.String("cpu")}, {Name: proto.String("mem")}},
+		}
+	}
+	c <- &protocol.Response{Type: protocol.Response_END_STREAM.Enum()}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify only the stopped series was cut short.
+	counts := make(map[string]int)
+	for _, s := range next.Series {
+		counts[s.GetName()]++
+	}
+	if counts["cpu"] != 1 || counts["mem"] != 3 {
+		t.Fatalf("unexpected counts: %v", counts)
+	} else if !p.Stopped("cpu") || p.Stopped("mem") {
+		t.Fatal("unexpected stopped series")
+	}
+}
+
+// Ensure a channel is released once all of the series it carries are stopped.
+func TestMergeChannelProcessor_Stopped_Release(t *testing.T) {
+	next := &stopProcessor{name: "cpu"}
+	p := influxdb.NewMergeChannelProcessor(next, 1)
+	go p.ProcessChannels()
+
+	// Send a response for the only series in the channel.
+	c, done, err := p.NextChannel(10, "cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c <- &protocol.Response{
+		Type:        protocol.Response_QUERY.Enum(),
+		MultiSeries: []*protocol.Series{{Name: proto.String("cpu")}},
+	}
+
+	// Verify the channel is released without the end of the stream.
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("channel not released")
+	}
+
+	// Verify the next channel is available.
+	c, _, err = p.NextChannel(10, "mem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c <- &protocol.Response{Type: protocol.Response_END_STREAM.Enum()}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	} else if len(next.Series) != 1 {
+		t.Fatalf("unexpected series count: %d", len(next.Series))
+	}
+}
+
+// stopProcessor records all yields and stops the named series after its
+// first yield.
+type stopProcessor struct {
+	ProcessorRecorder
+	name string
+}
+
+func (p *stopProcessor) Yield(s *protocol.Series) (bool, error) {
+	p.Series = append(p.Series, s)
+	return s.GetName() != p.name, nil
+}
@@ -1,17 +1,23 @@
 package influxdb
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"code.google.com/p/goprotobuf/proto"
+	"code.google.com/p/log4go"
 	"github.com/boltdb/bolt"
+	"github.com/influxdb/influxdb/engine"
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
 	"github.com/influxdb/influxdb/protocol"
 )
@@ -35,6 +41,23 @@ const (
 
 	// DefaultShardRetention is the length of time before a shard is dropped.
 	DefaultShardRetention = time.Duration(0)
+
+	// DefaultContinuousQueryCheckInterval is how often the server checks for
+	// continuous queries that need to be run.
+	DefaultContinuousQueryCheckInterval = 1 * time.Second
+
+	// DefaultContinuousQueryLeaseDuration is how long a server holds the
+	// exclusive right to run continuous queries once it is granted.
+	DefaultContinuousQueryLeaseDuration = 1 * time.Minute
+
+	// DefaultContinuousQueryBatchSize is the maximum number of GROUP BY
+	// intervals processed by a single run of a continuous query. It bounds
+	// the results held in memory while backfilling or catching up.
+	DefaultContinuousQueryBatchSize = 100
+
+	// DefaultPointBatchSize is the number of points read from a shard and
+	// passed to query processors at a time.
+	DefaultPointBatchSize = 100
 )
 
 const (
@@ -50,14 +73,24 @@ const (
 	deleteDBUserMessageType            = messaging.MessageType(0x08)
 	dbUserSetPasswordMessageType       = messaging.MessageType(0x09)
 	createShardIfNotExistsMessageType  = messaging.MessageType(0x0a)
+	dbUserSetPermissionsMessageType    = messaging.MessageType(0x0b)
+	updateShardSpaceMessageType        = messaging.MessageType(0x0c)
+	createContinuousQueryMessageType   = messaging.MessageType(0x0d)
+	deleteContinuousQueryMessageType   = messaging.MessageType(0x0e)
+	continuousQueryLeaseMessageType    = messaging.MessageType(0x0f)
+	deleteShardMessageType             = messaging.MessageType(0x10)
+	dropSeriesMessageType              = messaging.MessageType(0x11)
+	continuousQueryRunMessageType      = messaging.MessageType(0x12)
 
 	// per-topic messages
-	writeSeriesMessageType = messaging.MessageType(0x80)
+	writeSeriesMessageType  = messaging.MessageType(0x80)
+	deleteSeriesMessageType = messaging.MessageType(0x81)
 )
 
 // Server represents a collection of metadata and raw metric data.
 type Server struct {
 	mu   sync.RWMutex
+	id   uint64 // random identifier used for leases
 	path string
 	done chan struct{} // goroutine close notification
 
@@ -69,6 +102,13 @@ type Server struct {
 
 	databases map[string]*Database     // databases by name
 	admins    map[string]*ClusterAdmin // admins by name
+
+	cqLease continuousQueryLease // server allowed to run continuous queries
+
+	unsubscribes map[uint64]struct{} // topics to unsubscribe from
+
+	shardStore     string // default store for new shards
+	pointBatchSize int    // points per series yielded by shard queries
 }
 
 // NewServer returns a new instance of Server.
@@ -76,12 +116,49 @@ type Server struct {
 func NewServer(client MessagingClient) *Server {
 	assert(client != nil, "messaging client required")
 	return &Server{
-		client:    client,
-		meta:      &metastore{},
-		databases: make(map[string]*Database),
-		admins:    make(map[string]*ClusterAdmin),
-		errors:    make(map[uint64]error),
+		id:         randomID(),
+		client:     client,
+		meta:       &metastore{},
+		databases:  make(map[string]*Database),
+		admins:     make(map[string]*ClusterAdmin),
+		errors:     make(map[uint64]error),
+		shardStore: DefaultShardStore,
+
+		unsubscribes:   make(map[uint64]struct{}),
+		pointBatchSize: DefaultPointBatchSize,
+	}
+}
+
+// SetDefaultShardStore sets the store used by new shards in shard spaces
+// which do not specify their own store. Existing shards keep their store.
+func (s *Server) SetDefaultShardStore(name string) error {
+	if shardStores[name] == nil {
+		return ErrShardStoreNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shardStore = name
+	return nil
+}
+
+// defaultShardStore returns the store used by new shards in shard spaces
+// which do not specify their own store.
+func (s *Server) defaultShardStore() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.shardStore
+}
+
+// SetPointBatchSize sets the maximum number of points read from a shard and
+// yielded to query processors in a single series. Non-positive sizes reset
+// the batch size to the default.
+func (s *Server) SetPointBatchSize(n int) {
+	if n <= 0 {
+		n = DefaultPointBatchSize
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pointBatchSize = n
 }
 
 // Path returns the path used when opening the server.
@@ -126,10 +203,21 @@ func (s *Server) Open(path string) error {
 	// Set the server path.
 	s.path = path
 
+	// Open the store of each shard.
+	if err := s.openShards(); err != nil {
+		s.closeShards()
+		_ = s.meta.close()
+		s.path = ""
+		return fmt.Errorf("open shards: %s", err)
+	}
+
 	// Start goroutine to read messages from the broker.
 	s.done = make(chan struct{}, 0)
 	go s.processor(s.done)
 
+	// Start goroutine to run continuous queries.
+	go s.continuousQueryLoop(s.done)
+
 	return nil
 }
 
@@ -149,7 +237,8 @@ func (s *Server) Close() error {
 	close(s.done)
 	s.done = nil
 
-	// Close metastore.
+	// Close shards and metastore.
+	s.closeShards()
 	_ = s.meta.close()
 
 	// Remove path.
@@ -158,6 +247,33 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// openShards opens the stores of all shards on the server.
+func (s *Server) openShards() error {
+	for _, db := range s.databases {
+		for _, ss := range db.spaces {
+			for _, sh := range ss.Shards {
+				if err := sh.open(s.shardPath(sh.ID)); err != nil {
+					return fmt.Errorf("shard %d: %s", sh.ID, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// closeShards flushes and closes the stores of all shards on the server.
+func (s *Server) closeShards() {
+	for _, db := range s.databases {
+		for _, ss := range db.spaces {
+			for _, sh := range ss.Shards {
+				if err := sh.close(); err != nil {
+					log4go.Error("close shard %d: %s", sh.ID, err)
+				}
+			}
+		}
+	}
+}
+
 // load reads the state of the server from the metastore.
 func (s *Server) load() error {
 	return s.meta.view(func(tx *metatx) error {
@@ -324,7 +440,7 @@ func (s *Server) applyCreateShardIfNotExists(m *messaging.Message) error {
 	}
 
 	// Check if a matching shard already exists.
-	if err, ok := db.applyCreateShardIfNotExists(m.Index, c.Space, c.Timestamp); err != nil {
+	if err, ok := db.applyCreateShardIfNotExists(m.Index, c.Space, c.Timestamp, c.Engine); err != nil {
 		return err
 	} else if !ok {
 		return nil
@@ -342,6 +458,65 @@ type createShardIfNotExistsSpaceCommand struct {
 	Database  string    `json:"name"`
 	Space     string    `json:"space"`
 	Timestamp time.Time `json:"timestamp"`
+	Engine    string    `json:"engine,omitempty"`
+}
+
+func (s *Server) applyDeleteShard(m *messaging.Message) error {
+	var c deleteShardCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if s.databases[c.Database] == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Remove the shard and its data.
+	if err := db.applyDeleteShard(c.ID); err != nil {
+		return err
+	}
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	// Stop receiving writes for the shard once the lock is released.
+	s.unsubscribes[c.ID] = struct{}{}
+
+	return nil
+}
+
+// unsubscribe removes the server from the topics of deleted shards. It is
+// called by the processor after a message is applied so the broker isn't
+// contacted while holding the lock. Failed topics are retried after the
+// next message.
+func (s *Server) unsubscribe() {
+	s.mu.Lock()
+	var topicIDs []uint64
+	for id := range s.unsubscribes {
+		topicIDs = append(topicIDs, id)
+	}
+	s.mu.Unlock()
+
+	for _, id := range topicIDs {
+		if _, err := s.client.Unsubscribe(id); err != nil {
+			warnf("unsubscribe(%d): %s", id, err)
+			continue
+		}
+
+		s.mu.Lock()
+		delete(s.unsubscribes, id)
+		s.mu.Unlock()
+	}
+}
+
+type deleteShardCommand struct {
+	Database string `json:"database"`
+	ID       uint64 `json:"id"`
 }
 
 // ClusterAdmin returns an admin by name.
@@ -352,6 +527,16 @@ func (s *Server) ClusterAdmin(name string) *ClusterAdmin {
 	return s.admins[name]
 }
 
+// AuthenticateClusterAdmin returns the cluster admin with the given name if
+// the password matches. Returns an AuthenticationError otherwise.
+func (s *Server) AuthenticateClusterAdmin(username, password string) (*ClusterAdmin, error) {
+	u := s.ClusterAdmin(username)
+	if u == nil || !u.isValidPwd(password) {
+		return nil, NewAuthenticationError("invalid username or password")
+	}
+	return u, nil
+}
+
 // ClusterAdmins returns a list of all cluster admins, sorted by name.
 func (s *Server) ClusterAdmins() []*ClusterAdmin {
 	s.mu.Lock()
@@ -394,8 +579,9 @@ func (s *Server) applyCreateClusterAdmin(m *messaging.Message) error {
 	// Create the cluster admin.
 	u := &ClusterAdmin{
 		CommonUser: CommonUser{
-			Name: c.Username,
-			Hash: string(hash),
+			Name:     c.Username,
+			Hash:     string(hash),
+			CacheKey: c.Username,
 		},
 	}
 
@@ -448,6 +634,63 @@ type deleteClusterAdminCommand struct {
 	Username string `json:"username"`
 }
 
+// SetClusterAdminPassword changes the password of a cluster admin.
+func (s *Server) SetClusterAdminPassword(username, password string) error {
+	c := &clusterAdminSetPasswordCommand{Username: username, Password: password}
+	_, err := s.broadcast(clusterAdminSetPasswordMessageType, c)
+	return err
+}
+
+func (s *Server) applyClusterAdminSetPassword(m *messaging.Message) error {
+	var c clusterAdminSetPasswordCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate admin.
+	u := s.admins[c.Username]
+	if c.Username == "" {
+		return ErrUsernameRequired
+	} else if u == nil {
+		return ErrClusterAdminNotFound
+	}
+
+	// Generate the hash of the password.
+	hash, err := HashPassword(c.Password)
+	if err != nil {
+		return err
+	}
+
+	// Update the password hash and drop the cached password.
+	u.ChangePassword(string(hash))
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveClusterAdmin(u)
+	})
+
+	return nil
+}
+
+type clusterAdminSetPasswordCommand struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// CreateRootIfNotExists creates the root cluster admin with the default
+// password if the cluster doesn't have any cluster admins yet. Without an
+// admin, administrative statements could never be authorized.
+func (s *Server) CreateRootIfNotExists() error {
+	if len(s.ClusterAdmins()) > 0 {
+		return nil
+	}
+	if err := s.CreateClusterAdmin("root", DefaultRootPassword); err != nil && err != ErrClusterAdminExists {
+		return err
+	}
+	return nil
+}
+
 func (s *Server) applyDBUserSetPassword(m *messaging.Message) error {
 	var c dbUserSetPasswordCommand
 	mustUnmarshalJSON(m.Data, &c)
@@ -480,6 +723,39 @@ type dbUserSetPasswordCommand struct {
 	Password string `json:"password"`
 }
 
+func (s *Server) applyDBUserSetPermissions(m *messaging.Message) error {
+	var c dbUserSetPermissionsCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if s.databases[c.Database] == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Update permissions in database.
+	if err := db.applyChangePermissions(c.Username, c.ReadFrom, c.WriteTo); err != nil {
+		return err
+	}
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return nil
+}
+
+type dbUserSetPermissionsCommand struct {
+	Database string `json:"database"`
+	Username string `json:"username"`
+	ReadFrom string `json:"readFrom"`
+	WriteTo  string `json:"writeTo"`
+}
+
 func (s *Server) applyCreateDBUser(m *messaging.Message) error {
 	var c createDBUserCommand
 	mustUnmarshalJSON(m.Data, &c)
@@ -556,7 +832,7 @@ func (s *Server) applyCreateShardSpace(m *messaging.Message) error {
 		return ErrDatabaseNotFound
 	}
 
-	if err := db.applyCreateShardSpace(c.Name, c.Regex, c.Retention, c.Duration, c.ReplicaN, c.SplitN); err != nil {
+	if err := db.applyCreateShardSpace(c.Name, c.Regex, c.Retention, c.Duration, c.ReplicaN, c.SplitN, c.Engine); err != nil {
 		return err
 	}
 
@@ -576,6 +852,43 @@ type createShardSpaceCommand struct {
 	Duration  time.Duration `json:"duration"`
 	ReplicaN  uint32        `json:"replicaN"`
 	SplitN    uint32        `json:"splitN"`
+	Engine    string        `json:"engine,omitempty"`
+}
+
+func (s *Server) applyUpdateShardSpace(m *messaging.Message) error {
+	var c updateShardSpaceCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if s.databases[c.Database] == nil {
+		return ErrDatabaseNotFound
+	}
+
+	if err := db.applyUpdateShardSpace(c.Name, c.Regex, c.Retention, c.Duration, c.ReplicaN, c.SplitN, c.Engine); err != nil {
+		return err
+	}
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return nil
+}
+
+type updateShardSpaceCommand struct {
+	Database  string        `json:"database"`
+	Name      string        `json:"name"`
+	Regex     string        `json:"regex"`
+	Retention time.Duration `json:"retention"`
+	Duration  time.Duration `json:"duration"`
+	ReplicaN  uint32        `json:"replicaN"`
+	SplitN    uint32        `json:"splitN"`
+	Engine    string        `json:"engine,omitempty"`
 }
 
 func (s *Server) applyDeleteShardSpace(m *messaging.Message) error {
@@ -609,6 +922,149 @@ type deleteShardSpaceCommand struct {
 	Name     string `json:"name"`
 }
 
+func (s *Server) applyCreateContinuousQuery(m *messaging.Message) error {
+	var c createContinuousQueryCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if s.databases[c.Database] == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// The message index is used as the continuous query id.
+	if err := db.applyCreateContinuousQuery(m.Index, c.Query, c.Timestamp, c.Backfill); err != nil {
+		return err
+	}
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return nil
+}
+
+type createContinuousQueryCommand struct {
+	Database  string    `json:"database"`
+	Query     string    `json:"query"`
+	Timestamp time.Time `json:"timestamp"`
+	Backfill  time.Time `json:"backfill,omitempty"`
+}
+
+func (s *Server) applyDeleteContinuousQuery(m *messaging.Message) error {
+	var c deleteContinuousQueryCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if s.databases[c.Database] == nil {
+		return ErrDatabaseNotFound
+	}
+
+	if err := db.applyDeleteContinuousQuery(c.ID); err != nil {
+		return err
+	}
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return nil
+}
+
+type deleteContinuousQueryCommand struct {
+	Database string `json:"database"`
+	ID       uint64 `json:"id"`
+}
+
+func (s *Server) applyContinuousQueryRun(m *messaging.Message) error {
+	var c continuousQueryRunCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if s.databases[c.Database] == nil {
+		return ErrDatabaseNotFound
+	}
+
+	if err := db.applyContinuousQueryRun(c.ID, c.LastRun); err != nil {
+		return err
+	}
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return nil
+}
+
+type continuousQueryRunCommand struct {
+	Database string    `json:"database"`
+	ID       uint64    `json:"id"`
+	LastRun  time.Time `json:"lastRun"`
+}
+
+// requestContinuousQueryLease requests the right to run continuous queries.
+// Returns ErrContinuousQueryLeaseHeld if another server holds the lease.
+// The request is skipped if the server holds a lease that isn't half expired
+// or if another server holds a lease that isn't expired.
+func (s *Server) requestContinuousQueryLease(now time.Time) error {
+	s.mu.RLock()
+	lease := s.cqLease
+	s.mu.RUnlock()
+
+	if lease.serverID == s.id && now.Before(lease.expiry.Add(-DefaultContinuousQueryLeaseDuration/2)) {
+		return nil
+	} else if lease.serverID != s.id && now.Before(lease.expiry) {
+		return ErrContinuousQueryLeaseHeld
+	}
+
+	c := &continuousQueryLeaseCommand{ServerID: s.id, Timestamp: now, Duration: DefaultContinuousQueryLeaseDuration}
+	_, err := s.broadcast(continuousQueryLeaseMessageType, c)
+	return err
+}
+
+func (s *Server) applyContinuousQueryLease(m *messaging.Message) error {
+	var c continuousQueryLeaseCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only grant the lease if it is expired or already owned by the requester.
+	if s.cqLease.serverID != c.ServerID && c.Timestamp.Before(s.cqLease.expiry) {
+		return ErrContinuousQueryLeaseHeld
+	}
+	s.cqLease = continuousQueryLease{serverID: c.ServerID, expiry: c.Timestamp.Add(c.Duration)}
+
+	return nil
+}
+
+type continuousQueryLeaseCommand struct {
+	ServerID  uint64        `json:"serverID"`
+	Timestamp time.Time     `json:"timestamp"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// continuousQueryLease represents the server that is allowed to run
+// continuous queries until the expiry time.
+type continuousQueryLease struct {
+	serverID uint64
+	expiry   time.Time
+}
+
 func (s *Server) applyWriteSeries(m *messaging.Message) error {
 	req := &protocol.WriteSeriesRequest{}
 	if err := proto.Unmarshal(m.Data, req); err != nil {
@@ -631,6 +1087,353 @@ func (s *Server) applyWriteSeries(m *messaging.Message) error {
 	return nil
 }
 
+func (s *Server) applyDeleteSeries(m *messaging.Message) error {
+	var c deleteSeriesCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	return db.applyDeleteSeries(c.ShardID, c.FieldIDs, c.StartTime, c.EndTime)
+}
+
+type deleteSeriesCommand struct {
+	Database  string    `json:"database"`
+	ShardID   uint64    `json:"shardID"`
+	FieldIDs  []uint64  `json:"fieldIDs"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+}
+
+func (s *Server) applyDropSeries(m *messaging.Message) error {
+	var c dropSeriesCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if s.databases[c.Database] == nil {
+		return ErrDatabaseNotFound
+	}
+
+	if err := db.applyDropSeries(c.Name); err != nil {
+		return err
+	}
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return nil
+}
+
+type dropSeriesCommand struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+}
+
+// ExecuteQuery executes an InfluxQL query. Statements that change the server
+// are applied through the broker. Queries that return results, such as
+// selections and lists, write them to the processor.
+func (s *Server) ExecuteQuery(q influxql.Query, database string, p engine.Processor) error {
+	switch q := q.(type) {
+	case *influxql.CreateDatabaseQuery:
+		return s.CreateDatabase(q.Name)
+	case *influxql.DropDatabaseQuery:
+		return s.DeleteDatabase(q.Name)
+	case *influxql.ListDatabasesQuery:
+		return s.executeListDatabasesQuery(p)
+	case *influxql.GrantQuery:
+		return s.executeGrantQuery(q)
+	case *influxql.RevokeQuery:
+		return s.executeRevokeQuery(q)
+	case *influxql.CreateShardSpaceQuery:
+		return s.executeCreateShardSpaceQuery(q)
+	case *influxql.AlterShardSpaceQuery:
+		return s.executeAlterShardSpaceQuery(q)
+	case *influxql.DropShardSpaceQuery:
+		db := s.Database(q.Database)
+		if db == nil {
+			return ErrDatabaseNotFound
+		}
+		return db.DeleteShardSpace(q.Name)
+	}
+
+	// Remaining queries operate on a single database.
+	db := s.Database(database)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Selections with a target are registered as continuous queries.
+	if sq, ok := q.(*influxql.SelectQuery); ok && sq.Target != "" {
+		return db.executeCreateContinuousQueryQuery(sq, p)
+	}
+
+	switch q := q.(type) {
+	case *influxql.CreateUserQuery:
+		return db.CreateUser(q.Name, q.Password, nil)
+	case *influxql.SetPasswordQuery:
+		return db.ChangePassword(q.Name, q.Password)
+	case *influxql.DropUserQuery:
+		return db.DeleteUser(q.Name)
+	case *influxql.ListSeriesQuery:
+		return db.executeListSeriesQuery(q.Regex, p)
+	case *influxql.ListFieldsQuery:
+		return db.executeListFieldsQuery(q.Series, p)
+	case *influxql.ListShardSpacesQuery:
+		return db.executeListShardSpacesQuery(p)
+	case *influxql.ListShardsQuery:
+		return db.executeListShardsQuery(p)
+	case *influxql.ListUsersQuery:
+		return db.executeListUsersQuery(p)
+	case *influxql.ListContinuousQueriesQuery:
+		return db.executeListContinuousQueriesQuery(p)
+	case *influxql.DropContinuousQueryQuery:
+		return db.DeleteContinuousQuery(q.ID)
+	case *influxql.DropSeriesQuery:
+		return db.DropSeries(q.Name)
+	default:
+		// Convert to the engine's query model.
+		pq, err := engine.NewQueryFromInfluxQL(q)
+		if err != nil {
+			return err
+		}
+		return db.ExecuteQuery(nil, pq, p)
+	}
+}
+
+// executeListDatabasesQuery writes the names of all databases to the processor.
+func (s *Server) executeListDatabasesQuery(p engine.Processor) error {
+	series := newListSeries("databases", "name")
+	for _, db := range s.Databases() {
+		series.Points = append(series.Points, newListPoint(db.Name()))
+	}
+	_, err := p.Yield(series)
+	return err
+}
+
+// executeGrantQuery gives a user read and/or write access to all series in a database.
+func (s *Server) executeGrantQuery(q *influxql.GrantQuery) error {
+	db := s.Database(q.On)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	u := db.User(q.User)
+	if u == nil {
+		return ErrUserNotFound
+	}
+
+	readFrom, writeTo := u.GetReadPermission(), u.GetWritePermission()
+	if q.Privilege == influxql.ReadPrivilege || q.Privilege == influxql.AllPrivileges {
+		readFrom = ".*"
+	}
+	if q.Privilege == influxql.WritePrivilege || q.Privilege == influxql.AllPrivileges {
+		writeTo = ".*"
+	}
+	return db.ChangePermissions(q.User, readFrom, writeTo)
+}
+
+// executeRevokeQuery removes a user's read and/or write access to a database.
+func (s *Server) executeRevokeQuery(q *influxql.RevokeQuery) error {
+	db := s.Database(q.On)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	u := db.User(q.User)
+	if u == nil {
+		return ErrUserNotFound
+	}
+
+	readFrom, writeTo := u.GetReadPermission(), u.GetWritePermission()
+	if q.Privilege == influxql.ReadPrivilege || q.Privilege == influxql.AllPrivileges {
+		readFrom = ""
+	}
+	if q.Privilege == influxql.WritePrivilege || q.Privilege == influxql.AllPrivileges {
+		writeTo = ""
+	}
+	return db.ChangePermissions(q.User, readFrom, writeTo)
+}
+
+// executeCreateShardSpaceQuery creates a shard space. Settings that are not
+// specified in the query use the server defaults.
+func (s *Server) executeCreateShardSpaceQuery(q *influxql.CreateShardSpaceQuery) error {
+	db := s.Database(q.Database)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	ss := NewShardSpace()
+	ss.Name = q.Name
+	if q.Regex != nil {
+		ss.Regex = q.Regex
+	}
+	if q.Duration != 0 {
+		ss.Duration = q.Duration
+	}
+	if q.Retention != 0 {
+		ss.Retention = q.Retention
+	}
+	if q.ReplicaN != 0 {
+		ss.ReplicaN = uint32(q.ReplicaN)
+	}
+	if q.SplitN != 0 {
+		ss.SplitN = uint32(q.SplitN)
+	}
+	return db.CreateShardSpace(ss)
+}
+
+// executeAlterShardSpaceQuery changes the settings of a shard space.
+func (s *Server) executeAlterShardSpaceQuery(q *influxql.AlterShardSpaceQuery) error {
+	db := s.Database(q.Database)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	ss := db.ShardSpace(q.Name)
+	if ss == nil {
+		return ErrShardSpaceNotFound
+	}
+
+	// Copy the current settings and overwrite the ones that changed.
+	other := &ShardSpace{
+		Name:      ss.Name,
+		Regex:     ss.Regex,
+		Retention: ss.Retention,
+		Duration:  ss.Duration,
+		ReplicaN:  ss.ReplicaN,
+		SplitN:    ss.SplitN,
+		Engine:    ss.Engine,
+	}
+	if q.Regex != nil {
+		other.Regex = q.Regex
+	}
+	if q.Duration != nil {
+		other.Duration = *q.Duration
+	}
+	if q.Retention != nil {
+		other.Retention = *q.Retention
+	}
+	if q.ReplicaN != nil {
+		other.ReplicaN = uint32(*q.ReplicaN)
+	}
+	if q.SplitN != nil {
+		other.SplitN = uint32(*q.SplitN)
+	}
+	return db.UpdateShardSpace(other)
+}
+
+// RunContinuousQueries runs each continuous query over the GROUP BY intervals
+// that have completed since it was last run, up to DefaultContinuousQueryBatchSize
+// intervals at a time. Queries are only run by the server that holds the
+// continuous query lease.
+func (s *Server) RunContinuousQueries(now time.Time) error {
+	// Find the queries that have a completed interval.
+	var due []*ContinuousQuery
+	for _, db := range s.Databases() {
+		for _, cq := range db.ContinuousQueries() {
+			if cq.due(now) {
+				due = append(due, cq)
+			}
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	// Request the lease. If another server holds it then it runs the
+	// queries and broadcasts the intervals it has processed.
+	if err := s.requestContinuousQueryLease(now); err == ErrContinuousQueryLeaseHeld {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("lease: %s", err)
+	}
+
+	// Run each query that is due. A query that fails doesn't stop the
+	// others and is retried on the next run.
+	var failed []string
+	for _, cq := range due {
+		if err := cq.db.runContinuousQuery(cq, now); err != nil {
+			warnf("continuous query %d: %s", cq.ID, err)
+			failed = append(failed, fmt.Sprintf("%d (%s)", cq.ID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("continuous queries failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// EnforceRetentionPolicies deletes every shard that ended longer ago than
+// the retention of its shard space. Spaces with a zero retention keep their
+// shards forever.
+func (s *Server) EnforceRetentionPolicies(now time.Time) error {
+	for _, db := range s.Databases() {
+		for _, id := range db.expiredShardIDs(now) {
+			// Ignore shards that were deleted by another server's sweep.
+			if err := db.DeleteShard(id); err != nil && err != ErrShardNotFound {
+				return fmt.Errorf("delete shard(%d): %s", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// StartRetentionService starts a goroutine that enforces retention policies
+// once every period. The goroutine stops when the server is closed.
+func (s *Server) StartRetentionService(period time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.opened() {
+		return ErrServerClosed
+	}
+	go s.retentionLoop(s.done, period)
+	return nil
+}
+
+// retentionLoop runs in a separate goroutine and periodically deletes
+// expired shards.
+func (s *Server) retentionLoop(done chan struct{}, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if err := s.EnforceRetentionPolicies(now.UTC()); err != nil {
+				warn("enforce retention policies:", err)
+			}
+		}
+	}
+}
+
+// continuousQueryLoop runs in a separate goroutine and periodically runs
+// continuous queries.
+func (s *Server) continuousQueryLoop(done chan struct{}) {
+	ticker := time.NewTicker(DefaultContinuousQueryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if err := s.RunContinuousQueries(now.UTC()); err != nil {
+				warn("run continuous queries:", err)
+			}
+		}
+	}
+}
+
 // processor runs in a separate goroutine and processes all incoming broker messages.
 func (s *Server) processor(done chan struct{}) {
 	client := s.client
@@ -654,22 +1457,45 @@ func (s *Server) processor(done chan struct{}) {
 			err = s.applyCreateClusterAdmin(m)
 		case deleteClusterAdminMessageType:
 			err = s.applyDeleteClusterAdmin(m)
+		case clusterAdminSetPasswordMessageType:
+			err = s.applyClusterAdminSetPassword(m)
 		case createDBUserMessageType:
 			err = s.applyCreateDBUser(m)
 		case deleteDBUserMessageType:
 			err = s.applyDeleteDBUser(m)
 		case dbUserSetPasswordMessageType:
 			err = s.applyDBUserSetPassword(m)
+		case dbUserSetPermissionsMessageType:
+			err = s.applyDBUserSetPermissions(m)
 		case createShardSpaceMessageType:
 			err = s.applyCreateShardSpace(m)
+		case updateShardSpaceMessageType:
+			err = s.applyUpdateShardSpace(m)
 		case deleteShardSpaceMessageType:
 			err = s.applyDeleteShardSpace(m)
 		case createShardIfNotExistsMessageType:
 			err = s.applyCreateShardIfNotExists(m)
+		case deleteShardMessageType:
+			err = s.applyDeleteShard(m)
+		case createContinuousQueryMessageType:
+			err = s.applyCreateContinuousQuery(m)
+		case deleteContinuousQueryMessageType:
+			err = s.applyDeleteContinuousQuery(m)
+		case continuousQueryLeaseMessageType:
+			err = s.applyContinuousQueryLease(m)
+		case continuousQueryRunMessageType:
+			err = s.applyContinuousQueryRun(m)
+		case dropSeriesMessageType:
+			err = s.applyDropSeries(m)
 		case writeSeriesMessageType:
 			err = s.applyWriteSeries(m)
+		case deleteSeriesMessageType:
+			err = s.applyDeleteSeries(m)
 		}
 
+		// Unsubscribe from the topics of deleted shards.
+		s.unsubscribe()
+
 		// Sync high water mark and errors.
 		s.mu.Lock()
 		s.index = m.Index
@@ -687,6 +1513,9 @@ type MessagingClient interface {
 
 	// The streaming channel for all subscribed messages.
 	C() <-chan *messaging.Message
+
+	// Stops streaming messages for a topic.
+	Unsubscribe(topicID uint64) (index uint64, err error)
 }
 
 // metastore represents the low-level data store for metadata.
@@ -840,14 +1669,77 @@ func (tx *metatx) deleteClusterAdmin(name string) error {
 	return tx.Bucket([]byte("ClusterAdmins")).Delete([]byte(name))
 }
 
-// ContinuousQuery represents a query that exists on the server and processes
-// each incoming event.
+// ContinuousQuery represents a query that exists on the server and writes
+// the results of each completed GROUP BY interval into a target series.
 type ContinuousQuery struct {
-	ID    uint32
-	Query string
-	// TODO: ParsedQuery *parser.SelectQuery
+	ID    uint64 `json:"id"`
+	Query string `json:"query"`
+
+	db      *Database
+	query   *influxql.SelectQuery // parsed query
+	lastRun time.Time             // end of the last interval processed
 }
 
+// newContinuousQuery parses a query and returns a continuous query.
+// Returns an error if the query is not a selection with a target series
+// and a GROUP BY time interval.
+func newContinuousQuery(id uint64, query string) (*ContinuousQuery, error) {
+	q, err := influxql.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	sq, ok := q.(*influxql.SelectQuery)
+	if !ok || sq.Target == "" || sq.GroupByInterval() == 0 {
+		return nil, ErrInvalidContinuousQuery
+	}
+	return &ContinuousQuery{ID: id, Query: query, query: sq}, nil
+}
+
+// interval returns the GROUP BY time interval of the query.
+func (cq *ContinuousQuery) interval() time.Duration { return cq.query.GroupByInterval() }
+
+// due returns true if an interval has completed since the query was last run.
+func (cq *ContinuousQuery) due(now time.Time) bool {
+	cq.db.mu.Lock()
+	defer cq.db.mu.Unlock()
+	return now.Truncate(cq.interval()).After(cq.lastRun)
+}
+
+// selectQuery returns the selection for the time range [start, end).
+// A zero start time selects all data before the end time.
+func (cq *ContinuousQuery) selectQuery(start, end time.Time) *influxql.SelectQuery {
+	other := *cq.query
+	other.Target, other.NoBackfill = "", false
+
+	// Time ranges are inclusive when scanning shards so the end is moved
+	// back by the smallest unit of storage.
+	var cond influxql.Expr = &influxql.BinaryExpr{
+		Op:  influxql.LT,
+		LHS: &influxql.VarRef{Val: "time"},
+		RHS: &influxql.TimeLiteral{Val: end.Add(-time.Microsecond)},
+	}
+	if !start.IsZero() {
+		cond = &influxql.BinaryExpr{
+			Op:  influxql.AND,
+			LHS: &influxql.BinaryExpr{Op: influxql.GT, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: start}},
+			RHS: cond,
+		}
+	}
+	if other.Condition != nil {
+		cond = &influxql.BinaryExpr{Op: influxql.AND, LHS: other.Condition, RHS: cond}
+	}
+	other.Condition = cond
+
+	return &other
+}
+
+// continuousQueries represents a list of continuous queries, sortable by id.
+type continuousQueries []*ContinuousQuery
+
+func (p continuousQueries) Len() int           { return len(p) }
+func (p continuousQueries) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p continuousQueries) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // mustMarshal encodes a value to JSON.
 // This will panic if an error occurs. This should only be used internally when
 // an invalid marshal will cause corruption and a panic is appropriate.
@@ -868,6 +1760,16 @@ func mustUnmarshalJSON(b []byte, v interface{}) {
 	}
 }
 
+// randomID returns a random 64-bit identifier.
+// This will panic if the random source can't be read.
+func randomID() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("random id: " + err.Error())
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
 // assert will panic with a given formatted message if the given condition is false.
 func assert(condition bool, msg string, v ...interface{}) {
 	if !condition {
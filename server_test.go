@@ -1,15 +1,23 @@
 package influxdb_test
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"testing"
 	"time"
 
 	"code.google.com/p/go.crypto/bcrypt"
+	"code.google.com/p/goprotobuf/proto"
 	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
+	"github.com/influxdb/influxdb/protocol"
 )
 
 // Ensure the server can be successfully opened and closed.
@@ -116,6 +124,15 @@ func TestServer_Databases(t *testing.T) {
 	}
 }
 
+// Ensure the server returns an error when setting an unknown default shard store.
+func TestServer_SetDefaultShardStore_ErrShardStoreNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.SetDefaultShardStore("no_such_store"); err != influxdb.ErrShardStoreNotFound {
+		t.Fatal(err)
+	}
+}
+
 // Ensure the server can create a new cluster admin.
 func TestServer_CreateClusterAdmin(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -137,6 +154,33 @@ func TestServer_CreateClusterAdmin(t *testing.T) {
 	}
 }
 
+// Ensure the server authenticates cluster admins by their password.
+func TestServer_AuthenticateClusterAdmin(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.CreateClusterAdmin("susy", "pass"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateClusterAdmin("bob", "word"); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, err := s.AuthenticateClusterAdmin("susy", "pass"); err != nil {
+		t.Fatal(err)
+	} else if u.Name != "susy" {
+		t.Fatalf("username mismatch: %v", u.Name)
+	}
+	if _, err := s.AuthenticateClusterAdmin("bob", "pass"); err == nil {
+		t.Fatal("expected authentication error for the password of another admin")
+	}
+	if _, err := s.AuthenticateClusterAdmin("susy", "word"); err == nil {
+		t.Fatal("expected authentication error for invalid password")
+	}
+	if _, err := s.AuthenticateClusterAdmin("no_such_admin", "pass"); err == nil {
+		t.Fatal("expected authentication error for unknown admin")
+	}
+}
+
 // Ensure the server returns an error when creating an admin without a name.
 func TestServer_CreateClusterAdmin_ErrUsernameRequired(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -183,6 +227,58 @@ func TestServer_DeleteClusterAdmin(t *testing.T) {
 	}
 }
 
+// Ensure the server can change the password of a cluster admin.
+func TestServer_SetClusterAdminPassword(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateClusterAdmin("susy", "pass")
+
+	// Authenticate once so the old password is cached.
+	if _, err := s.AuthenticateClusterAdmin("susy", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the password.
+	if err := s.SetClusterAdminPassword("susy", "newpass"); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Only the new password is accepted.
+	if _, err := s.AuthenticateClusterAdmin("susy", "pass"); err == nil {
+		t.Fatal("expected authentication error")
+	} else if _, err := s.AuthenticateClusterAdmin("susy", "newpass"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown admins return an error.
+	if err := s.SetClusterAdminPassword("bob", "newpass"); err != influxdb.ErrClusterAdminNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server creates the root cluster admin only if there are no admins.
+func TestServer_CreateRootIfNotExists(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create root on a new server.
+	if err := s.CreateRootIfNotExists(); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.AuthenticateClusterAdmin("root", influxdb.DefaultRootPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	// Root isn't recreated once it has been replaced by another admin.
+	s.CreateClusterAdmin("susy", "pass")
+	s.DeleteClusterAdmin("root")
+	if err := s.CreateRootIfNotExists(); err != nil {
+		t.Fatal(err)
+	} else if s.ClusterAdmin("root") != nil {
+		t.Fatal("unexpected root admin")
+	}
+}
+
 // Ensure the server can return a list of all admins.
 func TestServer_ClusterAdmins(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -203,6 +299,497 @@ func TestServer_ClusterAdmins(t *testing.T) {
 	}
 }
 
+// Ensure the server can execute database and user management queries.
+func TestServer_ExecuteQuery_DDL(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Execute a series of management queries against the "foo" database.
+	for _, str := range []string{
+		`CREATE DATABASE foo`,
+		`CREATE DATABASE bar`,
+		`DROP DATABASE bar`,
+		`CREATE USER susy WITH PASSWORD 'pass'`,
+		`SET PASSWORD FOR susy = 'newpass'`,
+		`REVOKE ALL ON foo FROM susy`,
+		`GRANT READ ON foo TO susy`,
+		`CREATE USER bob WITH PASSWORD 'pass'`,
+		`DROP USER bob`,
+	} {
+		if err := s.ExecuteQuery(mustParseInfluxQL(str), "foo", nil); err != nil {
+			t.Fatalf("%s: %s", str, err)
+		}
+	}
+	s.Restart()
+
+	// Verify the databases and users.
+	if s.Database("bar") != nil {
+		t.Fatal("unexpected database: bar")
+	} else if s.Database("foo").User("bob") != nil {
+		t.Fatal("unexpected user: bob")
+	}
+	if u := s.Database("foo").User("susy"); u == nil {
+		t.Fatal("user not found")
+	} else if bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte("newpass")) != nil {
+		t.Fatal("invalid password")
+	} else if !u.HasReadAccess("cpu") {
+		t.Fatal("expected read access")
+	} else if u.HasWriteAccess("cpu") {
+		t.Fatal("unexpected write access")
+	}
+}
+
+// Ensure the server returns an error when managing users of a non-existent database.
+func TestServer_ExecuteQuery_ErrDatabaseNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.ExecuteQuery(mustParseInfluxQL(`CREATE USER susy WITH PASSWORD 'pass'`), "foo", nil); err != influxdb.ErrDatabaseNotFound {
+		t.Fatal(err)
+	}
+	if err := s.ExecuteQuery(mustParseInfluxQL(`GRANT ALL ON foo TO susy`), "", nil); err != influxdb.ErrDatabaseNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can execute shard space management queries.
+func TestServer_ExecuteQuery_ShardSpaces(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	for _, str := range []string{
+		`CREATE SHARD SPACE raw ON foo MATCHING /^cpu/ DURATION 1d RETENTION 7d`,
+		`ALTER SHARD SPACE raw ON foo RETENTION 30d SPLIT 2`,
+		`CREATE SHARD SPACE tmp ON foo`,
+		`DROP SHARD SPACE tmp ON foo`,
+	} {
+		if err := s.ExecuteQuery(mustParseInfluxQL(str), "", nil); err != nil {
+			t.Fatalf("%s: %s", str, err)
+		}
+	}
+	s.Restart()
+
+	// Verify the altered space kept its other settings.
+	db := s.Database("foo")
+	if ss := db.ShardSpace("raw"); ss == nil {
+		t.Fatal("shard space not found")
+	} else if ss.Regex.String() != "^cpu" || ss.Duration != 24*time.Hour || ss.Retention != 30*24*time.Hour {
+		t.Fatalf("unexpected shard space: %#v", ss)
+	} else if ss.ReplicaN != influxdb.DefaultReplicaN || ss.SplitN != 2 {
+		t.Fatalf("unexpected replication/split: %d/%d", ss.ReplicaN, ss.SplitN)
+	}
+	if db.ShardSpace("tmp") != nil {
+		t.Fatal("unexpected shard space: tmp")
+	}
+}
+
+// Ensure altering a shard space keeps its store.
+func TestServer_ExecuteQuery_AlterShardSpace_Engine(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.Database("foo").CreateShardSpace(&influxdb.ShardSpace{Name: "raw", Duration: 1 * time.Hour, Engine: "testbolt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ExecuteQuery(mustParseInfluxQL(`ALTER SHARD SPACE raw ON foo RETENTION 30d`), "", nil); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	if ss := s.Database("foo").ShardSpace("raw"); ss == nil {
+		t.Fatal("shard space not found")
+	} else if ss.Engine != "testbolt" || ss.Retention != 30*24*time.Hour {
+		t.Fatalf("unexpected shard space: %#v", ss)
+	}
+}
+
+// Ensure the server can list metadata about databases, series and users.
+func TestServer_ExecuteQuery_List(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+	db.CreateUser("susy", "pass", nil)
+
+	// Write a point to create a series and a shard.
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{
+				Values:    []*protocol.FieldValue{{Int64Value: proto.Int64(100)}},
+				Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z")),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		s    string
+		name string
+		rows [][]interface{}
+	}{
+		{s: `LIST DATABASES`, name: "databases", rows: [][]interface{}{{"bar"}, {"foo"}}},
+		{s: `LIST SERIES`, name: "series", rows: [][]interface{}{{"cpu_load"}}},
+		{s: `LIST SERIES FROM /^mem/`, name: "series"},
+		{s: `LIST FIELDS FROM cpu_load`, name: "fields", rows: [][]interface{}{{int64(1), "myval"}}},
+		{s: `LIST SHARD SPACES`, name: "shard_spaces", rows: [][]interface{}{{"myspace", "", "1h", "0s", int64(0), int64(0)}}},
+		{s: `LIST USERS`, name: "users", rows: [][]interface{}{{"susy", ".*", ".*"}}},
+	}
+
+	for i, tt := range tests {
+		var rec ProcessorRecorder
+		if err := s.ExecuteQuery(mustParseInfluxQL(tt.s), "foo", &rec); err != nil {
+			t.Errorf("%d. %s: %s", i, tt.s, err)
+		} else if len(rec.Series) != 1 {
+			t.Errorf("%d. %s: unexpected series count: %d", i, tt.s, len(rec.Series))
+		} else if name := rec.Series[0].GetName(); name != tt.name {
+			t.Errorf("%d. %s: unexpected name: %s", i, tt.s, name)
+		} else if rows := listRows(rec.Series[0]); !reflect.DeepEqual(rows, tt.rows) {
+			t.Errorf("%d. %s: unexpected rows: %#v", i, tt.s, rows)
+		}
+	}
+
+	// Verify that a single shard is listed.
+	var rec ProcessorRecorder
+	if err := s.ExecuteQuery(mustParseInfluxQL(`LIST SHARDS`), "foo", &rec); err != nil {
+		t.Fatal(err)
+	} else if n := len(rec.Series[0].Points); n != 1 {
+		t.Fatalf("unexpected shard count: %d", n)
+	}
+}
+
+// Ensure the server can create, list and drop continuous queries.
+func TestServer_ExecuteQuery_ContinuousQueries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "raw", Regex: regexp.MustCompile(`^cpu_load$`), Duration: 1 * time.Hour})
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "hourly", Regex: regexp.MustCompile(`^hourly\.`), Duration: 24 * time.Hour})
+
+	// Write points in two separate hours.
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z"))},
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(200)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:30:00Z"))},
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(300)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T01:00:00Z"))},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a continuous query.
+	var rec ProcessorRecorder
+	if err := s.ExecuteQuery(mustParseInfluxQL(`SELECT count(myval) FROM cpu_load GROUP BY time(1h) INTO hourly.cpu_load`), "foo", &rec); err != nil {
+		t.Fatal(err)
+	} else if rows := listRows(rec.Series[0]); len(rows) != 1 {
+		t.Fatalf("unexpected rows: %#v", rows)
+	}
+	id := uint64(listRows(rec.Series[0])[0][0].(int64))
+
+	// Verify the existing data is backfilled when the query is run.
+	if db.Series("hourly.cpu_load") != nil {
+		t.Fatal("unexpected series before the query was run")
+	} else if err := s.RunContinuousQueries(time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	} else if db.Series("hourly.cpu_load") == nil {
+		t.Fatal("backfill series not found")
+	}
+
+	// Verify the query is listed.
+	rec = ProcessorRecorder{}
+	if err := s.ExecuteQuery(mustParseInfluxQL(`LIST CONTINUOUS QUERIES`), "foo", &rec); err != nil {
+		t.Fatal(err)
+	} else if rows := listRows(rec.Series[0]); !reflect.DeepEqual(rows, [][]interface{}{{int64(id), "SELECT count(myval) FROM cpu_load GROUP BY time(1h) INTO hourly.cpu_load"}}) {
+		t.Fatalf("unexpected rows: %#v", rows)
+	}
+
+	// Drop the query.
+	if err := s.ExecuteQuery(mustParseInfluxQL(fmt.Sprintf(`DROP CONTINUOUS QUERY %d`, id)), "foo", nil); err != nil {
+		t.Fatal(err)
+	} else if a := db.ContinuousQueries(); len(a) != 0 {
+		t.Fatalf("unexpected continuous query count: %d", len(a))
+	}
+}
+
+// Ensure the server runs continuous queries for each completed interval.
+func TestServer_RunContinuousQueries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "raw", Regex: regexp.MustCompile(`^cpu_load$`), Duration: 24 * time.Hour})
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "hourly", Regex: regexp.MustCompile(`^hourly\.`), Duration: 24 * time.Hour})
+
+	// Create a continuous query without backfilling.
+	if err := s.ExecuteQuery(mustParseInfluxQL(`SELECT count(myval) FROM cpu_load GROUP BY time(1h) INTO hourly.cpu_load NO BACKFILL`), "foo", &ProcessorRecorder{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write a point in the current interval.
+	now := time.Now().UTC()
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(now.UnixNano() / int64(time.Microsecond))},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is written until the interval completes.
+	if err := s.RunContinuousQueries(now); err != nil {
+		t.Fatal(err)
+	} else if db.Series("hourly.cpu_load") != nil {
+		t.Fatal("unexpected series before interval completed")
+	}
+
+	// Run after the interval has completed.
+	if err := s.RunContinuousQueries(now.Add(1 * time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if db.Series("hourly.cpu_load") == nil {
+		t.Fatal("series not written")
+	}
+}
+
+// Ensure a failing continuous query doesn't stop the others from running.
+func TestServer_RunContinuousQueries_Error(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "raw", Regex: regexp.MustCompile(`^cpu_load$`), Duration: 24 * time.Hour})
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "hourly", Regex: regexp.MustCompile(`^hourly\.`), Duration: 24 * time.Hour})
+
+	// The target of the first query doesn't match a shard space.
+	for _, q := range []string{
+		`SELECT count(myval) FROM cpu_load GROUP BY time(1h) INTO nowhere.cpu_load NO BACKFILL`,
+		`SELECT count(myval) FROM cpu_load GROUP BY time(1h) INTO hourly.cpu_load NO BACKFILL`,
+	} {
+		if err := s.ExecuteQuery(mustParseInfluxQL(q), "foo", &ProcessorRecorder{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Write a point in the current interval.
+	now := time.Now().UTC()
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(now.UnixNano() / int64(time.Microsecond))},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the second query runs even though the first one fails.
+	if err := s.RunContinuousQueries(now.Add(1 * time.Hour)); err == nil {
+		t.Fatal("expected error")
+	} else if db.Series("hourly.cpu_load") == nil {
+		t.Fatal("series not written")
+	}
+}
+
+// Ensure continuous queries backfill a batch of intervals per run and resume
+// from the last processed interval after a restart.
+func TestServer_RunContinuousQueries_Resume(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "raw", Regex: regexp.MustCompile(`^cpu_load$`), Duration: 24 * time.Hour})
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "hourly", Regex: regexp.MustCompile(`^hourly\.`), Duration: 24 * time.Hour})
+
+	// Write points in the first and the second batch of hourly intervals.
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z"))},
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(200)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-06T00:00:00Z"))},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ExecuteQuery(mustParseInfluxQL(`SELECT count(myval) AS n FROM cpu_load GROUP BY time(1h) INTO hourly.cpu_load`), "foo", &ProcessorRecorder{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first batch is written by the first run.
+	if err := s.RunContinuousQueries(time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	} else if n := countPoints(s, `SELECT n FROM hourly.cpu_load`); n != 1 {
+		t.Fatalf("unexpected point count: %d", n)
+	}
+
+	// The next batch is written after a restart.
+	s.Restart()
+	if err := s.RunContinuousQueries(time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	} else if n := countPoints(s, `SELECT n FROM hourly.cpu_load`); n != 2 {
+		t.Fatalf("unexpected point count: %d", n)
+	}
+}
+
+// Ensure continuous queries can store distinct count sketches which are
+// merged to count distinct values over longer periods.
+func TestServer_RunContinuousQueries_CountDistinctSketch(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 24 * time.Hour})
+
+	// Write 1000 users a day for three days. Half of each day's users
+	// were also seen the day before.
+	for day := 0; day < 3; day++ {
+		series := &protocol.Series{Name: proto.String("events"), Fields: []string{"user"}}
+		for i := 0; i < 1000; i++ {
+			series.Points = append(series.Points, &protocol.Point{
+				Values:    []*protocol.FieldValue{{StringValue: proto.String(fmt.Sprintf("user%d", day*500+i))}},
+				Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z") + int64(day*24+i%24)*int64(time.Hour/time.Microsecond)),
+			})
+		}
+		if err := db.WriteSeries(series); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Store daily sketches for the existing data.
+	if err := s.ExecuteQuery(mustParseInfluxQL(`SELECT count_distinct_sketch(user) AS users FROM events GROUP BY time(1d) INTO daily.events`), "foo", &ProcessorRecorder{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		query  string
+		counts []int64
+	}{
+		{`select count_distinct_merge(users) from daily.events group by time(1d) order asc`, []int64{1000, 1000, 1000}},
+		{`select count_distinct_merge(users) from daily.events`, []int64{2000}},
+		{`select count_distinct_approx(user) from events`, []int64{2000}},
+	} {
+		var rec ProcessorRecorder
+		if err := db.ExecuteQuery(nil, mustParseQuery(tt.query)[0], &rec); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		var counts []int64
+		for _, series := range rec.Series {
+			for _, p := range series.Points {
+				counts = append(counts, p.GetValues()[0].GetInt64Value())
+			}
+		}
+		if len(counts) != len(tt.counts) {
+			t.Fatalf("%s: unexpected counts: %v", tt.query, counts)
+		}
+
+		// Counts are estimates so allow a 2% error.
+		for i, n := range counts {
+			if d := n - tt.counts[i]; d > tt.counts[i]/50 || -d > tt.counts[i]/50 {
+				t.Fatalf("%s: unexpected counts: %v", tt.query, counts)
+			}
+		}
+	}
+}
+
+// Ensure the server deletes shards that are older than their space's retention.
+func TestServer_EnforceRetentionPolicies(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour, Retention: 2 * time.Hour})
+
+	// Write a point to create a shard for 2000-01-01T00:00:00Z to 01:00:00Z.
+	if err := db.WriteSeries(&protocol.Series{
+		Name:   proto.String("cpu_load"),
+		Fields: []string{"myval"},
+		Points: []*protocol.Point{
+			{Values: []*protocol.FieldValue{{Int64Value: proto.Int64(100)}}, Timestamp: proto.Int64(mustParseMicroTime("2000-01-01T00:00:00Z"))},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	id := db.ShardSpace("myspace").Shards[0].ID
+	path := filepath.Join(s.Path(), "shards", strconv.FormatUint(id, 10))
+
+	// Shard is retained until its end time plus the retention has passed.
+	if err := s.EnforceRetentionPolicies(mustParseTime("2000-01-01T02:30:00Z")); err != nil {
+		t.Fatal(err)
+	} else if n := len(db.ShardSpace("myspace").Shards); n != 1 {
+		t.Fatalf("unexpected shard count: %d", n)
+	}
+
+	// Shard is removed once it has expired.
+	if err := s.EnforceRetentionPolicies(mustParseTime("2000-01-01T03:30:00Z")); err != nil {
+		t.Fatal(err)
+	} else if n := len(db.ShardSpace("myspace").Shards); n != 0 {
+		t.Fatalf("unexpected shard count: %d", n)
+	} else if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("shard data not removed: %s", err)
+	} else if !reflect.DeepEqual(c.Unsubscribed, []uint64{id}) {
+		t.Fatalf("unexpected unsubscribed topics: %v", c.Unsubscribed)
+	}
+	s.Restart()
+
+	if n := len(s.Database("foo").ShardSpace("myspace").Shards); n != 0 {
+		t.Fatalf("unexpected shard count after restart: %d", n)
+	}
+}
+
+// Ensure the server deletes a shard even if it fails to unsubscribe from the
+// shard's topic and retries the unsubscription later.
+func TestServer_DeleteShard_UnsubscribeError(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	db := s.Database("foo")
+	db.CreateShardSpace(&influxdb.ShardSpace{Name: "myspace", Duration: 1 * time.Hour})
+	db.CreateShardIfNotExists("myspace", mustParseTime("2000-01-01T00:00:00Z"))
+	id := db.ShardSpace("myspace").Shards[0].ID
+
+	// Fail the first unsubscription.
+	c.UnsubscribeFunc = func(topicID uint64) (uint64, error) {
+		c.UnsubscribeFunc = c.unsubscribe
+		return 0, errors.New("marker")
+	}
+	if err := db.DeleteShard(id); err != nil {
+		t.Fatal(err)
+	} else if n := len(db.ShardSpace("myspace").Shards); n != 0 {
+		t.Fatalf("unexpected shard count: %d", n)
+	} else if len(c.Unsubscribed) != 0 {
+		t.Fatalf("unexpected unsubscribed topics: %v", c.Unsubscribed)
+	}
+
+	// The unsubscription is retried after the next message.
+	s.CreateDatabase("bar")
+	if !reflect.DeepEqual(c.Unsubscribed, []uint64{id}) {
+		t.Fatalf("unexpected unsubscribed topics: %v", c.Unsubscribed)
+	}
+}
+
+// listRows returns the values of each point in a series.
+func listRows(s *protocol.Series) (rows [][]interface{}) {
+	for _, p := range s.Points {
+		var row []interface{}
+		for i := range p.Values {
+			row = append(row, p.GetFieldValue(i))
+		}
+		rows = append(rows, row)
+	}
+	return
+}
+
 // Server is a wrapping test struct for influxdb.Server.
 type Server struct {
 	*influxdb.Server
@@ -244,13 +831,18 @@ type MessagingClient struct {
 	index uint64
 	c     chan *messaging.Message
 
-	PublishFunc func(*messaging.Message) (uint64, error)
+	PublishFunc     func(*messaging.Message) (uint64, error)
+	UnsubscribeFunc func(topicID uint64) (uint64, error)
+
+	// Topics that have been unsubscribed from.
+	Unsubscribed []uint64
 }
 
 // NewMessagingClient returns a new instance of MessagingClient.
 func NewMessagingClient() *MessagingClient {
 	c := &MessagingClient{c: make(chan *messaging.Message, 1)}
 	c.PublishFunc = c.send
+	c.UnsubscribeFunc = c.unsubscribe
 	return c
 }
 
@@ -272,6 +864,18 @@ func (c *MessagingClient) send(m *messaging.Message) (uint64, error) {
 // C returns a channel for streaming message.
 func (c *MessagingClient) C() <-chan *messaging.Message { return c.c }
 
+// Unsubscribe executes the client's UnsubscribeFunc mock function.
+func (c *MessagingClient) Unsubscribe(topicID uint64) (uint64, error) {
+	return c.UnsubscribeFunc(topicID)
+}
+
+// unsubscribe records the topic id.
+// This is the default value of UnsubscribeFunc.
+func (c *MessagingClient) unsubscribe(topicID uint64) (uint64, error) {
+	c.Unsubscribed = append(c.Unsubscribed, topicID)
+	return 0, nil
+}
+
 // tempfile returns a temporary path.
 func tempfile() string {
 	f, _ := ioutil.TempFile("", "influxdb-")
@@ -281,6 +885,47 @@ func tempfile() string {
 	return path
 }
 
+// mustCopyDir recursively copies the files of a directory. Panic on error.
+func mustCopyDir(src, dst string) {
+	if err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), info.Mode())
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), b, info.Mode())
+	}); err != nil {
+		panic("copy dir: " + err.Error())
+	}
+}
+
+// countPoints returns the number of points returned by a query on the foo database.
+func countPoints(s *Server, q string) (n int) {
+	var rec ProcessorRecorder
+	if err := s.ExecuteQuery(mustParseInfluxQL(q), "foo", &rec); err != nil {
+		panic(err.Error())
+	}
+	for _, series := range rec.Series {
+		n += len(series.Points)
+	}
+	return
+}
+
+// mustParseInfluxQL parses an InfluxQL query string. Panic on error.
+func mustParseInfluxQL(s string) influxql.Query {
+	q, err := influxql.ParseQuery(s)
+	if err != nil {
+		panic(err.Error())
+	}
+	return q
+}
+
 // mustParseTime parses an IS0-8601 string. Panic on error.
 func mustParseTime(s string) time.Time {
 	t, err := time.Parse(time.RFC3339, s)
@@ -6,11 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sync"
 	"time"
 
 	"code.google.com/p/goprotobuf/proto"
 	"code.google.com/p/log4go"
-	"github.com/boltdb/bolt"
 	"github.com/influxdb/influxdb/engine"
 	"github.com/influxdb/influxdb/parser"
 	"github.com/influxdb/influxdb/protocol"
@@ -22,9 +22,19 @@ type Shard struct {
 	StartTime time.Time `json:"startTime,omitempty"`
 	EndTime   time.Time `json:"endTime,omitempty"`
 
-	store *bolt.DB
+	// Name of the shard store. Defaults to DefaultShardStore.
+	Engine string `json:"engine,omitempty"`
+
+	mu    sync.RWMutex
+	store ShardStore
+	wal   *wal
+	cache *shardCache
 }
 
+// maxShardCacheValues is the number of cached values which causes the cache
+// to be flushed to the shard's store.
+const maxShardCacheValues = 100000
+
 // newShard returns a new initialized Shard instance.
 func newShard() *Shard { return &Shard{} }
 
@@ -39,95 +49,223 @@ func (s *Shard) open(path string) error {
 	}
 
 	// Open store on shard.
-	store, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	store, err := NewShardStore(s.engine())
 	if err != nil {
 		return err
 	}
+	if err := store.Open(path); err != nil {
+		return err
+	}
 	s.store = store
 
-	// Initialize store.
-	if err := s.init(); err != nil {
+	// Open the write-ahead log and replay unflushed writes into the cache.
+	s.cache = newShardCache()
+	if s.wal, err = openWAL(path + ".wal"); err != nil {
+		_ = s.close()
+		return fmt.Errorf("wal: %s", err)
+	}
+	if err := s.wal.replay(func(series *protocol.Series) error {
+		s.cache.add(series)
+		return nil
+	}); err != nil {
 		_ = s.close()
-		return fmt.Errorf("init: %s", err)
+		return fmt.Errorf("replay: %s", err)
 	}
 
 	return nil
 }
 
-// init creates top-level buckets in the datastore.
-func (s *Shard) init() error {
-	return s.store.Update(func(tx *bolt.Tx) error {
-		_, _ = tx.CreateBucketIfNotExists([]byte("values"))
-		return nil
-	})
+// engine returns the name of the shard's store.
+func (s *Shard) engine() string {
+	if s.Engine == "" {
+		return DefaultShardStore
+	}
+	return s.Engine
 }
 
-// close shuts down the shard's store.
+// close flushes the cache and shuts down the shard's store.
 func (s *Shard) close() error {
-	return s.store.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.store == nil {
+		return nil
+	}
+
+	err := s.flush()
+	if s.wal != nil {
+		if e := s.wal.close(); err == nil {
+			err = e
+		}
+		s.wal = nil
+	}
+	if e := s.store.Close(); err == nil {
+		err = e
+	}
+	s.store, s.cache = nil, nil
+	return err
 }
 
-// write writes series data to a shard.
+// write writes series data to a shard's log and cache. The cache is
+// flushed to the store once it grows large enough.
 func (s *Shard) writeSeries(series *protocol.Series) error {
 	assert(len(series.GetFieldIds()) > 0, "field ids required for write")
 
-	return s.store.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("values"))
+	// Validate values before they are accepted into the log.
+	for _, p := range series.Points {
+		for _, v := range p.Values {
+			if !v.GetIsNull() && blockValueType(v) == 0 {
+				return errUnsupportedBlockValue
+			}
+		}
+	}
 
-		for i, fieldID := range series.FieldIds {
-			for _, p := range series.Points {
-				// Convert the storage key to a byte slice.
-				k := marshalStorageKey(newStorageKey(fieldID, p.GetTimestamp(), p.GetSequenceNumber()))
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-				// If value is null then delete it.
-				if p.Values[i].GetIsNull() {
-					if err := b.Delete(k); err != nil {
-						return fmt.Errorf("del: %s", err)
-					}
-					continue
-				}
+	if err := s.wal.append(series); err != nil {
+		return fmt.Errorf("wal: %s", err)
+	}
+	s.cache.add(series)
 
-				// Marshal the value via protobuf.
-				buf := proto.NewBuffer(nil)
-				if err := buf.Marshal(p.Values[i]); err != nil {
-					return err
-				}
+	if s.cache.n >= maxShardCacheValues {
+		return s.flush()
+	}
+	return nil
+}
 
-				// Write to the bucket.
-				if err := b.Put(k, buf.Bytes()); err != nil {
-					return fmt.Errorf("put: %s", err)
-				}
-			}
+// flush writes the cached values to the store and clears the log.
+// Must be called with the lock held.
+func (s *Shard) flush() error {
+	if s.cache == nil || s.cache.n == 0 {
+		return nil
+	}
+
+	// Merge each field's values into the existing blocks.
+	c, err := s.store.Cursor()
+	if err != nil {
+		return err
+	}
+	var batch []ShardStoreWrite
+	for fieldID, values := range s.cache.values {
+		if len(values) == 0 {
+			continue
+		}
+		min, max := values[0], values[len(values)-1]
+		a, err := rewriteBlocks(c, fieldID,
+			newStorageKey(fieldID, min.timestamp, min.seq),
+			newStorageKey(fieldID, max.timestamp, max.seq),
+			func(a []*blockValue) []*blockValue { return removeNullValues(mergeBlockValues(a, values)) },
+		)
+		if err != nil {
+			_ = c.Close()
+			return err
 		}
+		batch = append(batch, a...)
+	}
+	_ = c.Close()
 
-		return nil
-	})
+	// Write all fields in a single batch.
+	if err := s.store.WriteBatch(batch); err != nil {
+		return err
+	}
+
+	s.cache.reset()
+	return s.wal.truncate()
 }
 
-func (s *Shard) deleteSeries(name string) error {
-	panic("not yet implemented") // TODO
+// deleteSeries removes the values of a set of fields between tmin and tmax, inclusive.
+func (s *Shard) deleteSeries(fieldIDs []uint64, tmin, tmax time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Flush the cache first so cached values are deleted and the log
+	// cannot restore them.
+	if err := s.flush(); err != nil {
+		return fmt.Errorf("flush: %s", err)
+	}
+
+	for _, fieldID := range fieldIDs {
+		// Remove all of a field's blocks if the range covers the shard.
+		if !tmin.After(s.StartTime) && !tmax.Before(s.EndTime) {
+			if err := s.store.DeleteRange(
+				marshalStorageKey(newStorageKey(fieldID, math.MinInt64, 0)),
+				marshalStorageKey(newStorageKey(fieldID, math.MaxInt64, math.MaxUint64)),
+			); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Otherwise remove the values from the blocks overlapping the range.
+		c, err := s.store.Cursor()
+		if err != nil {
+			return err
+		}
+		min, max := microseconds(tmin), microseconds(tmax)
+		batch, err := rewriteBlocks(c, fieldID,
+			newStorageKey(fieldID, min, 0),
+			newStorageKey(fieldID, max, math.MaxUint64),
+			func(a []*blockValue) []*blockValue {
+				other := a[:0]
+				for _, v := range a {
+					if v.timestamp < min || v.timestamp > max {
+						other = append(other, v)
+					}
+				}
+				return other
+			},
+		)
+		_ = c.Close()
+		if err != nil {
+			return err
+		}
+		if err := s.store.WriteBatch(batch); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
+// aggregateMode specifies where the aggregates of a query are computed.
+type aggregateMode int
+
+const (
+	// aggregateNone returns raw points to be aggregated by the coordinator.
+	aggregateNone aggregateMode = iota
+
+	// aggregateLocal computes the final aggregates within the shard.
+	aggregateLocal
+
+	// aggregatePartial computes partial aggregate states within the shard
+	// which are merged by the coordinator.
+	aggregatePartial
+)
+
 // query executes a query against the shard and returns results to a channel.
-func (s *Shard) query(spec *parser.QuerySpec, name string, fields []*Field, resp chan<- *protocol.Response) {
+// Points are read and processed in batches of up to batchSize points.
+// The query stops early once done is closed.
+func (s *Shard) query(spec *parser.QuerySpec, name string, fields []*Field, mode aggregateMode, batchSize int, resp chan<- *protocol.Response, done <-chan struct{}) {
 	log4go.Debug("QUERY: shard %d, query '%s'", s.ID, spec.GetQueryStringWithTimeCondition())
+	w := NewResponseChannelWrapper(resp, done)
 	defer recoverFunc(spec.Database(), spec.GetQueryStringWithTimeCondition(), func(err interface{}) {
-		resp <- &protocol.Response{
+		w.Yield(&protocol.Response{
 			Type:         protocol.Response_ERROR.Enum(),
 			ErrorMessage: protocol.String(fmt.Sprintf("%s", err)),
-		}
+		})
 	})
 
 	var err error
 	var p engine.Processor
-	p = NewResponseChannelProcessor(NewResponseChannelWrapper(resp))
+	p = NewResponseChannelProcessor(w)
 	p = NewShardIdInserterProcessor(s.ID, p)
 
-	if p, err = s.processor(spec, p); err != nil {
-		resp <- &protocol.Response{
+	if p, err = s.processor(spec, p, mode); err != nil {
+		w.Yield(&protocol.Response{
 			Type:         protocol.Response_ERROR.Enum(),
 			ErrorMessage: protocol.String(err.Error()),
-		}
+		})
 		log4go.Error("error while creating engine: %s", err)
 		return
 	}
@@ -137,7 +275,7 @@ func (s *Shard) query(spec *parser.QuerySpec, name string, fields []*Field, resp
 	switch t := spec.SelectQuery().FromClause.Type; t {
 	case parser.FromClauseArray:
 		log4go.Debug("shard %s: running a regular query")
-		err = s.executeArrayQuery(spec, name, fields, p)
+		err = s.executeArrayQuery(spec, name, fields, batchSize, p, done)
 
 	// TODO
 	//case parser.FromClauseMerge, parser.FromClauseInnerJoin:
@@ -148,21 +286,21 @@ func (s *Shard) query(spec *parser.QuerySpec, name string, fields []*Field, resp
 		panic(fmt.Errorf("unknown from clause type %s", t))
 	}
 	if err != nil {
-		resp <- &protocol.Response{
+		w.Yield(&protocol.Response{
 			Type:         protocol.Response_ERROR.Enum(),
 			ErrorMessage: protocol.String(err.Error()),
-		}
+		})
 		return
 	}
 
 	_ = p.Close()
-	resp <- &protocol.Response{Type: protocol.Response_END_STREAM.Enum()}
+	w.Yield(&protocol.Response{Type: protocol.Response_END_STREAM.Enum()})
 }
 
-func (s *Shard) processor(spec *parser.QuerySpec, p engine.Processor) (engine.Processor, error) {
+func (s *Shard) processor(spec *parser.QuerySpec, p engine.Processor, mode aggregateMode) (engine.Processor, error) {
 	// We should aggregate at the shard level.
 	q := spec.SelectQuery()
-	if spec.CanAggregateLocally(s.Duration()) {
+	if mode == aggregateLocal {
 		log4go.Debug("creating a query engine")
 		var err error
 		if p, err = engine.NewQueryEngine(p, q, nil); err != nil {
@@ -174,6 +312,17 @@ func (s *Shard) processor(spec *parser.QuerySpec, p engine.Processor) (engine.Pr
 		return p, nil
 	}
 
+	// Only send the state of each aggregate so the coordinator doesn't
+	// need every point. Buckets spanning shards are merged there.
+	if mode == aggregatePartial {
+		log4go.Debug("creating a partial aggregator engine")
+		var err error
+		if p, err = engine.NewPartialAggregatorEngine(q, p); err != nil {
+			return nil, err
+		}
+		return engine.NewFilteringEngine(q, p), nil
+	}
+
 	// We shouldn't limit the queries if they have aggregates and aren't
 	// aggregated locally, otherwise the aggregation result which happen
 	// in the coordinator will get partial data and will be incorrect
@@ -197,7 +346,7 @@ func (s *Shard) processor(spec *parser.QuerySpec, p engine.Processor) (engine.Pr
 	return p, nil
 }
 
-func (s *Shard) executeArrayQuery(spec *parser.QuerySpec, name string, fields []*Field, processor engine.Processor) error {
+func (s *Shard) executeArrayQuery(spec *parser.QuerySpec, name string, fields []*Field, batchSize int, processor engine.Processor, done <-chan struct{}) error {
 	fnames := Fields(fields).Names()
 	aliases := spec.SelectQuery().GetTableAliases(name)
 
@@ -211,14 +360,30 @@ func (s *Shard) executeArrayQuery(spec *parser.QuerySpec, name string, fields []
 	i.startTime = spec.GetStartTime()
 	i.endTime = spec.GetEndTime()
 	i.ascending = spec.SelectQuery().Ascending
+	i.pushdown(engine.Predicates(spec.SelectQuery().GetWhereCondition()))
+
+	// Iterate over batches of points and yield to the processor for each alias.
+	// An alias is dropped once the processor doesn't need more of its data.
+	// Iteration stops when no aliases remain or the reader is done.
+	for points := i.batch(batchSize); len(points) > 0 && len(aliases) > 0; points = i.batch(batchSize) {
+		select {
+		case <-done:
+			log4go.Debug("Stopping processing, reader is done.")
+			return nil
+		default:
+		}
+
+		for j := 0; j < len(aliases); j++ {
+			// Each alias gets its own slice since processors may append to it.
+			a := points
+			if j > 0 {
+				a = append([]*protocol.Point(nil), points...)
+			}
 
-	// Iterate over each point and yield to the processor for each alias.
-	for p := i.first(); p != nil; p = i.next() {
-		for _, alias := range aliases {
 			series := &protocol.Series{
-				Name:   proto.String(alias),
+				Name:   proto.String(aliases[j]),
 				Fields: fnames,
-				Points: []*protocol.Point{p},
+				Points: a,
 			}
 
 			log4go.Debug("Yielding to %s %s", processor.Name(), series)
@@ -226,36 +391,45 @@ func (s *Shard) executeArrayQuery(spec *parser.QuerySpec, name string, fields []
 				log4go.Error("Error while processing data: %v", err)
 				return err
 			} else if !ok {
-				log4go.Debug("Stopping processing.")
-				// NOTE: This doesn't stop processing.
+				log4go.Debug("Stopping processing of %s.", aliases[j])
+				aliases = append(aliases[:j], aliases[j+1:]...)
+				j--
 			}
 		}
 	}
 
+	if i.err != nil {
+		return i.err
+	}
+
 	log4go.Debug("Finished running query %s", spec.GetQueryString())
 	return nil
 }
 
 // iterator returns a new iterator for a set of fields.
 func (s *Shard) iterator(fields []*Field) (*iterator, error) {
-	// Open a read-only transaction.
-	// This transaction must be closed separately by the iterator.
-	tx, err := s.store.Begin(false)
-	if err != nil {
-		return nil, err
-	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	// Initialize cursor.
+	// Initialize iterator.
 	i := &iterator{
-		tx:      tx,
 		fields:  fields,
-		cursors: make([]*bolt.Cursor, len(fields)),
-		values:  make([]rawValue, len(fields)),
+		cursors: make([]*fieldCursor, len(fields)),
+		values:  make([]*blockValue, len(fields)),
 	}
 
-	// Open a cursor for each field.
+	// Open a cursor for each field which merges the cache with the store.
+	// The cursors must be closed separately by the iterator.
 	for j := range fields {
-		i.cursors[j] = tx.Bucket([]byte("values")).Cursor()
+		c, err := s.store.Cursor()
+		if err != nil {
+			_ = i.close()
+			return nil, err
+		}
+		i.cursors[j] = &fieldCursor{
+			blocks: &blockCursor{cursor: c, fieldID: fields[j].ID},
+			cache:  s.cache.values[fields[j].ID],
+		}
 	}
 
 	return i, nil
@@ -355,6 +529,12 @@ func (sk storageKey) time() time.Time {
 	return time.Unix(0, sk.timestamp*int64(time.Microsecond))
 }
 
+// microseconds returns the number of microseconds since the epoch.
+// Unlike UnixNano(), this does not overflow for the default query start time.
+func microseconds(t time.Time) int64 {
+	return t.Unix()*int64(time.Second/time.Microsecond) + int64(t.Nanosecond())/int64(time.Microsecond)
+}
+
 func convertTimestampToUint(t int64) uint64 {
 	if t < 0 {
 		return uint64(math.MaxInt64 + t + 1)
@@ -369,189 +549,201 @@ func convertUintTimestampToInt64(t uint64) int64 {
 	return int64(t) - math.MaxInt64 - int64(1)
 }
 
-// iterator takes a slice of iterators and their corresponding
+// iterator takes a slice of field cursors and their corresponding
 // fields and turn it into a point iterator, i.e. an iterator that
 // yields whole points instead of column values.
 type iterator struct {
-	tx      *bolt.Tx
-	cursors []*bolt.Cursor
+	cursors []*fieldCursor
 
 	fields []*Field
-	values []rawValue
+	values []*blockValue
 
 	startTime time.Time
 	endTime   time.Time
 	ascending bool
 
-	valid bool
-	err   error
+	// Conditions on field values which points must match.
+	predicates []*fieldPredicates
 
-	point *protocol.Point
+	started bool
+	err     error
 }
 
-// close closes the read transaction and clears the cursors.
-func (i *iterator) close() (err error) {
-	err = i.tx.Rollback()
-	i.tx = nil
-	i.cursors = nil
-	return
+// fieldPredicates holds the predicates on a single field of an iterator.
+type fieldPredicates struct {
+	index      int
+	predicates []*engine.Predicate
 }
 
-// first moves the iterator to the first point.
-func (i *iterator) first() *protocol.Point {
-	i.valid = false
+// match returns true if a value matches all of the field's predicates.
+func (p *fieldPredicates) match(v *blockValue) bool {
+	for _, predicate := range p.predicates {
+		if !predicate.Match(v.value) {
+			return false
+		}
+	}
+	return true
+}
 
-	for j, c := range i.cursors {
-		// Read next key/value.
-		k, v := c.Seek(marshalStorageKey(newStorageKey(i.fields[j].ID, convertUintTimestampToInt64(uint64(i.startTime.UnixNano())), 0)))
-		if k == nil {
-			continue
+// pushdown sets predicates which points must match to be returned by the
+// iterator. Fields without predicates are only read for matching points.
+// Predicates on columns that aren't iterator fields are ignored.
+func (i *iterator) pushdown(predicates []*engine.Predicate) {
+	i.predicates = nil
+	for j, f := range i.fields {
+		p := &fieldPredicates{index: j}
+		for _, predicate := range predicates {
+			if predicate.Column == f.Name {
+				p.predicates = append(p.predicates, predicate)
+			}
+		}
+		if len(p.predicates) > 0 {
+			i.predicates = append(i.predicates, p)
 		}
+	}
+}
 
-		sk := mustUnmarshalStorageKey(k)
-		if sk.id != i.fields[j].ID {
-			log4go.Debug("first: different id reached")
-			continue
-		} else if sk.time().Before(i.startTime) || sk.time().After(i.endTime) {
-			log4go.Debug("first: outside time range: %s, %s", sk.time(), i.startTime)
+// close closes the cursors.
+func (i *iterator) close() (err error) {
+	for _, c := range i.cursors {
+		if c == nil {
 			continue
 		}
-
-		// Set the value for the field.
-		i.values[j] = rawValue{time: sk.timestamp, sequence: sk.seq, value: v}
+		if e := c.blocks.cursor.Close(); err == nil {
+			err = e
+		}
 	}
+	i.cursors = nil
+	return
+}
 
+// first moves the iterator to the first point.
+func (i *iterator) first() *protocol.Point {
+	for j, c := range i.cursors {
+		c.blocks.tmin, c.blocks.tmax = microseconds(i.startTime), microseconds(i.endTime)
+		c.blocks.ascending = i.ascending
+		i.values[j] = c.first()
+	}
 	return i.materialize()
 }
 
-// next moves the cursor to the next point.
-func (i *iterator) next() *protocol.Point {
-	i.valid = false
+// next moves the iterator to the next point.
+func (i *iterator) next() *protocol.Point { return i.materialize() }
 
-	// Move the cursors to the next value.
-	for j, c := range i.cursors {
-		// Ignore cursors which already have a value.
-		if i.values[j].value != nil {
-			continue
-		}
+// batch returns up to n of the following points, starting from the first
+// point on the first call. Returns an empty slice when no points remain.
+func (i *iterator) batch(n int) []*protocol.Point {
+	if n <= 0 {
+		n = 1
+	}
 
-		// Read next key/value.
-		k, v := c.Next()
-		if k == nil {
-			continue
+	points := make([]*protocol.Point, 0, n)
+	for len(points) < n {
+		var p *protocol.Point
+		if !i.started {
+			p, i.started = i.first(), true
+		} else {
+			p = i.next()
 		}
-
-		// Move to the next iterator if different field reached.
-		// Move to the next iterator if outside of time range.
-		sk := mustUnmarshalStorageKey(k)
-		if sk.id != i.fields[j].ID {
-			log4go.Debug("different id reached")
-			continue
-		} else if sk.time().Before(i.startTime) || sk.time().After(i.endTime) {
-			log4go.Debug("Outside time range: %s, %s", sk.time(), i.startTime)
-			continue
+		if p == nil {
+			break
 		}
-
-		// Set the value for the field.
-		i.values[j] = rawValue{time: sk.timestamp, sequence: sk.seq, value: v}
-		log4go.Debug("Iterator next value: %v", i.values[j])
+		points = append(points, p)
 	}
-
-	return i.materialize()
+	return points
 }
 
-// materialize creates a point from the current values and move the cursors forward.
+// materialize creates a point from the current values and moves the cursors forward.
 func (i *iterator) materialize() *protocol.Point {
 	// choose the highest (or lowest in case of ascending queries) timestamp
 	// and sequence number. that will become the timestamp and sequence of
-	// the next point.
-	var next *rawValue
-	for j, value := range i.values {
-		// Ignore nil values.
-		if value.value == nil {
-			continue
+	// the next point. with predicates, only points where every predicate
+	// matches are chosen.
+	var next *blockValue
+	if len(i.predicates) > 0 {
+		next = i.align()
+	} else {
+		for _, value := range i.values {
+			if value == nil {
+				continue
+			} else if next == nil || i.precedes(value, next) {
+				next = value
+			}
 		}
+	}
 
-		// Initialize next value if not set.
-		// Otherwise override next if value is before (ASC) or after (DESC).
-		if next == nil {
-			next = &i.values[j]
-		} else if i.ascending && value.before(next) {
-			next = &i.values[j]
-		} else if !i.ascending && value.after(next) {
-			next = &i.values[j]
+	// If no values remain then check if a cursor stopped because of an error.
+	if next == nil {
+		for _, c := range i.cursors {
+			if c.blocks.err != nil {
+				log4go.Error("Error while running query: %s", c.blocks.err)
+				i.err = c.blocks.err
+			}
 		}
+		return nil
 	}
 
 	// Set values to point that match the timestamp & sequence number.
-	buf := proto.NewBuffer(nil)
+	// Cursors behind the point skip the values of points which didn't match.
+	// Cursors are only advanced for the values that are used.
 	point := &protocol.Point{Values: make([]*protocol.FieldValue, len(i.fields))}
-	for j, c := range i.cursors {
-		value := &i.values[j]
-		log4go.Debug("Column value: %s", value)
-
-		// Skip value if it doesn't match point's timestamp and seq number.
-		if value.value == nil || value.time != next.time || value.sequence != next.sequence {
-			trueValue := true
-			point.Values[j] = &protocol.FieldValue{IsNull: &trueValue}
-			continue
-		}
-
-		// Iterator is valid if at least one column is set.
-		log4go.Debug("Setting is valid to true")
-		i.valid = true
-
-		// Advance the iterator to read a new value in the next iteration
-		if i.ascending {
-			c.Next()
-		} else {
-			c.Prev()
+	for j, value := range i.values {
+		if value != nil && i.precedes(value, next) {
+			value = i.cursors[j].seek(next)
+			i.values[j] = value
 		}
-
-		// Marshal value from protobufs into point.
-		fv := &protocol.FieldValue{}
-		buf.SetBuf(value.value)
-		if err := buf.Unmarshal(fv); err != nil {
-			log4go.Error("Error while running query: %s", err)
-			i.err, i.valid = err, false
-			return nil
+		if value == nil || value.timestamp != next.timestamp || value.seq != next.seq {
+			point.Values[j] = &protocol.FieldValue{IsNull: proto.Bool(true)}
+			continue
 		}
-		point.Values[j] = fv
-		value.value = nil
-	}
 
-	// This will only happen if there are no points for the given series
-	// and range and this is the first call to Next(). Otherwise we
-	// always call Next() on a valid iterator so we know we have
-	// more points
-	if next == nil {
-		return nil
+		point.Values[j] = value.value
+		i.values[j] = i.cursors[j].next()
 	}
 
 	// Set timestamp and sequence number on point.
-	point.SetTimestampInMicroseconds(next.time)
-	point.SequenceNumber = proto.Uint64(next.sequence)
+	point.SetTimestampInMicroseconds(next.timestamp)
+	point.SequenceNumber = proto.Uint64(next.seq)
 
 	return point
 }
 
-// rawValue represents the value for a field at a given time and sequence id.
-type rawValue struct {
-	time     int64
-	sequence uint64
-	value    []byte
-}
-
-// before returns true if the value is before another value.
-func (v rawValue) before(other *rawValue) bool {
-	return (v.time < other.time) || (v.time == other.time && v.sequence < other.sequence)
-}
+// align moves the cursors of fields with predicates to the next timestamp
+// and sequence number where every predicate matches. Returns nil if no such
+// point remains. Only the blocks of fields with predicates are read.
+func (i *iterator) align() *blockValue {
+	var key *blockValue
+	for n, j := 0, 0; n < len(i.predicates); j = (j + 1) % len(i.predicates) {
+		p := i.predicates[j]
+
+		// Skip values before the key and values which don't match.
+		v := i.values[p.index]
+		for v != nil && ((key != nil && i.precedes(v, key)) || !p.match(v)) {
+			if key != nil && i.precedes(v, key) {
+				v = i.cursors[p.index].seek(key)
+			} else {
+				v = i.cursors[p.index].next()
+			}
+		}
+		i.values[p.index] = v
+		if v == nil {
+			return nil
+		}
 
-// after returns true if the value is after another value.
-func (v rawValue) after(other *rawValue) bool {
-	return (v.time > other.time) || (v.time == other.time && v.sequence > other.sequence)
+		// Count the fields that agree on the key or start over with a later key.
+		if key != nil && v.timestamp == key.timestamp && v.seq == key.seq {
+			n++
+		} else {
+			key, n = v, 1
+		}
+	}
+	return key
 }
 
-func (v rawValue) String() string {
-	return fmt.Sprintf("[time: %d, sequence: %d, value: %v]", v.time, v.sequence, v.value)
+// precedes returns true if a comes before b in the iterator's direction.
+func (i *iterator) precedes(a, b *blockValue) bool {
+	if i.ascending {
+		return a.before(b)
+	}
+	return a.after(b)
 }
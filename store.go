@@ -0,0 +1,94 @@
+package influxdb
+
+import (
+	"sort"
+)
+
+// DefaultShardStore is the name of the store used by shards when no other
+// store is configured.
+const DefaultShardStore = "bolt"
+
+// ShardStore represents the ordered key/value storage underneath a shard.
+// Keys are sorted byte-wise.
+type ShardStore interface {
+	// Open opens the store at path, creating it if it doesn't exist.
+	Open(path string) error
+
+	// Close closes the store.
+	Close() error
+
+	// WriteBatch atomically applies a list of writes in order.
+	WriteBatch(batch []ShardStoreWrite) error
+
+	// DeleteRange removes all keys between min and max, inclusive.
+	DeleteRange(min, max []byte) error
+
+	// Cursor returns a cursor over a consistent view of the store. Writes made
+	// after the cursor is created are not visible to it. The cursor must be
+	// closed when it is no longer needed.
+	Cursor() (ShardStoreCursor, error)
+}
+
+// ShardStoreWrite represents a change to a single key in a batch.
+// A nil value removes the key.
+type ShardStoreWrite struct {
+	Key   []byte
+	Value []byte
+}
+
+// ShardStoreCursor iterates over the keys of a shard store in order.
+//
+// Each method returns the key and value at the cursor's new position or nil
+// keys if the position is past either end. Keys and values are only valid
+// until the cursor moves or is closed.
+type ShardStoreCursor interface {
+	// First moves to the first key.
+	First() (key, value []byte)
+
+	// Last moves to the last key.
+	Last() (key, value []byte)
+
+	// Seek moves to the first key that is greater than or equal to seek.
+	Seek(seek []byte) (key, value []byte)
+
+	// Next moves to the next key.
+	Next() (key, value []byte)
+
+	// Prev moves to the previous key.
+	Prev() (key, value []byte)
+
+	// Close releases the cursor's view of the store.
+	Close() error
+}
+
+// shardStores is the registry of shard store implementations by name.
+var shardStores = map[string]func() ShardStore{
+	"bolt": func() ShardStore { return &boltShardStore{} },
+}
+
+// RegisterShardStore makes a shard store implementation available by name.
+// Panics if a store is registered twice with the same name.
+func RegisterShardStore(name string, fn func() ShardStore) {
+	assert(fn != nil, "shard store function required: %s", name)
+	assert(shardStores[name] == nil, "shard store already registered: %s", name)
+	shardStores[name] = fn
+}
+
+// ShardStores returns a sorted list of the names of registered shard stores.
+func ShardStores() []string {
+	var a []string
+	for name := range shardStores {
+		a = append(a, name)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// NewShardStore returns a new, unopened instance of a named shard store.
+func NewShardStore(name string) (ShardStore, error) {
+	fn := shardStores[name]
+	if fn == nil {
+		return nil, ErrShardStoreNotFound
+	}
+	return fn(), nil
+}
@@ -0,0 +1,41 @@
+package influxdb_test
+
+import (
+	"testing"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/storetest"
+)
+
+func init() {
+	// Register a second store to test shard spaces which don't use the default.
+	influxdb.RegisterShardStore("testbolt", func() influxdb.ShardStore {
+		s, _ := influxdb.NewShardStore("bolt")
+		return s
+	})
+}
+
+// Ensure the bolt shard store passes the conformance suite.
+func TestBoltShardStore(t *testing.T) {
+	storetest.Test(t, func() influxdb.ShardStore {
+		s, err := influxdb.NewShardStore("bolt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// Ensure the registered shard stores can be listed.
+func TestShardStores(t *testing.T) {
+	if a := influxdb.ShardStores(); len(a) == 0 || a[0] != influxdb.DefaultShardStore {
+		t.Fatalf("unexpected stores: %v", a)
+	}
+}
+
+// Ensure an error is returned when creating an unregistered shard store.
+func TestNewShardStore_ErrShardStoreNotFound(t *testing.T) {
+	if _, err := influxdb.NewShardStore("no_such_store"); err != influxdb.ErrShardStoreNotFound {
+		t.Fatal(err)
+	}
+}
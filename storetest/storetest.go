@@ -0,0 +1,281 @@
+// Package storetest provides a conformance test suite for shard stores.
+//
+// Implementations of influxdb.ShardStore should run the suite from their own
+// tests:
+//
+//	func TestMyStore(t *testing.T) {
+//		storetest.Test(t, func() influxdb.ShardStore { return NewMyStore() })
+//	}
+package storetest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdb/influxdb"
+)
+
+// tests is the list of conformance tests run against each store.
+var tests = []struct {
+	name string
+	fn   func(s influxdb.ShardStore, path string) error
+}{
+	{"Empty", testEmpty},
+	{"WriteBatch", testWriteBatch},
+	{"WriteBatch_Order", testWriteBatchOrder},
+	{"DeleteRange", testDeleteRange},
+	{"Cursor_Seek", testCursorSeek},
+	{"Cursor_Reverse", testCursorReverse},
+	{"Cursor_Snapshot", testCursorSnapshot},
+	{"Reopen", testReopen},
+}
+
+// Test runs the conformance suite. A new store is created by fn for each test.
+func Test(t *testing.T, fn func() influxdb.ShardStore) {
+	for _, tt := range tests {
+		if err := run(fn, tt.fn); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+	}
+}
+
+// run opens a store in a temporary directory and executes a test against it.
+func run(fn func() influxdb.ShardStore, test func(influxdb.ShardStore, string) error) error {
+	dir, err := ioutil.TempDir("", "influxdb-storetest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store")
+	s := fn()
+	if err := s.Open(path); err != nil {
+		return fmt.Errorf("open: %s", err)
+	}
+	defer s.Close()
+
+	return test(s, path)
+}
+
+// Ensure an empty store returns no keys.
+func testEmpty(s influxdb.ShardStore, path string) error {
+	c, err := s.Cursor()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if k, _ := c.First(); k != nil {
+		return fmt.Errorf("unexpected first key: %x", k)
+	} else if k, _ := c.Last(); k != nil {
+		return fmt.Errorf("unexpected last key: %x", k)
+	} else if k, _ := c.Seek([]byte("foo")); k != nil {
+		return fmt.Errorf("unexpected seek key: %x", k)
+	}
+	return nil
+}
+
+// Ensure written keys can be read back in order.
+func testWriteBatch(s influxdb.ShardStore, path string) error {
+	if err := s.WriteBatch(writes("c", "a", "e", "b", "d")); err != nil {
+		return err
+	}
+	return expect(s, "a", "b", "c", "d", "e")
+}
+
+// Ensure writes in a batch are applied in order and nil values remove keys.
+func testWriteBatchOrder(s influxdb.ShardStore, path string) error {
+	if err := s.WriteBatch(writes("a", "b", "c")); err != nil {
+		return err
+	}
+	if err := s.WriteBatch([]influxdb.ShardStoreWrite{
+		{Key: []byte("a")},
+		{Key: []byte("b")},
+		{Key: []byte("b"), Value: []byte("new")},
+		{Key: []byte("d"), Value: []byte("d")},
+		{Key: []byte("d")},
+	}); err != nil {
+		return err
+	}
+	if err := expect(s, "b", "c"); err != nil {
+		return err
+	}
+
+	// Verify the overwritten value.
+	c, err := s.Cursor()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, v := c.Seek([]byte("b")); string(v) != "new" {
+		return fmt.Errorf("unexpected value: %q", v)
+	}
+	return nil
+}
+
+// Ensure a range of keys can be removed, including both bounds.
+func testDeleteRange(s influxdb.ShardStore, path string) error {
+	if err := s.WriteBatch(writes("a", "b", "ba", "c", "d", "e")); err != nil {
+		return err
+	}
+	if err := s.DeleteRange([]byte("b"), []byte("d")); err != nil {
+		return err
+	}
+	if err := expect(s, "a", "e"); err != nil {
+		return err
+	}
+
+	// Deleting a range without keys is not an error.
+	if err := s.DeleteRange([]byte("x"), []byte("z")); err != nil {
+		return err
+	}
+	return expect(s, "a", "e")
+}
+
+// Ensure seeking moves to the first key at or after the seek key.
+func testCursorSeek(s influxdb.ShardStore, path string) error {
+	if err := s.WriteBatch(writes("b", "d", "f")); err != nil {
+		return err
+	}
+	c, err := s.Cursor()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for _, tt := range []struct{ seek, key string }{
+		{"a", "b"},
+		{"b", "b"},
+		{"c", "d"},
+		{"f", "f"},
+		{"g", ""},
+	} {
+		if k, _ := c.Seek([]byte(tt.seek)); string(k) != tt.key {
+			return fmt.Errorf("seek(%s): unexpected key: %q", tt.seek, k)
+		}
+	}
+
+	// Verify moving forward and backward from a seek.
+	if k, _ := c.Seek([]byte("c")); string(k) != "d" {
+		return fmt.Errorf("unexpected seek key: %q", k)
+	} else if k, _ := c.Next(); string(k) != "f" {
+		return fmt.Errorf("unexpected next key: %q", k)
+	} else if k, _ := c.Next(); k != nil {
+		return fmt.Errorf("unexpected key after last: %q", k)
+	}
+	if k, _ := c.Seek([]byte("c")); string(k) != "d" {
+		return fmt.Errorf("unexpected seek key: %q", k)
+	} else if k, _ := c.Prev(); string(k) != "b" {
+		return fmt.Errorf("unexpected prev key: %q", k)
+	} else if k, _ := c.Prev(); k != nil {
+		return fmt.Errorf("unexpected key before first: %q", k)
+	}
+	return nil
+}
+
+// Ensure a cursor can iterate in reverse from the last key.
+func testCursorReverse(s influxdb.ShardStore, path string) error {
+	if err := s.WriteBatch(writes("a", "b", "c")); err != nil {
+		return err
+	}
+	c, err := s.Cursor()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var keys []string
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		if !bytes.Equal(k, v) {
+			return fmt.Errorf("unexpected value for %q: %q", k, v)
+		}
+		keys = append(keys, string(k))
+	}
+	if fmt.Sprint(keys) != "[c b a]" {
+		return fmt.Errorf("unexpected keys: %v", keys)
+	}
+	return nil
+}
+
+// Ensure a cursor does not see writes made after it was created.
+func testCursorSnapshot(s influxdb.ShardStore, path string) error {
+	if err := s.WriteBatch(writes("a", "b")); err != nil {
+		return err
+	}
+	c, err := s.Cursor()
+	if err != nil {
+		return err
+	}
+
+	// Change the store in a separate goroutine since some stores block
+	// writers while a cursor is open.
+	done := make(chan error, 1)
+	go func() {
+		if err := s.WriteBatch(append(writes("c"), influxdb.ShardStoreWrite{Key: []byte("a")})); err != nil {
+			done <- err
+			return
+		}
+		done <- s.DeleteRange([]byte("b"), []byte("b"))
+	}()
+
+	var keys []string
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		keys = append(keys, string(k))
+	}
+	if err := c.Close(); err != nil {
+		return err
+	}
+	if err := <-done; err != nil {
+		return err
+	}
+
+	if fmt.Sprint(keys) != "[a b]" {
+		return fmt.Errorf("unexpected keys: %v", keys)
+	}
+	return expect(s, "c")
+}
+
+// Ensure keys are available after the store is reopened.
+func testReopen(s influxdb.ShardStore, path string) error {
+	if err := s.WriteBatch(writes("a", "b")); err != nil {
+		return err
+	}
+	if err := s.Close(); err != nil {
+		return fmt.Errorf("close: %s", err)
+	}
+	if err := s.Open(path); err != nil {
+		return fmt.Errorf("reopen: %s", err)
+	}
+	return expect(s, "a", "b")
+}
+
+// writes returns a batch which writes each key with itself as the value.
+func writes(keys ...string) []influxdb.ShardStoreWrite {
+	var a []influxdb.ShardStoreWrite
+	for _, k := range keys {
+		a = append(a, influxdb.ShardStoreWrite{Key: []byte(k), Value: []byte(k)})
+	}
+	return a
+}
+
+// expect returns an error if the store's keys do not match keys.
+func expect(s influxdb.ShardStore, keys ...string) error {
+	c, err := s.Cursor()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var a []string
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		a = append(a, string(k))
+	}
+	if fmt.Sprint(a) != fmt.Sprint(keys) {
+		return fmt.Errorf("unexpected keys: %v, expected %v", a, keys)
+	}
+	return nil
+}
@@ -127,9 +127,16 @@ func (u *DBUser) GetDb() string {
 	return u.DB
 }
 
+// ChangePermissions replaces the read and write matchers for the user.
+// A blank permission removes all access.
 func (u *DBUser) ChangePermissions(readPermissions, writePermissions string) {
-	u.ReadFrom = []*Matcher{{true, readPermissions}}
-	u.WriteTo = []*Matcher{{true, writePermissions}}
+	u.ReadFrom, u.WriteTo = nil, nil
+	if readPermissions != "" {
+		u.ReadFrom = []*Matcher{{true, readPermissions}}
+	}
+	if writePermissions != "" {
+		u.WriteTo = []*Matcher{{true, writePermissions}}
+	}
 }
 
 // dbUsers represents a list of database users, sortable by name.
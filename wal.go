@@ -0,0 +1,201 @@
+package influxdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+	"code.google.com/p/log4go"
+	"github.com/influxdb/influxdb/protocol"
+)
+
+const (
+	// walHeaderSize is the size of the header before each entry in the log.
+	// The header contains the entry's length and its CRC-32 checksum.
+	walHeaderSize = 8
+
+	// walSyncInterval is how often appended entries are synced to disk.
+	walSyncInterval = 100 * time.Millisecond
+
+	// walSyncSize is the number of unsynced bytes which cause the log to be
+	// synced as soon as an entry is appended.
+	walSyncSize = 1 << 20
+)
+
+// wal represents a write-ahead log of series written to a shard which have
+// not yet been flushed to the shard's store.
+//
+// Entries are not synced one by one. The log is synced every walSyncInterval
+// and whenever walSyncSize bytes are waiting, so a crash of the machine can
+// lose the writes of the last interval. A crash of the process loses nothing
+// since the entries are already in the file system's cache.
+type wal struct {
+	mu       sync.Mutex
+	file     *os.File
+	unsynced int // bytes appended since the last sync
+
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// openWAL opens the write-ahead log at path, creating it if it doesn't exist.
+func openWAL(path string) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &wal{file: f, closing: make(chan struct{})}
+	l.wg.Add(1)
+	go l.syncLoop()
+	return l, nil
+}
+
+// close stops syncing and closes the underlying file.
+func (l *wal) close() error {
+	l.mu.Lock()
+	if l.file == nil {
+		l.mu.Unlock()
+		return nil
+	}
+	l.mu.Unlock()
+
+	close(l.closing)
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.file.Sync()
+	if e := l.file.Close(); err == nil {
+		err = e
+	}
+	l.file = nil
+	return err
+}
+
+// syncLoop runs in a separate goroutine and periodically syncs the log.
+func (l *wal) syncLoop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(walSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.closing:
+			return
+		case <-ticker.C:
+			if err := l.sync(); err != nil {
+				log4go.Error("wal: sync: %s", err)
+			}
+		}
+	}
+}
+
+// sync writes the appended entries to disk. Appends aren't blocked while
+// the file is synced.
+func (l *wal) sync() error {
+	l.mu.Lock()
+	n, f := l.unsynced, l.file
+	l.unsynced = 0
+	l.mu.Unlock()
+
+	if n == 0 {
+		return nil
+	}
+	return f.Sync()
+}
+
+// append writes a series to the end of the log. The entry is synced to disk
+// with the other entries of the current interval, see wal.
+func (l *wal) append(s *protocol.Series) error {
+	data, err := proto.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// Encode entry.
+	b := make([]byte, walHeaderSize+len(data))
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(data))
+	copy(b[walHeaderSize:], data)
+
+	// Write to log file. Sync right away if too much data is waiting.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(b); err != nil {
+		return err
+	}
+	if l.unsynced += len(b); l.unsynced >= walSyncSize {
+		l.unsynced = 0
+		return l.file.Sync()
+	}
+	return nil
+}
+
+// replay reads each series in the log and passes it to fn. An incomplete or
+// corrupt entry ends the log and is removed along with anything after it.
+func (l *wal) replay(fn func(*protocol.Series) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+
+	var offset int64
+	r := bufio.NewReader(l.file)
+	for {
+		// Read entry header and data.
+		var hdr [walHeaderSize]byte
+		if _, err := io.ReadFull(r, hdr[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		data := make([]byte, binary.BigEndian.Uint32(hdr[0:4]))
+		if _, err := io.ReadFull(r, data); err == io.EOF || err == io.ErrUnexpectedEOF {
+			log4go.Warn("wal: incomplete entry at offset %d", offset)
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// Verify and decode the series.
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(hdr[4:8]) {
+			log4go.Warn("wal: checksum mismatch at offset %d", offset)
+			break
+		}
+		s := &protocol.Series{}
+		if err := proto.Unmarshal(data, s); err != nil {
+			log4go.Warn("wal: invalid entry at offset %d: %s", offset, err)
+			break
+		}
+
+		if err := fn(s); err != nil {
+			return err
+		}
+		offset += int64(walHeaderSize + len(data))
+	}
+
+	// Remove anything after the last valid entry and move to the end.
+	if err := l.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := l.file.Seek(offset, os.SEEK_SET)
+	return err
+}
+
+// truncate removes all entries from the log.
+func (l *wal) truncate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.file.Seek(0, os.SEEK_SET)
+	return err
+}